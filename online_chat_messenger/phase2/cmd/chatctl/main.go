@@ -0,0 +1,334 @@
+// chatctl はチャットサーバーの管理APIを操作するコマンドラインツールです。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"online_chat_messenger/internal/admin"
+	"online_chat_messenger/internal/transcript"
+)
+
+const (
+	// envAddr は管理APIのURLを指定する環境変数です。
+	envAddr = "CHATCTL_ADDR"
+	// envToken は管理APIのトークンを指定する環境変数です。サーバーと同じ名前を使います。
+	envToken = "CHAT_ADMIN_TOKEN"
+
+	defaultAddr = "http://127.0.0.1:9091"
+)
+
+// errUsage はコマンドの使い方が間違っていることを表します。
+var errUsage = errors.New("使い方が正しくありません")
+
+// command はサブコマンドを表します。
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, c *ctl, args []string) error
+}
+
+var commands = map[string]command{
+	"rooms":    {"rooms", "ルームの一覧を表示します", runRooms},
+	"users":    {"users <room>", "ルームのメンバーを表示します", runUsers},
+	"kick":     {"kick <room> <user>", "ユーザーをルームから退出させます", runKick},
+	"ban":      {"ban <room> <user>", "ユーザーを退出させ、接続元のアドレスからの接続を拒否します", runBan},
+	"bans":     {"bans", "接続を拒否しているアドレスの一覧を表示します", runBans},
+	"unban":    {"unban <address>", "接続の拒否を解除します", runUnban},
+	"close":    {"close <room>", "全メンバーを退出させてルームを閉じます", runClose},
+	"announce": {"announce [-room <room>] <message>", "お知らせを送信します（ルームを省略すると全ルーム）", runAnnounce},
+	"export":   {"export [-format jsonl|markdown|html] [-from <time>] [-to <time>] [-o <file>] <room>", "会話記録を書き出します（-o を省略すると標準出力）", runExport},
+	"stats":    {"stats", "サーバーの統計情報を表示します", runStats},
+}
+
+// commandOrder はヘルプに表示するサブコマンドの順番です。
+var commandOrder = []string{"rooms", "users", "kick", "ban", "bans", "unban", "close", "announce", "export", "stats"}
+
+// ctl はサブコマンドの実行に必要な状態を保持します。
+type ctl struct {
+	client *admin.Client
+	json   bool
+	out    io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("chatctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { printUsage(fs) }
+
+	addr := fs.String("addr", envOr(envAddr, defaultAddr), "管理APIのURL（環境変数 "+envAddr+"）")
+	token := fs.String("token", "", "管理APIのトークン（環境変数 "+envToken+"）")
+	jsonOutput := fs.Bool("json", false, "結果をJSONで出力する")
+	timeout := fs.Duration("timeout", 10*time.Second, "リクエストのタイムアウト")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "不明なコマンドです: %s\n\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	// トークンはヘルプに表示されないよう、デフォルト値ではなく解析後に環境変数から読み込む
+	if *token == "" {
+		*token = os.Getenv(envToken)
+	}
+	if *token == "" {
+		fmt.Fprintf(stderr, "トークンを -token または環境変数 %s で指定してください\n", envToken)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c := &ctl{client: admin.NewClient(*addr, *token), json: *jsonOutput, out: stdout}
+	if err := cmd.run(ctx, c, fs.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "使用法: chatctl %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(stderr, "エラー:", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "使用法: chatctl [オプション] <コマンド> [引数]")
+	fmt.Fprintln(w, "\nコマンド:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range commandOrder {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].help)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nオプション:")
+	fs.PrintDefaults()
+}
+
+func runRooms(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	rooms, err := c.client.ListRooms(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(rooms, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ROOM\tMEMBERS\tCREATOR\tCREATED\tTOPIC")
+		for _, room := range rooms {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", room.Name, room.MembersString(), room.Creator, room.CreatedAt.Local().Format(time.DateTime), room.Topic)
+		}
+	})
+}
+
+func runUsers(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	users, err := c.client.ListUsers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(users, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "USER\tACCOUNT\tHOST\tADDRESS\tUDP_ADDR")
+		for _, user := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\n", user.Name, orDash(user.Account), user.Host, user.Address, orDash(user.UDPAddr))
+		}
+	})
+}
+
+func runKick(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	result, err := c.client.Kick(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.print(result, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "%s から %s を退出させました（%d人）\n", result.Room, result.User, result.Kicked)
+	})
+}
+
+func runBan(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	result, err := c.client.Ban(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.print(result, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "%s から %s を退出させました（%d人）\n", result.Room, result.User, result.Kicked)
+		fmt.Fprintf(tw, "接続を拒否したアドレス: %s\n", strings.Join(result.Banned, ", "))
+	})
+}
+
+func runBans(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	result, err := c.client.ListBans(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(result.Bans, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ADDRESS\tBANNED_AT")
+		for _, ban := range result.Bans {
+			fmt.Fprintf(tw, "%s\t%s\n", ban.Address, ban.BannedAt.Local().Format(time.DateTime))
+		}
+	})
+}
+
+func runUnban(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := c.client.Unban(ctx, args[0]); err != nil {
+		return err
+	}
+	return c.print(map[string]string{"address": args[0]}, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "%s の接続の拒否を解除しました\n", args[0])
+	})
+}
+
+func runClose(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	result, err := c.client.CloseRoom(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(result, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "%s を閉じました（%d人が退出しました）\n", result.Room, result.RemovedUsers)
+	})
+}
+
+func runAnnounce(ctx context.Context, c *ctl, args []string) error {
+	fs := flag.NewFlagSet("announce", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	room := fs.String("room", "", "送信先のルーム（省略すると全ルーム）")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		return errUsage
+	}
+
+	result, err := c.client.Announce(ctx, *room, strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+	return c.print(result, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "%dルームにお知らせを送信しました\n", result.Rooms)
+	})
+}
+
+func runExport(ctx context.Context, c *ctl, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	formatName := fs.String("format", "", "書き出す形式（省略すると -o の拡張子、それもなければ jsonl）")
+	fromText := fs.String("from", "", "この時刻以降のメッセージを書き出す（例: 2006-01-02、2006-01-02T15:04）")
+	toText := fs.String("to", "", "この時刻より前のメッセージを書き出す")
+	output := fs.String("o", "", "書き出すファイル")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	if *formatName == "" {
+		*formatName = "jsonl"
+		if ext := filepath.Ext(*output); ext != "" {
+			*formatName = ext
+		}
+	}
+	format, err := transcript.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	from, err := transcript.ParseTime(*fromText, time.Local)
+	if err != nil {
+		return err
+	}
+	to, err := transcript.ParseTime(*toText, time.Local)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return c.client.Export(ctx, fs.Arg(0), format, from, to, c.out)
+	}
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := c.client.Export(ctx, fs.Arg(0), format, from, to, f); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s の会話記録を %s に書き出しました\n", fs.Arg(0), *output)
+	return nil
+}
+
+func runStats(ctx context.Context, c *ctl, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	stats, err := c.client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(stats, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "STARTED_AT\t%s\n", stats.StartedAt.Local().Format(time.DateTime))
+		fmt.Fprintf(tw, "UPTIME\t%s\n", time.Duration(stats.UptimeSeconds)*time.Second)
+		fmt.Fprintf(tw, "ROOMS\t%d\n", stats.Rooms)
+		fmt.Fprintf(tw, "USERS\t%d\n", stats.Users)
+		fmt.Fprintf(tw, "GOROUTINES\t%d\n", stats.Goroutines)
+	})
+}
+
+// print は -json が指定されていればvをJSONで、そうでなければtableで表形式に出力します。
+func (c *ctl) print(v any, table func(tw *tabwriter.Writer)) error {
+	if c.json {
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
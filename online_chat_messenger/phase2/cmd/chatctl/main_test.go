@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"online_chat_messenger/internal/admin"
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+)
+
+const testToken = "s3cret"
+
+// newTestServer はlobbyにtaro（ホスト）とhanakoが参加している管理APIのサーバーを起動します。
+func newTestServer(t *testing.T) (*httptest.Server, *chat.SimpleRoomManager) {
+	t.Helper()
+	rooms := chat.NewSimpleRoomManager()
+	users := auth.NewSimpleUserManager()
+	t.Cleanup(func() {
+		users.Close()
+		rooms.Close()
+	})
+
+	room, err := rooms.CreateRoom("lobby", "", chat.Metadata{Creator: "taro", Topic: "雑談"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"taro", "hanako"} {
+		user := chat.NewUser(name, name+"-token", "192.0.2.1:50000")
+		if err := room.AddUser(user, i == 0); err != nil {
+			t.Fatal(err)
+		}
+		users.RegisterUser(user.GetToken(), "lobby", user)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service := admin.NewService(rooms, users, nil)
+	service.SetLogger(logger)
+	service.SetBanList(auth.NewBanList())
+	handler := admin.NewHTTPHandler(service, testToken)
+	handler.SetLogger(logger)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, rooms
+}
+
+// chatctl はコマンドを実行し、終了コードと標準出力・標準エラー出力を返します。
+func chatctl(t *testing.T, server *httptest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv(envToken, "")
+	var stdout, stderr strings.Builder
+	code := run(append([]string{"-addr", server.URL, "-token", testToken}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUsageErrors(t *testing.T) {
+	server, _ := newTestServer(t)
+	tests := []struct {
+		name string
+		args []string
+		want string // 標準エラー出力に含まれる文字列
+	}{
+		{"コマンドなし", nil, "使用法: chatctl [オプション]"},
+		{"不明なコマンド", []string{"reboot"}, "不明なコマンドです: reboot"},
+		{"引数が足りない", []string{"kick", "lobby"}, "使用法: chatctl kick <room> <user>"},
+		{"引数が多い", []string{"rooms", "extra"}, "使用法: chatctl rooms"},
+		{"お知らせの本文がない", []string{"announce", "-room", "lobby"}, "使用法: chatctl announce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := chatctl(t, server, tt.args...)
+			if code != 2 || !strings.Contains(stderr, tt.want) {
+				t.Errorf("exit %d, stderr %q, want 2 and %q", code, stderr, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingToken(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv(envToken, "")
+	var stdout, stderr strings.Builder
+	if code := run([]string{"-addr", server.URL, "rooms"}, &stdout, &stderr); code != 2 || !strings.Contains(stderr.String(), envToken) {
+		t.Errorf("exit %d, stderr %q", code, stderr.String())
+	}
+
+	// 環境変数のトークンを使う
+	t.Setenv(envToken, testToken)
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"-addr", server.URL, "rooms"}, &stdout, &stderr); code != 0 {
+		t.Errorf("exit %d, stderr %q with the token from the environment", code, stderr.String())
+	}
+}
+
+func TestWrongToken(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv(envToken, "")
+	var stdout, stderr strings.Builder
+	code := run([]string{"-addr", server.URL, "-token", "wrong", "rooms"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "(401)") {
+		t.Errorf("exit %d, stderr %q, want 1 and the 401 error", code, stderr.String())
+	}
+}
+
+func TestTableOutput(t *testing.T) {
+	server, _ := newTestServer(t)
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"rooms"}, []string{"ROOM", "MEMBERS", "lobby", "雑談"}},
+		{[]string{"users", "lobby"}, []string{"USER", "hanako", "taro", "true"}},
+		{[]string{"stats"}, []string{"ROOMS", "USERS"}},
+		{[]string{"announce", "メンテナンス", "を行います"}, []string{"1ルームにお知らせを送信しました"}},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := chatctl(t, server, tt.args...)
+		if code != 0 {
+			t.Errorf("%v: exit %d, stderr %q", tt.args, code, stderr)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("%v: output does not contain %q:\n%s", tt.args, want, stdout)
+			}
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	server, _ := newTestServer(t)
+	code, stdout, stderr := chatctl(t, server, "-json", "users", "lobby")
+	if code != 0 {
+		t.Fatalf("exit %d, stderr %q", code, stderr)
+	}
+	var users []admin.UserInfo
+	if err := json.Unmarshal([]byte(stdout), &users); err != nil {
+		t.Fatalf("%v:\n%s", err, stdout)
+	}
+	if len(users) != 2 || users[0].Name != "hanako" || users[1].Name != "taro" || !users[1].Host {
+		t.Errorf("users = %+v", users)
+	}
+}
+
+func TestKickAndClose(t *testing.T) {
+	server, rooms := newTestServer(t)
+
+	code, stdout, stderr := chatctl(t, server, "kick", "lobby", "hanako")
+	if code != 0 || !strings.Contains(stdout, "lobby から hanako を退出させました（1人）") {
+		t.Errorf("kick: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	code, _, stderr = chatctl(t, server, "kick", "lobby", "hanako")
+	if code != 1 || !strings.Contains(stderr, "(404)") {
+		t.Errorf("kick twice: exit %d, stderr %q, want 1 and the 404 error", code, stderr)
+	}
+
+	code, stdout, stderr = chatctl(t, server, "close", "lobby")
+	if code != 0 || !strings.Contains(stdout, "lobby を閉じました（1人が退出しました）") {
+		t.Errorf("close: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if _, err := rooms.FindRoom("lobby"); err == nil {
+		t.Error("the room still exists after close")
+	}
+}
+
+func TestBanAndUnban(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	code, stdout, stderr := chatctl(t, server, "ban", "lobby", "hanako")
+	if code != 0 || !strings.Contains(stdout, "接続を拒否したアドレス: 192.0.2.1") {
+		t.Fatalf("ban: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, stdout, _ := chatctl(t, server, "bans"); code != 0 || !strings.Contains(stdout, "192.0.2.1") {
+		t.Errorf("bans: exit %d, stdout %q", code, stdout)
+	}
+	if code, stdout, _ := chatctl(t, server, "unban", "192.0.2.1"); code != 0 || !strings.Contains(stdout, "192.0.2.1 の接続の拒否を解除しました") {
+		t.Errorf("unban: exit %d, stdout %q", code, stdout)
+	}
+	if code, _, stderr := chatctl(t, server, "unban", "192.0.2.1"); code != 1 || !strings.Contains(stderr, "(404)") {
+		t.Errorf("unban twice: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestExportWithoutMessageLog(t *testing.T) {
+	server, _ := newTestServer(t)
+	code, _, stderr := chatctl(t, server, "export", "lobby")
+	if code != 1 || !strings.Contains(stderr, "(501)") {
+		t.Errorf("exit %d, stderr %q, want 1 and the 501 error", code, stderr)
+	}
+	if code, _, stderr := chatctl(t, server, "export", "-format", "pdf", "lobby"); code != 1 || !strings.Contains(stderr, "unknown transcript format") {
+		t.Errorf("unknown format: exit %d, stderr %q", code, stderr)
+	}
+}
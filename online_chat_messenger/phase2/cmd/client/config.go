@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// clientConfig はクライアントの設定です。
+// デフォルト値 < 設定ファイル < コマンドライン引数 の順に上書きされます。
+type clientConfig struct {
+	Host      string `json:"host"`
+	TCPPort   int    `json:"tcp_port"`
+	UDPPort   int    `json:"udp_port"`
+	UserName  string `json:"user_name"`
+	RoomName  string `json:"room_name"`
+	Password  string `json:"password"`
+	Operation string `json:"operation"` // "create" (1) または "join" (2)
+	// 招待制のルームに参加するときの招待コード
+	InviteCode string `json:"invite_code"`
+	// アカウントを登録する・ログインする（パスワードは起動時に入力する）
+	Register bool `json:"register"`
+	Login    bool `json:"login"`
+
+	// ルームを作成するときに設定する付加情報
+	Topic       string `json:"topic"`
+	Description string `json:"description"`
+	MaxMembers  int    `json:"max_members"`
+	InviteOnly  bool   `json:"invite_only"`
+}
+
+// defaultClientConfig はデフォルトの設定を返します。
+func defaultClientConfig() clientConfig {
+	return clientConfig{
+		Host:    "localhost",
+		TCPPort: 8088,
+		UDPPort: 8089,
+	}
+}
+
+// loadClientConfig はコマンドライン引数と設定ファイルから設定を読み込みます。
+func loadClientConfig(args []string) (clientConfig, error) {
+	cfg := defaultClientConfig()
+
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	configPath := fs.String("config", "", "設定ファイル(JSON)のパス")
+	host := fs.String("host", cfg.Host, "サーバーのホスト名")
+	tcpPort := fs.Int("tcp-port", cfg.TCPPort, "サーバーのTCPポート番号")
+	udpPort := fs.Int("udp-port", cfg.UDPPort, "サーバーのUDPポート番号")
+	userName := fs.String("user", "", "ユーザー名")
+	roomName := fs.String("room", "", "ルーム名")
+	password := fs.String("password", "", "ルームのパスワード")
+	operation := fs.String("op", "", "操作（create または join）")
+	topic := fs.String("topic", "", "作成するルームのトピック")
+	description := fs.String("description", "", "作成するルームの説明")
+	maxMembers := fs.Int("max-members", 0, "作成するルームのメンバー数の上限（0はサーバーの上限に従う）")
+	inviteOnly := fs.Bool("invite-only", false, "作成するルームを招待制にする")
+	inviteCode := fs.String("invite", "", "招待制のルームに参加するときの招待コード")
+	register := fs.Bool("register", false, "ユーザー名でアカウントを登録してから入室する")
+	login := fs.Bool("login", false, "ユーザー名のアカウントにログインしてから入室する")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// 設定ファイルを読み込む
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return cfg, fmt.Errorf("設定ファイルの読み込みに失敗しました: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("設定ファイルの解析に失敗しました: %w", err)
+		}
+	}
+
+	// 明示的に指定された引数で上書きする
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host":
+			cfg.Host = *host
+		case "tcp-port":
+			cfg.TCPPort = *tcpPort
+		case "udp-port":
+			cfg.UDPPort = *udpPort
+		case "user":
+			cfg.UserName = *userName
+		case "room":
+			cfg.RoomName = *roomName
+		case "password":
+			cfg.Password = *password
+		case "op":
+			cfg.Operation = *operation
+		case "topic":
+			cfg.Topic = *topic
+		case "description":
+			cfg.Description = *description
+		case "max-members":
+			cfg.MaxMembers = *maxMembers
+		case "invite-only":
+			cfg.InviteOnly = *inviteOnly
+		case "invite":
+			cfg.InviteCode = *inviteCode
+		case "register":
+			cfg.Register = *register
+		case "login":
+			cfg.Login = *login
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// validate は設定値を検証し、操作名を正規化します。
+func (c *clientConfig) validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("ホスト名が指定されていません")
+	}
+	if c.TCPPort < 1 || c.TCPPort > 65535 {
+		return fmt.Errorf("TCPポート番号が不正です: %d", c.TCPPort)
+	}
+	if c.UDPPort < 1 || c.UDPPort > 65535 {
+		return fmt.Errorf("UDPポート番号が不正です: %d", c.UDPPort)
+	}
+
+	if c.Register && c.Login {
+		return fmt.Errorf("-register と -login は同時に指定できません")
+	}
+
+	if c.MaxMembers < 0 {
+		return fmt.Errorf("メンバー数の上限が不正です: %d", c.MaxMembers)
+	}
+
+	switch c.Operation {
+	case "", "create", "join":
+	case "1":
+		c.Operation = "create"
+	case "2":
+		c.Operation = "join"
+	default:
+		return fmt.Errorf("不明な操作です: %s", c.Operation)
+	}
+	return nil
+}
+
+// tcpAddr はサーバーのTCPアドレスを返します。
+func (c clientConfig) tcpAddr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.TCPPort))
+}
+
+// udpAddr はサーバーのUDPアドレスを返します。
+func (c clientConfig) udpAddr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.UDPPort))
+}
@@ -2,252 +2,444 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"os"
-	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"golang.org/x/term"
+
+	"online_chat_messenger/internal/client"
+	"online_chat_messenger/internal/command"
 	"online_chat_messenger/internal/protocol"
+	"online_chat_messenger/internal/transcript"
 )
 
 // ユーザー入力を取得する関数
-func getUserInput(reader *bufio.Reader, prompt string) string {
+// 入力が終わった場合（Ctrl+Dなど）はio.EOFを返します。
+func getUserInput(reader *bufio.Reader, prompt string) (string, error) {
 	fmt.Print(prompt)
-	input, _ := reader.ReadString('\n')
-	return input[:len(input)-1] // 改行を削除
+	input, err := reader.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || input == "") {
+		return "", err
+	}
+	return strings.TrimRight(input, "\r\n"), nil // 改行を削除
 }
 
-// サーバーに接続する関数
-func connectToServer() (net.Conn, error) {
-	conn, err := net.Dial("tcp", "localhost:8088")
+// getPassword はパスワードを入力してもらいます。端末の場合は入力した文字を表示しません。
+func getPassword(reader *bufio.Reader, prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return getUserInput(reader, prompt)
+	}
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
 	if err != nil {
-		return nil, fmt.Errorf("サーバーへの接続に失敗しました: %v", err)
+		return "", err
 	}
-	return conn, nil
+	return string(password), nil
 }
 
-// ルーム作成リクエストを送信する関数
-func sendRequest(conn net.Conn, choice, roomName, userName string) error {
-	request := map[string]string{
-		"room_name": roomName,
-		"user_name": userName,
+func main() {
+	cfg, err := loadClientConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
 	}
 
-	requestBody, err := json.Marshal(request)
+	// サーバーに接続
+	c, err := client.Dial(cfg.tcpAddr(), cfg.udpAddr())
 	if err != nil {
-		return fmt.Errorf("JSONのエンコードに失敗しました: %v", err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	defer c.Close()
 
-	operation, err := strconv.ParseUint(choice, 10, 8)
-	if err != nil {
-		return fmt.Errorf("選択の変換に失敗しました: %v", err)
+	reader := bufio.NewReader(os.Stdin)
+
+	// 設定されていない項目のみユーザー入力を取得
+	if cfg.Operation == "" {
+		if cfg.Operation, err = getUserInput(reader, "選択してください（1: 新規ルーム作成, 2: 既存ルーム入室）: "); err != nil {
+			return
+		}
+		if err := cfg.validate(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if cfg.RoomName == "" {
+		if cfg.RoomName, err = getUserInput(reader, "ルーム名を入力してください: "); err != nil {
+			return
+		}
 	}
+	if cfg.UserName == "" {
+		if cfg.UserName, err = getUserInput(reader, "ユーザー名を入力してください: "); err != nil {
+			return
+		}
+	}
+	userName := cfg.UserName
 
-	requestTCRPMessage := protocol.TCRPMessage{
-		Header: protocol.TCRPHeader{
-			Operation: uint8(operation),
-			State:     0,
-		},
-		Body: requestBody,
+	// アカウントの登録・ログイン（指定した場合のみ）
+	if cfg.Register || cfg.Login {
+		password, err := getPassword(reader, "アカウントのパスワードを入力してください: ")
+		if err != nil {
+			return
+		}
+		if cfg.Register {
+			err = c.Register(userName, password)
+		} else {
+			err = c.Login(userName, password)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		userName = c.Account()
+		fmt.Println("ログインしました:", userName)
 	}
 
-	encodedRequest, err := protocol.EncodeTCRPMessage(requestTCRPMessage)
+	// ルーム作成/参加リクエストを送信
+	switch cfg.Operation {
+	case "create":
+		err = c.CreateRoom(cfg.RoomName, userName, cfg.Password, client.RoomOptions{
+			Topic:       cfg.Topic,
+			Description: cfg.Description,
+			MaxMembers:  cfg.MaxMembers,
+			InviteOnly:  cfg.InviteOnly,
+		})
+		if err == nil {
+			fmt.Println("ルーム作成に成功しました！")
+		}
+	case "join":
+		err = c.JoinRoom(cfg.RoomName, userName, cfg.Password, cfg.InviteCode)
+		// 招待制のルームで招待コードを指定していなかった場合は、入力してもらってやり直す
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) && statusErr.Status == protocol.StatusInviteRequired {
+			fmt.Println(err)
+			if cfg.InviteCode, err = getUserInput(reader, "招待コードを入力してください: "); err != nil {
+				return
+			}
+			err = c.JoinRoom(cfg.RoomName, userName, cfg.Password, cfg.InviteCode)
+		}
+		// パスワードが違う場合も、入力してもらってやり直す
+		if errors.As(err, &statusErr) && statusErr.Status == protocol.StatusWrongPassword {
+			fmt.Println(err)
+			if cfg.Password, err = getPassword(reader, "ルームのパスワードを入力してください: "); err != nil {
+				return
+			}
+			err = c.JoinRoom(cfg.RoomName, userName, cfg.Password, cfg.InviteCode)
+		}
+		if err == nil {
+			fmt.Println("ルームへの参加に成功しました！")
+		}
+	default:
+		err = fmt.Errorf("操作が選択されていません")
+	}
 	if err != nil {
-		return fmt.Errorf("TCRPメッセージのエンコードに失敗しました: %v", err)
+		fmt.Println(err)
+		return
 	}
 
-	_, err = conn.Write(encodedRequest)
-	if err != nil {
-		return fmt.Errorf("サーバーへの送信に失敗しました: %v", err)
+	fmt.Println("トークン:", c.Token())
+	fmt.Println("ルーム名:", c.RoomName())
+	if motd := c.MOTD(); motd != "" {
+		fmt.Println("お知らせ:", motd)
+	}
+	if topic := c.Topic(); topic != "" {
+		fmt.Println("トピック:", topic)
+	}
+	if description := c.Description(); description != "" {
+		fmt.Println("説明:", description)
 	}
 
-	return nil
-}
+	// 受信処理をゴルーチンで実行
+	go func() {
+		for event := range c.Messages() {
+			formatReceiveMessage(event)
+		}
+	}()
 
-// サーバーからの応答を受信する関数
-func receiveResponse(conn net.Conn) (protocol.TCRPMessage, error) {
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
-	if err != nil {
-		return protocol.TCRPMessage{}, fmt.Errorf("サーバーからの受信に失敗しました: %v", err)
-	}
+	// メインスレッドで送信処理を実行
+	for {
+		// /nick で名前が変わることがあるので、毎回クライアントから取得する
+		message, err := getUserInput(reader, c.UserName()+"> ")
+		if err != nil {
+			// 入力が終わった場合は /exit と同じように退出する
+			fmt.Println()
+			leaveRoom(c)
+			return
+		}
+		if message == "" {
+			continue
+		}
 
-	responseTCRPMessage, err := protocol.DecodeTCRPMessage(buffer[:n])
-	if err != nil {
-		return protocol.TCRPMessage{}, fmt.Errorf("TCRPメッセージのデコードに失敗しました: %v", err)
-	}
+		name, args, ok := command.Split(message)
+		if !ok {
+			// コマンドでない入力と "//" で始まる入力はそのまま送信する（"//" はサーバーが "/" に戻す）
+			if err := c.Send(message); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+		spec, ok := command.Lookup(name)
+		if !ok {
+			fmt.Printf("不明なコマンドです: /%s（/help でコマンドの一覧を表示します）\n", name)
+			continue
+		}
 
-	return responseTCRPMessage, nil
+		switch spec.Name {
+		case "help":
+			printHelp()
+		case "clear":
+			fmt.Print("\033[H\033[2J")
+		case "exit":
+			leaveRoom(c)
+			return
+		case "search":
+			if args == "" {
+				fmt.Println("使用法:", spec.Usage)
+				continue
+			}
+			searchHistory(c, args)
+		case "export":
+			exportTranscript(c, strings.Fields(args))
+		case "msg":
+			sendDirect(c, args)
+		case "invite":
+			createInvite(c, args)
+		case "invites":
+			listInvites(c)
+		case "revoke":
+			if args == "" {
+				fmt.Println("使用法:", spec.Usage)
+				continue
+			}
+			if err := c.RevokeInvite(args); err != nil {
+				fmt.Println("招待コードの取り消しに失敗しました:", err)
+				continue
+			}
+			fmt.Println("招待コードを取り消しました:", args)
+		default:
+			// サーバーコマンドはチャットメッセージとして送信し、サーバーが実行する
+			if err := c.Send(message); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
 }
 
-func main() {
-	// サーバーに接続
-	conn, err := connectToServer()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// leaveRoom はルームから退出してチャットを終了します。
+func leaveRoom(c *client.Client) {
+	if err := c.Leave(); err != nil {
+		fmt.Println("ルームからの退出に失敗しました:", err)
 	}
-	defer conn.Close()
-
-	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("チャットを終了します")
+}
 
-	// ユーザー入力を取得
-	choice := getUserInput(reader, "選択してください（1: 新規ルーム作成, 2: 既存ルーム入室）: ")
-	roomName := getUserInput(reader, "ルーム名を入力してください: ")
-	userName := getUserInput(reader, "ユーザー名を入力してください: ")
+// printHelp はコマンドの一覧をクライアントで処理するものとサーバーで処理するものに分けて表示します。
+func printHelp() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, group := range []struct {
+		title string
+		scope command.Scope
+	}{
+		{"クライアントのコマンド:", command.ScopeClient},
+		{"サーバーのコマンド:", command.ScopeServer},
+	} {
+		fmt.Fprintln(w, group.title)
+		for _, spec := range command.Specs {
+			if spec.Scope != group.scope {
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", spec.Usage, spec.Help)
+		}
+	}
+	fmt.Fprintln(w, "\"/\" で始まるメッセージを送るには \"//\" と入力してください")
+	w.Flush()
+}
 
-	// ルーム作成/参加リクエストを送信
-	err = sendRequest(conn, choice, roomName, userName)
-	if err != nil {
-		fmt.Println(err)
+// sendDirect は "<ユーザー名> <メッセージ>" を宛先のユーザーだけに送信し、送信した内容を表示します。
+func sendDirect(c *client.Client, args string) {
+	to, text, _ := strings.Cut(strings.TrimLeft(args, " "), " ")
+	text = strings.TrimSpace(text)
+	if to == "" || text == "" {
+		spec, _ := command.Lookup("msg")
+		fmt.Println("使用法:", spec.Usage)
 		return
 	}
-
-	// 準拠応答を受信 (State = 1)
-	responseTCRPMessage, err := receiveResponse(conn)
-	if err != nil {
+	if err := c.SendDirect(to, text); err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("[DM → %s] %s\n", to, text)
+}
 
-	if responseTCRPMessage.Header.State != 1 {
-		fmt.Println("リクエスト処理に失敗しました。状態コード:", responseTCRPMessage.Header.State)
+// createInvite は招待コードを発行して表示します。
+// 有効期間（"30m"、"24h" など）を指定すると期間内は何度でも使えるコードに、省略すると1回だけ使えるコードになります。
+func createInvite(c *client.Client, args string) {
+	var ttl time.Duration
+	if args != "" {
+		var err error
+		if ttl, err = time.ParseDuration(args); err != nil || ttl <= 0 {
+			spec, _ := command.Lookup("invite")
+			fmt.Println("使用法:", spec.Usage)
+			return
+		}
+	}
+	invite, err := c.CreateInvite(ttl, ttl == 0)
+	if err != nil {
+		fmt.Println("招待コードの発行に失敗しました:", err)
 		return
 	}
+	fmt.Println("招待コード:", invite.Code, "（"+describeInvite(invite)+"）")
+}
 
-	fmt.Println("サーバーからの応答を受信しました。状態:", responseTCRPMessage.Header.State)
-
-	// 完了応答を受信 (State = 2)
-	completeTCRPMessage, err := receiveResponse(conn)
+// listInvites は有効な招待コードの一覧を表示します。
+func listInvites(c *client.Client) {
+	invites, err := c.Invites()
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("招待コードの取得に失敗しました:", err)
 		return
 	}
-
-	if completeTCRPMessage.Header.State != 2 {
-		fmt.Println("リクエスト完了に失敗しました。状態コード:", completeTCRPMessage.Header.State)
+	if len(invites) == 0 {
+		fmt.Println("有効な招待コードはありません")
 		return
 	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tCREATED BY\tCREATED\tLIMIT")
+	for _, invite := range invites {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", invite.Code, invite.CreatedBy, invite.CreatedAt.Local().Format("01/02 15:04"), describeInvite(invite))
+	}
+	w.Flush()
+}
 
-	fmt.Println("サーバーからの完了応答を受信しました。状態:", completeTCRPMessage.Header.State)
+// describeInvite は招待コードの使用回数と有効期限を表示用の文字列にします。
+func describeInvite(invite client.Invite) string {
+	var limits []string
+	if invite.SingleUse {
+		limits = append(limits, "1回のみ")
+	}
+	if !invite.ExpiresAt.IsZero() {
+		limits = append(limits, invite.ExpiresAt.Local().Format("01/02 15:04")+"まで")
+	}
+	return strings.Join(limits, "、")
+}
 
-	// 応答を表示
-	var response map[string]string
-	err = json.Unmarshal(completeTCRPMessage.Body, &response)
+// searchHistory はルームの履歴を検索し、見つかったメッセージを古い順に表示します。
+func searchHistory(c *client.Client, query string) {
+	results, err := c.Search(query, 0)
 	if err != nil {
-		fmt.Printf("JSONのデコードに失敗しました: %v\n", err)
+		fmt.Println("検索に失敗しました:", err)
 		return
 	}
-
-	if completeTCRPMessage.Header.Operation == 1 {
-		fmt.Println("ルーム作成に成功しました！")
-	} else if completeTCRPMessage.Header.Operation == 2 {
-		fmt.Println("ルームへの参加に成功しました！")
+	if len(results) == 0 {
+		fmt.Println("見つかりませんでした")
+		return
 	}
-
-	token := response["token"] // サーバから返されたトークン
-	fmt.Println("トークン:", token)
-
-	// roomNameがレスポンスに含まれている場合は更新
-	if respRoomName, ok := response["roomName"]; ok {
-		roomName = respRoomName
+	// サーバーは新しい順に返すので、会話と同じ順になるよう逆から表示する
+	for i := len(results) - 1; i >= 0; i-- {
+		result := results[i]
+		fmt.Printf("[%s] #%s %s> %s\n", result.Time.Local().Format("01/02 15:04"), result.Room, result.Sender, result.Text)
 	}
-	fmt.Println("ルーム名:", roomName)
-
-	// TCPの接続を閉じる
-	conn.Close()
+	fmt.Printf("%d件見つかりました\n", len(results))
+}
 
-	// ===== UDPのチャットルーム処理に移行 =============
-	udpConn, err := connectToServerUDP()
-	if err != nil {
-		fmt.Println("UDP接続に失敗しました。", err)
+// exportTranscript はルームの会話記録を "<ルーム名>-<日時>.<拡張子>" のファイルに書き出します。
+// 引数は [形式] [開始時刻] [終了時刻] で、形式を省略するとMarkdownで書き出します。
+func exportTranscript(c *client.Client, args []string) {
+	if len(args) > 3 {
+		fmt.Println("使用法: /export [jsonl|markdown|html] [開始時刻] [終了時刻]")
 		return
 	}
-	defer udpConn.Close()
-
-	// 受信処理をゴルーチンで実行
-	go func() {
-		for {
-			buf := make([]byte, 4096)
-			n, err := udpConn.Read(buf)
-			if err != nil {
-				fmt.Println("サーバからの受信に失敗しました:", err)
-				return
-			}
-			formatReceiveMessage(buf[:n])
-		}
-	}()
-
-	// メインスレッドで送信処理を実行
-	reader = bufio.NewReader(os.Stdin)
-	for {
-		message := getUserInput(reader, userName+"> ")
-		if message == "/exit" {
-			fmt.Println("チャットを終了します")
-			break
+	args = append(args, make([]string, 3-len(args))...)
+
+	format := transcript.FormatMarkdown
+	if args[0] != "" {
+		var err error
+		if format, err = transcript.ParseFormat(args[0]); err != nil {
+			fmt.Println(err)
+			return
 		}
-		sendChat(udpConn, message, token, roomName)
 	}
-}
-
-// UDPでサーバーに接続する関数
-func connectToServerUDP() (*net.UDPConn, error) {
-	serverAddr, err := net.ResolveUDPAddr("udp", ":8089")
+	from, err := transcript.ParseTime(args[1], time.Local)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	to, err := transcript.ParseTime(args[2], time.Local)
 	if err != nil {
-		return nil, fmt.Errorf("UDPアドレスの解決に失敗しました: %v", err)
+		fmt.Println(err)
+		return
 	}
 
-	conn, err := net.DialUDP("udp", nil, serverAddr)
+	entries, truncated, err := c.Export(from, to)
 	if err != nil {
-		return nil, fmt.Errorf("UDPサーバーへの接続に失敗しました: %v", err)
+		fmt.Println("会話記録の取得に失敗しました:", err)
+		return
 	}
 
-	return conn, nil
+	now := time.Now()
+	path := fmt.Sprintf("%s-%s%s", strings.NewReplacer("/", "_", `\`, "_").Replace(c.RoomName()), now.Format("20060102-150405"), format.Extension())
+	if err := writeTranscript(path, format, transcript.Header{Room: c.RoomName(), From: from, To: to, ExportedAt: now}, entries); err != nil {
+		fmt.Println("会話記録の書き出しに失敗しました:", err)
+		return
+	}
+	fmt.Printf("%d件の会話記録を %s に書き出しました\n", len(entries), path)
+	if truncated {
+		fmt.Println("件数が上限に達したため、それ以降のメッセージは含まれていません。期間を指定して分けて書き出してください")
+	}
 }
 
-func sendChat(conn net.Conn, message string, token string, roomName string) {
-	// UDPメッセージのプロトコルに則ってデータを用意する
-	roomNameBytes := []byte(roomName)
-	tokenBytes := []byte(token)
-	messageBytes := []byte(message)
-
-	udpHeader := protocol.UDPHeader{
-		RoomNameSize: uint8(len(roomNameBytes)),
-		TokenSize:    uint8(len(tokenBytes)),
+// writeTranscript は会話記録をファイルに書き出します。
+func writeTranscript(path string, format transcript.Format, header transcript.Header, entries []transcript.Entry) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	// ボディ部分を構築: roomName + token + message の順
-	bodySize := len(roomNameBytes) + len(tokenBytes) + len(messageBytes)
-	body := make([]byte, bodySize)
-
-	// ルーム名をコピー
-	offset := 0
-	copy(body[offset:], roomNameBytes)
-	offset += len(roomNameBytes)
-
-	// トークンをコピー
-	copy(body[offset:], tokenBytes)
-	offset += len(tokenBytes)
-
-	// メッセージをコピー
-	copy(body[offset:], messageBytes)
-
-	// 送信データを構築（ヘッダー + ボディ）
-	data := make([]byte, 2+len(body))
-	data[0] = udpHeader.RoomNameSize
-	data[1] = udpHeader.TokenSize
-	copy(data[2:], body)
-
-	_, err := conn.Write(data)
+	w, err := transcript.NewWriter(f, format, header)
 	if err != nil {
-		fmt.Println("UDPサーバへの送信に失敗しました:", err)
-		return
+		return err
+	}
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
 	}
+	return f.Close()
 }
 
-func formatReceiveMessage(buf []byte) {
-	message := string(buf)
+func formatReceiveMessage(event client.Event) {
+	var message string
+	switch event.Type {
+	case client.EventError:
+		message = fmt.Sprintf("サーバからの受信に失敗しました: %v", event.Err)
+	case client.EventDirect:
+		// 自分だけに宛てられたメッセージは他のメッセージと区別して表示する
+		message = fmt.Sprintf("[DM ← %s] %s", event.Sender, event.Text)
+	case client.EventAction:
+		message = fmt.Sprintf("* %s %s", event.Sender, event.Text)
+	case client.EventRenamed:
+		message = "[サーバー]> " + event.Text
+	case client.EventHistory:
+		// 入室前のメッセージは送信された時刻を付けて表示する。/me の動作は動作として表示する
+		clock := event.Time.Local().Format("01/02 15:04")
+		if action, ok := strings.CutPrefix(event.Text, "/me "); ok {
+			message = fmt.Sprintf("[%s] * %s %s", clock, event.Sender, action)
+		} else {
+			message = fmt.Sprintf("[%s] %s> %s", clock, event.Sender, event.Text)
+		}
+	default:
+		message = event.Sender + "> " + event.Text
+	}
 
 	// 画面をクリアせずに、現在の入力行を消去して新しいメッセージを表示
 	fmt.Print("\r\033[K") // カーソルを行頭に移動して行をクリア
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"online_chat_messenger/internal/config"
+)
+
+// loadConfig はデフォルト値、設定ファイル、環境変数、コマンドライン引数の順に設定を読み込み、検証します。
+// 後方互換のため `server <TCPポート番号> <UDPポート番号>` の形式も受け付けます。
+func loadConfig(args []string) (config.Config, error) {
+	cfg := config.Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "使用法: server [オプション] [<TCPポート番号> <UDPポート番号>]")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "設定ファイル(JSON)のパス")
+	tcpPort := fs.Int("tcp-port", cfg.TCP.Port, "TCPポート番号")
+	tcpBind := fs.String("tcp-bind", cfg.TCP.BindAddress, "TCPで待ち受けるアドレス")
+	udpPort := fs.Int("udp-port", cfg.UDP.Port, "UDPポート番号")
+	udpBind := fs.String("udp-bind", cfg.UDP.BindAddress, "UDPで待ち受けるアドレス")
+	inactiveTimeout := fs.Duration("inactive-timeout", cfg.Timeouts.InactiveTimeout.Std(), "非アクティブなユーザーを削除するまでの時間")
+	maxRooms := fs.Int("max-rooms", cfg.Limits.MaxRooms, "ルーム数の上限（0は無制限）")
+	maxRoomsPerAddress := fs.Int("max-rooms-per-address", cfg.Limits.MaxRoomsPerAddress, "同じIPアドレスから作成できるルーム数の上限（0は無制限）")
+	emptyRoomTTL := fs.Duration("empty-room-ttl", cfg.Timeouts.EmptyRoomTTL.Std(), "空になったルームを削除するまでの時間（0は削除しない）")
+	maxRoomMembers := fs.Int("max-room-members", cfg.Limits.MaxRoomMembers, "1ルームあたりのメンバー数の上限（0は無制限）")
+	logLevel := fs.String("log-level", cfg.Log.Level, "ログレベル（debug, info, warn, error）")
+	logFormat := fs.String("log-format", cfg.Log.Format, "ログ形式（text, json）")
+	metricsAddr := fs.String("metrics-addr", cfg.Metrics.Address, "メトリクスを公開するHTTPのアドレス（空の場合は無効）")
+	adminAddr := fs.String("admin-addr", cfg.Admin.Address, "管理APIのHTTPのアドレス（空の場合は無効。トークンは設定ファイルか環境変数で指定）")
+	console := fs.Bool("console", cfg.Admin.Console, "標準入力から管理コマンドを受け付ける")
+	adminRPCSocket := fs.String("admin-rpc-socket", cfg.Admin.RPCSocket, "管理RPCのUnixドメインソケットのパス（空の場合は無効）")
+	storeDir := fs.String("store-dir", cfg.Store.Dir, "ルームとセッションを保存するディレクトリ（空の場合は保存しない）")
+	messageLogDir := fs.String("message-log-dir", cfg.MessageLog.Dir, "メッセージを記録するディレクトリ（空の場合は記録しない）")
+	accountsFile := fs.String("accounts-file", cfg.Accounts.File, "登録ユーザーのアカウントを保存するファイル（空の場合は再起動で消える）")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		if err := config.LoadFile(&cfg, *configPath); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := config.ApplyEnv(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	// 明示的に指定された引数で上書きする
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tcp-port":
+			cfg.TCP.Port = *tcpPort
+		case "tcp-bind":
+			cfg.TCP.BindAddress = *tcpBind
+		case "udp-port":
+			cfg.UDP.Port = *udpPort
+		case "udp-bind":
+			cfg.UDP.BindAddress = *udpBind
+		case "inactive-timeout":
+			cfg.Timeouts.InactiveTimeout = config.Duration(*inactiveTimeout)
+		case "max-rooms":
+			cfg.Limits.MaxRooms = *maxRooms
+		case "max-rooms-per-address":
+			cfg.Limits.MaxRoomsPerAddress = *maxRoomsPerAddress
+		case "empty-room-ttl":
+			cfg.Timeouts.EmptyRoomTTL = config.Duration(*emptyRoomTTL)
+		case "max-room-members":
+			cfg.Limits.MaxRoomMembers = *maxRoomMembers
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "metrics-addr":
+			cfg.Metrics.Address = *metricsAddr
+		case "admin-addr":
+			cfg.Admin.Address = *adminAddr
+		case "console":
+			cfg.Admin.Console = *console
+		case "admin-rpc-socket":
+			cfg.Admin.RPCSocket = *adminRPCSocket
+		case "store-dir":
+			cfg.Store.Dir = *storeDir
+		case "message-log-dir":
+			cfg.MessageLog.Dir = *messageLogDir
+		case "accounts-file":
+			cfg.Accounts.File = *accountsFile
+		}
+	})
+
+	// 位置引数によるポート指定
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		tcp, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return cfg, fmt.Errorf("TCPポート番号が不正です: %s", fs.Arg(0))
+		}
+		udp, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return cfg, fmt.Errorf("UDPポート番号が不正です: %s", fs.Arg(1))
+		}
+		cfg.TCP.Port, cfg.UDP.Port = tcp, udp
+	default:
+		fs.Usage()
+		return cfg, fmt.Errorf("引数の数が不正です")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/config"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	data := `{"tcp": {"port": 9001}, "udp": {"port": 9002}, "limits": {"max_rooms": 3}, "log": {"level": "warn"}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(config.EnvUDPPort, "9102")
+	t.Setenv(config.EnvMaxRooms, "4")
+	t.Setenv(config.EnvLogLevel, "error")
+
+	tests := []struct {
+		name          string
+		args          []string
+		tcp, udp, max int
+		level         string
+	}{
+		{"環境変数が設定ファイルより優先される", []string{"-config", path}, 9001, 9102, 4, "error"},
+		{"引数が環境変数より優先される", []string{"-config", path, "-max-rooms", "5", "-log-level", "debug"}, 9001, 9102, 5, "debug"},
+		{"位置引数のポート番号が最も優先される", []string{"-config", path, "-tcp-port", "9201", "9301", "9302"}, 9301, 9302, 4, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.TCP.Port != tt.tcp || cfg.UDP.Port != tt.udp || cfg.Limits.MaxRooms != tt.max || cfg.Log.Level != tt.level {
+				t.Errorf("tcp %d, udp %d, max_rooms %d, level %s, want %d, %d, %d, %s",
+					cfg.TCP.Port, cfg.UDP.Port, cfg.Limits.MaxRooms, cfg.Log.Level, tt.tcp, tt.udp, tt.max, tt.level)
+			}
+			// どこでも指定していない項目はデフォルト値
+			if cfg.Timeouts.InactiveTimeout.Std() != 5*time.Minute {
+				t.Errorf("InactiveTimeout = %v", cfg.Timeouts.InactiveTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"位置引数が1つ", []string{"9001"}, "引数の数が不正です"},
+		{"ポート番号が数値でない", []string{"tcp", "9002"}, "TCPポート番号が不正です"},
+		{"検証に失敗する", []string{"-inactive-timeout", "1s"}, "timeouts.cleanup_interval"},
+		{"設定ファイルがない", []string{"-config", filepath.Join(t.TempDir(), "missing.json")}, "missing.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConfig(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig(%q) = %v, want an error about %s", tt.args, err, tt.want)
+			}
+		})
+	}
+}
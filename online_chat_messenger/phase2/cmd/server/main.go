@@ -1,56 +1,413 @@
 package main
 
 import (
+	"container/heap"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"online_chat_messenger/internal/admin"
 	"online_chat_messenger/internal/auth"
 	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/config"
+	"online_chat_messenger/internal/logging"
+	"online_chat_messenger/internal/metrics"
+	"online_chat_messenger/internal/msglog"
 	"online_chat_messenger/internal/network"
+	"online_chat_messenger/internal/protocol"
+	"online_chat_messenger/internal/search"
+	"online_chat_messenger/internal/store"
+)
+
+const (
+	// tokenKeyFileName は設定に鍵がない場合に使うトークンの鍵を、状態の保存先に保存するファイル名です。
+	tokenKeyFileName = "token.key"
+	// fallbackTokenKeyID は設定に鍵がない場合に使うトークンの鍵の識別子です。
+	fallbackTokenKeyID = "auto"
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("使用法: server <TCPポート番号> <UDPポート番号>")
+	args := os.Args[1:]
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Printf("設定の読み込みに失敗しました:\n%v\n", err)
+		os.Exit(2)
+	}
+	if err := run(cfg, args); err != nil {
 		os.Exit(1)
 	}
-	tcpPort := os.Args[1]
-	udpPort := os.Args[2]
+}
+
+// run はサーバーを起動し、停止するまで待ちます。
+// 終了時に保存先やメッセージログを閉じる処理を必ず実行するため、エラーはos.Exitせずにログに出力してから返します。
+func run(cfg config.Config, args []string) error {
+	// 管理コンソールが端末で動く場合は、入力中の行を崩さないようにログもコンソールに出力する
+	var console *admin.Console
+	var logOutput io.Writer = os.Stderr
+	if cfg.Admin.Console {
+		console = admin.NewConsole(os.Stdin, os.Stdout)
+		defer console.Close()
+		if console.IsTerminal() {
+			logOutput = console
+		}
+	}
+
+	// ロガーの初期化（レベルは再読み込みで変更できる）
+	logLevel := new(slog.LevelVar)
+	logger, err := logging.New(logOutput, logLevel, cfg.Log.Format)
+	if err != nil {
+		fmt.Printf("ロガーの初期化に失敗しました: %v\n", err)
+		return err
+	}
+	slog.SetDefault(logger)
+	protocol.SetLogger(logger)
 
 	roomManager := chat.NewSimpleRoomManager()
-	userManager := auth.NewSimpleUserManager()
-	simpleUserManager := userManager // 型アサーションが不要になる
+	roomManager.SetLogger(logger)
+	defer roomManager.Close()
+	userManager := auth.NewSimpleUserManagerWithTimeout(cfg.Timeouts.InactiveTimeout.Std(), cfg.Timeouts.CleanupInterval.Std())
+	userManager.SetLogger(logger)
+	defer userManager.Close()
 
 	// ルームマネージャーをユーザーマネージャーに設定
-	simpleUserManager.SetRoomManager(roomManager)
+	userManager.SetRoomManager(roomManager)
+
+	// 保存されていたルームとセッションの復元（設定されている場合のみ）
+	var stateStore store.Store
+	var revoked map[string]time.Time
+	if cfg.Store.Dir != "" {
+		fileStore, err := store.OpenFileStore(cfg.Store.Dir, cfg.Store.SnapshotInterval.Std())
+		if err != nil {
+			logger.Error("保存先を開けませんでした", "dir", cfg.Store.Dir, "error", err)
+			return err
+		}
+		fileStore.SetLogger(logger)
+		defer fileStore.Close()
+
+		state, err := fileStore.Load()
+		if err != nil {
+			logger.Error("保存した状態の読み込みに失敗しました", "error", err)
+			return err
+		}
+		// 復元したルームとセッションを再び保存しないよう、復元してから保存先を設定する
+		roomManager.Restore(state.Rooms)
+		sessions := userManager.Restore(state.Sessions)
+		roomManager.SetStore(fileStore)
+		userManager.SetStore(fileStore)
+		// セッションはバックグラウンドで書き込むため、保存先を閉じる前に残りを書き込む
+		defer userManager.Flush()
+		stateStore, revoked = fileStore, state.Revoked
+		logger.Info("保存した状態を復元しました", "dir", cfg.Store.Dir, "rooms", len(state.Rooms), "sessions", sessions)
+	}
+
+	// メッセージの記録（設定されている場合のみ）
+	var messageLog *msglog.Log
+	if cfg.MessageLog.Dir != "" {
+		messageLog, err = msglog.Open(cfg.MessageLog.Dir, cfg.MessageLog.SegmentSize, cfg.MessageLog.CompactInterval.Std())
+		if err != nil {
+			logger.Error("メッセージログを開けませんでした", "dir", cfg.MessageLog.Dir, "error", err)
+			return err
+		}
+		messageLog.SetLogger(logger)
+		defer messageLog.Close()
+	}
+
+	// メッセージの全文検索インデックス（メッセージログがあればそこから構築する）
+	searchIndex := search.NewIndex(cfg.Chat.Search.MaxDocuments)
+	if messageLog != nil {
+		buildSearchIndex(searchIndex, messageLog, cfg.Chat.Search.MaxDocuments, logger)
+	}
+
+	// 接続を拒否するアドレスの一覧（管理APIから変更する）
+	banList := auth.NewBanList()
+
+	// 登録ユーザーのアカウント（ファイルが指定されていない場合はメモリ上に保持する）
+	var accountStore auth.AccountStore = auth.NewMemoryAccountStore()
+	if cfg.Accounts.File != "" {
+		accountStore, err = auth.OpenFileAccountStore(cfg.Accounts.File)
+		if err != nil {
+			logger.Error("アカウントのファイルを開けませんでした", "file", cfg.Accounts.File, "error", err)
+			return err
+		}
+	}
+	accounts := auth.NewAccounts(accountStore)
+
+	// ルームのトークンの署名（設定に鍵がない場合に使う鍵は、状態の保存が有効なら保存先に残して再起動後も使う）
+	var fallbackKey auth.TokenKey
+	if cfg.Store.Dir != "" {
+		fallbackKey, err = auth.LoadOrCreateTokenKey(filepath.Join(cfg.Store.Dir, tokenKeyFileName), fallbackTokenKeyID)
+	} else {
+		fallbackKey, err = auth.GenerateTokenKey(fallbackTokenKeyID)
+	}
+	if err != nil {
+		logger.Error("トークンの鍵を用意できませんでした", "error", err)
+		return err
+	}
+	tokenSigner, err := auth.NewTokenSigner([]auth.TokenKey{fallbackKey})
+	if err != nil {
+		logger.Error("トークンの鍵を用意できませんでした", "error", err)
+		return err
+	}
+	// 退出させたユーザーのトークンが再起動後に使えるようにならないよう、取り消しの一覧も復元して保存する
+	if stateStore != nil {
+		restored := tokenSigner.Restore(revoked)
+		tokenSigner.SetStore(stateStore)
+		logger.Info("取り消したトークンを復元しました", "revoked", restored)
+	}
 
 	// TCPサーバーの初期化
-	tcpServer, err := network.NewTCPServer(tcpPort, roomManager, userManager)
+	listener, err := net.Listen("tcp", cfg.TCP.Addr())
 	if err != nil {
-		fmt.Printf("TCPサーバーの起動に失敗しました: %v\n", err)
-		os.Exit(1)
+		logger.Error("TCPサーバーの起動に失敗しました", "error", err)
+		return err
 	}
+	tcpServer := network.NewTCPServerWithListener(listener, roomManager, userManager)
+	tcpServer.SetLogger(logger)
+	tcpServer.SetBanList(banList)
+	tcpServer.SetSearchIndex(searchIndex)
+	tcpServer.SetAccounts(accounts)
+	tcpServer.SetTokenSigner(tokenSigner)
 	defer tcpServer.Close()
 
-	// UDPサーバーの初期化（同じポートを使用）
-	udpServer, err := network.NewUDPServer(udpPort, roomManager, userManager)
+	// UDPサーバーの初期化
+	packetConn, err := net.ListenPacket("udp", cfg.UDP.Addr())
 	if err != nil {
-		fmt.Printf("UDPサーバーの起動に失敗しました: %v\n", err)
-		os.Exit(1)
+		logger.Error("UDPサーバーの起動に失敗しました", "error", err)
+		return err
+	}
+	udpServer := network.NewUDPServerWithConn(packetConn, roomManager, userManager)
+	udpServer.SetLogger(logger)
+	udpServer.SetSearchIndex(searchIndex)
+	udpServer.SetAccounts(accounts)
+	udpServer.SetTokenSigner(tokenSigner)
+	if messageLog != nil {
+		tcpServer.SetMessageLog(messageLog)
+		udpServer.SetMessageLog(messageLog)
 	}
 	defer udpServer.Close()
 
-	// UDPサーバーを別のゴルーチンで起動
+	// メトリクスの公開（設定されている場合のみ）
+	if cfg.Metrics.Address != "" {
+		serverMetrics := metrics.New(roomManager)
+		tcpServer.SetMetrics(serverMetrics)
+		udpServer.SetMetrics(serverMetrics)
+
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", serverMetrics.Handler())
+		if err := startHTTPServer("メトリクスサーバー", cfg.Metrics.Address, mux, logger); err != nil {
+			logger.Error("メトリクスサーバーの起動に失敗しました", "error", err)
+			return err
+		}
+	}
+
+	// 管理APIの公開（設定されている場合のみ）
+	adminService := admin.NewService(roomManager, userManager, udpServer)
+	adminService.SetLogger(logger)
+	adminService.SetBanList(banList)
+	adminService.SetTokenSigner(tokenSigner)
+	if messageLog != nil {
+		adminService.SetMessageLog(messageLog)
+	}
+
+	var adminHandler *admin.HTTPHandler
+	if cfg.Admin.Address != "" {
+		adminHandler = admin.NewHTTPHandler(adminService, cfg.Admin.Token)
+		adminHandler.SetLogger(logger)
+		if err := startHTTPServer("管理APIサーバー", cfg.Admin.Address, adminHandler, logger); err != nil {
+			logger.Error("管理APIサーバーの起動に失敗しました", "error", err)
+			return err
+		}
+	}
+
+	if cfg.Admin.RPCSocket != "" {
+		rpcListener, err := admin.ListenUnix(cfg.Admin.RPCSocket)
+		if err != nil {
+			logger.Error("管理RPCサーバーの起動に失敗しました", "error", err)
+			return err
+		}
+		rpcServer := admin.NewRPCServer(adminService, rpcListener)
+		rpcServer.SetLogger(logger)
+		defer rpcServer.Close()
+		go func() {
+			if err := rpcServer.Serve(); err != nil {
+				logger.Error("管理RPCサーバーの実行中にエラーが発生しました", "error", err)
+			}
+		}()
+	}
+
+	// 標準入力の管理コンソール（設定されている場合のみ）
+	if console != nil {
+		console.SetService(adminService)
+		go func() {
+			err := console.Run()
+			switch {
+			case errors.Is(err, admin.ErrShutdown):
+				logger.Info("コンソールからサーバーを停止します")
+				tcpServer.Close()
+			case err != nil:
+				logger.Error("管理コンソールの実行中にエラーが発生しました", "error", err)
+			}
+		}()
+	}
+
+	app := &app{
+		cfg:         cfg,
+		logger:      logger,
+		logLevel:    logLevel,
+		roomManager: roomManager,
+		userManager: userManager,
+		tcpServer:   tcpServer,
+		udpServer:   udpServer,
+		searchIndex: searchIndex,
+		accounts:    accounts,
+		tokens:      tokenSigner,
+		fallbackKey: fallbackKey,
+
+		adminHandler: adminHandler,
+		messageLog:   messageLog,
+	}
+	app.apply(cfg)
+
+	// 復元したルームの履歴をメッセージログから読み込む
+	if messageLog != nil {
+		restoreHistory(roomManager, messageLog, cfg.Chat.History.MaxMessages, logger)
+	}
+
+	// SIGINTとSIGTERMではコンソールのexitと同じくTCPサーバーを閉じ、runから戻って終了処理を実行する
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		sig := <-stop
+		logger.Info("シグナルを受け取ったためサーバーを停止します", "signal", sig.String())
+		tcpServer.Close()
+	}()
+
+	// SIGHUPで設定を再読み込みする
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			app.reload(args)
+		}
+	}()
+
+	// UDPサーバーを別のゴルーチンで起動（エラーで止まった場合はTCPサーバーも停止する）
+	udpErr := make(chan error, 1)
 	go func() {
 		if err := udpServer.Start(); err != nil {
-			fmt.Printf("UDPサーバーの実行中にエラーが発生しました: %v\n", err)
-			os.Exit(1)
+			logger.Error("UDPサーバーの実行中にエラーが発生しました", "error", err)
+			udpErr <- err
+			tcpServer.Close()
 		}
 	}()
 
 	// TCPサーバーをメインゴルーチンで起動
 	if err := tcpServer.Start(); err != nil {
-		fmt.Printf("TCPサーバーの実行中にエラーが発生しました: %v\n", err)
-		os.Exit(1)
+		logger.Error("TCPサーバーの実行中にエラーが発生しました", "error", err)
+		return err
+	}
+	select {
+	case err := <-udpErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// restoreHistory はメッセージログから各ルームの直近のメッセージを読み込み、ルームの履歴に追加します。
+func restoreHistory(roomManager *chat.SimpleRoomManager, messageLog *msglog.Log, n int, logger *slog.Logger) {
+	for _, room := range roomManager.GetAllRooms() {
+		simpleRoom, ok := room.(*chat.SimpleRoom)
+		if !ok {
+			continue
+		}
+		records, err := messageLog.Last(room.GetName(), n)
+		if err != nil {
+			logger.Warn("履歴の読み込みに失敗しました", "room", room.GetName(), "error", err)
+			continue
+		}
+		for _, record := range records {
+			if record.System {
+				continue
+			}
+			simpleRoom.AddMessage(chat.Message{Sender: record.Sender, Text: record.Text, Time: record.Time})
+		}
+	}
+}
+
+// buildSearchIndex はメッセージログに記録されたメッセージのうち、新しいものから最大maxDocuments件を時刻順に検索インデックスに登録します。
+// maxDocumentsが0以下の場合は search.NewIndex と同じく無制限として、すべてのメッセージを登録します。
+// ログ全体を読み込まないよう、ルームをまたいで新しいものだけを件数の上限まで残しながら読み進めます。
+func buildSearchIndex(index *search.Index, messageLog *msglog.Log, maxDocuments int, logger *slog.Logger) {
+	rooms, err := messageLog.Rooms()
+	if err != nil {
+		logger.Warn("検索インデックスの構築に失敗しました", "error", err)
+		return
+	}
+
+	newest := &recordHeap{}
+	for _, room := range rooms {
+		err := messageLog.Scan(room, time.Time{}, time.Time{}, func(record msglog.Record) bool {
+			if record.System {
+				return true
+			}
+			// 上限に達している場合は、残しているうちで最も古いものより新しいメッセージだけを入れ替える
+			// （上限は1以上なので、入れ替えるときはヒープが空ではない）
+			if maxDocuments <= 0 || newest.Len() < maxDocuments {
+				heap.Push(newest, record)
+			} else if record.Time.After((*newest)[0].Time) {
+				(*newest)[0] = record
+				heap.Fix(newest, 0)
+			}
+			return true
+		})
+		if err != nil {
+			logger.Warn("検索インデックスの構築に失敗しました", "room", room, "error", err)
+		}
+	}
+
+	// ルームをまたいで古い順に登録する
+	for newest.Len() > 0 {
+		record := heap.Pop(newest).(msglog.Record)
+		index.Add(record.Room, record.Sender, record.Text, record.Time)
 	}
+	logger.Info("検索インデックスを構築しました", "documents", index.Len())
+}
+
+// recordHeap は時刻が最も古いレコードを先頭にするヒープです。
+type recordHeap []msglog.Record
+
+func (h recordHeap) Len() int           { return len(h) }
+func (h recordHeap) Less(i, j int) bool { return h[i].Time.Before(h[j].Time) }
+func (h recordHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *recordHeap) Push(x any)        { *h = append(*h, x.(msglog.Record)) }
+func (h *recordHeap) Pop() any {
+	old := *h
+	record := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return record
+}
+
+// startHTTPServer は指定したアドレスで待ち受け、別のゴルーチンでHTTPサーバーを起動します。
+func startHTTPServer(name, addr string, handler http.Handler, logger *slog.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		logger.Info(name+"を起動しました", "addr", listener.Addr().String())
+		if err := http.Serve(listener, handler); err != nil {
+			logger.Error(name+"の実行中にエラーが発生しました", "error", err)
+		}
+	}()
+	return nil
 }
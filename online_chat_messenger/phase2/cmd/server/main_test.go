@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/msglog"
+	"online_chat_messenger/internal/search"
+)
+
+func TestBuildSearchIndex(t *testing.T) {
+	messageLog, err := msglog.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer messageLog.Close()
+
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, record := range []msglog.Record{
+		{Room: "lobby", Sender: "taro", Text: "一番目の雨", Time: base},
+		{Room: "dev", Sender: "jiro", Text: "二番目の雨", Time: base.Add(time.Minute)},
+		{Room: "lobby", Sender: "", Text: "taroが退出しました", Time: base.Add(2 * time.Minute), System: true},
+		{Room: "lobby", Sender: "hanako", Text: "三番目の雨", Time: base.Add(3 * time.Minute)},
+	} {
+		if _, err := messageLog.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		maxDocuments int
+		want         []string
+	}{
+		{"上限より少ない", 10, []string{"三番目の雨", "二番目の雨", "一番目の雨"}},
+		{"新しいものだけを残す", 2, []string{"三番目の雨", "二番目の雨"}},
+		{"上限が1", 1, []string{"三番目の雨"}},
+		{"上限が0は無制限", 0, []string{"三番目の雨", "二番目の雨", "一番目の雨"}},
+		{"上限が負は無制限", -1, []string{"三番目の雨", "二番目の雨", "一番目の雨"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := search.NewIndex(tt.maxDocuments)
+			buildSearchIndex(index, messageLog, tt.maxDocuments, logger)
+
+			var got []string
+			for _, doc := range index.Search(search.Query{Text: "雨"}) {
+				got = append(got, doc.Text)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Search(雨) = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Search(雨) = %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"online_chat_messenger/internal/admin"
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/config"
+	"online_chat_messenger/internal/logging"
+	"online_chat_messenger/internal/msglog"
+	"online_chat_messenger/internal/network"
+	"online_chat_messenger/internal/search"
+)
+
+// app は実行中のサーバーの構成要素と現在の設定を保持します。
+type app struct {
+	cfg      config.Config
+	mutex    sync.Mutex
+	logger   *slog.Logger
+	logLevel *slog.LevelVar
+
+	roomManager *chat.SimpleRoomManager
+	userManager *auth.SimpleUserManager
+	tcpServer   *network.TCPServer
+	udpServer   *network.UDPServer
+	searchIndex *search.Index
+	accounts    *auth.Accounts
+	tokens      *auth.TokenSigner
+	fallbackKey auth.TokenKey // 設定に鍵がない場合に使うトークンの鍵
+
+	adminHandler *admin.HTTPHandler // 管理APIが無効の場合はnil
+	messageLog   *msglog.Log        // メッセージの記録が無効の場合はnil
+}
+
+// apply は実行中に変更できる設定を各構成要素に反映します。
+func (a *app) apply(cfg config.Config) {
+	if level, err := logging.ParseLevel(cfg.Log.Level); err == nil {
+		a.logLevel.Set(level)
+	}
+	a.roomManager.SetLimits(cfg.Limits.MaxRooms, cfg.Limits.MaxRoomsPerAddress, cfg.Limits.MaxRoomMembers)
+	a.roomManager.SetEmptyRoomTTL(cfg.Timeouts.EmptyRoomTTL.Std(), cfg.Timeouts.CleanupInterval.Std())
+	a.roomManager.SetHistoryLimits(cfg.Chat.History.MaxMessages, cfg.Chat.History.MaxAge.Std())
+	a.userManager.SetTimeouts(cfg.Timeouts.InactiveTimeout.Std(), cfg.Timeouts.CleanupInterval.Std())
+	a.tcpServer.SetMaxMessageSize(cfg.Limits.MaxTCPMessageSize)
+	a.tcpServer.SetReadTimeout(cfg.Timeouts.TCPReadTimeout.Std())
+	a.tcpServer.SetMOTD(cfg.Chat.MOTD)
+	a.udpServer.SetMaxPacketSize(cfg.Limits.MaxUDPPacketSize)
+	a.udpServer.SetRateLimit(cfg.Chat.RateLimit.MessagesPerSecond, cfg.Chat.RateLimit.Burst)
+	a.udpServer.SetBannedWords(cfg.Chat.BannedWords)
+	a.udpServer.SetHistoryReplay(cfg.Chat.History.Replay)
+	a.searchIndex.SetMaxDocuments(cfg.Chat.Search.MaxDocuments)
+	// サーバーからのお知らせの送信者名は、なりすましを防ぐため常に予約する
+	a.accounts.SetReservedNames(append([]string{network.SystemSenderName}, cfg.Accounts.ReservedNames...))
+	a.accounts.SetPolicy(cfg.Accounts.PBKDF2Iterations, cfg.Accounts.MinPasswordLength, cfg.Accounts.LoginTTL.Std())
+	a.roomManager.SetPasswordIterations(cfg.Accounts.PBKDF2Iterations)
+	if err := a.tokens.SetKeys(a.tokenKeys(cfg.Tokens)); err != nil {
+		a.logger.Error("トークンの鍵を設定できませんでした。現在の鍵を使い続けます", "error", err)
+	}
+	a.tokens.SetTTL(cfg.Tokens.TTL.Std())
+	if a.adminHandler != nil {
+		a.adminHandler.SetToken(cfg.Admin.Token)
+	}
+	if a.messageLog != nil {
+		overrides := make(map[string]msglog.Retention, len(cfg.MessageLog.Rooms))
+		for room, r := range cfg.MessageLog.Rooms {
+			overrides[room] = msglog.Retention{MaxAge: r.MaxAge.Std(), MaxBytes: r.MaxBytes}
+		}
+		a.messageLog.SetRetention(msglog.Retention{MaxAge: cfg.MessageLog.MaxAge.Std(), MaxBytes: cfg.MessageLog.MaxBytes}, overrides)
+	}
+}
+
+// tokenKeys は設定のトークンの鍵を、署名に使う順に並べて返します。設定に鍵がない場合は起動時に用意した鍵を使います。
+func (a *app) tokenKeys(cfg config.TokenConfig) []auth.TokenKey {
+	if len(cfg.Keys) == 0 {
+		return []auth.TokenKey{a.fallbackKey}
+	}
+	keys := make([]auth.TokenKey, 0, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		// 設定は読み込み時に検証済みのため、読み取れない鍵はない
+		tokenKey, err := key.TokenKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, tokenKey)
+	}
+	return keys
+}
+
+// reload は設定を読み込み直し、実行中に変更できる項目を反映します。
+// ポート番号など再起動が必要な項目の変更は適用せずにログに出力します。
+func (a *app) reload(args []string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.logger.Info("設定を再読み込みします")
+	next, err := loadConfig(args)
+	if err != nil {
+		a.logger.Error("設定の再読み込みに失敗しました。現在の設定を維持します", "error", err)
+		return
+	}
+
+	applied, changes := config.Reload(a.cfg, next)
+	if len(changes) == 0 {
+		a.logger.Info("設定に変更はありませんでした")
+		return
+	}
+
+	for _, change := range changes {
+		if change.RequiresRestart {
+			a.logger.Warn("再起動が必要なため適用しませんでした", "field", change.Field, "old", change.Old, "new", change.New)
+		} else {
+			a.logger.Info("設定を変更しました", "field", change.Field, "old", change.Old, "new", change.New)
+		}
+	}
+
+	a.apply(applied)
+	a.cfg = applied
+}
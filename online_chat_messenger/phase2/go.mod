@@ -3,7 +3,9 @@ module online_chat_messenger
 go 1.24.0
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/term v0.29.0 // indirect
+	github.com/google/uuid v1.6.0
+	golang.org/x/term v0.29.0
+	golang.org/x/text v0.22.0
 )
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"online_chat_messenger/internal/transcript"
+)
+
+// APIError は管理APIがエラーを返したことを表します。
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("管理APIがエラーを返しました (%d): %s", e.StatusCode, e.Message)
+}
+
+// Client は管理HTTP APIのクライアントです。
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient は新しいClientを生成します。baseURLは "http://127.0.0.1:9091" のような管理APIのURLです。
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ListRooms はルームの一覧を取得します。
+func (c *Client) ListRooms(ctx context.Context) ([]RoomInfo, error) {
+	var response RoomsResponse
+	err := c.do(ctx, http.MethodGet, "/api/rooms", nil, &response)
+	return response.Rooms, err
+}
+
+// ListUsers はルームのメンバーの一覧を取得します。
+func (c *Client) ListUsers(ctx context.Context, room string) ([]UserInfo, error) {
+	var response UsersResponse
+	err := c.do(ctx, http.MethodGet, "/api/rooms/"+url.PathEscape(room)+"/users", nil, &response)
+	return response.Users, err
+}
+
+// Kick はユーザーをルームから退出させます。
+func (c *Client) Kick(ctx context.Context, room, user string) (KickResponse, error) {
+	var response KickResponse
+	err := c.do(ctx, http.MethodDelete, "/api/rooms/"+url.PathEscape(room)+"/users/"+url.PathEscape(user), nil, &response)
+	return response, err
+}
+
+// Ban はユーザーをルームから退出させ、接続元のアドレスからの接続を拒否します。
+func (c *Client) Ban(ctx context.Context, room, user string) (KickResponse, error) {
+	var response KickResponse
+	err := c.do(ctx, http.MethodPost, "/api/rooms/"+url.PathEscape(room)+"/users/"+url.PathEscape(user)+"/ban", nil, &response)
+	return response, err
+}
+
+// ListBans は接続を拒否しているアドレスの一覧を取得します。
+func (c *Client) ListBans(ctx context.Context) (BansResponse, error) {
+	var response BansResponse
+	err := c.do(ctx, http.MethodGet, "/api/bans", nil, &response)
+	return response, err
+}
+
+// Unban は接続の拒否を解除します。
+func (c *Client) Unban(ctx context.Context, address string) error {
+	return c.do(ctx, http.MethodDelete, "/api/bans/"+url.PathEscape(address), nil, nil)
+}
+
+// CloseRoom はルームを閉じます。
+func (c *Client) CloseRoom(ctx context.Context, room string) (CloseRoomResponse, error) {
+	var response CloseRoomResponse
+	err := c.do(ctx, http.MethodDelete, "/api/rooms/"+url.PathEscape(room), nil, &response)
+	return response, err
+}
+
+// Announce はお知らせを送信します。roomが空の場合はすべてのルームに送信します。
+func (c *Client) Announce(ctx context.Context, room, message string) (AnnounceResponse, error) {
+	var response AnnounceResponse
+	err := c.do(ctx, http.MethodPost, "/api/announcements", AnnounceRequest{Room: room, Message: message}, &response)
+	return response, err
+}
+
+// Export はルームの会話記録をwに書き出します。fromとtoはゼロ値の場合は制限しません。
+func (c *Client) Export(ctx context.Context, room string, format transcript.Format, from, to time.Time, w io.Writer) error {
+	query := url.Values{"format": {string(format)}}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	return c.do(ctx, http.MethodGet, "/api/rooms/"+url.PathEscape(room)+"/export?"+query.Encode(), nil, w)
+}
+
+// Stats はサーバーの統計情報を取得します。
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var response Stats
+	err := c.do(ctx, http.MethodGet, "/api/stats", nil, &response)
+	return response, err
+}
+
+// do はリクエストを送信し、レスポンスのJSONをoutにデコードします。outがio.Writerの場合はレスポンスをそのまま書き込みます。
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("リクエストのエンコードに失敗しました: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("リクエストの作成に失敗しました: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("管理APIへの接続に失敗しました: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var apiErr errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			apiErr.Error = response.Status
+		}
+		return &APIError{StatusCode: response.StatusCode, Message: apiErr.Error}
+	}
+
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		if _, err := io.Copy(w, response.Body); err != nil {
+			return fmt.Errorf("レスポンスの受信に失敗しました: %w", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("レスポンスのデコードに失敗しました: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package admin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"online_chat_messenger/internal/transcript"
+)
+
+// ErrShutdown はコンソールからサーバーの停止が要求されたことを表します。
+var ErrShutdown = errors.New("shutdown requested")
+
+const consolePrompt = "chat> "
+
+// consoleCommand はコンソールのコマンドを表します。
+type consoleCommand struct {
+	usage string
+	help  string
+	args  int // 必要な引数の最小の数
+	run   func(c *Console, args []string) error
+}
+
+var consoleCommands = map[string]consoleCommand{
+	"rooms":  {"rooms", "ルームの一覧を表示します", 0, (*Console).runRooms},
+	"users":  {"users <room>", "ルームのメンバーを表示します", 1, (*Console).runUsers},
+	"kick":   {"kick <room> <user>", "ユーザーをルームから退出させます", 2, (*Console).runKick},
+	"say":    {"say <room> <text>", "ルームにお知らせを送信します", 2, (*Console).runSay},
+	"close":  {"close <room>", "全メンバーを退出させてルームを閉じます", 1, (*Console).runClose},
+	"export": {"export <room> <jsonl|markdown|html> <file> [from] [to]", "会話記録をサーバーのファイルに書き出します", 3, (*Console).runExport},
+	"stats":  {"stats", "サーバーの統計情報を表示します", 0, (*Console).runStats},
+	"exit":   {"exit", "サーバーを停止します", 0, func(*Console, []string) error { return ErrShutdown }},
+}
+
+// helpはコマンドの一覧を参照するため、初期化の循環を避けてinitで登録する
+func init() {
+	consoleCommands["help"] = consoleCommand{"help", "コマンドの一覧を表示します", 0, (*Console).runHelp}
+}
+
+// consoleCommandOrder はヘルプに表示するコマンドの順番です。
+var consoleCommandOrder = []string{"rooms", "users", "kick", "say", "close", "export", "stats", "help", "exit"}
+
+// Console はサーバーの標準入力から管理コマンドを受け付ける対話型のコンソールです。
+// 入力が端末の場合は行編集とルーム名・ユーザー名のタブ補完が使えます。
+type Console struct {
+	service *Service
+
+	in       *os.File
+	out      io.Writer
+	terminal *term.Terminal // 入力が端末でない場合はnil
+	restore  func()         // 端末の状態を元に戻す
+	mutex    sync.Mutex     // restoreと、端末でない場合の出力を保護する
+}
+
+// NewConsole は新しいConsoleを生成します。inが端末の場合、Runの実行中は端末をrawモードに切り替えます。
+func NewConsole(in, out *os.File) *Console {
+	c := &Console{in: in, out: out, restore: func() {}}
+
+	if term.IsTerminal(int(in.Fd())) {
+		c.terminal = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{in, out}, consolePrompt)
+		c.terminal.AutoCompleteCallback = c.complete
+	}
+	return c
+}
+
+// SetService はコマンドの実行に使うServiceを設定します。Runより前に呼び出してください。
+func (c *Console) SetService(service *Service) {
+	c.service = service
+}
+
+// IsTerminal は入力が端末かどうかを返します。
+func (c *Console) IsTerminal() bool {
+	return c.terminal != nil
+}
+
+// Write はプロンプトと入力中の行を崩さずに出力します。ログの出力先として使えます。
+func (c *Console) Write(p []byte) (int, error) {
+	if c.terminal != nil {
+		return c.terminal.Write(p)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.out.Write(p)
+}
+
+// Close は端末の状態を元に戻します。
+func (c *Console) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.restore()
+	c.restore = func() {}
+	return nil
+}
+
+// Run は入力が終わるまでコマンドを読み込んで実行します。
+// exitコマンド、または端末でCtrl-C・Ctrl-Dが入力された場合はErrShutdownを返します。
+// 端末でない入力が終わった場合はnilを返します。
+func (c *Console) Run() error {
+	if c.terminal == nil {
+		scanner := bufio.NewScanner(c.in)
+		for scanner.Scan() {
+			if err := c.Execute(scanner.Text()); errors.Is(err, ErrShutdown) {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	fd := int(c.in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("端末をrawモードに切り替えられませんでした: %w", err)
+	}
+	c.mutex.Lock()
+	c.restore = func() { term.Restore(fd, state) }
+	c.mutex.Unlock()
+	defer c.Close()
+
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		c.terminal.SetSize(width, height)
+	}
+
+	for {
+		line, err := c.terminal.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return ErrShutdown
+			}
+			return err
+		}
+		if err := c.Execute(line); errors.Is(err, ErrShutdown) {
+			return err
+		}
+	}
+}
+
+// Execute は1行のコマンドを実行し、結果を出力します。
+// 引数に空白を含める場合はダブルクォートで囲みます（例: users "my room"）。
+func (c *Console) Execute(line string) error {
+	args, _ := splitArgs(line)
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd, ok := consoleCommands[args[0]]
+	if !ok {
+		c.printf("不明なコマンドです: %s（help でコマンドの一覧を表示します）\n", args[0])
+		return nil
+	}
+	if len(args)-1 < cmd.args {
+		c.printf("使用法: %s\n", cmd.usage)
+		return nil
+	}
+
+	err := cmd.run(c, args[1:])
+	if err != nil && !errors.Is(err, ErrShutdown) {
+		c.printf("エラー: %v\n", err)
+	}
+	return err
+}
+
+func (c *Console) runRooms(args []string) error {
+	c.table(func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "ROOM\tMEMBERS\tCREATOR\tCREATED\tTOPIC")
+		for _, room := range c.service.ListRooms() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", room.Name, room.MembersString(), room.Creator, room.CreatedAt.Local().Format(time.DateTime), room.Topic)
+		}
+	})
+	return nil
+}
+
+func (c *Console) runUsers(args []string) error {
+	users, err := c.service.ListUsers(args[0])
+	if err != nil {
+		return err
+	}
+	c.table(func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "USER\tACCOUNT\tHOST\tADDRESS\tUDP_ADDR")
+		for _, user := range users {
+			udpAddr := user.UDPAddr
+			if udpAddr == "" {
+				udpAddr = "-"
+			}
+			account := user.Account
+			if account == "" {
+				account = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\n", user.Name, account, user.Host, user.Address, udpAddr)
+		}
+	})
+	return nil
+}
+
+func (c *Console) runKick(args []string) error {
+	kicked, err := c.service.Kick(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	c.printf("%s から %s を退出させました（%d人）\n", args[0], args[1], kicked)
+	return nil
+}
+
+func (c *Console) runSay(args []string) error {
+	if _, err := c.service.Announce(args[0], strings.Join(args[1:], " ")); err != nil {
+		return err
+	}
+	c.printf("%s にお知らせを送信しました\n", args[0])
+	return nil
+}
+
+func (c *Console) runClose(args []string) error {
+	removed, err := c.service.CloseRoom(args[0])
+	if err != nil {
+		return err
+	}
+	c.printf("%s を閉じました（%d人が退出しました）\n", args[0], removed)
+	return nil
+}
+
+func (c *Console) runExport(args []string) error {
+	format, err := transcript.ParseFormat(args[1])
+	if err != nil {
+		return err
+	}
+	var from, to time.Time
+	if len(args) > 3 {
+		if from, err = transcript.ParseTime(args[3], time.Local); err != nil {
+			return err
+		}
+	}
+	if len(args) > 4 {
+		if to, err = transcript.ParseTime(args[4], time.Local); err != nil {
+			return err
+		}
+	}
+
+	// 会話記録には個人的な内容が含まれるため、所有者だけが読み書きできる権限で作成する
+	f, err := os.OpenFile(args[2], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	count, err := c.service.Export(f, args[0], format, from, to)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	c.printf("%s の会話記録を %s に書き出しました（%d件）\n", args[0], args[2], count)
+	return nil
+}
+
+func (c *Console) runStats(args []string) error {
+	stats := c.service.Stats()
+	c.table(func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "STARTED_AT\t%s\n", stats.StartedAt.Local().Format(time.DateTime))
+		fmt.Fprintf(tw, "UPTIME\t%s\n", time.Duration(stats.UptimeSeconds)*time.Second)
+		fmt.Fprintf(tw, "ROOMS\t%d\n", stats.Rooms)
+		fmt.Fprintf(tw, "USERS\t%d\n", stats.Users)
+		fmt.Fprintf(tw, "GOROUTINES\t%d\n", stats.Goroutines)
+	})
+	return nil
+}
+
+func (c *Console) runHelp(args []string) error {
+	c.table(func(tw *tabwriter.Writer) {
+		for _, name := range consoleCommandOrder {
+			fmt.Fprintf(tw, "%s\t%s\n", consoleCommands[name].usage, consoleCommands[name].help)
+		}
+	})
+	return nil
+}
+
+func (c *Console) printf(format string, args ...any) {
+	fmt.Fprintf(c, format, args...)
+}
+
+// table は表形式の出力をまとめて書き出します。
+func (c *Console) table(write func(tw *tabwriter.Writer)) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	write(tw)
+	tw.Flush()
+	io.WriteString(c, b.String())
+}
+
+// complete はタブキーが押されたときに、カーソル位置の単語をコマンド名・ルーム名・ユーザー名で補完します。
+// 候補が複数ある場合は共通する部分までを補完します。
+func (c *Console) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || c.service == nil {
+		return "", 0, false
+	}
+
+	head, tail := line[:pos], line[pos:]
+	args, partial := splitArgs(head)
+	prefix := ""
+	if partial {
+		prefix = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	var matches []string
+	for _, candidate := range c.candidates(args) {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	var completion string
+	if len(matches) == 1 {
+		completion = quoteArg(matches[0]) + " "
+	} else if completion = commonPrefix(matches); strings.ContainsRune(completion, ' ') {
+		// 候補が確定していないため、クォートは閉じずに続きを入力できるようにする
+		completion = `"` + completion
+	}
+
+	// 入力中の単語を補完した単語に置き換える
+	start := len(head)
+	if partial {
+		start = lastArgStart(head)
+	}
+	newHead := head[:start] + completion
+	return newHead + tail, len(newHead), true
+}
+
+// candidates は入力済みの引数に続く単語の候補を返します。
+func (c *Console) candidates(args []string) []string {
+	var candidates []string
+	switch {
+	case len(args) == 0:
+		candidates = append(candidates, consoleCommandOrder...)
+	case len(args) == 1 && args[0] != "rooms" && args[0] != "stats" && args[0] != "help" && args[0] != "exit":
+		for _, room := range c.service.ListRooms() {
+			candidates = append(candidates, room.Name)
+		}
+	case len(args) == 2 && args[0] == "kick":
+		users, _ := c.service.ListUsers(args[1])
+		for _, user := range users {
+			candidates = append(candidates, user.Name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// splitArgs は行を空白で区切ります。ダブルクォートで囲んだ部分は空白を含む1つの引数になります。
+// 行が空白で終わっていない場合（最後の引数が入力途中の場合）、partialはtrueになります。
+func splitArgs(line string) (args []string, partial bool) {
+	var current strings.Builder
+	started, inQuote := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			started = true
+		case r == ' ' && !inQuote:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args, started
+}
+
+// lastArgStart は入力途中の最後の引数が始まるバイト位置を返します。
+func lastArgStart(line string) int {
+	start, inQuote, started := 0, false, false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			if !started {
+				start, started = i, true
+			}
+			inQuote = !inQuote
+		case r == ' ' && !inQuote:
+			started = false
+		default:
+			if !started {
+				start, started = i, true
+			}
+		}
+	}
+	return start
+}
+
+// quoteArg は空白を含む引数をダブルクォートで囲みます。
+func quoteArg(s string) string {
+	if strings.ContainsRune(s, ' ') {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// commonPrefix はすべての文字列に共通する先頭部分を返します。
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"online_chat_messenger/internal/chat"
+)
+
+// newTestConsole は入力をinから読み、出力をファイルに書くコンソールを返します。出力は返す関数で読み出します。
+func newTestConsole(t *testing.T, f *fixture, in *os.File) (*Console, func() string) {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "console.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+	c := NewConsole(in, out)
+	c.SetService(f.service)
+	return c, func() string {
+		b, err := os.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+// input はlinesを書き込んで閉じたパイプの読み込み側を返します。
+func input(t *testing.T, lines ...string) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	go func() {
+		io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		w.Close()
+	}()
+	return r
+}
+
+func TestConsoleExecute(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // 出力に含まれる文字列
+	}{
+		{"rooms", "lobby"},
+		{"users lobby", "hanako"},
+		{"users nowhere", "エラー: room not found"},
+		{"kick lobby", "使用法: kick <room> <user>"},
+		{"kick lobby jiro", "エラー: user not found"},
+		{"say lobby メンテナンス を行います", "lobby にお知らせを送信しました"},
+		{"export lobby pdf out.pdf", "エラー: unknown transcript format"},
+		{"stats", "ROOMS"},
+		{"help", "kick <room> <user>"},
+		{"reboot", "不明なコマンドです: reboot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			c, output := newTestConsole(t, newFixture(t), input(t))
+			// エラーは出力してから返す
+			if err := c.Execute(tt.line); errors.Is(err, ErrShutdown) {
+				t.Fatalf("Execute(%q) = %v", tt.line, err)
+			}
+			if got := output(); !strings.Contains(got, tt.want) {
+				t.Errorf("Execute(%q) printed %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsoleKickRevokesToken(t *testing.T) {
+	f := newFixture(t)
+	c, output := newTestConsole(t, f, input(t))
+	if err := c.Execute("kick lobby hanako"); err != nil {
+		t.Fatal(err)
+	}
+	if got := output(); !strings.Contains(got, "lobby から hanako を退出させました（1人）") {
+		t.Errorf("output = %q", got)
+	}
+	f.wantRemoved(t, "hanako")
+}
+
+func TestConsoleRun(t *testing.T) {
+	f := newFixture(t)
+
+	// 端末でない入力ではexitで停止し、それまでのコマンドを順に実行する
+	c, output := newTestConsole(t, f, input(t, "users lobby", "close lobby", "exit", "rooms"))
+	if c.IsTerminal() {
+		t.Fatal("a pipe is reported as a terminal")
+	}
+	if err := c.Run(); !errors.Is(err, ErrShutdown) {
+		t.Errorf("Run = %v, want ErrShutdown", err)
+	}
+	got := output()
+	if !strings.Contains(got, "taro") || !strings.Contains(got, "lobby を閉じました（2人が退出しました）") {
+		t.Errorf("output = %q", got)
+	}
+	if strings.Contains(got, "ROOM") {
+		t.Errorf("a command after exit was executed: %q", got)
+	}
+
+	// 入力が終わってもサーバーは停止しない
+	c, _ = newTestConsole(t, f, input(t, "stats"))
+	if err := c.Run(); err != nil {
+		t.Errorf("Run at the end of input = %v, want nil", err)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		args    []string
+		partial bool
+	}{
+		{"", nil, false},
+		{"rooms", []string{"rooms"}, true},
+		{"kick lobby ", []string{"kick", "lobby"}, false},
+		{"  users   lobby", []string{"users", "lobby"}, true},
+		{`users "my room"`, []string{"users", "my room"}, true},
+		{`kick "my room" taro `, []string{"kick", "my room", "taro"}, false},
+		{`users "my ro`, []string{"users", "my ro"}, true},
+		{`say lobby ""`, []string{"say", "lobby", ""}, true},
+	}
+	for _, tt := range tests {
+		args, partial := splitArgs(tt.line)
+		if !reflect.DeepEqual(args, tt.args) || partial != tt.partial {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q, %v", tt.line, args, partial, tt.args, tt.partial)
+		}
+	}
+}
+
+func TestConsoleComplete(t *testing.T) {
+	f := newFixture(t)
+	for _, name := range []string{"my room", "my rooms"} {
+		if _, err := f.rooms.CreateRoom(name, "", chat.Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _ := newTestConsole(t, f, input(t))
+
+	tests := []struct {
+		name string
+		line string
+		pos  int // -1は行末
+		key  rune
+		want string
+		ok   bool
+	}{
+		{"コマンド名", "ki", -1, '\t', "kick ", true},
+		{"ルーム名", "users lo", -1, '\t', "users lobby ", true},
+		{"空白を含む名前は共通部分までクォートを開いて補完する", "users m", -1, '\t', `users "my room`, true},
+		{"候補が1つになればクォートを閉じる", `users "my rooms`, -1, '\t', `users "my rooms" `, true},
+		{"ユーザー名", "kick lobby ha", -1, '\t', "kick lobby hanako ", true},
+		{"候補に共通部分がなければ行は変わらない", "kick lobby ", -1, '\t', "kick lobby ", true},
+		{"引数のないコマンド", "stats ", -1, '\t', "", false},
+		{"候補がない", "users zzz", -1, '\t', "", false},
+		{"カーソルの後ろは残す", "users lo taro", len("users lo"), '\t', "users lobby  taro", true},
+		{"タブ以外のキー", "ki", -1, 'x', "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := tt.pos
+			if pos < 0 {
+				pos = len(tt.line)
+			}
+			got, newPos, ok := c.complete(tt.line, pos, tt.key)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("complete(%q, %d) = %q, %v, want %q, %v", tt.line, pos, got, ok, tt.want, tt.ok)
+			}
+			if ok && !strings.HasPrefix(got[newPos:], tt.line[pos:]) {
+				t.Errorf("complete(%q, %d) moved the cursor to %d in %q", tt.line, pos, newPos, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/transcript"
+)
+
+// maxRequestBodySize は管理APIが受け付けるリクエストボディの最大サイズです。
+const maxRequestBodySize = 64 * 1024
+
+// HTTPHandler はServiceの操作をJSONのHTTP APIとして公開します。
+// すべてのリクエストに "Authorization: Bearer <トークン>" ヘッダーが必要です。
+//
+//	GET    /api/rooms                         ルームの一覧
+//	GET    /api/rooms/{room}/users            ルームのメンバーの一覧
+//	DELETE /api/rooms/{room}/users/{user}     ユーザーを退出させる
+//	POST   /api/rooms/{room}/users/{user}/ban ユーザーを退出させ、接続元のアドレスを拒否する
+//	DELETE /api/rooms/{room}                  ルームを閉じる
+//	GET    /api/rooms/{room}/export           会話記録を書き出す（?format=jsonl|markdown|html&from=&to=）
+//	POST   /api/announcements                 お知らせを送信する（{"room": "", "message": "..."}）
+//	GET    /api/bans                          接続を拒否しているアドレスの一覧
+//	DELETE /api/bans/{address}                接続の拒否を解除する
+//	GET    /api/stats                         統計情報
+type HTTPHandler struct {
+	service *Service
+	mux     *http.ServeMux
+	logger  *slog.Logger
+
+	token      string
+	tokenMutex sync.RWMutex
+}
+
+// AnnounceRequest はお知らせの送信リクエストです。Roomが空の場合はすべてのルームに送信します。
+type AnnounceRequest struct {
+	Room    string `json:"room,omitempty"`
+	Message string `json:"message"`
+}
+
+// RoomsResponse はルームの一覧のレスポンスです。
+type RoomsResponse struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+// UsersResponse はルームのメンバーの一覧のレスポンスです。
+type UsersResponse struct {
+	Room  string     `json:"room"`
+	Users []UserInfo `json:"users"`
+}
+
+// KickResponse はユーザーを退出させた結果です。Bannedは接続を拒否した場合のみ含まれます。
+type KickResponse struct {
+	Room   string   `json:"room"`
+	User   string   `json:"user"`
+	Kicked int      `json:"kicked"`
+	Banned []string `json:"banned,omitempty"`
+}
+
+// CloseRoomResponse はルームを閉じた結果です。
+type CloseRoomResponse struct {
+	Room         string `json:"room"`
+	RemovedUsers int    `json:"removed_users"`
+}
+
+// AnnounceResponse はお知らせを送信した結果です。
+type AnnounceResponse struct {
+	Rooms int `json:"rooms"` // 送信したルーム数
+}
+
+// BansResponse は接続を拒否しているアドレスの一覧のレスポンスです。
+type BansResponse struct {
+	Bans []auth.BanEntry `json:"bans"`
+}
+
+// errorResponse はエラー時のレスポンスです。
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHTTPHandler は新しいHTTPHandlerを生成します。
+func NewHTTPHandler(service *Service, token string) *HTTPHandler {
+	h := &HTTPHandler{
+		service: service,
+		mux:     http.NewServeMux(),
+		logger:  slog.Default().With("component", "admin_http"),
+		token:   token,
+	}
+
+	h.mux.HandleFunc("GET /api/rooms", h.handleListRooms)
+	h.mux.HandleFunc("GET /api/rooms/{room}/users", h.handleListUsers)
+	h.mux.HandleFunc("DELETE /api/rooms/{room}/users/{user}", h.handleKick)
+	h.mux.HandleFunc("POST /api/rooms/{room}/users/{user}/ban", h.handleBan)
+	h.mux.HandleFunc("DELETE /api/rooms/{room}", h.handleCloseRoom)
+	h.mux.HandleFunc("GET /api/rooms/{room}/export", h.handleExport)
+	h.mux.HandleFunc("POST /api/announcements", h.handleAnnounce)
+	h.mux.HandleFunc("GET /api/bans", h.handleListBans)
+	h.mux.HandleFunc("DELETE /api/bans/{address}", h.handleUnban)
+	h.mux.HandleFunc("GET /api/stats", h.handleStats)
+	return h
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (h *HTTPHandler) SetLogger(logger *slog.Logger) {
+	h.logger = logger.With("component", "admin_http")
+}
+
+// SetToken は認証に使うトークンを変更します。実行中のサーバーにも即座に反映されます。
+func (h *HTTPHandler) SetToken(token string) {
+	h.tokenMutex.Lock()
+	defer h.tokenMutex.Unlock()
+	h.token = token
+}
+
+// ServeHTTP はトークンを検証してからリクエストを処理します。
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.logger.Warn("認証に失敗しました", "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chat-admin"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+
+	h.logger.Debug("管理APIへのリクエスト", "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+	h.mux.ServeHTTP(w, r)
+}
+
+// authorized はAuthorizationヘッダーのBearerトークンを検証します。
+func (h *HTTPHandler) authorized(r *http.Request) bool {
+	h.tokenMutex.RLock()
+	token := h.token
+	h.tokenMutex.RUnlock()
+
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func (h *HTTPHandler) handleListRooms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, RoomsResponse{Rooms: h.service.ListRooms()})
+}
+
+func (h *HTTPHandler) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	users, err := h.service.ListUsers(room)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, UsersResponse{Room: room, Users: users})
+}
+
+func (h *HTTPHandler) handleKick(w http.ResponseWriter, r *http.Request) {
+	room, user := r.PathValue("room"), r.PathValue("user")
+	kicked, err := h.service.Kick(room, user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, KickResponse{Room: room, User: user, Kicked: kicked})
+}
+
+func (h *HTTPHandler) handleBan(w http.ResponseWriter, r *http.Request) {
+	room, user := r.PathValue("room"), r.PathValue("user")
+	kicked, banned, err := h.service.Ban(room, user)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, KickResponse{Room: room, User: user, Kicked: kicked, Banned: banned})
+}
+
+func (h *HTTPHandler) handleListBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, BansResponse{Bans: h.service.ListBans()})
+}
+
+func (h *HTTPHandler) handleUnban(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.Unban(r.PathValue("address")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) handleCloseRoom(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	removed, err := h.service.CloseRoom(room)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CloseRoomResponse{Room: room, RemovedUsers: removed})
+}
+
+// handleExport は会話記録をファイルとしてダウンロードできるように返します。
+// 書き出しの途中でエラーが発生した場合は、すでにステータスコードを返しているためログにだけ出力します。
+func (h *HTTPHandler) handleExport(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	query := r.URL.Query()
+
+	format := transcript.FormatJSONL
+	if name := query.Get("format"); name != "" {
+		var err error
+		if format, err = transcript.ParseFormat(name); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+	}
+	from, err := transcript.ParseTime(query.Get("from"), time.Local)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "from: " + err.Error()})
+		return
+	}
+	to, err := transcript.ParseTime(query.Get("to"), time.Local)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "to: " + err.Error()})
+		return
+	}
+
+	// 存在しないルームなどはヘッダーを書く前に検出してJSONのエラーを返す
+	ew := &exportWriter{w: w, format: format, filename: room + format.Extension()}
+	if _, err := h.service.Export(ew, room, format, from, to); err != nil {
+		if !ew.started {
+			writeError(w, err)
+			return
+		}
+		h.logger.Warn("会話記録の書き出しに失敗しました", "room", room, "error", err)
+		return
+	}
+	// 空のJSON Linesは何も書き込まれないため、ここでヘッダーを送る
+	ew.Write(nil)
+}
+
+// exportWriter は最初に書き込まれたときに会話記録のレスポンスヘッダーを送ります。
+type exportWriter struct {
+	w        http.ResponseWriter
+	format   transcript.Format
+	filename string
+	started  bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", e.format.ContentType())
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(e.filename)))
+		e.w.WriteHeader(http.StatusOK)
+	}
+	return e.w.Write(p)
+}
+
+func (h *HTTPHandler) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	var request AnnounceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body: " + err.Error()})
+		return
+	}
+
+	rooms, err := h.service.Announce(request.Room, request.Message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AnnounceResponse{Rooms: rooms})
+}
+
+func (h *HTTPHandler) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.service.Stats())
+}
+
+// writeError はServiceのエラーを対応するステータスコードのJSONで返します。
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrUserNotFound), errors.Is(err, ErrNotBanned):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrBanUnavailable), errors.Is(err, ErrExportUnavailable):
+		status = http.StatusNotImplemented
+	case errors.Is(err, ErrEmptyMessage):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
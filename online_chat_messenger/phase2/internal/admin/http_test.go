@@ -0,0 +1,192 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "s3cret"
+
+func newTestHandler(t *testing.T, f *fixture, token string) *httptest.Server {
+	t.Helper()
+	h := NewHTTPHandler(f.service, token)
+	h.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call はリクエストを送り、ステータスコードとJSONのレスポンスを返します。
+func call(t *testing.T, server *httptest.Server, method, path, authorization, body string, v any) int {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+func TestHTTPHandlerRejectsUnauthorized(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, testAdminToken)
+
+	tests := []struct {
+		name          string
+		method, path  string
+		authorization string
+	}{
+		{"ヘッダーなし", http.MethodGet, "/api/rooms", ""},
+		{"Bearer以外", http.MethodGet, "/api/rooms", "Basic " + testAdminToken},
+		{"トークンが違う", http.MethodGet, "/api/rooms", "Bearer wrong"},
+		{"トークンの前方一致", http.MethodGet, "/api/rooms", "Bearer s3c"},
+		{"退出は認証の前に実行しない", http.MethodDelete, "/api/rooms/lobby/users/hanako", "Bearer wrong"},
+		{"存在しないパスも認証を先に確認する", http.MethodGet, "/api/unknown", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response errorResponse
+			if status := call(t, server, tt.method, tt.path, tt.authorization, "", &response); status != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", status)
+			}
+			if response.Error != "unauthorized" {
+				t.Errorf("error = %q", response.Error)
+			}
+		})
+	}
+	if got := f.members(t, "lobby"); len(got) != 2 {
+		t.Errorf("members = %v, want nobody removed by unauthorized requests", got)
+	}
+
+	var rooms RoomsResponse
+	if status := call(t, server, http.MethodGet, "/api/rooms", "Bearer "+testAdminToken, "", &rooms); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(rooms.Rooms) != 1 || rooms.Rooms[0].Name != "lobby" || rooms.Rooms[0].Members != 2 {
+		t.Errorf("rooms = %+v", rooms.Rooms)
+	}
+}
+
+func TestHTTPHandlerEmptyTokenRejectsEverything(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, "")
+	if status := call(t, server, http.MethodGet, "/api/stats", "Bearer ", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 when no token is configured", status)
+	}
+}
+
+func TestHTTPHandlerSetToken(t *testing.T) {
+	f := newFixture(t)
+	h := NewHTTPHandler(f.service, testAdminToken)
+	h.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	h.SetToken("rotated")
+	if status := call(t, server, http.MethodGet, "/api/stats", "Bearer "+testAdminToken, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("old token: status = %d, want 401", status)
+	}
+	if status := call(t, server, http.MethodGet, "/api/stats", "Bearer rotated", "", nil); status != http.StatusOK {
+		t.Errorf("new token: status = %d, want 200", status)
+	}
+}
+
+func TestHTTPKickRevokesToken(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, testAdminToken)
+
+	var kicked KickResponse
+	if status := call(t, server, http.MethodDelete, "/api/rooms/lobby/users/hanako", "Bearer "+testAdminToken, "", &kicked); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if kicked.Kicked != 1 || kicked.Banned != nil {
+		t.Errorf("response = %+v", kicked)
+	}
+	f.wantRemoved(t, "hanako")
+
+	var response errorResponse
+	if status := call(t, server, http.MethodDelete, "/api/rooms/lobby/users/hanako", "Bearer "+testAdminToken, "", &response); status != http.StatusNotFound {
+		t.Errorf("kick twice: status = %d, want 404", status)
+	}
+}
+
+func TestHTTPBanRevokesToken(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, testAdminToken)
+
+	var banned KickResponse
+	if status := call(t, server, http.MethodPost, "/api/rooms/lobby/users/hanako/ban", "Bearer "+testAdminToken, "", &banned); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if banned.Kicked != 1 || len(banned.Banned) != 1 || banned.Banned[0] != "192.0.2.2" {
+		t.Errorf("response = %+v", banned)
+	}
+	f.wantRemoved(t, "hanako")
+
+	var bans BansResponse
+	call(t, server, http.MethodGet, "/api/bans", "Bearer "+testAdminToken, "", &bans)
+	if len(bans.Bans) != 1 || bans.Bans[0].Address != "192.0.2.2" {
+		t.Errorf("bans = %+v", bans.Bans)
+	}
+	if status := call(t, server, http.MethodDelete, "/api/bans/192.0.2.2", "Bearer "+testAdminToken, "", nil); status != http.StatusNoContent {
+		t.Errorf("unban: status = %d, want 204", status)
+	}
+}
+
+func TestHTTPCloseRoomRevokesTokens(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, testAdminToken)
+
+	var closed CloseRoomResponse
+	if status := call(t, server, http.MethodDelete, "/api/rooms/lobby", "Bearer "+testAdminToken, "", &closed); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if closed.RemovedUsers != 2 {
+		t.Errorf("response = %+v", closed)
+	}
+	f.wantRemoved(t, "taro")
+	f.wantRemoved(t, "hanako")
+
+	var response errorResponse
+	if status := call(t, server, http.MethodGet, "/api/rooms/lobby/users", "Bearer "+testAdminToken, "", &response); status != http.StatusNotFound {
+		t.Errorf("closed room: status = %d, want 404", status)
+	}
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	f := newFixture(t)
+	server := newTestHandler(t, f, testAdminToken)
+
+	var announced AnnounceResponse
+	if status := call(t, server, http.MethodPost, "/api/announcements", "Bearer "+testAdminToken, `{"message":"メンテナンスを行います"}`, &announced); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if announced.Rooms != 1 {
+		t.Errorf("response = %+v", announced)
+	}
+
+	var response errorResponse
+	if status := call(t, server, http.MethodPost, "/api/announcements", "Bearer "+testAdminToken, `{"message":""}`, &response); status != http.StatusBadRequest {
+		t.Errorf("empty message: status = %d, want 400", status)
+	}
+	if status := call(t, server, http.MethodPost, "/api/announcements", "Bearer "+testAdminToken, `{`, &response); status != http.StatusBadRequest {
+		t.Errorf("invalid body: status = %d, want 400", status)
+	}
+}
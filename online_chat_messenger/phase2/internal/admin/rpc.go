@@ -0,0 +1,269 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// RPCRequest は remote_procedure_call と同じ形式のリクエストです。
+//
+//	{"method": "kick", "params": ["lobby", "taro"], "param_types": ["str", "str"]}
+type RPCRequest struct {
+	Method     string            `json:"method"`
+	Params     []json.RawMessage `json:"params"`
+	ParamTypes []string          `json:"param_types"`
+	ID         string            `json:"id,omitempty"` // 省略した場合はサーバーが生成する
+}
+
+// RPCResponse はリクエストの結果です。成功した場合はResultとResultType、
+// 失敗した場合はErrorとErrorTypeが設定されます。
+type RPCResponse struct {
+	Result     any    `json:"result"`
+	ResultType string `json:"result_type"`
+	Error      string `json:"error"`
+	ErrorType  string `json:"error_type"`
+	ID         string `json:"id"`
+}
+
+// MarshalJSON は成功時には結果だけを、失敗時にはエラーだけを出力します。
+func (r RPCResponse) MarshalJSON() ([]byte, error) {
+	if r.ErrorType != "" {
+		return json.Marshal(struct {
+			Error     string `json:"error"`
+			ErrorType string `json:"error_type"`
+			ID        string `json:"id"`
+		}{r.Error, r.ErrorType, r.ID})
+	}
+	return json.Marshal(struct {
+		Result     any    `json:"result"`
+		ResultType string `json:"result_type"`
+		ID         string `json:"id"`
+	}{r.Result, r.ResultType, r.ID})
+}
+
+// RPCError はエラーの種類を持つRPCのエラーです。
+type RPCError struct {
+	Type    string // "ValueError" のようなエラーの種類
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// rpcMethod はRPCで呼び出せる関数です。paramsは型を検証済みの文字列の引数です。
+type rpcMethod struct {
+	paramTypes []string // 引数の型
+	optional   int      // 省略できる先頭の引数の数
+	call       func(s *Service, params []string) (any, error)
+}
+
+// rpcMethods はRPCで呼び出せる関数の一覧です。
+var rpcMethods = map[string]rpcMethod{
+	"listRooms": {
+		call: func(s *Service, params []string) (any, error) { return s.ListRooms(), nil },
+	},
+	"listUsers": {
+		paramTypes: []string{"str"},
+		call:       func(s *Service, params []string) (any, error) { return s.ListUsers(params[0]) },
+	},
+	"kick": {
+		paramTypes: []string{"str", "str"},
+		call:       func(s *Service, params []string) (any, error) { return s.Kick(params[0], params[1]) },
+	},
+	"closeRoom": {
+		paramTypes: []string{"str"},
+		call:       func(s *Service, params []string) (any, error) { return s.CloseRoom(params[0]) },
+	},
+	// announce(message) は全ルームに、announce(room, message) は指定したルームに送信する
+	"announce": {
+		paramTypes: []string{"str", "str"},
+		optional:   1,
+		call: func(s *Service, params []string) (any, error) {
+			if len(params) == 1 {
+				return s.Announce("", params[0])
+			}
+			return s.Announce(params[0], params[1])
+		},
+	},
+}
+
+// RPCServer はServiceの操作をUnixドメインソケット上のJSON RPCとして公開します。
+// リクエストとレスポンスの形式は remote_procedure_call プロジェクトと同じです。
+type RPCServer struct {
+	service  *Service
+	listener net.Listener
+	logger   *slog.Logger
+	wg       sync.WaitGroup
+}
+
+// ListenUnix はUnixドメインソケットで待ち受けます。
+// 前回の起動で残ったソケットファイルは削除し、所有者だけが接続できるように権限を設定します。
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("ソケットの権限の設定に失敗しました: %w", err)
+	}
+	return listener, nil
+}
+
+// NewRPCServer は新しいRPCServerを生成します。
+func NewRPCServer(service *Service, listener net.Listener) *RPCServer {
+	return &RPCServer{
+		service:  service,
+		listener: listener,
+		logger:   slog.Default().With("component", "admin_rpc"),
+	}
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (s *RPCServer) SetLogger(logger *slog.Logger) {
+	s.logger = logger.With("component", "admin_rpc")
+}
+
+// Serve は接続を待ち受け、リクエストを処理します。Closeされた場合はnilを返します。
+func (s *RPCServer) Serve() error {
+	s.logger.Info("管理RPCサーバーを起動しました", "addr", s.listener.Addr().String())
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+// Close は待ち受けを停止し、処理中の接続の終了を待ちます。
+func (s *RPCServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// handleConnection は接続が閉じられるまでリクエストを読み込み、順に応答します。
+func (s *RPCServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		var request RPCRequest
+		if err := decoder.Decode(&request); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Warn("リクエストのデコードに失敗しました", "error", err)
+				encoder.Encode(RPCResponse{Error: err.Error(), ErrorType: "JSONDecodeError", ID: uuid.NewString()})
+			}
+			return
+		}
+
+		response := s.Call(request)
+		if err := encoder.Encode(response); err != nil {
+			s.logger.Warn("レスポンスの送信に失敗しました", "error", err)
+			return
+		}
+	}
+}
+
+// Call はリクエストされた関数を呼び出し、結果を返します。
+func (s *RPCServer) Call(request RPCRequest) RPCResponse {
+	id := request.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	logger := s.logger.With("method", request.Method, "id", id)
+
+	result, err := s.call(request)
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Type: errorType(err), Message: err.Error()}
+		}
+		logger.Info("RPCの呼び出しに失敗しました", "error", rpcErr)
+		return RPCResponse{Error: rpcErr.Message, ErrorType: rpcErr.Type, ID: id}
+	}
+
+	logger.Debug("RPCを呼び出しました")
+	return RPCResponse{Result: result, ResultType: resultType(result), ID: id}
+}
+
+// call は引数を検証してから関数を呼び出します。
+func (s *RPCServer) call(request RPCRequest) (any, error) {
+	method, ok := rpcMethods[request.Method]
+	if !ok {
+		return nil, &RPCError{Type: "AttributeError", Message: fmt.Sprintf("unknown method: %q", request.Method)}
+	}
+
+	minParams := len(method.paramTypes) - method.optional
+	if len(request.Params) < minParams || len(request.Params) > len(method.paramTypes) {
+		return nil, &RPCError{Type: "ValueError", Message: "Invalid number of parameters"}
+	}
+	if len(request.ParamTypes) != len(request.Params) {
+		return nil, &RPCError{Type: "ValueError", Message: "params and param_types must have the same length"}
+	}
+
+	// 省略された引数は先頭から詰める（announce(message) は announce("", message) と同じ）
+	expected := method.paramTypes[len(method.paramTypes)-len(request.Params):]
+	params := make([]string, len(request.Params))
+	for i, raw := range request.Params {
+		if request.ParamTypes[i] != expected[i] {
+			return nil, &RPCError{Type: "ValueError", Message: "Invalid parameter type"}
+		}
+		if err := json.Unmarshal(raw, &params[i]); err != nil {
+			return nil, &RPCError{Type: "TypeError", Message: fmt.Sprintf("parameter %d must be a string", i)}
+		}
+	}
+
+	return method.call(s.service, params)
+}
+
+// errorType はServiceのエラーをRPCのエラーの種類に変換します。
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrRoomNotFound):
+		return "RoomNotFoundError"
+	case errors.Is(err, ErrUserNotFound):
+		return "UserNotFoundError"
+	case errors.Is(err, ErrEmptyMessage):
+		return "ValueError"
+	default:
+		return "Error"
+	}
+}
+
+// resultType は結果の型を remote_procedure_call と同じ名前で返します。
+func resultType(result any) string {
+	switch result.(type) {
+	case int:
+		return "int"
+	case string:
+		return "str"
+	case bool:
+		return "bool"
+	case []RoomInfo, []UserInfo:
+		return "list"
+	default:
+		return "dict"
+	}
+}
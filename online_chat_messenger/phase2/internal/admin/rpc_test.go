@@ -0,0 +1,184 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rpcRequest はJSONで書いた引数からリクエストを作ります。
+func rpcRequest(method string, params ...string) RPCRequest {
+	request := RPCRequest{Method: method, Params: []json.RawMessage{}, ParamTypes: []string{}}
+	for _, param := range params {
+		request.Params = append(request.Params, json.RawMessage(param))
+		request.ParamTypes = append(request.ParamTypes, "str")
+	}
+	return request
+}
+
+func newTestRPCServer(t *testing.T, f *fixture, listener net.Listener) *RPCServer {
+	t.Helper()
+	s := NewRPCServer(f.service, listener)
+	s.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s
+}
+
+func TestRPCCall(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    RPCRequest
+		resultType string
+		errorType  string
+	}{
+		{"listRooms", rpcRequest("listRooms"), "list", ""},
+		{"listUsers", rpcRequest("listUsers", `"lobby"`), "list", ""},
+		{"kick", rpcRequest("kick", `"lobby"`, `"hanako"`), "int", ""},
+		{"announceは全ルーム", rpcRequest("announce", `"お知らせ"`), "int", ""},
+		{"announceはルームを指定できる", rpcRequest("announce", `"lobby"`, `"お知らせ"`), "int", ""},
+		{"closeRoom", rpcRequest("closeRoom", `"lobby"`), "int", ""},
+		{"不明な関数", rpcRequest("shutdown"), "", "AttributeError"},
+		{"引数が足りない", rpcRequest("kick", `"lobby"`), "", "ValueError"},
+		{"引数が多い", rpcRequest("listRooms", `"lobby"`), "", "ValueError"},
+		{"引数と型の数が違う", RPCRequest{Method: "listUsers", Params: []json.RawMessage{[]byte(`"lobby"`)}}, "", "ValueError"},
+		{"型の名前が違う", RPCRequest{Method: "listUsers", Params: []json.RawMessage{[]byte(`1`)}, ParamTypes: []string{"int"}}, "", "ValueError"},
+		{"引数が文字列でない", RPCRequest{Method: "listUsers", Params: []json.RawMessage{[]byte(`1`)}, ParamTypes: []string{"str"}}, "", "TypeError"},
+		{"ルームがない", rpcRequest("listUsers", `"nowhere"`), "", "RoomNotFoundError"},
+		{"ユーザーがいない", rpcRequest("kick", `"lobby"`, `"jiro"`), "", "UserNotFoundError"},
+		{"お知らせが空", rpcRequest("announce", `" "`), "", "ValueError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRPCServer(t, newFixture(t), nil)
+			response := s.Call(tt.request)
+			if response.ResultType != tt.resultType || response.ErrorType != tt.errorType {
+				t.Errorf("Call = %+v, want result_type %q, error_type %q", response, tt.resultType, tt.errorType)
+			}
+			if response.ID == "" {
+				t.Error("the response has no ID")
+			}
+		})
+	}
+}
+
+func TestRPCCallKeepsRequestID(t *testing.T) {
+	s := newTestRPCServer(t, newFixture(t), nil)
+	request := rpcRequest("listRooms")
+	request.ID = "req-1"
+	if response := s.Call(request); response.ID != "req-1" {
+		t.Errorf("ID = %q, want req-1", response.ID)
+	}
+}
+
+func TestRPCKickRevokesToken(t *testing.T) {
+	f := newFixture(t)
+	s := newTestRPCServer(t, f, nil)
+	if response := s.Call(rpcRequest("kick", `"lobby"`, `"hanako"`)); response.Result != 1 {
+		t.Fatalf("kick = %+v", response)
+	}
+	f.wantRemoved(t, "hanako")
+}
+
+// socketPath はUnixドメインソケットのパスの長さの制限に収まる一時ファイルのパスを返します。
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "admin.sock")
+}
+
+func TestRPCServerOverUnixSocket(t *testing.T) {
+	path := socketPath(t)
+
+	// 前回の起動で残ったソケットファイルは削除して待ち受ける
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ListenUnix(path)
+	if err != nil {
+		t.Fatalf("ListenUnix over a stale socket: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	s := newTestRPCServer(t, newFixture(t), listener)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// 1つの接続で続けて呼び出せる
+	for _, line := range []string{
+		`{"method": "listUsers", "params": ["lobby"], "param_types": ["str"], "id": "1"}`,
+		`{"method": "kick", "params": ["lobby", "nobody"], "param_types": ["str", "str"], "id": "2"}`,
+		`{"method": }`,
+	} {
+		if _, err := io.WriteString(conn, line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 成功した場合は結果だけを、失敗した場合はエラーだけを返す
+	var users map[string]any
+	readJSON(t, reader, &users)
+	if users["id"] != "1" || users["result_type"] != "list" || len(users["result"].([]any)) != 2 {
+		t.Errorf("listUsers = %v", users)
+	}
+	if _, ok := users["error"]; ok {
+		t.Errorf("a successful response has an error field: %v", users)
+	}
+	var kick map[string]any
+	readJSON(t, reader, &kick)
+	if kick["id"] != "2" || kick["error_type"] != "UserNotFoundError" {
+		t.Errorf("kick = %v", kick)
+	}
+	if _, ok := kick["result"]; ok {
+		t.Errorf("an error response has a result field: %v", kick)
+	}
+	var decodeErr map[string]any
+	readJSON(t, reader, &decodeErr)
+	if decodeErr["error_type"] != "JSONDecodeError" {
+		t.Errorf("malformed request = %v", decodeErr)
+	}
+
+	// 不正なリクエストの後は接続を閉じる
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("read after a malformed request = %v, want EOF", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve after Close = %v, want nil", err)
+	}
+}
+
+func readJSON(t *testing.T, reader *bufio.Reader, v any) {
+	t.Helper()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), v); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+}
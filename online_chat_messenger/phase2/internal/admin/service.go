@@ -0,0 +1,355 @@
+// Package admin は実行中のチャットサーバーを管理するための操作を提供します。
+// HTTP APIなどの管理用インターフェースはすべてServiceを通してルームとユーザーを操作します。
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/msglog"
+	"online_chat_messenger/internal/transcript"
+)
+
+var (
+	// ErrRoomNotFound は指定されたルームが存在しないことを表します。
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrUserNotFound は指定されたユーザーがルームにいないことを表します。
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmptyMessage はお知らせの本文が空であることを表します。
+	ErrEmptyMessage = errors.New("message is empty")
+	// ErrNotBanned は指定されたアドレスが接続拒否の一覧にないことを表します。
+	ErrNotBanned = errors.New("address is not banned")
+	// ErrBanUnavailable は接続拒否の一覧が設定されていないことを表します。
+	ErrBanUnavailable = errors.New("ban list is not configured")
+	// ErrExportUnavailable はメッセージログが設定されていないため会話記録を書き出せないことを表します。
+	ErrExportUnavailable = errors.New("message log is not configured")
+)
+
+// Notifier はルームやユーザーにサーバーからのお知らせを送信します。
+// 通常は *network.UDPServer を使用します。
+type Notifier interface {
+	NotifyRoom(room chat.Room, text string)
+	NotifyUser(user chat.User, text string)
+}
+
+// RoomInfo はルームの概要です。
+type RoomInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	chat.Metadata
+}
+
+// MembersString はメンバー数を表示用の文字列にします。ルームに上限がある場合は "人数/上限" の形式です。
+func (r RoomInfo) MembersString() string {
+	if r.MaxMembers > 0 {
+		return fmt.Sprintf("%d/%d", r.Members, r.MaxMembers)
+	}
+	return strconv.Itoa(r.Members)
+}
+
+// UserInfo はルームに参加しているユーザーの情報です。トークンは含みません。
+type UserInfo struct {
+	Name    string `json:"name"`
+	Host    bool   `json:"host"`
+	Address string `json:"address"`            // TCPで接続してきたアドレス
+	UDPAddr string `json:"udp_addr,omitempty"` // メッセージの送信先（まだ送信していない場合は空）
+	Account string `json:"account,omitempty"`  // ログインしたアカウントの名前（ゲストの場合は空）
+}
+
+// Stats はサーバーの統計情報です。
+type Stats struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Rooms         int       `json:"rooms"`
+	Users         int       `json:"users"` // 全ルームの参加ユーザー数の合計
+	Goroutines    int       `json:"goroutines"`
+}
+
+// Service はルームとユーザーに対する管理操作を提供します。
+type Service struct {
+	roomManager chat.RoomManager
+	userManager auth.UserManager
+	notifier    Notifier
+	banList     *auth.BanList
+	tokens      *auth.TokenSigner
+	messageLog  *msglog.Log
+	logger      *slog.Logger
+	startedAt   time.Time
+}
+
+// NewService は新しいServiceを生成します。notifierがnilの場合はお知らせを送信しません。
+func NewService(roomManager chat.RoomManager, userManager auth.UserManager, notifier Notifier) *Service {
+	return &Service{
+		roomManager: roomManager,
+		userManager: userManager,
+		notifier:    notifier,
+		logger:      slog.Default().With("component", "admin"),
+		startedAt:   time.Now(),
+	}
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (s *Service) SetLogger(logger *slog.Logger) {
+	s.logger = logger.With("component", "admin")
+}
+
+// SetBanList は接続を拒否するアドレスの一覧を設定します。TCPServerと同じ一覧を渡してください。
+func (s *Service) SetBanList(banList *auth.BanList) {
+	s.banList = banList
+}
+
+// SetTokenSigner は退出させたユーザーのトークンを取り消すTokenSignerを設定します。TCPServerと同じものを渡してください。
+func (s *Service) SetTokenSigner(tokens *auth.TokenSigner) {
+	s.tokens = tokens
+}
+
+// SetMessageLog は会話記録の書き出しに使うメッセージログを設定します。
+func (s *Service) SetMessageLog(l *msglog.Log) {
+	s.messageLog = l
+}
+
+// ListRooms はすべてのルームを名前順に返します。
+func (s *Service) ListRooms() []RoomInfo {
+	rooms := s.roomManager.GetAllRooms()
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		infos = append(infos, RoomInfo{Name: room.GetName(), Members: len(room.GetUsers()), Metadata: room.Metadata()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// ListUsers は指定したルームに参加しているユーザーを名前順に返します。
+func (s *Service) ListUsers(roomName string) ([]UserInfo, error) {
+	room, err := s.findRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	users := room.GetUsers()
+	infos := make([]UserInfo, 0, len(users))
+	for _, user := range users {
+		info := UserInfo{Name: user.GetName(), Host: user.IsHost(), Address: user.GetAddress()}
+		if udpAddr := user.GetUDPAddr(); udpAddr != nil {
+			info.UDPAddr = udpAddr.String()
+		}
+		if accountUser, ok := user.(chat.AccountUser); ok {
+			info.Account = accountUser.Account()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Kick は指定した名前のユーザーをルームから退出させ、トークンを無効にします。
+// 同じ名前のユーザーが複数いる場合はすべて退出させ、その人数を返します。
+func (s *Service) Kick(roomName, userName string) (int, error) {
+	room, err := s.findRoom(roomName)
+	if err != nil {
+		return 0, err
+	}
+
+	kicked := 0
+	for _, user := range room.GetUsers() {
+		if !chat.SameName(user.GetName(), userName) {
+			continue
+		}
+		s.notifyUser(user, "管理者によってルームから退出させられました")
+		s.removeUser(room, user)
+		kicked++
+	}
+	if kicked == 0 {
+		return 0, ErrUserNotFound
+	}
+
+	s.logger.Info("ユーザーを退出させました", "room", roomName, "user", userName, "count", kicked)
+	return kicked, nil
+}
+
+// Ban は指定した名前のユーザーを退出させ、接続元のIPアドレスからの接続を拒否します。
+// 退出させた人数と接続を拒否したアドレスを返します。
+func (s *Service) Ban(roomName, userName string) (int, []string, error) {
+	if s.banList == nil {
+		return 0, nil, ErrBanUnavailable
+	}
+	room, err := s.findRoom(roomName)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var addresses []string
+	for _, user := range room.GetUsers() {
+		if !chat.SameName(user.GetName(), userName) {
+			continue
+		}
+		addresses = append(addresses, s.banList.Ban(user.GetAddress()))
+	}
+	if len(addresses) == 0 {
+		return 0, nil, ErrUserNotFound
+	}
+
+	kicked, err := s.Kick(roomName, userName)
+	if err != nil {
+		return 0, addresses, err
+	}
+	s.logger.Info("ユーザーの接続を拒否しました", "room", roomName, "user", userName, "addresses", addresses)
+	return kicked, addresses, nil
+}
+
+// Unban はアドレスを接続拒否の一覧から削除します。
+func (s *Service) Unban(address string) error {
+	if s.banList == nil {
+		return ErrBanUnavailable
+	}
+	if !s.banList.Unban(address) {
+		return ErrNotBanned
+	}
+	s.logger.Info("接続の拒否を解除しました", "address", address)
+	return nil
+}
+
+// ListBans は接続を拒否しているアドレスの一覧を返します。
+func (s *Service) ListBans() []auth.BanEntry {
+	if s.banList == nil {
+		return []auth.BanEntry{}
+	}
+	return s.banList.List()
+}
+
+// CloseRoom はルームの全ユーザーを退出させてからルームを削除し、退出させた人数を返します。
+func (s *Service) CloseRoom(roomName string) (int, error) {
+	room, err := s.findRoom(roomName)
+	if err != nil {
+		return 0, err
+	}
+
+	s.notifyRoom(room, "ルームは管理者によって閉じられました")
+	users := room.GetUsers()
+	for _, user := range users {
+		s.removeUser(room, user)
+	}
+	if err := s.roomManager.DeleteRoom(roomName); err != nil {
+		return len(users), ErrRoomNotFound
+	}
+
+	s.logger.Info("ルームを閉じました", "room", roomName, "users", len(users))
+	return len(users), nil
+}
+
+// Announce はルームにお知らせを送信し、送信したルーム数を返します。
+// roomNameが空の場合はすべてのルームに送信します。
+func (s *Service) Announce(roomName, message string) (int, error) {
+	if strings.TrimSpace(message) == "" {
+		return 0, ErrEmptyMessage
+	}
+
+	var rooms []chat.Room
+	if roomName == "" {
+		rooms = s.roomManager.GetAllRooms()
+	} else {
+		room, err := s.findRoom(roomName)
+		if err != nil {
+			return 0, err
+		}
+		rooms = []chat.Room{room}
+	}
+
+	for _, room := range rooms {
+		s.notifyRoom(room, message)
+	}
+
+	s.logger.Info("お知らせを送信しました", "room", roomName, "rooms", len(rooms))
+	return len(rooms), nil
+}
+
+// Export はルームの会話記録のうち、時刻がfrom以上to未満のものを指定した形式でwに書き出し、件数を返します。
+// fromとtoはゼロ値の場合は制限しません。閉じられたルームでも、メッセージログが残っていれば書き出せます。
+func (s *Service) Export(w io.Writer, roomName string, format transcript.Format, from, to time.Time) (int, error) {
+	if s.messageLog == nil {
+		return 0, ErrExportUnavailable
+	}
+	if _, err := s.findRoom(roomName); err != nil {
+		rooms, err := s.messageLog.Rooms()
+		if err != nil {
+			return 0, err
+		}
+		if !slices.Contains(rooms, roomName) {
+			return 0, ErrRoomNotFound
+		}
+	}
+
+	tw, err := transcript.NewWriter(w, format, transcript.Header{Room: roomName, From: from, To: to, ExportedAt: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	var writeErr error
+	err = s.messageLog.Scan(roomName, from, to, func(record msglog.Record) bool {
+		writeErr = tw.Write(transcript.Entry{Time: record.Time, Sender: record.Sender, Text: record.Text, System: record.System})
+		return writeErr == nil
+	})
+	if err := errors.Join(err, writeErr, tw.Close()); err != nil {
+		return tw.Count(), err
+	}
+
+	s.logger.Info("会話記録を書き出しました", "room", roomName, "format", format, "entries", tw.Count())
+	return tw.Count(), nil
+}
+
+// Stats はサーバーの統計情報を返します。
+func (s *Service) Stats() Stats {
+	rooms := s.roomManager.GetAllRooms()
+	users := 0
+	for _, room := range rooms {
+		users += len(room.GetUsers())
+	}
+	return Stats{
+		StartedAt:     s.startedAt,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		Rooms:         len(rooms),
+		Users:         users,
+		Goroutines:    runtime.NumGoroutine(),
+	}
+}
+
+// findRoom はルームを検索し、見つからない場合はErrRoomNotFoundを返します。
+func (s *Service) findRoom(roomName string) (chat.Room, error) {
+	room, err := s.roomManager.FindRoom(roomName)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// removeUser はユーザーをルームから削除し、トークンを無効にします。
+func (s *Service) removeUser(room chat.Room, user chat.User) {
+	room.RemoveUser(user)
+	s.userManager.DeleteUser(user.GetToken())
+	if s.tokens != nil {
+		if err := s.tokens.Revoke(user.GetToken()); err != nil {
+			s.logger.Warn("トークンの取り消しに失敗しました", "user", user.GetName(), "error", err)
+		}
+	}
+}
+
+func (s *Service) notifyRoom(room chat.Room, text string) {
+	if s.notifier != nil {
+		s.notifier.NotifyRoom(room, text)
+	}
+}
+
+func (s *Service) notifyUser(user chat.User, text string) {
+	if s.notifier != nil {
+		s.notifier.NotifyUser(user, text)
+	}
+}
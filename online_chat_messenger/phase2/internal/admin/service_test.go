@@ -0,0 +1,222 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+)
+
+// notice はNotifierに送られたお知らせです。
+type notice struct {
+	to   string // ルーム名またはユーザー名
+	text string
+}
+
+// fakeNotifier は送られたお知らせを記録します。
+type fakeNotifier struct {
+	mutex   sync.Mutex
+	notices []notice
+}
+
+func (n *fakeNotifier) NotifyRoom(room chat.Room, text string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.notices = append(n.notices, notice{room.GetName(), text})
+}
+
+func (n *fakeNotifier) NotifyUser(user chat.User, text string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.notices = append(n.notices, notice{user.GetName(), text})
+}
+
+func (n *fakeNotifier) sent() []notice {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]notice(nil), n.notices...)
+}
+
+// fixture は管理操作のテストに使うルームとユーザーです。
+// lobbyにホストのtaro（192.0.2.1）とhanako（192.0.2.2）が参加しています。
+type fixture struct {
+	service  *Service
+	rooms    *chat.SimpleRoomManager
+	users    *auth.SimpleUserManager
+	tokens   *auth.TokenSigner
+	banList  *auth.BanList
+	notifier *fakeNotifier
+	token    map[string]string // ユーザー名ごとのトークン
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	key, err := auth.GenerateTokenKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenSigner([]auth.TokenKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{
+		rooms:    chat.NewSimpleRoomManager(),
+		users:    auth.NewSimpleUserManager(),
+		tokens:   tokens,
+		banList:  auth.NewBanList(),
+		notifier: &fakeNotifier{},
+		token:    make(map[string]string),
+	}
+	t.Cleanup(func() {
+		f.users.Close()
+		f.rooms.Close()
+	})
+
+	room, err := f.rooms.CreateRoom("lobby", "", chat.Metadata{Creator: "taro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []struct {
+		name, address string
+		host          bool
+	}{
+		{"taro", "192.0.2.1:50000", true},
+		{"hanako", "192.0.2.2:50000", false},
+	} {
+		token, _, err := tokens.Issue("", "lobby", member.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := chat.NewUser(member.name, token, member.address)
+		if err := room.AddUser(user, member.host); err != nil {
+			t.Fatal(err)
+		}
+		f.users.RegisterUser(token, "lobby", user)
+		f.token[member.name] = token
+	}
+
+	f.service = NewService(f.rooms, f.users, f.notifier)
+	f.service.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	f.service.SetBanList(f.banList)
+	f.service.SetTokenSigner(f.tokens)
+	return f
+}
+
+// members はルームのメンバーの名前を返します。
+func (f *fixture) members(t *testing.T, roomName string) []string {
+	t.Helper()
+	users, err := f.service.ListUsers(roomName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	return names
+}
+
+// wantRemoved はユーザーのトークンが取り消され、ユーザーの表からも削除されていることを確認します。
+func (f *fixture) wantRemoved(t *testing.T, userName string) {
+	t.Helper()
+	token := f.token[userName]
+	if _, err := f.tokens.Verify(token, "lobby"); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("Verify(%s) = %v, want ErrTokenRevoked", userName, err)
+	}
+	if _, err := f.users.FindUser(token); err == nil {
+		t.Errorf("FindUser(%s) succeeded after removal", userName)
+	}
+}
+
+func TestServiceKick(t *testing.T) {
+	f := newFixture(t)
+
+	// 名前は正規化して比べる
+	kicked, err := f.service.Kick("lobby", "ＨＡＮＡＫＯ")
+	if err != nil || kicked != 1 {
+		t.Fatalf("Kick = %d, %v", kicked, err)
+	}
+	if got := f.members(t, "lobby"); len(got) != 1 || got[0] != "taro" {
+		t.Errorf("members = %v, want [taro]", got)
+	}
+	f.wantRemoved(t, "hanako")
+	if _, err := f.tokens.Verify(f.token["taro"], "lobby"); err != nil {
+		t.Errorf("Verify(taro) = %v, want the host's token to stay valid", err)
+	}
+	if got := f.notifier.sent(); len(got) != 1 || got[0].to != "hanako" {
+		t.Errorf("notices = %v, want one to hanako", got)
+	}
+
+	if _, err := f.service.Kick("lobby", "hanako"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Kick twice = %v, want ErrUserNotFound", err)
+	}
+	if _, err := f.service.Kick("nowhere", "taro"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("Kick(nowhere) = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestServiceBan(t *testing.T) {
+	f := newFixture(t)
+
+	kicked, banned, err := f.service.Ban("lobby", "hanako")
+	if err != nil || kicked != 1 || len(banned) != 1 || banned[0] != "192.0.2.2" {
+		t.Fatalf("Ban = %d, %v, %v", kicked, banned, err)
+	}
+	f.wantRemoved(t, "hanako")
+	if !f.banList.IsBanned("192.0.2.2:60000") {
+		t.Error("the address is not banned")
+	}
+
+	if err := f.service.Unban("192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.service.Unban("192.0.2.2"); !errors.Is(err, ErrNotBanned) {
+		t.Errorf("Unban twice = %v, want ErrNotBanned", err)
+	}
+
+	f.service.SetBanList(nil)
+	if _, _, err := f.service.Ban("lobby", "taro"); !errors.Is(err, ErrBanUnavailable) {
+		t.Errorf("Ban without a ban list = %v, want ErrBanUnavailable", err)
+	}
+}
+
+func TestServiceCloseRoom(t *testing.T) {
+	f := newFixture(t)
+
+	removed, err := f.service.CloseRoom("lobby")
+	if err != nil || removed != 2 {
+		t.Fatalf("CloseRoom = %d, %v", removed, err)
+	}
+	f.wantRemoved(t, "taro")
+	f.wantRemoved(t, "hanako")
+	if _, err := f.rooms.FindRoom("lobby"); err == nil {
+		t.Error("the room still exists")
+	}
+	if got := f.notifier.sent(); len(got) != 1 || got[0].to != "lobby" {
+		t.Errorf("notices = %v, want one to the room", got)
+	}
+}
+
+func TestServiceAnnounce(t *testing.T) {
+	f := newFixture(t)
+	if _, err := f.rooms.CreateRoom("dev", "", chat.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := f.service.Announce("", "メンテナンスを行います"); err != nil || n != 2 {
+		t.Errorf("Announce(all) = %d, %v, want 2 rooms", n, err)
+	}
+	if n, err := f.service.Announce("dev", "dev だけ"); err != nil || n != 1 {
+		t.Errorf("Announce(dev) = %d, %v, want 1 room", n, err)
+	}
+	if _, err := f.service.Announce("", "  "); !errors.Is(err, ErrEmptyMessage) {
+		t.Errorf("Announce(blank) = %v, want ErrEmptyMessage", err)
+	}
+	if got := f.notifier.sent(); len(got) != 3 {
+		t.Errorf("notices = %v, want 3", got)
+	}
+}
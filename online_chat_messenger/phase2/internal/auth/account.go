@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/pwhash"
+)
+
+// Account は登録ユーザーのアカウントです。
+type Account struct {
+	Name         string    `json:"name"`          // 登録した名前（表示に使う）
+	PasswordHash string    `json:"password_hash"` // "pbkdf2-sha256$<反復回数>$<ソルト>$<ハッシュ>" の形式
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const (
+	// DefaultPBKDF2Iterations はパスワードのハッシュに使うPBKDF2のデフォルトの反復回数です。
+	DefaultPBKDF2Iterations = pwhash.DefaultIterations
+	// DefaultMinPasswordLength はパスワードのデフォルトの最小文字数です。
+	DefaultMinPasswordLength = 8
+	// MaxPasswordLength はパスワードの最大バイト数です。ハッシュの計算量を抑えるために制限します。
+	MaxPasswordLength = 256
+	// DefaultLoginTTL はログイントークンのデフォルトの有効期間です。
+	DefaultLoginTTL = 24 * time.Hour
+)
+
+var (
+	// ErrAccountNotFound はアカウントが見つからないことを表します。
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrNameReserved は名前が登録ユーザーか予約された名前のため使えないことを表します。
+	ErrNameReserved = errors.New("name is reserved")
+	// ErrInvalidCredentials はユーザー名かパスワードが正しくないことを表します。
+	ErrInvalidCredentials = errors.New("invalid user name or password")
+	// ErrLoginExpired はログイントークンが見つからないか、有効期限が切れていることを表します。
+	ErrLoginExpired = errors.New("login expired")
+	// ErrInvalidPassword はパスワードの長さが正しくないことを表します。
+	ErrInvalidPassword = errors.New("パスワードの長さが正しくありません")
+)
+
+// login はログイントークンに対応するアカウントと有効期限です。
+type login struct {
+	key       string // アカウントのキー
+	expiresAt time.Time
+}
+
+// Accounts は登録ユーザーのアカウントとログインを管理します。複数のゴルーチンから安全に使用できます。
+// アカウントはchat.NormalizeNameで正規化した名前をキーにして保存するため、全角・半角や大文字・小文字だけが違う名前は同じアカウントになります。
+type Accounts struct {
+	store             AccountStore
+	reserved          map[string]bool // 正規化した予約名
+	iterations        int
+	minPasswordLength int
+	loginTTL          time.Duration
+	logins            map[string]login // キーはログイントークン
+	mutex             sync.RWMutex
+}
+
+// NewAccounts はアカウントの保存先を指定して新しいAccountsを生成します。
+func NewAccounts(store AccountStore) *Accounts {
+	return &Accounts{
+		store:             store,
+		reserved:          make(map[string]bool),
+		iterations:        DefaultPBKDF2Iterations,
+		minPasswordLength: DefaultMinPasswordLength,
+		loginTTL:          DefaultLoginTTL,
+		logins:            make(map[string]login),
+	}
+}
+
+// SetReservedNames はゲストが使えず、登録もできない名前を設定します。
+func (a *Accounts) SetReservedNames(names []string) {
+	reserved := make(map[string]bool, len(names))
+	for _, name := range names {
+		reserved[chat.NormalizeName(name)] = true
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.reserved = reserved
+}
+
+// SetPolicy はPBKDF2の反復回数、パスワードの最小文字数、ログイントークンの有効期間を設定します。
+// 反復回数は以降に登録・変更するパスワードにだけ適用され、登録済みのパスワードは登録時の回数で確認します。
+func (a *Accounts) SetPolicy(iterations, minPasswordLength int, loginTTL time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.iterations = iterations
+	a.minPasswordLength = minPasswordLength
+	a.loginTTL = loginTTL
+}
+
+// IsReserved は名前が予約された名前か、登録ユーザーの名前かを返します。
+func (a *Accounts) IsReserved(name string) bool {
+	key := chat.NormalizeName(name)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.reserved[key] {
+		return true
+	}
+	_, err := a.store.Account(key)
+	return err == nil
+}
+
+// Register はアカウントを登録します。名前はcommand.ValidateNameなどで確認してから渡してください。
+// 予約された名前や登録済みの名前の場合はErrNameReservedを返します。
+func (a *Accounts) Register(name, password string) (Account, error) {
+	a.mutex.RLock()
+	iterations, minPasswordLength := a.iterations, a.minPasswordLength
+	a.mutex.RUnlock()
+
+	if utf8.RuneCountInString(password) < minPasswordLength || len(password) > MaxPasswordLength {
+		return Account{}, fmt.Errorf("%w: %d文字以上%dバイト以下で指定してください", ErrInvalidPassword, minPasswordLength, MaxPasswordLength)
+	}
+	// ハッシュの計算は時間がかかるため、ロックの外で行う
+	hash, err := pwhash.Hash(password, iterations)
+	if err != nil {
+		return Account{}, err
+	}
+
+	key := chat.NormalizeName(name)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.reserved[key] {
+		return Account{}, ErrNameReserved
+	}
+	if _, err := a.store.Account(key); err == nil {
+		return Account{}, ErrNameReserved
+	} else if !errors.Is(err, ErrAccountNotFound) {
+		return Account{}, err
+	}
+	account := Account{Name: name, PasswordHash: hash, CreatedAt: time.Now()}
+	if err := a.store.SaveAccount(key, account); err != nil {
+		return Account{}, fmt.Errorf("アカウントの保存に失敗しました: %w", err)
+	}
+	return account, nil
+}
+
+// Authenticate は名前とパスワードを確認してアカウントを返します。
+// 名前とパスワードのどちらが違うかは区別せず、ErrInvalidCredentialsを返します。
+func (a *Accounts) Authenticate(name, password string) (Account, error) {
+	a.mutex.RLock()
+	account, err := a.store.Account(chat.NormalizeName(name))
+	iterations := a.iterations
+	a.mutex.RUnlock()
+
+	if errors.Is(err, ErrAccountNotFound) {
+		// アカウントがあるかどうかを応答時間から推測されないよう、同じだけ計算する
+		pwhash.Hash(password, iterations)
+		return Account{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Account{}, err
+	}
+	if len(password) > MaxPasswordLength || !pwhash.Verify(password, account.PasswordHash) {
+		return Account{}, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// Login は名前とパスワードを確認し、ルームの作成・参加に使うログイントークンを発行します。
+func (a *Accounts) Login(name, password string) (token string, account Account, expiresAt time.Time, err error) {
+	account, err = a.Authenticate(name, password)
+	if err != nil {
+		return "", Account{}, time.Time{}, err
+	}
+	token, expiresAt = a.IssueLogin(account)
+	return token, account, expiresAt, nil
+}
+
+// IssueLogin はパスワードを確認せずにアカウントのログイントークンを発行します。登録した直後のログインに使います。
+func (a *Accounts) IssueLogin(account Account) (token string, expiresAt time.Time) {
+	now := time.Now()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// 有効期限の切れたトークンはここでまとめて削除する
+	for t, l := range a.logins {
+		if !now.Before(l.expiresAt) {
+			delete(a.logins, t)
+		}
+	}
+	token = GenerateToken()
+	expiresAt = now.Add(a.loginTTL)
+	a.logins[token] = login{key: chat.NormalizeName(account.Name), expiresAt: expiresAt}
+	return token, expiresAt
+}
+
+// LookupLogin はログイントークンのアカウントを返します。見つからないか有効期限が切れている場合はErrLoginExpiredを返します。
+func (a *Accounts) LookupLogin(token string) (Account, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	l, ok := a.logins[token]
+	if !ok || !time.Now().Before(l.expiresAt) {
+		return Account{}, ErrLoginExpired
+	}
+	account, err := a.store.Account(l.key)
+	if err != nil {
+		return Account{}, ErrLoginExpired
+	}
+	return account, nil
+}
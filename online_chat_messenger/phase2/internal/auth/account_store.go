@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AccountStore はアカウントの保存先のインターフェースです。
+// keyはchat.NormalizeNameで正規化した名前で、Accountsが計算して渡します。
+type AccountStore interface {
+	// Account はアカウントを返します。見つからない場合はErrAccountNotFoundを返します。
+	Account(key string) (Account, error)
+	// SaveAccount はアカウントを保存します。同じキーのアカウントは上書きします。
+	SaveAccount(key string, account Account) error
+}
+
+// MemoryAccountStore はメモリ上にアカウントを保持するAccountStoreです。再起動すると消えます。
+type MemoryAccountStore struct {
+	accounts map[string]Account
+	mutex    sync.RWMutex
+}
+
+// NewMemoryAccountStore は空のMemoryAccountStoreを生成します。
+func NewMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{accounts: make(map[string]Account)}
+}
+
+// Account はアカウントを返します。
+func (s *MemoryAccountStore) Account(key string) (Account, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	account, ok := s.accounts[key]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+// SaveAccount はアカウントを保存します。
+func (s *MemoryAccountStore) SaveAccount(key string, account Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accounts[key] = account
+	return nil
+}
+
+// FileAccountStore はアカウントをJSONファイルに保存するAccountStoreです。
+// 保存のたびに一時ファイルへ書き出してから置き換えるため、書き込み途中で停止してもファイルは壊れません。
+// ファイルにはパスワードのハッシュが含まれるため、所有者だけが読み書きできる権限で作成します。
+type FileAccountStore struct {
+	path     string
+	accounts map[string]Account
+	mutex    sync.RWMutex
+}
+
+// OpenFileAccountStore はファイルからアカウントを読み込みます。ファイルがない場合は空の状態で開きます。
+func OpenFileAccountStore(path string) (*FileAccountStore, error) {
+	s := &FileAccountStore{path: path, accounts: make(map[string]Account)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("アカウントのファイルの読み込みに失敗しました: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.accounts); err != nil {
+		return nil, fmt.Errorf("アカウントのファイルの解析に失敗しました: %w", err)
+	}
+	return s, nil
+}
+
+// Account はアカウントを返します。
+func (s *FileAccountStore) Account(key string) (Account, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	account, ok := s.accounts[key]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+// SaveAccount はアカウントを保存し、ファイルに書き出します。書き出せなかった場合は保存前の状態に戻します。
+func (s *FileAccountStore) SaveAccount(key string, account Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.accounts[key]
+	s.accounts[key] = account
+	if err := s.writeLocked(); err != nil {
+		if existed {
+			s.accounts[key] = previous
+		} else {
+			delete(s.accounts, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeLocked はすべてのアカウントをファイルに書き出します。呼び出し元でs.mutexをロックしておく必要があります。
+func (s *FileAccountStore) writeLocked() error {
+	data, err := json.MarshalIndent(s.accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
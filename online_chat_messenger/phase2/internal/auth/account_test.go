@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIterations はテストを速くするためのPBKDF2の反復回数です。
+const testIterations = 1000
+
+func newTestAccounts(store AccountStore) *Accounts {
+	a := NewAccounts(store)
+	a.SetPolicy(testIterations, DefaultMinPasswordLength, time.Hour)
+	return a
+}
+
+func TestAccountsRegisterAndLogin(t *testing.T) {
+	a := newTestAccounts(NewMemoryAccountStore())
+	if _, err := a.Register("Taro", "password1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 正規化した名前が同じアカウントは登録できない
+	if _, err := a.Register("ｔａｒｏ", "password2"); !errors.Is(err, ErrNameReserved) {
+		t.Errorf("Register(duplicate) = %v, want ErrNameReserved", err)
+	}
+	if !a.IsReserved("TARO") {
+		t.Error("IsReserved(TARO) = false")
+	}
+
+	token, account, _, err := a.Login("taro", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "Taro" {
+		t.Errorf("Login account = %q, want the registered name", account.Name)
+	}
+	if got, err := a.LookupLogin(token); err != nil || got.Name != "Taro" {
+		t.Errorf("LookupLogin = %+v, %v", got, err)
+	}
+
+	for _, tt := range []struct{ name, password string }{
+		{"taro", "password2"},
+		{"jiro", "password1"},
+		{"taro", strings.Repeat("a", MaxPasswordLength+1)},
+	} {
+		if _, _, _, err := a.Login(tt.name, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login(%q, %q) = %v, want ErrInvalidCredentials", tt.name, tt.password, err)
+		}
+	}
+	if _, err := a.LookupLogin("unknown"); !errors.Is(err, ErrLoginExpired) {
+		t.Errorf("LookupLogin(unknown) = %v, want ErrLoginExpired", err)
+	}
+}
+
+func TestAccountsPasswordPolicy(t *testing.T) {
+	a := newTestAccounts(NewMemoryAccountStore())
+	for _, password := range []string{"short", strings.Repeat("a", MaxPasswordLength+1)} {
+		if _, err := a.Register("taro", password); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("Register(%d bytes) = %v, want ErrInvalidPassword", len(password), err)
+		}
+	}
+	// 文字数で数えるため、8文字の日本語は8バイト未満の扱いにならない
+	if _, err := a.Register("taro", "パスワードです。"); err != nil {
+		t.Errorf("Register(8 characters) = %v", err)
+	}
+}
+
+func TestAccountsReservedNames(t *testing.T) {
+	a := newTestAccounts(NewMemoryAccountStore())
+	a.SetReservedNames([]string{"admin"})
+	if !a.IsReserved("ＡＤＭＩＮ") {
+		t.Error("IsReserved(ＡＤＭＩＮ) = false")
+	}
+	if _, err := a.Register("Admin", "password1"); !errors.Is(err, ErrNameReserved) {
+		t.Errorf("Register(reserved) = %v, want ErrNameReserved", err)
+	}
+	if a.IsReserved("guest") {
+		t.Error("IsReserved(guest) = true")
+	}
+}
+
+func TestAccountsLoginExpires(t *testing.T) {
+	a := newTestAccounts(NewMemoryAccountStore())
+	a.SetPolicy(testIterations, DefaultMinPasswordLength, -time.Second)
+	account, err := a.Register("taro", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := a.IssueLogin(account)
+	if _, err := a.LookupLogin(token); !errors.Is(err, ErrLoginExpired) {
+		t.Errorf("LookupLogin = %v, want ErrLoginExpired", err)
+	}
+}
+
+func TestFileAccountStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	store, err := OpenFileAccountStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestAccounts(store).Register("taro", "password1"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileAccountStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAccounts(reopened)
+	if _, err := a.Authenticate("TARO", "password1"); err != nil {
+		t.Errorf("Authenticate after reopening = %v", err)
+	}
+	if _, err := a.Authenticate("taro", "password2"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate(wrong password) = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BanEntry は接続を拒否するアドレスの情報です。
+type BanEntry struct {
+	Address  string    `json:"address"` // IPアドレス（ポート番号は含まない）
+	BannedAt time.Time `json:"banned_at"`
+}
+
+// BanList は接続を拒否するIPアドレスの一覧です。複数のゴルーチンから安全に使用できます。
+type BanList struct {
+	entries map[string]time.Time
+	mutex   sync.RWMutex
+}
+
+// NewBanList は空のBanListを生成します。
+func NewBanList() *BanList {
+	return &BanList{entries: make(map[string]time.Time)}
+}
+
+// Ban はアドレスを一覧に追加します。"host:port" 形式の場合はポート番号を取り除きます。
+// 追加したアドレスを返します。
+func (b *BanList) Ban(address string) string {
+	host := hostOf(address)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, exists := b.entries[host]; !exists {
+		b.entries[host] = time.Now()
+	}
+	return host
+}
+
+// Unban はアドレスを一覧から削除します。一覧になかった場合はfalseを返します。
+func (b *BanList) Unban(address string) bool {
+	host := hostOf(address)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, exists := b.entries[host]; !exists {
+		return false
+	}
+	delete(b.entries, host)
+	return true
+}
+
+// IsBanned はアドレスが一覧に含まれているかを返します。
+func (b *BanList) IsBanned(address string) bool {
+	host := hostOf(address)
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, exists := b.entries[host]
+	return exists
+}
+
+// List は一覧をアドレス順に返します。
+func (b *BanList) List() []BanEntry {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	entries := make([]BanEntry, 0, len(b.entries))
+	for address, bannedAt := range b.entries {
+		entries = append(entries, BanEntry{Address: address, BannedAt: bannedAt})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+	return entries
+}
+
+// hostOf は "host:port" 形式のアドレスからホスト部分を取り出します。
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
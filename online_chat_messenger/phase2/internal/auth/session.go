@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"log/slog"
+	"sync"
+
+	"online_chat_messenger/internal/store"
+)
+
+// sessionWriter はセッションの保存・削除を、ユーザーの表のロックの外でまとめて保存先に書き込みます。
+// FileStoreは1件ごとにディスクに同期するため、パケットの処理やユーザーの表のロックを書き込みで待たせないようにします。
+// 同じトークンの変更が書き込む前に重なった場合は、最後の変更だけを書き込みます。
+type sessionWriter struct {
+	store   store.Store
+	logger  *slog.Logger
+	pending map[string]*store.Session // キーはトークン、nilはセッションの削除
+	mutex   sync.Mutex
+
+	writeMutex sync.Mutex // 古い変更を新しい変更の後に書き込まないよう、書き込みを1つずつ行う
+	wake       chan struct{}
+	done       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+}
+
+// newSessionWriter は保存先を指定してsessionWriterを生成し、書き込みを行うゴルーチンを開始します。
+func newSessionWriter(s store.Store, logger *slog.Logger) *sessionWriter {
+	w := &sessionWriter{
+		store:   s,
+		logger:  logger,
+		pending: make(map[string]*store.Session),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// save はセッションの保存を予約します。
+func (w *sessionWriter) save(session store.Session) {
+	w.enqueue(session.Token, &session)
+}
+
+// delete はセッションの削除を予約します。
+func (w *sessionWriter) delete(token string) {
+	w.enqueue(token, nil)
+}
+
+func (w *sessionWriter) enqueue(token string, session *store.Session) {
+	w.mutex.Lock()
+	w.pending[token] = session
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop は変更が予約されるたびに、たまっている変更をまとめて書き込みます。
+func (w *sessionWriter) loop() {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-w.wake:
+			w.flush()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// flush は予約されている変更をすべて書き込みます。
+func (w *sessionWriter) flush() {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+
+	w.mutex.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*store.Session)
+	w.mutex.Unlock()
+
+	for token, session := range pending {
+		if session == nil {
+			if err := w.store.DeleteSession(token); err != nil {
+				w.logger.Warn("セッションの削除に失敗しました", "error", err)
+			}
+			continue
+		}
+		if err := w.store.SaveSession(*session); err != nil {
+			w.logger.Warn("セッションの保存に失敗しました", "user", session.UserName, "error", err)
+		}
+	}
+}
+
+// close は書き込みを行うゴルーチンを停止し、残っている変更を書き込みます。
+func (w *sessionWriter) close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		<-w.stopped
+		w.flush()
+	})
+}
@@ -1,8 +1,384 @@
 package auth
 
-import "github.com/google/uuid"
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"online_chat_messenger/internal/store"
+)
 
 // GenerateToken は新しいトークンを生成します。
+// ログイントークンのように、サーバーが表で対応を管理するトークンに使います。
 func GenerateToken() string {
 	return uuid.New().String()
 }
+
+// TokenKey はトークンの署名に使う鍵です。
+type TokenKey struct {
+	ID     string `json:"id"`     // トークンに含める鍵の識別子（英数字・"_"・"-" の8文字以内）
+	Secret []byte `json:"secret"` // MinTokenSecretLengthバイト以上のランダムな値
+}
+
+const (
+	// DefaultTokenTTL はルームのトークンのデフォルトの有効期間です。
+	DefaultTokenTTL = 24 * time.Hour
+	// MinTokenSecretLength は署名に使う鍵の最小バイト数です。
+	MinTokenSecretLength = 32
+	// MaxTokenKeyIDLength は鍵の識別子の最大文字数です。
+	MaxTokenKeyIDLength = 8
+	// MaxTokenLength はトークンの最大バイト数です。UDPパケットのヘッダーではトークンのサイズを1バイトで表すため、これを超えられません。
+	MaxTokenLength = 255
+)
+
+// トークンに含める役割です。発行したときの役割で、あとからホストが変わっても書き換わりません。
+const (
+	RoleMember = "member"
+	RoleHost   = "host"
+)
+
+var (
+	// ErrInvalidToken はトークンの形式か署名が正しくないことを表します。
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired はトークンの有効期限が切れていることを表します。
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenRevoked はトークンが取り消されていることを表します。
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrInvalidTokenKey は署名に使う鍵の設定が正しくないことを表します。
+	ErrInvalidTokenKey = errors.New("トークンの鍵が正しくありません")
+)
+
+// TokenClaims はトークンが表す内容です。
+type TokenClaims struct {
+	SessionID string    `json:"session_id"` // セッションの識別子（ゲストを含むすべてのトークンに入り、取り消しに使う）
+	Account   string    `json:"account"`    // 登録ユーザーのアカウントの名前（ゲストは空）
+	RoomName  string    `json:"room_name"`
+	Role      string    `json:"role"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenVersion はトークンのペイロードの形式のバージョンです。
+const tokenVersion = 1
+
+// tokenHeaderSize はペイロードのうちアカウントの名前より前の部分のバイト数です。
+// バージョン(1) 役割(1) 発行時刻(4) 有効期限(4) セッションの識別子(8) の順に並びます。
+const tokenHeaderSize = 18
+
+// tokenEncoding はトークンの各部分に使うエンコーディングです。
+var tokenEncoding = base64.RawURLEncoding
+
+// TokenSigner はルームのトークンをHMAC-SHA256で署名・検証します。複数のゴルーチンから安全に使用できます。
+//
+// トークンは "<鍵の識別子>.<ペイロード>.<署名>" の形式で、ペイロードにはセッションの識別子・アカウントの名前・役割・発行時刻・有効期限が入ります。
+// セッションの識別子は発行のたびにランダムに決まり、ゲストを含めてユーザーのセッションを識別します。
+// 表示名は /nick で変わるためトークンに含めず、変わらないアカウントの名前だけを入れます。
+// ルーム名はUDPパケットやリクエストに含まれるためトークンには入れず、署名の計算にだけ使います。
+// そのため、ユーザーの表を引かずに、トークンがそのルームのために発行されたものかを確認できます。
+//
+// 署名には先頭の鍵を使い、検証には登録されているすべての鍵を使います。
+// 新しい鍵を先頭に追加し、古い鍵で署名したトークンの有効期限が切れてから古い鍵を削除すると、参加中のユーザーを退出させずに鍵を切り替えられます。
+//
+// 取り消したトークンは、SetStoreで保存先を設定すると保存先にも書き込み、再起動後にRestoreで復元できます。
+type TokenSigner struct {
+	keys    map[string][]byte
+	current string // 署名に使う鍵の識別子
+	ttl     time.Duration
+	revoked map[string]time.Time // 取り消したトークンのセッションの識別子と、そのトークンの有効期限
+	store   store.Store          // 取り消しの保存先（nilの場合はメモリ上にだけ保持する）
+	mutex   sync.RWMutex
+}
+
+// NewTokenSigner は鍵を指定して新しいTokenSignerを生成します。先頭の鍵で署名します。
+func NewTokenSigner(keys []TokenKey) (*TokenSigner, error) {
+	s := &TokenSigner{ttl: DefaultTokenTTL, revoked: make(map[string]time.Time)}
+	if err := s.SetKeys(keys); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetKeys は署名・検証に使う鍵を入れ替えます。先頭の鍵で署名し、一覧にない鍵で署名されたトークンは無効になります。
+func (s *TokenSigner) SetKeys(keys []TokenKey) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("%w: 鍵を1つ以上指定してください", ErrInvalidTokenKey)
+	}
+	m := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if err := ValidateTokenKey(key); err != nil {
+			return err
+		}
+		if _, exists := m[key.ID]; exists {
+			return fmt.Errorf("%w: 識別子 %s が重複しています", ErrInvalidTokenKey, key.ID)
+		}
+		m[key.ID] = key.Secret
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = m
+	s.current = keys[0].ID
+	return nil
+}
+
+// SetStore は取り消しの保存先を設定します。以降に取り消したトークンは保存先にも書き込みます。
+// 保存されていた取り消しを再び書き込まないよう、Restoreで復元してから設定します。
+func (s *TokenSigner) SetStore(st store.Store) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.store = st
+}
+
+// Restore は保存されていた取り消しの一覧を復元し、復元した件数を返します。有効期限の切れたものは復元しません。
+// キーはセッションの識別子、値はトークンの有効期限です。
+func (s *TokenSigner) Restore(revoked map[string]time.Time) int {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	restored := 0
+	for id, expiresAt := range revoked {
+		if now.Before(expiresAt) {
+			s.revoked[id] = expiresAt
+			restored++
+		}
+	}
+	return restored
+}
+
+// SetTTL は以降に発行するトークンの有効期間を設定します。
+func (s *TokenSigner) SetTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ttl = ttl
+}
+
+// Issue はルームのユーザーのトークンを発行します。accountには登録ユーザーのアカウントの名前を指定し、ゲストの場合は空にします。
+// hostがtrueの場合はホストの役割になります。
+func (s *TokenSigner) Issue(account, roomName string, host bool) (string, TokenClaims, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", TokenClaims{}, fmt.Errorf("セッションの識別子の生成に失敗しました: %w", err)
+	}
+
+	s.mutex.RLock()
+	kid, secret, ttl := s.current, s.keys[s.current], s.ttl
+	s.mutex.RUnlock()
+
+	// 秒単位で記録するため、切り捨てた時刻を使う
+	now := time.Now().Truncate(time.Second)
+	claims := TokenClaims{
+		SessionID: hex.EncodeToString(id),
+		Account:   account,
+		RoomName:  roomName,
+		Role:      RoleMember,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	payload := make([]byte, tokenHeaderSize, tokenHeaderSize+len(account))
+	payload[0] = tokenVersion
+	if host {
+		claims.Role = RoleHost
+		payload[1] = 1
+	}
+	binary.BigEndian.PutUint32(payload[2:6], uint32(claims.IssuedAt.Unix()))
+	binary.BigEndian.PutUint32(payload[6:10], uint32(claims.ExpiresAt.Unix()))
+	copy(payload[10:18], id)
+	payload = append(payload, account...)
+
+	signed := kid + "." + tokenEncoding.EncodeToString(payload)
+	token := signed + "." + tokenEncoding.EncodeToString(tokenMAC(secret, signed, roomName))
+	if len(token) > MaxTokenLength {
+		return "", TokenClaims{}, fmt.Errorf("トークンが%dバイトを超えました: アカウントの名前が長すぎます", MaxTokenLength)
+	}
+	return token, claims, nil
+}
+
+// Verify はトークンの署名・有効期限・取り消しを確認し、トークンの内容を返します。
+// roomNameにはトークンを使ったルームの名前を指定し、別のルームのために発行されたトークンはErrInvalidTokenになります。
+func (s *TokenSigner) Verify(token, roomName string) (TokenClaims, error) {
+	kid, payloadPart, macPart, ok := splitToken(token)
+	if !ok {
+		return TokenClaims{}, ErrInvalidToken
+	}
+	mac, err := tokenEncoding.DecodeString(macPart)
+	if err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	s.mutex.RLock()
+	secret, ok := s.keys[kid]
+	s.mutex.RUnlock()
+	if !ok || !hmac.Equal(mac, tokenMAC(secret, kid+"."+payloadPart, roomName)) {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	claims, err := decodeTokenPayload(payloadPart)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	claims.RoomName = roomName
+	if !time.Now().Before(claims.ExpiresAt) {
+		return TokenClaims{}, ErrTokenExpired
+	}
+
+	s.mutex.RLock()
+	_, revoked := s.revoked[claims.SessionID]
+	s.mutex.RUnlock()
+	if revoked {
+		return TokenClaims{}, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Revoke はトークンを取り消し、有効期限までVerifyで拒否するようにします。退出させたユーザーのトークンに使います。
+// 保存先が設定されている場合は保存先にも書き込み、書き込みに失敗した場合もメモリ上では取り消したうえでエラーを返します。
+// 有効期限の切れたトークンは一覧から削除されます。
+func (s *TokenSigner) Revoke(token string) error {
+	_, payloadPart, _, ok := splitToken(token)
+	if !ok {
+		return ErrInvalidToken
+	}
+	claims, err := decodeTokenPayload(payloadPart)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.mutex.Lock()
+	for id, expiresAt := range s.revoked {
+		if !now.Before(expiresAt) {
+			delete(s.revoked, id)
+		}
+	}
+	if !now.Before(claims.ExpiresAt) {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.revoked[claims.SessionID] = claims.ExpiresAt
+	st := s.store
+	s.mutex.Unlock()
+
+	if st != nil {
+		if err := st.SaveRevocation(claims.SessionID, claims.ExpiresAt); err != nil {
+			return fmt.Errorf("トークンの取り消しの保存に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+// tokenMAC はトークンの署名を計算します。ルーム名はトークンに含めず、署名の対象にだけ加えます。
+// signedは "<鍵の識別子>.<ペイロード>" の部分で、0バイトを含まないため区切りに0バイトを使います。
+func tokenMAC(secret []byte, signed, roomName string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signed))
+	h.Write([]byte{0})
+	h.Write([]byte(roomName))
+	return h.Sum(nil)
+}
+
+// splitToken はトークンを鍵の識別子・ペイロード・署名に分けます。
+func splitToken(token string) (kid, payload, mac string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// decodeTokenPayload はトークンのペイロードを読み取ります。ルーム名はペイロードに含まれないため空のままです。
+func decodeTokenPayload(payloadPart string) (TokenClaims, error) {
+	payload, err := tokenEncoding.DecodeString(payloadPart)
+	if err != nil || len(payload) < tokenHeaderSize || payload[0] != tokenVersion {
+		return TokenClaims{}, ErrInvalidToken
+	}
+	claims := TokenClaims{
+		SessionID: hex.EncodeToString(payload[10:18]),
+		Account:   string(payload[tokenHeaderSize:]),
+		Role:      RoleMember,
+		IssuedAt:  time.Unix(int64(binary.BigEndian.Uint32(payload[2:6])), 0),
+		ExpiresAt: time.Unix(int64(binary.BigEndian.Uint32(payload[6:10])), 0),
+	}
+	if payload[1] == 1 {
+		claims.Role = RoleHost
+	}
+	return claims, nil
+}
+
+// ValidateTokenKey は鍵の識別子と長さを確認します。
+func ValidateTokenKey(key TokenKey) error {
+	if err := ValidateTokenKeyID(key.ID); err != nil {
+		return err
+	}
+	if len(key.Secret) < MinTokenSecretLength {
+		return fmt.Errorf("%w: %s: 鍵は%dバイト以上必要です", ErrInvalidTokenKey, key.ID, MinTokenSecretLength)
+	}
+	return nil
+}
+
+// ValidateTokenKeyID は鍵の識別子が英数字・"_"・"-" の MaxTokenKeyIDLength 文字以内かを確認します。
+func ValidateTokenKeyID(id string) error {
+	if id == "" || len(id) > MaxTokenKeyIDLength {
+		return fmt.Errorf("%w: 識別子は%d文字以内で指定してください: %q", ErrInvalidTokenKey, MaxTokenKeyIDLength, id)
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("%w: 識別子に使えない文字が含まれています: %q", ErrInvalidTokenKey, id)
+		}
+	}
+	return nil
+}
+
+// GenerateTokenKey はランダムな鍵を生成します。
+func GenerateTokenKey(id string) (TokenKey, error) {
+	secret := make([]byte, MinTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return TokenKey{}, fmt.Errorf("鍵の生成に失敗しました: %w", err)
+	}
+	return TokenKey{ID: id, Secret: secret}, nil
+}
+
+// LoadOrCreateTokenKey はファイルから鍵を読み込みます。ファイルがない場合はランダムな鍵を生成して保存します。
+// 鍵を設定していないサーバーでも、再起動の前に発行したトークンを使い続けられるようにするためのものです。
+func LoadOrCreateTokenKey(path, id string) (TokenKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var key TokenKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return TokenKey{}, fmt.Errorf("鍵のファイルの解析に失敗しました: %w", err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return TokenKey{}, fmt.Errorf("鍵のファイルの読み込みに失敗しました: %w", err)
+	}
+
+	key, err := GenerateTokenKey(id)
+	if err != nil {
+		return TokenKey{}, err
+	}
+	data, err = json.Marshal(key)
+	if err != nil {
+		return TokenKey{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return TokenKey{}, fmt.Errorf("鍵のファイルの保存に失敗しました: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return TokenKey{}, fmt.Errorf("鍵のファイルの保存に失敗しました: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/store"
+)
+
+func newTestSigner(t *testing.T, ids ...string) (*TokenSigner, []TokenKey) {
+	t.Helper()
+	keys := make([]TokenKey, len(ids))
+	for i, id := range ids {
+		key, err := GenerateTokenKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	s, err := NewTokenSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, keys
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+
+	for _, tt := range []struct {
+		account string
+		host    bool
+		role    string
+	}{
+		{"", false, RoleMember},
+		{"taro", true, RoleHost},
+		{"たろう", false, RoleMember},
+	} {
+		token, issued, err := s.Issue(tt.account, "lobby", tt.host)
+		if err != nil {
+			t.Fatalf("Issue(%q): %v", tt.account, err)
+		}
+		if !strings.HasPrefix(token, "k1.") || len(token) > MaxTokenLength {
+			t.Errorf("Issue(%q) = %q", tt.account, token)
+		}
+
+		claims, err := s.Verify(token, "lobby")
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if claims != issued {
+			t.Errorf("Verify = %+v, want %+v", claims, issued)
+		}
+		if len(claims.SessionID) != 16 || claims.Account != tt.account || claims.RoomName != "lobby" || claims.Role != tt.role {
+			t.Errorf("Verify = %+v", claims)
+		}
+		if !claims.ExpiresAt.Equal(claims.IssuedAt.Add(DefaultTokenTTL)) {
+			t.Errorf("ExpiresAt = %v, IssuedAt = %v", claims.ExpiresAt, claims.IssuedAt)
+		}
+	}
+}
+
+func TestTokenRejectsTampering(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+	token, _, err := s.Issue("taro", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, payloadPart, macPart, _ := splitToken(token)
+
+	// 役割をホストに書き換えたペイロード
+	payload, err := tokenEncoding.DecodeString(payloadPart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload[1] = 1
+	promoted := kid + "." + tokenEncoding.EncodeToString(payload) + "." + macPart
+
+	// 署名の1バイトを書き換えたトークン
+	mac, err := tokenEncoding.DecodeString(macPart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac[0] ^= 0xff
+	forged := kid + "." + payloadPart + "." + tokenEncoding.EncodeToString(mac)
+
+	for name, tampered := range map[string]string{
+		"role":        promoted,
+		"mac":         forged,
+		"unknown key": "k2." + payloadPart + "." + macPart,
+		"missing mac": kid + "." + payloadPart,
+		"empty":       "",
+		"not base64":  kid + ".!!!." + macPart,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.Verify(tampered, "lobby"); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestTokenIsBoundToRoom(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+	token, _, err := s.Issue("", "lobby", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token, "other"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify(other room) = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTokenExpires(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+	s.SetTTL(-time.Second)
+	token, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(token, "lobby"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Verify = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestTokenRevoke(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+	revoked, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Revoke(revoked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(revoked, "lobby"); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Verify(revoked) = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := s.Verify(other, "lobby"); err != nil {
+		t.Errorf("Verify(other) = %v", err)
+	}
+	if err := s.Revoke("garbage"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Revoke(garbage) = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTokenRevocationIsRestored(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, keys := newTestSigner(t, "k1")
+	s.SetStore(st)
+	revoked, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(revoked); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 再起動後のサーバーで、保存した取り消しの一覧を復元する
+	reopened, err := store.OpenFileStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	state, err := reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := NewTokenSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := restarted.Restore(state.Revoked); n != 1 {
+		t.Errorf("Restore = %d, want 1", n)
+	}
+	if _, err := restarted.Verify(revoked, "lobby"); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Verify(revoked) after restart = %v, want ErrTokenRevoked", err)
+	}
+
+	// 有効期限の切れた取り消しは復元しない
+	if n := restarted.Restore(map[string]time.Time{"0011223344556677": time.Now().Add(-time.Second)}); n != 0 {
+		t.Errorf("Restore(expired) = %d, want 0", n)
+	}
+}
+
+func TestTokenKeyRotation(t *testing.T) {
+	s, oldKeys := newTestSigner(t, "old")
+	oldToken, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新しい鍵を先頭に追加すると、新しい鍵で署名し、古い鍵のトークンも検証できる
+	newKey, err := GenerateTokenKey("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKeys([]TokenKey{newKey, oldKeys[0]}); err != nil {
+		t.Fatal(err)
+	}
+	newToken, _, err := s.Issue("", "lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newToken, "new.") {
+		t.Errorf("Issue = %q, want the new key", newToken)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := s.Verify(token, "lobby"); err != nil {
+			t.Errorf("Verify(%q) = %v", token, err)
+		}
+	}
+
+	// 古い鍵を削除すると、古い鍵のトークンは使えなくなる
+	if err := s.SetKeys([]TokenKey{newKey}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(oldToken, "lobby"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify(old) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.Verify(newToken, "lobby"); err != nil {
+		t.Errorf("Verify(new) = %v", err)
+	}
+}
+
+func TestTokenRejectsLongAccount(t *testing.T) {
+	s, _ := newTestSigner(t, "k1")
+	if _, _, err := s.Issue(strings.Repeat("a", MaxTokenLength), "lobby", false); err == nil {
+		t.Error("Issue with a long account succeeded")
+	}
+}
+
+func TestSetKeysValidation(t *testing.T) {
+	valid, err := GenerateTokenKey("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, keys := range map[string][]TokenKey{
+		"empty":        nil,
+		"short":        {{ID: "k1", Secret: make([]byte, MinTokenSecretLength-1)}},
+		"bad id":       {{ID: "k.1", Secret: valid.Secret}},
+		"long id":      {{ID: "123456789", Secret: valid.Secret}},
+		"duplicate":    {valid, valid},
+		"missing id":   {{Secret: valid.Secret}},
+		"non-ascii id": {{ID: "鍵", Secret: valid.Secret}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewTokenSigner(keys); !errors.Is(err, ErrInvalidTokenKey) {
+				t.Errorf("NewTokenSigner = %v, want ErrInvalidTokenKey", err)
+			}
+		})
+	}
+}
+
+func TestLoadOrCreateTokenKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store", "token.key")
+	created, err := LoadOrCreateTokenKey(path, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrCreateTokenKey(path, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID != "auto" || !bytes.Equal(loaded.Secret, created.Secret) {
+		t.Errorf("LoadOrCreateTokenKey = %+v, want %+v", loaded, created)
+	}
+}
@@ -2,48 +2,77 @@ package auth
 
 import (
 	"errors"
-	"fmt"
+	"log/slog"
+	"net"
 	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/store"
 	"sync"
 	"time"
 )
 
 // UserManager はユーザー管理のインターフェースです。
 type UserManager interface {
-	RegisterUser(token string, user chat.User) error
+	RegisterUser(token, roomName string, user chat.User) error
 	FindUser(token string) (chat.User, error)
 	DeleteUser(token string) error
 }
 
+const (
+	// DefaultInactiveTimeout は非アクティブとみなすまでのデフォルトの時間です。
+	DefaultInactiveTimeout = 5 * time.Minute
+	// DefaultCleanupInterval は非アクティブユーザーを削除する処理のデフォルトの実行間隔です。
+	DefaultCleanupInterval = 1 * time.Minute
+)
+
 // SimpleUserManager はUserManagerのシンプルな実装です。
 type SimpleUserManager struct {
 	users           map[string]chat.User
-	lastActivityMap map[string]int64
+	rooms           map[string]string // キーはトークン、値はユーザーが参加しているルームの名前
+	lastActivityMap map[string]time.Time
 	mutex           sync.RWMutex
 	roomManager     chat.RoomManager
+	sessions        *sessionWriter // セッションの保存先への書き込み（nilの場合は保存しない）
+	logger          *slog.Logger
+	inactiveTimeout time.Duration
+	intervalCh      chan time.Duration // 削除処理の実行間隔の変更を通知する
+	done            chan struct{}
+	closeOnce       sync.Once
 }
 
 // NewSimpleUserManager は新しいSimpleUserManagerを生成します。
 func NewSimpleUserManager() *SimpleUserManager {
+	return NewSimpleUserManagerWithTimeout(DefaultInactiveTimeout, DefaultCleanupInterval)
+}
+
+// NewSimpleUserManagerWithTimeout は非アクティブ判定の時間と削除処理の実行間隔を指定して
+// 新しいSimpleUserManagerを生成します。
+func NewSimpleUserManagerWithTimeout(inactiveTimeout, cleanupInterval time.Duration) *SimpleUserManager {
 	manager := &SimpleUserManager{
 		users:           make(map[string]chat.User),
-		lastActivityMap: make(map[string]int64),
+		rooms:           make(map[string]string),
+		lastActivityMap: make(map[string]time.Time),
 		mutex:           sync.RWMutex{},
+		logger:          slog.Default().With("component", "auth"),
+		inactiveTimeout: inactiveTimeout,
+		intervalCh:      make(chan time.Duration),
+		done:            make(chan struct{}),
 	}
 
 	// 非アクティブユーザーを定期的に削除するゴルーチンを開始
-	go manager.cleanupInactiveUsers()
+	go manager.cleanupInactiveUsers(cleanupInterval)
 
 	return manager
 }
 
-// RegisterUser はユーザーを登録します。
-func (m *SimpleUserManager) RegisterUser(token string, user chat.User) error {
+// RegisterUser はユーザーを、参加したルームの名前とともに登録します。
+func (m *SimpleUserManager) RegisterUser(token, roomName string, user chat.User) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	m.users[token] = user
-	m.lastActivityMap[token] = time.Now().Unix()
+	m.rooms[token] = roomName
+	m.lastActivityMap[token] = time.Now()
+	m.saveSession(token, user)
 	return nil
 }
 
@@ -64,11 +93,28 @@ func (m *SimpleUserManager) DeleteUser(token string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if _, exists := m.users[token]; exists {
+		m.deleteSession(token)
+	}
 	delete(m.users, token)
+	delete(m.rooms, token)
 	delete(m.lastActivityMap, token)
 	return nil
 }
 
+// SaveSession はユーザーのセッションを保存し直します。UDPアドレスが変わったときに呼び出します。
+func (m *SimpleUserManager) SaveSession(token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[token]
+	if !exists {
+		return errors.New("user not found")
+	}
+	m.saveSession(token, user)
+	return nil
+}
+
 // UpdateActivity はユーザーの最終アクティビティ時間を更新します。
 func (m *SimpleUserManager) UpdateActivity(token string) error {
 	m.mutex.Lock()
@@ -78,55 +124,179 @@ func (m *SimpleUserManager) UpdateActivity(token string) error {
 		return errors.New("user not found")
 	}
 
-	m.lastActivityMap[token] = time.Now().Unix()
+	m.lastActivityMap[token] = time.Now()
+	return nil
+}
+
+// SetTimeouts は非アクティブ判定の時間と削除処理の実行間隔を変更します。
+// 実行中のサーバーにも即座に反映されます。
+func (m *SimpleUserManager) SetTimeouts(inactiveTimeout, cleanupInterval time.Duration) {
+	m.mutex.Lock()
+	m.inactiveTimeout = inactiveTimeout
+	m.mutex.Unlock()
+
+	select {
+	case m.intervalCh <- cleanupInterval:
+	case <-m.done:
+	}
+}
+
+// Close は非アクティブユーザーの削除処理とセッションの書き込みを停止します。保存を待っているセッションは書き込んでから停止します。
+func (m *SimpleUserManager) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+
+	m.mutex.RLock()
+	sessions := m.sessions
+	m.mutex.RUnlock()
+	if sessions != nil {
+		sessions.close()
+	}
 	return nil
 }
 
+// Flush は保存を待っているセッションの変更を保存先に書き込みます。保存先を閉じる前に呼び出してください。
+func (m *SimpleUserManager) Flush() {
+	m.mutex.RLock()
+	sessions := m.sessions
+	m.mutex.RUnlock()
+	if sessions != nil {
+		sessions.flush()
+	}
+}
+
 // cleanupInactiveUsers は非アクティブなユーザーを定期的に削除します。
-func (m *SimpleUserManager) cleanupInactiveUsers() {
-	ticker := time.NewTicker(1 * time.Minute)
+func (m *SimpleUserManager) cleanupInactiveUsers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.removeInactiveUsers()
+	for {
+		select {
+		case <-ticker.C:
+			m.removeInactiveUsers()
+		case interval := <-m.intervalCh:
+			ticker.Reset(interval)
+		case <-m.done:
+			return
+		}
 	}
 }
 
-// removeInactiveUsers は5分間アクティビティがなかったユーザーを削除します。
+// removeInactiveUsers は一定時間アクティビティがなかったユーザーを削除します。
 func (m *SimpleUserManager) removeInactiveUsers() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	now := time.Now().Unix()
-	inactiveThreshold := int64(5 * 60) // 5分（秒単位）
+	now := time.Now()
 
 	for token, lastActivity := range m.lastActivityMap {
-		if now-lastActivity > inactiveThreshold {
+		if now.Sub(lastActivity) > m.inactiveTimeout {
 			// ユーザーが所属するルームからも削除する必要がある
 			if user, exists := m.users[token]; exists {
-				// ルームマネージャーが設定されている場合、ルームからユーザーを削除
+				// ルームマネージャーが設定されている場合、参加しているルームからユーザーを削除
 				if m.roomManager != nil {
-					// すべてのルームをチェックし、ユーザーが所属していれば削除
-					for _, room := range m.getRooms() {
+					if room, err := m.roomManager.FindRoom(m.rooms[token]); err == nil {
 						room.RemoveUser(user)
-						fmt.Printf("非アクティブユーザー '%s' をルーム '%s' から削除しました\n",
-							user.GetName(), room.GetName())
+						m.logger.Debug("非アクティブユーザーをルームから削除しました",
+							"user", user.GetName(), "room", room.GetName())
 					}
 				}
-				fmt.Printf("非アクティブユーザー '%s' を削除します\n", user.GetName())
+				m.logger.Info("非アクティブユーザーを削除します", "user", user.GetName(), "idle", now.Sub(lastActivity).Round(time.Millisecond))
+				m.deleteSession(token)
 				delete(m.users, token)
+				delete(m.rooms, token)
 				delete(m.lastActivityMap, token)
 			}
 		}
 	}
 }
 
-// getRooms はルームマネージャーからすべてのルームを取得します
-func (m *SimpleUserManager) getRooms() []chat.Room {
-	if simpleRoomManager, ok := m.roomManager.(*chat.SimpleRoomManager); ok {
-		return simpleRoomManager.GetAllRooms()
+// SetLogger はログの出力先となるロガーを設定します。
+func (m *SimpleUserManager) SetLogger(logger *slog.Logger) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.logger = logger.With("component", "auth")
+}
+
+// SetStore はセッションの保存先を設定します。以降に登録・削除したユーザーが保存されます。
+// 保存先への書き込みはバックグラウンドで行うため、保存先を閉じる前にFlushを呼び出してください。
+func (m *SimpleUserManager) SetStore(s store.Store) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sessions != nil {
+		m.sessions.close()
+	}
+	m.sessions = newSessionWriter(s, m.logger)
+}
+
+// Restore は保存されていたセッションのユーザーを作成し、ルームに入室させて登録します。
+// ルームが見つからないセッションは無視します。最終アクティビティ時間は現在時刻になります。
+// 復元したユーザーを再び保存しないよう、ルームの復元の後、SetStoreより前に呼び出してください。
+func (m *SimpleUserManager) Restore(sessions map[string]store.Session) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.roomManager == nil {
+		return 0
+	}
+
+	restored := 0
+	now := time.Now()
+	for token, session := range sessions {
+		room, err := m.roomManager.FindRoom(session.RoomName)
+		if err != nil {
+			m.logger.Warn("ルームが見つからないためセッションを復元しません", "user", session.UserName, "room", session.RoomName)
+			continue
+		}
+
+		user := chat.NewUser(session.UserName, token, session.Address)
+		if simpleUser, ok := user.(*chat.SimpleUser); ok && session.Account != "" {
+			simpleUser.SetAccount(session.Account)
+		}
+		if session.UDPAddr != "" {
+			if udpAddr, err := net.ResolveUDPAddr("udp", session.UDPAddr); err == nil {
+				user.SetUDPAddr(udpAddr)
+			}
+		}
+		if err := room.AddUser(user, session.Host); err != nil {
+			m.logger.Warn("セッションを復元できませんでした", "user", session.UserName, "room", session.RoomName, "error", err)
+			continue
+		}
+		m.users[token] = user
+		m.rooms[token] = session.RoomName
+		m.lastActivityMap[token] = now
+		restored++
+	}
+	return restored
+}
+
+// saveSession はユーザーのセッションを、登録したときのルームの名前とともに保存します。
+// 保存先への書き込みはsessionWriterがロックの外で行います。呼び出し元でm.mutexをロックしておく必要があります。
+func (m *SimpleUserManager) saveSession(token string, user chat.User) {
+	if m.sessions == nil {
+		return
+	}
+
+	session := store.Session{
+		Token:    token,
+		UserName: user.GetName(),
+		RoomName: m.rooms[token],
+		Address:  user.GetAddress(),
+		Host:     user.IsHost(),
+	}
+	if udpAddr := user.GetUDPAddr(); udpAddr != nil {
+		session.UDPAddr = udpAddr.String()
+	}
+	if accountUser, ok := user.(chat.AccountUser); ok {
+		session.Account = accountUser.Account()
+	}
+	m.sessions.save(session)
+}
+
+// deleteSession は保存したセッションを削除します。呼び出し元でm.mutexをロックしておく必要があります。
+func (m *SimpleUserManager) deleteSession(token string) {
+	if m.sessions != nil {
+		m.sessions.delete(token)
 	}
-	return []chat.Room{}
 }
 
 // SetRoomManager はルームマネージャーを設定します。
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net"
+	"testing"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/store"
+)
+
+func newTestUserManager(t *testing.T) (*SimpleUserManager, *chat.SimpleRoomManager, *store.MemoryStore) {
+	t.Helper()
+	rooms := chat.NewSimpleRoomManager()
+	t.Cleanup(func() { rooms.Close() })
+	users := NewSimpleUserManager()
+	t.Cleanup(func() { users.Close() })
+	users.SetRoomManager(rooms)
+
+	s := store.NewMemoryStore()
+	users.SetStore(s)
+	return users, rooms, s
+}
+
+// joinRoom はユーザーをルームに入室させて登録します。
+func joinRoom(t *testing.T, users *SimpleUserManager, room chat.Room, name, token string) chat.User {
+	t.Helper()
+	user := chat.NewUser(name, token, "127.0.0.1:1")
+	if err := room.AddUser(user, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.RegisterUser(token, room.GetName(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func loadSessions(t *testing.T, s store.Store) map[string]store.Session {
+	t.Helper()
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.Sessions
+}
+
+func TestSessionsAreSavedWithRoom(t *testing.T) {
+	users, rooms, s := newTestUserManager(t)
+	lobby, err := rooms.CreateRoom("lobby", "", chat.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rooms.CreateRoom("other", "", chat.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taro := joinRoom(t, users, lobby, "taro", "t1")
+	joinRoom(t, users, other, "jiro", "t2")
+	users.Flush()
+
+	sessions := loadSessions(t, s)
+	if got := sessions["t1"]; got.RoomName != "lobby" || got.UserName != "taro" {
+		t.Errorf("session t1 = %+v", got)
+	}
+	if got := sessions["t2"]; got.RoomName != "other" || got.UserName != "jiro" {
+		t.Errorf("session t2 = %+v", got)
+	}
+
+	// UDPアドレスが変わったら保存し直す
+	taro.SetUDPAddr(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000})
+	if err := users.SaveSession("t1"); err != nil {
+		t.Fatal(err)
+	}
+	users.Flush()
+	if got := loadSessions(t, s)["t1"]; got.UDPAddr != "127.0.0.1:5000" || got.RoomName != "lobby" {
+		t.Errorf("session t1 = %+v", got)
+	}
+
+	users.DeleteUser("t1")
+	users.Flush()
+	if _, ok := loadSessions(t, s)["t1"]; ok {
+		t.Error("session t1 was not deleted")
+	}
+}
+
+func TestSessionWriterKeepsLatestChange(t *testing.T) {
+	users, rooms, s := newTestUserManager(t)
+	lobby, err := rooms.CreateRoom("lobby", "", chat.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 書き込む前に登録と削除を繰り返しても、最後の変更が残る
+	for i := 0; i < 10; i++ {
+		user := joinRoom(t, users, lobby, "taro", "t1")
+		lobby.RemoveUser(user)
+		users.DeleteUser("t1")
+	}
+	joinRoom(t, users, lobby, "hanako", "t1")
+	users.Close()
+
+	if got := loadSessions(t, s)["t1"]; got.UserName != "hanako" {
+		t.Errorf("session t1 = %+v, want the last registration", got)
+	}
+}
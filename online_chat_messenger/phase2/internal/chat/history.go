@@ -0,0 +1,65 @@
+package chat
+
+import "time"
+
+// Message はルームで送信されたメッセージです。
+type Message struct {
+	Sender string
+	Text   string
+	Time   time.Time // サーバーがメッセージを受け付けた時刻
+}
+
+// history はルームの直近のメッセージを古い順に保持します。
+type history struct {
+	messages    []Message
+	maxMessages int           // 保持する件数の上限（0は保持しない）
+	maxAge      time.Duration // 保持する期間（0は無制限）
+}
+
+// add はメッセージを追加し、上限を超えた古いメッセージを削除します。
+func (h *history) add(msg Message) {
+	if h.maxMessages <= 0 {
+		return
+	}
+	h.messages = append(h.messages, msg)
+	h.prune(msg.Time)
+}
+
+// last は期限内のメッセージのうち、新しいものからn件を古い順に返します。
+func (h *history) last(n int, now time.Time) []Message {
+	h.prune(now)
+	if n > len(h.messages) {
+		n = len(h.messages)
+	}
+	if n <= 0 {
+		return nil
+	}
+	messages := make([]Message, n)
+	copy(messages, h.messages[len(h.messages)-n:])
+	return messages
+}
+
+// setLimits は上限を変更し、超えたメッセージを削除します。
+func (h *history) setLimits(maxMessages int, maxAge time.Duration, now time.Time) {
+	h.maxMessages = maxMessages
+	h.maxAge = maxAge
+	h.prune(now)
+}
+
+// prune は件数の上限を超えたメッセージと期限切れのメッセージを削除します。
+func (h *history) prune(now time.Time) {
+	drop := 0
+	if len(h.messages) > h.maxMessages {
+		drop = len(h.messages) - max(h.maxMessages, 0)
+	}
+	if h.maxAge > 0 {
+		for drop < len(h.messages) && now.Sub(h.messages[drop].Time) > h.maxAge {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	// 古いメッセージへの参照を残さないよう、新しいスライスにコピーする
+	h.messages = append([]Message(nil), h.messages[drop:]...)
+}
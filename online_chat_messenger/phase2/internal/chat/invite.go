@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"online_chat_messenger/internal/store"
+)
+
+// Invite は招待制のルームに参加するための招待コードです。
+// 1回だけ使えるコードと有効期限のあるコードがあり、両方を指定した場合はどちらかに達すると使えなくなります。
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"created_by"` // 発行したホストの名前
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // ゼロ値は期限なし
+	SingleUse bool      `json:"single_use,omitempty"`
+}
+
+// expired は招待コードの有効期限が切れているかどうかを返します。
+func (i Invite) expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+const (
+	// MaxInvites は1つのルームで同時に有効な招待コードの最大数です。
+	MaxInvites = 50
+	// MaxInviteTTL は招待コードの有効期間の上限です。
+	MaxInviteTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInviteRequired は招待制のルームに招待コードなしで参加しようとしたことを表します。
+	ErrInviteRequired = errors.New("invite code required")
+	// ErrInvalidInvite は招待コードが存在しないか、使用済み・期限切れであることを表します。
+	ErrInvalidInvite = errors.New("invalid invite code")
+	// ErrInvalidInviteOptions は招待コードの有効期間や使用回数の指定が正しくないことを表します。
+	ErrInvalidInviteOptions = errors.New("招待コードの設定が正しくありません")
+)
+
+// inviteEncoding は招待コードの文字列に使うエンコーディングです。入力しやすいよう大文字と数字だけを使います。
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newInviteCode はランダムな招待コードを生成します。
+func newInviteCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return inviteEncoding.EncodeToString(b), nil
+}
+
+// normalizeInviteCode は入力された招待コードを比較できる形にします。大文字・小文字と前後の空白は区別しません。
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CreateInvite は招待コードを発行します。ttlが0の場合は期限なしで、singleUseがfalseの場合は何度でも使えます。
+// いつまでも何度でも使えるコードはパスワードと変わらないため、ttlかsingleUseのどちらかを指定する必要があります。
+func (r *SimpleRoom) CreateInvite(createdBy string, ttl time.Duration, singleUse bool) (Invite, error) {
+	if ttl < 0 || ttl > MaxInviteTTL {
+		return Invite{}, fmt.Errorf("%w: 有効期間は%s以内で指定してください", ErrInvalidInviteOptions, MaxInviteTTL)
+	}
+	if ttl == 0 && !singleUse {
+		return Invite{}, fmt.Errorf("%w: 有効期間を指定するか、1回だけ使えるコードにしてください", ErrInvalidInviteOptions)
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		return Invite{}, fmt.Errorf("招待コードの生成に失敗しました: %w", err)
+	}
+
+	now := time.Now()
+	invite := Invite{Code: code, CreatedBy: createdBy, CreatedAt: now, SingleUse: singleUse}
+	if ttl > 0 {
+		invite.ExpiresAt = now.Add(ttl)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pruneInvites(now)
+	if len(r.invites) >= MaxInvites {
+		return Invite{}, fmt.Errorf("%w: 有効な招待コードは%d個までです", ErrInvalidInviteOptions, MaxInvites)
+	}
+	if r.invites == nil {
+		r.invites = make(map[string]Invite)
+	}
+	r.invites[code] = invite
+	return invite, nil
+}
+
+// Invites は有効な招待コードを発行した順に返します。
+func (r *SimpleRoom) Invites() []Invite {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pruneInvites(time.Now())
+	invites := make([]Invite, 0, len(r.invites))
+	for _, invite := range r.invites {
+		invites = append(invites, invite)
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].CreatedAt.Before(invites[j].CreatedAt) })
+	return invites
+}
+
+// RevokeInvite は招待コードを無効にします。有効なコードが見つからない場合はErrInvalidInviteを返します。
+func (r *SimpleRoom) RevokeInvite(code string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pruneInvites(time.Now())
+	code = normalizeInviteCode(code)
+	if _, ok := r.invites[code]; !ok {
+		return ErrInvalidInvite
+	}
+	delete(r.invites, code)
+	return nil
+}
+
+// AddUserWithInvite は招待コードを確認して、ホストでないユーザーとしてルームに追加します。
+// 参加できた場合だけ招待コードを使用したことにし、1回だけ使えるコードは無効になります。
+func (r *SimpleRoom) AddUserWithInvite(user User, code string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	code = normalizeInviteCode(code)
+	if code == "" {
+		return ErrInviteRequired
+	}
+	r.pruneInvites(time.Now())
+	invite, ok := r.invites[code]
+	if !ok {
+		return ErrInvalidInvite
+	}
+	if err := r.addUserLocked(user, false); err != nil {
+		return err
+	}
+	if invite.SingleUse {
+		delete(r.invites, code)
+	}
+	return nil
+}
+
+// pruneInvites は有効期限の切れた招待コードを削除します。呼び出し元でr.mutexをロックしておく必要があります。
+func (r *SimpleRoom) pruneInvites(now time.Time) {
+	for code, invite := range r.invites {
+		if invite.expired(now) {
+			delete(r.invites, code)
+		}
+	}
+}
+
+// storedInvites は保存する招待コードを返します。呼び出し元でr.mutexをロックしておく必要があります。
+func (r *SimpleRoom) storedInvites() []store.Invite {
+	if len(r.invites) == 0 {
+		return nil
+	}
+	invites := make([]store.Invite, 0, len(r.invites))
+	for _, invite := range r.invites {
+		invites = append(invites, store.Invite(invite))
+	}
+	return invites
+}
+
+// restoreInvites は保存されていた招待コードのうち、有効期限の切れていないものを復元します。
+func (r *SimpleRoom) restoreInvites(saved []store.Invite, now time.Time) {
+	for _, s := range saved {
+		invite := Invite(s)
+		if invite.expired(now) {
+			continue
+		}
+		if r.invites == nil {
+			r.invites = make(map[string]Invite)
+		}
+		r.invites[invite.Code] = invite
+	}
+}
@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"errors"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrNameTaken はルームに同じ名前のユーザーがいることを表します。
+var ErrNameTaken = errors.New("name is already taken")
+
+// NormalizeName は名前を比較するために正規化します。
+// Unicodeの互換分解（NFKC）と大文字・小文字の畳み込みを行い、全角・半角の英数字やカタカナ、
+// 合成済みの文字と結合文字（「é」と「e」+アクセント、「が」と「か」+濁点）、合字や丸囲み文字などの違いを無視します。
+func NormalizeName(name string) string {
+	// 畳み込みで互換文字が現れることがあるため、もう一度NFKCで揃える
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(name)))
+}
+
+// SameName は2つの名前が正規化すると同じになるかどうかを返します。
+func SameName(a, b string) bool {
+	return NormalizeName(a) == NormalizeName(b)
+}
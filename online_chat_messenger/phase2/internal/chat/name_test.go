@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSameName(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"大文字・小文字", "Taro", "tARO", true},
+		{"全角英字", "ＴＡＲＯ", "taro", true},
+		{"全角数字と記号", "ｔａｒｏ＿１", "taro_1", true},
+		{"半角カタカナ", "ﾀﾛｳ", "タロウ", true},
+		{"半角カタカナの濁点", "ｶﾞｸ", "ガク", true},
+		{"半角カタカナの半濁点", "ﾊﾟﾝ", "パン", true},
+		{"結合文字の濁点", "が", "が", true},
+		{"結合文字のアクセント", "José", "José", true},
+		{"合字", "ﬁsh", "fish", true},
+		{"丸囲み文字", "Ⓐⓑ", "ab", true},
+		{"ドイツ語のエスツェット", "Straße", "STRASSE", true},
+		{"ギリシャ文字の語末形", "ΟΔΟΣ", "οδος", true},
+		{"違う名前", "taro", "jiro", false},
+		{"濁点の有無", "か", "が", false},
+		{"アクセントの有無", "José", "Jose", false},
+		{"ひらがなとカタカナ", "たろう", "タロウ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameName(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameName(%q, %q) = %v, want %v（正規化: %q, %q）", tt.a, tt.b, got, tt.want, NormalizeName(tt.a), NormalizeName(tt.b))
+			}
+		})
+	}
+}
+
+func TestNormalizeNameIsIdempotent(t *testing.T) {
+	for _, name := range []string{"ＴＡＲＯ", "ﾀﾞﾛｳ", "José", "ﬁ", "Ⓐ", "Straße"} {
+		once := NormalizeName(name)
+		if twice := NormalizeName(once); twice != once {
+			t.Errorf("NormalizeName(%q) = %q, もう一度正規化すると %q", name, once, twice)
+		}
+	}
+}
+
+func TestAddUserRejectsNormalizedDuplicate(t *testing.T) {
+	room := NewSimpleRoom("lobby", "")
+	if err := room.AddUser(NewUser("José", "t1", "127.0.0.1:1"), true); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	err := room.AddUser(NewUser("JOSÉ", "t2", "127.0.0.1:2"), false)
+	if !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("AddUser の重複した名前: got %v, want %v", err, ErrNameTaken)
+	}
+}
@@ -2,12 +2,20 @@ package chat
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"online_chat_messenger/internal/pwhash"
+	"online_chat_messenger/internal/store"
 )
 
 // RoomManager はチャットルーム管理のインターフェースです。
 type RoomManager interface {
-	CreateRoom(name, password string) (Room, error)
+	CreateRoom(name, password string, meta Metadata) (Room, error)
 	FindRoom(name string) (Room, error)
 	DeleteRoom(name string) error
 	GetAllRooms() []Room
@@ -20,6 +28,43 @@ type Room interface {
 	RemoveUser(user User) error
 	Broadcast(message string, sender User) error
 	GetUsers() []User
+	Metadata() Metadata
+	SetMetadata(meta Metadata)
+}
+
+// Metadata はルームのトピックや作成者などの付加情報です。
+type Metadata struct {
+	Topic       string    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Creator     string    `json:"creator,omitempty"`      // ルームを作成したユーザーの名前
+	CreatorAddr string    `json:"creator_addr,omitempty"` // ルームを作成した接続元のIPアドレス（アドレスごとのルーム数の上限に使う）
+	CreatedAt   time.Time `json:"created_at"`             // 作成時にサーバーが設定する
+	MaxMembers  int       `json:"max_members,omitempty"`  // ルームで決めたメンバー数の上限（0はサーバーの上限だけに従う）
+	InviteOnly  bool      `json:"invite_only,omitempty"`  // 参加にホストが発行した招待コードが必要
+}
+
+const (
+	// MaxTopicLength はトピックに使える最大の文字数です。
+	MaxTopicLength = 100
+	// MaxDescriptionLength は説明に使える最大の文字数です。
+	MaxDescriptionLength = 500
+)
+
+// ErrInvalidMetadata はルームの付加情報が正しくないことを表します。
+var ErrInvalidMetadata = errors.New("ルームの情報が正しくありません")
+
+// Validate はトピック・説明の長さとメンバー数の上限を確かめます。
+func (m Metadata) Validate() error {
+	if utf8.RuneCountInString(m.Topic) > MaxTopicLength {
+		return fmt.Errorf("%w: トピックは%d文字以内で指定してください", ErrInvalidMetadata, MaxTopicLength)
+	}
+	if utf8.RuneCountInString(m.Description) > MaxDescriptionLength {
+		return fmt.Errorf("%w: 説明は%d文字以内で指定してください", ErrInvalidMetadata, MaxDescriptionLength)
+	}
+	if m.MaxMembers < 0 {
+		return fmt.Errorf("%w: メンバー数の上限は0以上で指定してください", ErrInvalidMetadata)
+	}
+	return nil
 }
 
 // User はチャットルームのユーザーを表します。
@@ -32,46 +77,365 @@ type User interface {
 	SetUDPAddr(addr *net.UDPAddr)
 }
 
+// AccountUser は登録ユーザーとしてログインしたユーザーです。
+type AccountUser interface {
+	// Account はアカウントの名前を返します。ゲストの場合は空です。
+	Account() string
+}
+
+var (
+	// ErrRoomExists は同じ名前のルームがすでにあることを表します。
+	ErrRoomExists = errors.New("room already exists")
+	// ErrRoomNotFound はルームが見つからないことを表します。
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrRoomLimitReached はサーバーのルーム数が上限に達していることを表します。
+	ErrRoomLimitReached = errors.New("room limit reached")
+	// ErrAddressRoomLimitReached は同じアドレスから作成したルーム数が上限に達していることを表します。
+	ErrAddressRoomLimitReached = errors.New("room limit per address reached")
+	// ErrRoomFull はルームのメンバー数が上限に達していることを表します。
+	ErrRoomFull = errors.New("room is full")
+	// ErrWrongPassword はルームのパスワードが一致しないことを表します。
+	ErrWrongPassword = errors.New("wrong room password")
+)
+
 // SimpleRoomManager はRoomManagerのシンプルな実装です。
 type SimpleRoomManager struct {
-	rooms map[string]Room
+	rooms              map[string]Room
+	maxRooms           int           // ルーム数の上限（0は無制限）
+	maxRoomsPerAddress int           // 同じアドレスから作成できるルーム数の上限（0は無制限）
+	maxRoomMembers     int           // 新しく作成するルームのメンバー数の上限（0は無制限）
+	historySize        int           // 新しく作成するルームの履歴の件数の上限
+	historyMaxAge      time.Duration // 新しく作成するルームの履歴の保持期間（0は無制限）
+	emptyRoomTTL       time.Duration // 空になったルームを削除するまでの時間（0は削除しない）
+	passwordIterations int           // ルームのパスワードのハッシュの反復回数
+	store              store.Store   // ルームの保存先（nilの場合は保存しない）
+	rehashed           []string      // 平文のパスワードから復元し、保存し直す必要があるルームの名前
+	logger             *slog.Logger
+	mutex              sync.RWMutex
+
+	intervalCh chan time.Duration // 空のルームを削除する処理の実行間隔の変更を通知する
+	done       chan struct{}
+	startOnce  sync.Once
+	closeOnce  sync.Once
 }
 
+// DefaultHistorySize はルームごとに保持するメッセージの履歴のデフォルトの件数です。
+const DefaultHistorySize = 100
+
 // NewSimpleRoomManager は新しいSimpleRoomManagerを生成します。
 func NewSimpleRoomManager() *SimpleRoomManager {
-	return &SimpleRoomManager{rooms: make(map[string]Room)}
+	return &SimpleRoomManager{
+		rooms:              make(map[string]Room),
+		historySize:        DefaultHistorySize,
+		passwordIterations: pwhash.DefaultIterations,
+		logger:             slog.Default().With("component", "chat"),
+		intervalCh:         make(chan time.Duration),
+		done:               make(chan struct{}),
+	}
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (m *SimpleRoomManager) SetLogger(logger *slog.Logger) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.logger = logger.With("component", "chat")
+}
+
+// SetLimits はルーム数、同じアドレスから作成できるルーム数、1ルームあたりのメンバー数の上限を設定します。0は無制限を表します。
+// 上限を下げても既存のルームは削除せず、メンバー数の上限は既存のルームにも適用されますが、すでに入室しているユーザーは退出させません。
+func (m *SimpleRoomManager) SetLimits(maxRooms, maxRoomsPerAddress, maxRoomMembers int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.maxRooms = maxRooms
+	m.maxRoomsPerAddress = maxRoomsPerAddress
+	m.maxRoomMembers = maxRoomMembers
+	for _, room := range m.rooms {
+		if simpleRoom, ok := room.(*SimpleRoom); ok {
+			simpleRoom.SetMaxMembers(maxRoomMembers)
+		}
+	}
+}
+
+// SetHistoryLimits はルームごとに保持するメッセージの履歴の件数と期間を設定します。
+// maxMessagesが0の場合は履歴を保持せず、maxAgeが0の場合は期間を制限しません。既存のルームにも適用されます。
+func (m *SimpleRoomManager) SetHistoryLimits(maxMessages int, maxAge time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.historySize = maxMessages
+	m.historyMaxAge = maxAge
+	for _, room := range m.rooms {
+		if simpleRoom, ok := room.(*SimpleRoom); ok {
+			simpleRoom.SetHistoryLimits(maxMessages, maxAge)
+		}
+	}
+}
+
+// SetPasswordIterations は以降に作成するルームのパスワードのハッシュの反復回数を設定します。
+// 作成済みのルームのパスワードは作成したときの回数で確認します。
+func (m *SimpleRoomManager) SetPasswordIterations(iterations int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.passwordIterations = iterations
+}
+
+// hashPassword はルームのパスワードのハッシュを計算します。パスワードがない場合は空を返します。
+func (m *SimpleRoomManager) hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	m.mutex.RLock()
+	iterations := m.passwordIterations
+	m.mutex.RUnlock()
+	return pwhash.Hash(password, iterations)
 }
 
-// CreateRoom は新しいチャットルームを作成します。
-func (m *SimpleRoomManager) CreateRoom(name, password string) (Room, error) {
+// newRoom は設定された上限を適用したSimpleRoomを生成します。呼び出し元でm.mutexをロックしておく必要があります。
+func (m *SimpleRoomManager) newRoom(name, passwordHash string) *SimpleRoom {
+	room := NewSimpleRoom(name, passwordHash)
+	room.maxMembers = m.maxRoomMembers
+	room.history.maxMessages = m.historySize
+	room.history.maxAge = m.historyMaxAge
+	return room
+}
+
+// CreateRoom は新しいチャットルームを作成します。metaのCreatedAtは無視し、作成した時刻を設定します。
+// パスワードはハッシュにして保持し、平文は保存しません。
+func (m *SimpleRoomManager) CreateRoom(name, password string, meta Metadata) (Room, error) {
+	if err := meta.Validate(); err != nil {
+		return nil, err
+	}
+	// ハッシュの計算は時間がかかるため、ロックの外で行う
+	passwordHash, err := m.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	if _, ok := m.rooms[name]; ok {
-		return nil, errors.New("room already exists")
+		return nil, ErrRoomExists
+	}
+	if m.maxRooms > 0 && len(m.rooms) >= m.maxRooms {
+		return nil, ErrRoomLimitReached
+	}
+	if m.maxRoomsPerAddress > 0 && meta.CreatorAddr != "" && m.countRoomsByAddress(meta.CreatorAddr) >= m.maxRoomsPerAddress {
+		return nil, ErrAddressRoomLimitReached
+	}
+	room := m.newRoom(name, passwordHash)
+	meta.CreatedAt = room.meta.CreatedAt
+	room.meta = meta
+	if m.store != nil {
+		if err := m.store.SaveRoom(room.stored()); err != nil {
+			return nil, fmt.Errorf("ルームの保存に失敗しました: %w", err)
+		}
 	}
-	room := NewSimpleRoom(name, password)
 	m.rooms[name] = room
 	return room, nil
 }
 
+// SaveRoom はルームの付加情報を変更したあとに、ルームを保存し直します。
+func (m *SimpleRoomManager) SaveRoom(name string) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	room, ok := m.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	simpleRoom, ok := room.(*SimpleRoom)
+	if !ok || m.store == nil {
+		return nil
+	}
+	if err := m.store.SaveRoom(simpleRoom.stored()); err != nil {
+		return fmt.Errorf("ルームの保存に失敗しました: %w", err)
+	}
+	return nil
+}
+
 // FindRoom は指定された名前のチャットルームを返します。
 func (m *SimpleRoomManager) FindRoom(name string) (Room, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	room, ok := m.rooms[name]
 	if !ok {
-		return nil, errors.New("room not found")
+		return nil, ErrRoomNotFound
 	}
 	return room, nil
 }
 
 // DeleteRoom は指定された名前のチャットルームを削除します。
 func (m *SimpleRoomManager) DeleteRoom(name string) error {
-	if _, ok := m.rooms[name]; !ok {
-		return errors.New("room not found")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	room, ok := m.rooms[name]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if simpleRoom, ok := room.(*SimpleRoom); ok {
+		simpleRoom.close()
 	}
 	delete(m.rooms, name)
+	if m.store != nil {
+		if err := m.store.DeleteRoom(name); err != nil {
+			return fmt.Errorf("保存したルームの削除に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetStore はルームの保存先を設定します。以降に作成・削除したルームが保存されます。
+// 平文のパスワードから復元したルームは、ハッシュにしたパスワードで保存し直します。
+func (m *SimpleRoomManager) SetStore(s store.Store) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.store = s
+
+	for _, name := range m.rehashed {
+		room, ok := m.rooms[name].(*SimpleRoom)
+		if !ok {
+			continue
+		}
+		if err := s.SaveRoom(room.stored()); err != nil {
+			m.logger.Warn("ルームの保存に失敗しました", "room", name, "error", err)
+		}
+	}
+	m.rehashed = nil
+}
+
+// Restore は保存されていたルームを作成します。同じ名前のルームがある場合は上書きしません。
+// 以前のバージョンで平文のまま保存されたパスワードはハッシュにし、SetStoreで保存し直します。
+// 復元したルームを再び保存しないよう、SetStoreより前に呼び出してください。
+func (m *SimpleRoomManager) Restore(rooms map[string]store.Room) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, saved := range rooms {
+		if _, ok := m.rooms[name]; ok {
+			continue
+		}
+		passwordHash := saved.Password
+		if passwordHash != "" && !pwhash.IsHash(passwordHash) {
+			hash, err := pwhash.Hash(passwordHash, m.passwordIterations)
+			if err != nil {
+				m.logger.Warn("ルームのパスワードをハッシュにできなかったため復元しません", "room", name, "error", err)
+				continue
+			}
+			passwordHash = hash
+			m.rehashed = append(m.rehashed, name)
+			m.logger.Info("平文で保存されていたルームのパスワードをハッシュにしました", "room", name)
+		}
+		room := m.newRoom(name, passwordHash)
+		room.meta.Topic = saved.Topic
+		room.meta.Description = saved.Description
+		room.meta.Creator = saved.Creator
+		room.meta.CreatorAddr = saved.CreatorAddr
+		room.meta.MaxMembers = saved.MaxMembers
+		room.meta.InviteOnly = saved.InviteOnly
+		room.restoreInvites(saved.Invites, time.Now())
+		if !saved.CreatedAt.IsZero() {
+			room.meta.CreatedAt = saved.CreatedAt
+		}
+		m.rooms[name] = room
+	}
+}
+
+// countRoomsByAddress はアドレスから作成されたルームの数を返します。呼び出し元でm.mutexをロックしておく必要があります。
+func (m *SimpleRoomManager) countRoomsByAddress(addr string) int {
+	n := 0
+	for _, room := range m.rooms {
+		if room.Metadata().CreatorAddr == addr {
+			n++
+		}
+	}
+	return n
+}
+
+// SetEmptyRoomTTL は空になったルームを削除するまでの時間と、削除する処理の実行間隔を設定します。
+// ttlが0の場合は削除しません。最初の呼び出しで削除する処理を開始し、以降の呼び出しは実行中の処理に反映されます。
+func (m *SimpleRoomManager) SetEmptyRoomTTL(ttl, interval time.Duration) {
+	m.mutex.Lock()
+	m.emptyRoomTTL = ttl
+	m.mutex.Unlock()
+
+	started := false
+	m.startOnce.Do(func() {
+		go m.expireEmptyRooms(interval)
+		started = true
+	})
+	if started {
+		return
+	}
+	select {
+	case m.intervalCh <- interval:
+	case <-m.done:
+	}
+}
+
+// Close は空のルームを削除する処理を停止します。
+func (m *SimpleRoomManager) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
 	return nil
 }
 
+// expireEmptyRooms は空のルームを定期的に削除します。
+func (m *SimpleRoomManager) expireEmptyRooms(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.DeleteEmptyRooms(time.Now())
+		case interval := <-m.intervalCh:
+			ticker.Reset(interval)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// DeleteEmptyRooms はメンバーがいない状態が設定した時間以上続いたルームを削除し、削除したルームの名前を返します。
+func (m *SimpleRoomManager) DeleteEmptyRooms(now time.Time) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.emptyRoomTTL <= 0 {
+		return nil
+	}
+
+	var deleted []string
+	for name, room := range m.rooms {
+		simpleRoom, ok := room.(*SimpleRoom)
+		if !ok {
+			continue
+		}
+		// 空かどうかの確認と削除の印をルームのロックの中で行い、FindRoomの後に入室しようとしているユーザーを削除したルームに入れない
+		since, closed := simpleRoom.closeIfEmptyFor(m.emptyRoomTTL, now)
+		if !closed {
+			continue
+		}
+		delete(m.rooms, name)
+		if m.store != nil {
+			if err := m.store.DeleteRoom(name); err != nil {
+				m.logger.Warn("保存したルームの削除に失敗しました", "room", name, "error", err)
+			}
+		}
+		m.logger.Info("空のルームを削除しました", "room", name, "empty", now.Sub(since).Round(time.Second))
+		deleted = append(deleted, name)
+	}
+	return deleted
+}
+
 // GetAllRooms はすべてのルームを返します。
 func (m *SimpleRoomManager) GetAllRooms() []Room {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	rooms := make([]Room, 0, len(m.rooms))
 	for _, room := range m.rooms {
 		rooms = append(rooms, room)
@@ -81,14 +445,23 @@ func (m *SimpleRoomManager) GetAllRooms() []Room {
 
 // SimpleRoom はRoomのシンプルな実装です。
 type SimpleRoom struct {
-	name     string
-	password string
-	users    map[string]User
+	name         string
+	passwordHash string // pwhash.Hashで計算したパスワードのハッシュ（パスワードがない場合は空）
+	users        map[string]User
+	maxMembers   int // サーバーで決めたメンバー数の上限（0は無制限）
+	meta         Metadata
+	emptySince   time.Time         // 最後のメンバーが退出した時刻（メンバーがいる場合はゼロ値）
+	closed       bool              // RoomManagerから削除された（以降は入室できない）
+	invites      map[string]Invite // 有効な招待コード（キーはコード）
+	history      history
+	mutex        sync.RWMutex
 }
 
 // NewSimpleRoom は新しいSimpleRoomを生成します。
-func NewSimpleRoom(name, password string) *SimpleRoom {
-	return &SimpleRoom{name: name, password: password, users: make(map[string]User)}
+// passwordHashにはpwhash.Hashで計算したパスワードのハッシュを指定し、パスワードがない場合は空にします。
+func NewSimpleRoom(name, passwordHash string) *SimpleRoom {
+	now := time.Now()
+	return &SimpleRoom{name: name, passwordHash: passwordHash, users: make(map[string]User), meta: Metadata{CreatedAt: now}, emptySince: now}
 }
 
 // GetName はチャットルームの名前を返します。
@@ -96,15 +469,180 @@ func (r *SimpleRoom) GetName() string {
 	return r.name
 }
 
+// CheckPassword はパスワードがルームのパスワードと一致するかどうかを返します。
+// パスワードのないルームでは常にtrueを返します。
+func (r *SimpleRoom) CheckPassword(password string) bool {
+	if r.passwordHash == "" {
+		return true
+	}
+	return pwhash.Verify(password, r.passwordHash)
+}
+
+// SetMaxMembers はメンバー数の上限を設定します。0は無制限を表します。
+func (r *SimpleRoom) SetMaxMembers(maxMembers int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxMembers = maxMembers
+}
+
+// SetHistoryLimits は保持するメッセージの履歴の件数と期間を設定します。
+func (r *SimpleRoom) SetHistoryLimits(maxMessages int, maxAge time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.history.setLimits(maxMessages, maxAge, time.Now())
+}
+
+// Metadata はルームの付加情報を返します。
+func (r *SimpleRoom) Metadata() Metadata {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.meta
+}
+
+// EmptySince はメンバーがいない場合に、最後のメンバーが退出した時刻（一度も入室していない場合は作成した時刻）を返します。
+func (r *SimpleRoom) EmptySince() (time.Time, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.emptySince, len(r.users) == 0
+}
+
+// SetMetadata はルームの付加情報を変更します。作成者と作成時刻は変更しません。
+// メンバー数の上限を下げても、すでに入室しているユーザーは退出させません。
+func (r *SimpleRoom) SetMetadata(meta Metadata) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	meta.Creator = r.meta.Creator
+	meta.CreatorAddr = r.meta.CreatorAddr
+	meta.CreatedAt = r.meta.CreatedAt
+	r.meta = meta
+}
+
+// stored は保存するルームの情報を返します。
+func (r *SimpleRoom) stored() store.Room {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return store.Room{
+		Name:        r.name,
+		Password:    r.passwordHash,
+		CreatedAt:   r.meta.CreatedAt,
+		Topic:       r.meta.Topic,
+		Description: r.meta.Description,
+		Creator:     r.meta.Creator,
+		CreatorAddr: r.meta.CreatorAddr,
+		MaxMembers:  r.meta.MaxMembers,
+		InviteOnly:  r.meta.InviteOnly,
+		Invites:     r.storedInvites(),
+	}
+}
+
+// AddMessage はメッセージを履歴に追加します。
+func (r *SimpleRoom) AddMessage(msg Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.history.add(msg)
+}
+
+// History は履歴のうち新しいものからn件を古い順に返します。
+func (r *SimpleRoom) History(n int) []Message {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.history.last(n, time.Now())
+}
+
 // AddUser はチャットルームにユーザーを追加します。
+// NormalizeNameで同じになる名前のユーザーがすでにいる場合はErrNameTakenを返します。
 func (r *SimpleRoom) AddUser(user User, isHost bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.addUserLocked(user, isHost)
+}
+
+// addUserLocked はAddUserの本体です。呼び出し元でr.mutexをロックしておく必要があります。
+// RoomManagerから削除されたルームにはErrRoomNotFoundを返します。
+func (r *SimpleRoom) addUserLocked(user User, isHost bool) error {
+	if r.closed {
+		return ErrRoomNotFound
+	}
+	if r.nameTaken(user.GetName(), user.GetToken()) {
+		return ErrNameTaken
+	}
+	if _, exists := r.users[user.GetToken()]; !exists {
+		// サーバーの上限とルームの上限のうち小さい方を適用する
+		for _, limit := range []int{r.maxMembers, r.meta.MaxMembers} {
+			if limit > 0 && len(r.users) >= limit {
+				return ErrRoomFull
+			}
+		}
+	}
+	if simpleUser, ok := user.(*SimpleUser); ok {
+		simpleUser.mutex.Lock()
+		simpleUser.isHost = isHost
+		simpleUser.mutex.Unlock()
+	}
 	r.users[user.GetToken()] = user
+	r.emptySince = time.Time{}
 	return nil
 }
 
+// closeIfEmptyFor はメンバーがいない状態がttl以上続いている場合に、ルームを削除済みにして入室できないようにします。
+// 最後のメンバーが退出した時刻と、削除済みにしたかどうかを返します。
+func (r *SimpleRoom) closeIfEmptyFor(ttl time.Duration, now time.Time) (time.Time, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.users) > 0 || now.Sub(r.emptySince) < ttl {
+		return r.emptySince, false
+	}
+	r.closed = true
+	return r.emptySince, true
+}
+
+// close はルームを削除済みにして、以降は入室できないようにします。
+func (r *SimpleRoom) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+}
+
+// RenameUser はルームのメンバーの名前を変更します。
+// NormalizeNameで同じになる名前の他のメンバーがいる場合はErrNameTakenを返します。
+func (r *SimpleRoom) RenameUser(user User, name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.users[user.GetToken()]; !ok {
+		return errors.New("user is not a member of the room")
+	}
+	renamable, ok := user.(interface{ SetName(name string) })
+	if !ok {
+		return errors.New("user cannot be renamed")
+	}
+	if r.nameTaken(name, user.GetToken()) {
+		return ErrNameTaken
+	}
+	renamable.SetName(name)
+	return nil
+}
+
+// nameTaken はトークンのユーザー以外に同じ名前のメンバーがいるかどうかを返します。呼び出し元でr.mutexをロックしておく必要があります。
+func (r *SimpleRoom) nameTaken(name, token string) bool {
+	normalized := NormalizeName(name)
+	for t, u := range r.users {
+		if t != token && NormalizeName(u.GetName()) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
 // RemoveUser はチャットルームからユーザーを削除します。
 func (r *SimpleRoom) RemoveUser(user User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	delete(r.users, user.GetToken())
+	if len(r.users) == 0 && r.emptySince.IsZero() {
+		r.emptySince = time.Now()
+	}
 	return nil
 }
 
@@ -117,6 +655,9 @@ func (r *SimpleRoom) Broadcast(message string, sender User) error {
 
 // GetUsers はチャットルーム内の全ユーザーを返します。
 func (r *SimpleRoom) GetUsers() []User {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
 	users := make([]User, 0, len(r.users))
 	for _, user := range r.users {
 		users = append(users, user)
@@ -130,7 +671,9 @@ type SimpleUser struct {
 	token   string
 	address string
 	isHost  bool
+	account string // ログインしたアカウントの名前（ゲストの場合は空）
 	udpAddr *net.UDPAddr
+	mutex   sync.RWMutex
 }
 
 // NewUser は新しいSimpleUserを生成します。
@@ -140,9 +683,32 @@ func NewUser(name, token, address string) User {
 
 // GetName はユーザーの名前を返します。
 func (u *SimpleUser) GetName() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
 	return u.name
 }
 
+// SetName はユーザーの名前を変更します。
+func (u *SimpleUser) SetName(name string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.name = name
+}
+
+// Account はログインしたアカウントの名前を返します。ゲストの場合は空です。
+func (u *SimpleUser) Account() string {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.account
+}
+
+// SetAccount はユーザーをアカウントに結び付けます。
+func (u *SimpleUser) SetAccount(account string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.account = account
+}
+
 // GetToken はユーザーのトークンを返します。
 func (u *SimpleUser) GetToken() string {
 	return u.token
@@ -155,15 +721,21 @@ func (u *SimpleUser) GetAddress() string {
 
 // IsHost はユーザーがホストかどうかを返します。
 func (u *SimpleUser) IsHost() bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
 	return u.isHost
 }
 
 // GetUDPAddr はユーザーのUDPアドレスを返します。
 func (u *SimpleUser) GetUDPAddr() *net.UDPAddr {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
 	return u.udpAddr
 }
 
 // SetUDPAddr はユーザーのUDPアドレスを設定します。
 func (u *SimpleUser) SetUDPAddr(addr *net.UDPAddr) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
 	u.udpAddr = addr
 }
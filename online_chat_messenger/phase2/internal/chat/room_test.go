@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/pwhash"
+	"online_chat_messenger/internal/store"
+)
+
+// testPasswordIterations はテストを速くするためのPBKDF2の反復回数です。
+const testPasswordIterations = 1000
+
+func newTestRoomManager(t *testing.T) *SimpleRoomManager {
+	t.Helper()
+	m := NewSimpleRoomManager()
+	m.SetPasswordIterations(testPasswordIterations)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestCreateRoomStoresPasswordHash(t *testing.T) {
+	m := newTestRoomManager(t)
+	s := store.NewMemoryStore()
+	m.SetStore(s)
+
+	if _, err := m.CreateRoom("secret", "open sesame", Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateRoom("open", "", Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := state.Rooms["secret"].Password
+	if !pwhash.IsHash(saved) || !pwhash.Verify("open sesame", saved) {
+		t.Errorf("saved password = %q, want a hash of the password", saved)
+	}
+	if saved := state.Rooms["open"].Password; saved != "" {
+		t.Errorf("saved password of a room without one = %q", saved)
+	}
+}
+
+func TestRestoreHashesPlaintextPassword(t *testing.T) {
+	m := newTestRoomManager(t)
+	m.Restore(map[string]store.Room{
+		"legacy": {Name: "legacy", Password: "open sesame"},
+		"open":   {Name: "open"},
+	})
+
+	s := store.NewMemoryStore()
+	m.SetStore(s)
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 平文で保存されていたルームだけを保存し直す
+	saved, ok := state.Rooms["legacy"]
+	if !ok || !pwhash.Verify("open sesame", saved.Password) {
+		t.Errorf("legacy room = %+v, want it saved again with a hashed password", saved)
+	}
+	if _, ok := state.Rooms["open"]; ok {
+		t.Error("room without a plaintext password was saved again")
+	}
+}
+
+func TestJoinAfterEmptyRoomExpired(t *testing.T) {
+	m := newTestRoomManager(t)
+	m.SetEmptyRoomTTL(time.Minute, time.Hour)
+	room, err := m.CreateRoom("lobby", "", Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// FindRoomで見つけた後、入室する前にルームが削除された場合
+	found, err := m.FindRoom("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted := m.DeleteEmptyRooms(time.Now().Add(2 * time.Minute)); len(deleted) != 1 {
+		t.Fatalf("DeleteEmptyRooms = %v, want lobby", deleted)
+	}
+	if err := found.AddUser(NewUser("taro", "t1", "127.0.0.1:1"), false); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("AddUser to an expired room = %v, want ErrRoomNotFound", err)
+	}
+	if len(room.GetUsers()) != 0 {
+		t.Error("user was added to an expired room")
+	}
+}
+
+func TestEmptyRoomIsKeptWhenJoinedFirst(t *testing.T) {
+	m := newTestRoomManager(t)
+	m.SetEmptyRoomTTL(time.Minute, time.Hour)
+	room, err := m.CreateRoom("lobby", "", Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := room.AddUser(NewUser("taro", "t1", "127.0.0.1:1"), false); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := m.DeleteEmptyRooms(time.Now().Add(2 * time.Minute)); len(deleted) != 0 {
+		t.Errorf("DeleteEmptyRooms = %v, want none", deleted)
+	}
+	if _, err := m.FindRoom("lobby"); err != nil {
+		t.Errorf("FindRoom = %v", err)
+	}
+}
+
+func TestJoinAfterRoomDeleted(t *testing.T) {
+	m := newTestRoomManager(t)
+	room, err := m.CreateRoom("lobby", "", Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteRoom("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := room.AddUser(NewUser("taro", "t1", "127.0.0.1:1"), true); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("AddUser to a deleted room = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	m := newTestRoomManager(t)
+	secret, err := m.CreateRoom("secret", "open sesame", Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := m.CreateRoom("open", "", Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		room     Room
+		password string
+		want     bool
+	}{
+		{secret, "open sesame", true},
+		{secret, "open", false},
+		{secret, "", false},
+		{open, "", true},
+		{open, "anything", true},
+	} {
+		if got := tt.room.(*SimpleRoom).CheckPassword(tt.password); got != tt.want {
+			t.Errorf("%s: CheckPassword(%q) = %v, want %v", tt.room.GetName(), tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		meta Metadata
+		ok   bool
+	}{
+		{"ゼロ値", Metadata{}, true},
+		{"トピックは文字数で数える", Metadata{Topic: strings.Repeat("話", MaxTopicLength)}, true},
+		{"トピックが長すぎる", Metadata{Topic: strings.Repeat("a", MaxTopicLength+1)}, false},
+		{"説明は文字数で数える", Metadata{Description: strings.Repeat("説", MaxDescriptionLength)}, true},
+		{"説明が長すぎる", Metadata{Description: strings.Repeat("a", MaxDescriptionLength+1)}, false},
+		{"メンバー数の上限が負", Metadata{MaxMembers: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.meta.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("Validate = %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidMetadata) {
+				t.Errorf("Validate = %v, want ErrInvalidMetadata", err)
+			}
+		})
+	}
+}
+
+func TestCreateRoomRejectsInvalidMetadata(t *testing.T) {
+	m := newTestRoomManager(t)
+	if _, err := m.CreateRoom("lobby", "", Metadata{Topic: strings.Repeat("a", MaxTopicLength+1)}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("CreateRoom = %v, want ErrInvalidMetadata", err)
+	}
+	if _, err := m.FindRoom("lobby"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("FindRoom = %v, want the room not to be created", err)
+	}
+}
+
+func TestSetMetadataKeepsCreator(t *testing.T) {
+	m := newTestRoomManager(t)
+	room, err := m.CreateRoom("lobby", "", Metadata{Creator: "taro", CreatorAddr: "192.0.2.1", Topic: "雑談"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := room.Metadata()
+	if created.CreatedAt.IsZero() {
+		t.Error("CreatedAt is not set")
+	}
+
+	// 作成者と作成時刻は変更できない
+	room.SetMetadata(Metadata{Creator: "jiro", CreatedAt: time.Now().Add(time.Hour), Topic: "新しい話題", MaxMembers: 3})
+	got := room.Metadata()
+	if got.Topic != "新しい話題" || got.MaxMembers != 3 {
+		t.Errorf("Metadata = %+v", got)
+	}
+	if got.Creator != "taro" || got.CreatorAddr != "192.0.2.1" || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("SetMetadata changed the creator: %+v", got)
+	}
+}
@@ -0,0 +1,39 @@
+package chattest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/client"
+)
+
+// ErrTimeout はReceiveで待機時間内にイベントが届かなかったことを表します。
+var ErrTimeout = errors.New("chattest: receive timeout")
+
+// Dial はサーバーに接続するクライアントを生成します。
+// クライアントはテスト終了時に自動的に閉じられます。
+func (s *Server) Dial(tb testing.TB) *client.Client {
+	tb.Helper()
+
+	c, err := client.Dial(s.TCPAddr, s.UDPAddr)
+	if err != nil {
+		tb.Fatalf("chattest: クライアントの接続に失敗しました: %v", err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Receive はクライアントが受信したイベントを1つ返します。
+// timeout以内に届かなければErrTimeoutを返します。
+func Receive(c *client.Client, timeout time.Duration) (client.Event, error) {
+	select {
+	case event, ok := <-c.Messages():
+		if !ok {
+			return client.Event{}, errors.New("chattest: client closed")
+		}
+		return event, nil
+	case <-time.After(timeout):
+		return client.Event{}, ErrTimeout
+	}
+}
@@ -0,0 +1,148 @@
+package chattest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/client"
+)
+
+// receiveEvent は種類がtypのイベントが届くまで、それ以外のイベントを読み飛ばします。
+func receiveEvent(t *testing.T, c *client.Client, typ client.EventType) client.Event {
+	t.Helper()
+	for {
+		event, err := Receive(c, receiveTimeout)
+		if err != nil {
+			t.Fatalf("waiting for event type %d: %v", typ, err)
+		}
+		if event.Type == typ {
+			return event
+		}
+	}
+}
+
+// wantNoDirect は個人宛てのメッセージが届かないことを確認します。
+func wantNoDirect(t *testing.T, c *client.Client) {
+	t.Helper()
+	for {
+		event, err := Receive(c, 300*time.Millisecond)
+		if errors.Is(err, ErrTimeout) {
+			return
+		}
+		if err != nil || event.Type == client.EventDirect {
+			t.Fatalf("received %+v, %v, want no direct message", event, err)
+		}
+	}
+}
+
+// dial はクライアントを接続し、ルームを作成するか参加します。
+func dial(t *testing.T, s *Server, roomName, userName string, create bool) *client.Client {
+	t.Helper()
+	c := s.Dial(t)
+	var err error
+	if create {
+		err = c.CreateRoom(roomName, userName, "", client.RoomOptions{})
+	} else {
+		err = c.JoinRoom(roomName, userName, "", "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDirectMessageInRoom(t *testing.T) {
+	s := NewServer(t, Options{})
+	taro := dial(t, s, "lobby", "taro", true)
+	hanako := dial(t, s, "lobby", "hanako", false)
+	jiro := dial(t, s, "lobby", "jiro", false)
+
+	// 名前は大文字・小文字や全角・半角を区別しない
+	if err := taro.SendDirect("HANAKO", "内緒です"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, hanako, client.EventDirect); event.Sender != "taro" || event.Text != "内緒です" {
+		t.Errorf("hanako received %+v", event)
+	}
+	// 他のメンバーと送信者には届かない
+	wantNoDirect(t, jiro)
+	wantNoDirect(t, taro)
+
+	// 個人宛てのメッセージは履歴に残らない
+	late := dial(t, s, "lobby", "saburo", false)
+	for {
+		event, err := Receive(late, 300*time.Millisecond)
+		if errors.Is(err, ErrTimeout) {
+			break
+		}
+		if err != nil || strings.Contains(event.Text, "内緒です") {
+			t.Fatalf("a member who joined later received %+v, %v", event, err)
+		}
+	}
+}
+
+func TestDirectMessageToAnotherRoom(t *testing.T) {
+	s := NewServer(t, Options{})
+	taro := dial(t, s, "lobby", "taro", true)
+	hanako := dial(t, s, "other", "hanako", true)
+
+	// 同じルームにいなければサーバー全体から探す
+	if err := taro.SendDirect("hanako", "別のルームへ"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, hanako, client.EventDirect); event.Sender != "taro" || event.Text != "別のルームへ" {
+		t.Errorf("hanako received %+v", event)
+	}
+
+	// 同じルームに同じ名前のユーザーがいればそちらだけに届ける
+	sameRoom := dial(t, s, "lobby", "hanako", false)
+	if err := taro.SendDirect("hanako", "同じルームへ"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, sameRoom, client.EventDirect); event.Text != "同じルームへ" {
+		t.Errorf("the member in the same room received %+v", event)
+	}
+	wantNoDirect(t, hanako)
+}
+
+func TestDirectMessageNotDelivered(t *testing.T) {
+	s := NewServer(t, Options{})
+	taro := dial(t, s, "lobby", "taro", true)
+	hanako := dial(t, s, "lobby", "hanako", false)
+
+	tests := []struct {
+		name string
+		to   string
+	}{
+		{"存在しないユーザー", "jiro"},
+		{"自分自身", "taro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := taro.SendDirect(tt.to, "届きますか"); err != nil {
+				t.Fatal(err)
+			}
+			// 送信者にだけ理由を知らせる
+			event := receiveEvent(t, taro, client.EventSystem)
+			if !strings.Contains(event.Text, tt.to+" というユーザーは見つかりませんでした") {
+				t.Errorf("taro received %+v", event)
+			}
+			wantNoDirect(t, taro)
+			wantNoDirect(t, hanako)
+		})
+	}
+
+	// 退出したユーザーには届かない
+	if err := hanako.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if err := taro.SendDirect("hanako", "まだいますか"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, taro, client.EventSystem); !strings.Contains(event.Text, "hanako というユーザーは見つかりませんでした") {
+		t.Errorf("taro received %+v", event)
+	}
+	wantNoDirect(t, hanako)
+}
@@ -0,0 +1,71 @@
+package chattest
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"online_chat_messenger/internal/client"
+	"online_chat_messenger/internal/logging"
+	"online_chat_messenger/internal/protocol"
+)
+
+// syncBuffer は複数のゴルーチンから書き込めるバッファです。
+type syncBuffer struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestLogsDoNotContainSecrets(t *testing.T) {
+	var buf syncBuffer
+	logger, err := logging.New(&buf, slog.LevelDebug, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protocol.SetLogger(logger)
+	t.Cleanup(func() { protocol.SetLogger(slog.Default()) })
+
+	s := NewServer(t, Options{Logger: logger, PasswordIterations: 1000})
+	host := s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "open-sesame", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	guest := s.Dial(t)
+	wantStatus(t, guest.JoinRoom("lobby", "hanako", "wrong-sesame", ""), protocol.StatusWrongPassword)
+	if err := guest.JoinRoom("lobby", "hanako", "open-sesame", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := guest.Send("こんにちは"); err != nil {
+		t.Fatal(err)
+	}
+	receiveMessage(t, host)
+	secrets := []string{"open-sesame", "wrong-sesame", host.Token(), guest.Token()}
+	if err := guest.Leave(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	// リクエストはログに出力されている
+	if !strings.Contains(got, `"room":"lobby"`) || !strings.Contains(got, `"component":"protocol"`) {
+		t.Fatalf("the requests were not logged:\n%s", got)
+	}
+	for _, secret := range secrets {
+		if strings.Contains(got, secret) {
+			t.Errorf("the log contains %q:\n%s", secret, got)
+		}
+	}
+}
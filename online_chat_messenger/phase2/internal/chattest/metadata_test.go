@@ -0,0 +1,101 @@
+package chattest
+
+import (
+	"strings"
+	"testing"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/client"
+	"online_chat_messenger/internal/protocol"
+)
+
+// runCommand はコマンドを送信し、送信者に届いたお知らせを返します。
+func runCommand(t *testing.T, c *client.Client, line string) string {
+	t.Helper()
+	if err := c.Send(line); err != nil {
+		t.Fatal(err)
+	}
+	return receiveEvent(t, c, client.EventSystem).Text
+}
+
+func TestCreateRoomWithInvalidMetadata(t *testing.T) {
+	s := NewServer(t, Options{})
+	tests := []struct {
+		name string
+		opts client.RoomOptions
+	}{
+		{"トピックが長すぎる", client.RoomOptions{Topic: strings.Repeat("話", chat.MaxTopicLength+1)}},
+		{"説明が長すぎる", client.RoomOptions{Description: strings.Repeat("a", chat.MaxDescriptionLength+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantStatus(t, s.Dial(t).CreateRoom("lobby", "taro", "", tt.opts), protocol.StatusInvalidRequest)
+			if _, err := s.RoomManager.FindRoom("lobby"); err == nil {
+				t.Error("the room was created")
+			}
+		})
+	}
+}
+
+func TestMetadataCommands(t *testing.T) {
+	s := NewServer(t, Options{})
+	host := s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "", client.RoomOptions{Topic: "雑談", Description: "なんでも"}); err != nil {
+		t.Fatal(err)
+	}
+	guest := dial(t, s, "lobby", "hanako", false)
+	room, err := s.RoomManager.FindRoom("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ホストでなくても表示はできる
+	if got := runCommand(t, guest, "/topic"); got != "トピック: 雑談" {
+		t.Errorf("/topic = %q", got)
+	}
+	if got := runCommand(t, guest, "/desc"); got != "説明: なんでも" {
+		t.Errorf("/desc = %q", got)
+	}
+
+	// ホストでなければ変更できない
+	for _, line := range []string{"/topic 乗っ取り", "/desc 乗っ取り", "/limit 1"} {
+		if got := runCommand(t, guest, line); !strings.Contains(got, "ホストだけが実行できます") {
+			t.Errorf("%s by a guest = %q", line, got)
+		}
+	}
+	if meta := room.Metadata(); meta.Topic != "雑談" || meta.Description != "なんでも" || meta.MaxMembers != 0 {
+		t.Errorf("a guest changed the metadata: %+v", meta)
+	}
+
+	// 長すぎる場合はホストでも変更できない
+	if got := runCommand(t, host, "/topic "+strings.Repeat("話", chat.MaxTopicLength+1)); !strings.Contains(got, "100文字以内") {
+		t.Errorf("/topic too long = %q", got)
+	}
+	if got := runCommand(t, host, "/desc "+strings.Repeat("a", chat.MaxDescriptionLength+1)); !strings.Contains(got, "500文字以内") {
+		t.Errorf("/desc too long = %q", got)
+	}
+	if meta := room.Metadata(); meta.Topic != "雑談" || meta.Description != "なんでも" {
+		t.Errorf("invalid metadata was applied: %+v", meta)
+	}
+
+	// ホストが変更するとメンバー全員に知らせる
+	topic := strings.Repeat("話", chat.MaxTopicLength)
+	if err := host.Send("/topic " + topic); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, guest, client.EventSystem); event.Text != "taro がトピックを「"+topic+"」に変更しました" {
+		t.Errorf("guest received %+v", event)
+	}
+	if meta := room.Metadata(); meta.Topic != topic {
+		t.Errorf("Topic = %q", meta.Topic)
+	}
+
+	// 後から入室したユーザーには新しいトピックが届く
+	late := s.Dial(t)
+	if err := late.JoinRoom("lobby", "jiro", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if late.Topic() != topic || late.Description() != "なんでも" {
+		t.Errorf("topic %q, description %q on join", late.Topic(), late.Description())
+	}
+}
@@ -0,0 +1,127 @@
+// Package chattest はphase2のチャットサーバーをテストから起動するためのユーティリティを提供します。
+package chattest
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/msglog"
+	"online_chat_messenger/internal/network"
+	"online_chat_messenger/internal/search"
+)
+
+// Options はテスト用サーバーの設定です。ゼロ値の項目にはデフォルト値が使われます。
+// TokenSigner・MessageLog・SearchIndex・Accountsはnilの場合は使わず、閉じる必要があるものは呼び出し側で閉じます。
+type Options struct {
+	InactiveTimeout time.Duration // 非アクティブとみなすまでの時間
+	CleanupInterval time.Duration // 非アクティブユーザーを削除する間隔
+	Logger          *slog.Logger  // nilの場合はログを出力しない
+
+	TokenSigner *auth.TokenSigner // 署名付きトークンを発行・確認する（nilの場合はランダムなトークン）
+	MessageLog  *msglog.Log       // メッセージを永続化する
+	SearchIndex *search.Index     // メッセージの検索に使う
+	Accounts    *auth.Accounts    // アカウントの登録・ログインに使う
+
+	MaxRooms           int // サーバー全体のルーム数の上限（0は無制限）
+	MaxRoomsPerAddress int // 同じアドレスから作成できるルーム数の上限（0は無制限）
+	MaxRoomMembers     int // 1ルームあたりのメンバー数の上限（0は無制限）
+	PasswordIterations int // ルームのパスワードのハッシュの反復回数（0はpwhash.DefaultIterations）
+}
+
+// Server はランダムなポートでプロセス内に起動したTCP/UDPサーバーです。
+type Server struct {
+	TCPAddr     string // TCPサーバーのアドレス（例: 127.0.0.1:54321）
+	UDPAddr     string // UDPサーバーのアドレス
+	RoomManager *chat.SimpleRoomManager
+	UserManager *auth.SimpleUserManager
+
+	tcpServer *network.TCPServer
+	udpServer *network.UDPServer
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewServer はTCP/UDPサーバーをランダムなポートで起動します。
+// サーバーはテスト終了時に自動的に停止されます。
+func NewServer(tb testing.TB, opts Options) *Server {
+	tb.Helper()
+
+	if opts.InactiveTimeout == 0 {
+		opts.InactiveTimeout = auth.DefaultInactiveTimeout
+	}
+	if opts.CleanupInterval == 0 {
+		opts.CleanupInterval = auth.DefaultCleanupInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("chattest: TCPリスナーの作成に失敗しました: %v", err)
+	}
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		listener.Close()
+		tb.Fatalf("chattest: UDPコネクションの作成に失敗しました: %v", err)
+	}
+
+	roomManager := chat.NewSimpleRoomManager()
+	roomManager.SetLogger(opts.Logger)
+	roomManager.SetLimits(opts.MaxRooms, opts.MaxRoomsPerAddress, opts.MaxRoomMembers)
+	if opts.PasswordIterations > 0 {
+		roomManager.SetPasswordIterations(opts.PasswordIterations)
+	}
+	userManager := auth.NewSimpleUserManagerWithTimeout(opts.InactiveTimeout, opts.CleanupInterval)
+	userManager.SetRoomManager(roomManager)
+	userManager.SetLogger(opts.Logger)
+
+	s := &Server{
+		TCPAddr:     listener.Addr().String(),
+		UDPAddr:     packetConn.LocalAddr().String(),
+		RoomManager: roomManager,
+		UserManager: userManager,
+		tcpServer:   network.NewTCPServerWithListener(listener, roomManager, userManager),
+		udpServer:   network.NewUDPServerWithConn(packetConn, roomManager, userManager),
+	}
+
+	s.tcpServer.SetLogger(opts.Logger)
+	s.udpServer.SetLogger(opts.Logger)
+	s.tcpServer.SetTokenSigner(opts.TokenSigner)
+	s.udpServer.SetTokenSigner(opts.TokenSigner)
+	s.tcpServer.SetMessageLog(opts.MessageLog)
+	s.udpServer.SetMessageLog(opts.MessageLog)
+	s.tcpServer.SetSearchIndex(opts.SearchIndex)
+	s.udpServer.SetSearchIndex(opts.SearchIndex)
+	s.tcpServer.SetAccounts(opts.Accounts)
+	s.udpServer.SetAccounts(opts.Accounts)
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.tcpServer.Start()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.udpServer.Start()
+	}()
+
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Close はサーバーを停止し、すべてのゴルーチンの終了を待ちます。
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.tcpServer.Close()
+		s.udpServer.Close()
+		s.UserManager.Close()
+		s.RoomManager.Close()
+		s.wg.Wait()
+	})
+}
@@ -0,0 +1,219 @@
+package chattest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/client"
+	"online_chat_messenger/internal/protocol"
+)
+
+// receiveTimeout はイベントを待つ時間です。
+const receiveTimeout = 2 * time.Second
+
+// receiveMessage はチャットメッセージが届くまで、お知らせや履歴を読み飛ばします。
+func receiveMessage(t *testing.T, c *client.Client) client.Event {
+	t.Helper()
+	for {
+		event, err := Receive(c, receiveTimeout)
+		if err != nil {
+			t.Fatalf("Receive = %v", err)
+		}
+		if event.Type == client.EventError {
+			t.Fatalf("Receive = %v", event.Err)
+		}
+		if event.Type == client.EventMessage {
+			return event
+		}
+	}
+}
+
+// wantStatus はエラーがステータスコードstatusの拒否であることを確認します。
+func wantStatus(t *testing.T, err error, status uint8) {
+	t.Helper()
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != status {
+		t.Errorf("err = %v, want status %d", err, status)
+	}
+}
+
+// roomMembers はルームにいるユーザーの名前を返します。
+func roomMembers(t *testing.T, s *Server, roomName string) []string {
+	t.Helper()
+	room, err := s.RoomManager.FindRoom(roomName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, user := range room.GetUsers() {
+		names = append(names, user.GetName())
+	}
+	return names
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	s := NewServer(t, Options{
+		InactiveTimeout:    500 * time.Millisecond,
+		CleanupInterval:    50 * time.Millisecond,
+		MaxRoomMembers:     2,
+		PasswordIterations: 1000,
+	})
+
+	host := s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "open sesame", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	guest := s.Dial(t)
+	wantStatus(t, guest.JoinRoom("lobby", "hanako", "wrong", ""), protocol.StatusWrongPassword)
+	if err := guest.JoinRoom("lobby", "hanako", "open sesame", ""); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.Dial(t).JoinRoom("lobby", "jiro", "open sesame", ""), protocol.StatusRoomFull)
+
+	// チャット
+	if err := host.Send("こんにちは"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveMessage(t, guest); event.Sender != "taro" || event.Text != "こんにちは" {
+		t.Errorf("guest received %+v", event)
+	}
+	if err := guest.Send("よろしく"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveMessage(t, host); event.Sender != "hanako" || event.Text != "よろしく" {
+		t.Errorf("host received %+v", event)
+	}
+
+	// 送信しないゲストは非アクティブとして削除され、送信を続けるホストは残る
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := host.Send(""); err != nil {
+			t.Fatal(err)
+		}
+		members := roomMembers(t, s, "lobby")
+		if len(members) == 1 && members[0] == "taro" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("members = %v, want only the host after the inactivity timeout", members)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 退出
+	if err := host.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if members := roomMembers(t, s, "lobby"); len(members) != 0 {
+		t.Errorf("members after leaving = %v", members)
+	}
+	if err := host.Leave(); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Leave twice = %v, want ErrNotInRoom", err)
+	}
+}
+
+func TestLeaveRejectsNonMember(t *testing.T) {
+	s := NewServer(t, Options{})
+	host, guest := s.Dial(t), s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := guest.JoinRoom("lobby", "hanako", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// ルームのメンバーでなくなったユーザーの退出は、受け付ける前に拒否する
+	room, err := s.RoomManager.FindRoom("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range room.GetUsers() {
+		if user.GetToken() == guest.Token() {
+			room.RemoveUser(user)
+		}
+	}
+	wantStatus(t, guest.Leave(), protocol.StatusPermissionDenied)
+
+	// ルームがなくなっている場合
+	s.RoomManager.DeleteRoom("lobby")
+	wantStatus(t, host.Leave(), protocol.StatusRoomNotFound)
+}
+
+func TestRoomLimit(t *testing.T) {
+	s := NewServer(t, Options{MaxRooms: 1})
+	if err := s.Dial(t).CreateRoom("lobby", "taro", "", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, s.Dial(t).CreateRoom("other", "hanako", "", client.RoomOptions{}), protocol.StatusRoomLimitReached)
+}
+
+func TestSignedTokens(t *testing.T) {
+	key, err := auth.GenerateTokenKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenSigner([]auth.TokenKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(t, Options{TokenSigner: tokens})
+
+	host := s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(host.Token(), "test.") {
+		t.Errorf("token = %q, want a token signed with the test key", host.Token())
+	}
+	claims, err := tokens.Verify(host.Token(), "lobby")
+	if err != nil || claims.Role != auth.RoleHost {
+		t.Errorf("Verify = %+v, %v, want a host token", claims, err)
+	}
+
+	guest := s.Dial(t)
+	if err := guest.JoinRoom("lobby", "hanako", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := guest.Send("こんにちは"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveMessage(t, host); event.Sender != "hanako" {
+		t.Errorf("host received %+v", event)
+	}
+
+	// 署名のあるトークンはユーザーの表を引かないため、表に登録がなくてもルームのメンバーなら送信できる
+	s.UserManager.DeleteUser(guest.Token())
+	if err := guest.Send("まだいます"); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveMessage(t, host); event.Text != "まだいます" {
+		t.Errorf("host received %+v", event)
+	}
+
+	// ルームのメンバーでなくなったトークンのメッセージは配信しない
+	room, err := s.RoomManager.FindRoom("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range room.GetUsers() {
+		if user.GetToken() == guest.Token() {
+			room.RemoveUser(user)
+		}
+	}
+	if err := guest.Send("もういません"); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event, err := Receive(host, 300*time.Millisecond)
+		if errors.Is(err, ErrTimeout) {
+			break
+		}
+		if err != nil || event.Type == client.EventMessage {
+			t.Fatalf("host received %+v, %v from a user who is not a member", event, err)
+		}
+	}
+}
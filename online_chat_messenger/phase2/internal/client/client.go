@@ -0,0 +1,560 @@
+// Package client はphase2のチャットプロトコルを操作するクライアントライブラリです。
+// 端末に依存しないため、CLIクライアントのほかボットやテストからも利用できます。
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"online_chat_messenger/internal/protocol"
+	"online_chat_messenger/internal/transcript"
+)
+
+// ErrNotInRoom はルームに入室する前に送信・退出しようとしたことを表します。
+var ErrNotInRoom = errors.New("client: not in a room")
+
+// EventType はクライアントが受信するイベントの種類です。
+type EventType int
+
+const (
+	// EventMessage は他のユーザーからのチャットメッセージです。
+	EventMessage EventType = iota
+	// EventError は受信中に発生したエラーです。
+	EventError
+	// EventSystem はサーバーからのお知らせです。
+	EventSystem
+	// EventHistory は入室前に送信されたメッセージです。入室直後に古い順に届きます。
+	EventHistory
+	// EventDirect は他のユーザーから自分だけに宛てられたメッセージです。
+	EventDirect
+	// EventAction は他のユーザーが /me で送信した動作です。Textは送信者の名前を含みません。
+	EventAction
+	// EventRenamed は自分の名前が変更されたことの通知です。Senderが新しい名前です。
+	EventRenamed
+)
+
+// Event はサーバーから受信したイベントを表します。
+type Event struct {
+	Type   EventType
+	Sender string    // 送信者の名前
+	Text   string    // メッセージ本文
+	Time   time.Time // サーバーがメッセージを受け付けた時刻（EventErrorの場合は発生時刻）
+	Err    error     // EventErrorの場合のエラー
+}
+
+// Client はチャットサーバーとの通信を行うクライアントです。
+type Client struct {
+	tcpAddr string
+	udpConn net.Conn
+
+	roomName string
+	userName string
+	token    string
+	motd     string
+	topic    string
+	desc     string
+	mutex    sync.RWMutex
+
+	account    string // ログインしたアカウントの名前（ゲストの場合は空）
+	loginToken string
+
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial はTCPとUDPのサーバーアドレスを指定してクライアントを生成します。
+// TCPはルーム操作のたびに接続し、UDPは受信のために接続したままにします。
+func Dial(tcpAddr, udpAddr string) (*Client, error) {
+	udpConn, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("UDPサーバーへの接続に失敗しました: %w", err)
+	}
+
+	c := &Client{
+		tcpAddr: tcpAddr,
+		udpConn: udpConn,
+		events:  make(chan Event, 64),
+		done:    make(chan struct{}),
+	}
+	go c.receiveLoop()
+	return c, nil
+}
+
+// RoomOptions はルームの作成時に設定する付加情報です。ゼロ値の項目は設定しません。
+type RoomOptions struct {
+	Topic       string
+	Description string
+	MaxMembers  int  // ルームのメンバー数の上限（0はサーバーの上限だけに従う）
+	InviteOnly  bool // 参加にホストが発行した招待コードを必要にする
+}
+
+// CreateRoom はルームを作成し、ホストとして入室します。
+func (c *Client) CreateRoom(roomName, userName, password string, opts RoomOptions) error {
+	return c.enterRoom(protocol.OperationCreateRoom, roomName, userName, password, opts, "")
+}
+
+// JoinRoom は既存のルームに入室します。招待制のルームにはinviteCodeにホストが発行した招待コードを指定します。
+func (c *Client) JoinRoom(roomName, userName, password, inviteCode string) error {
+	return c.enterRoom(protocol.OperationJoinRoom, roomName, userName, password, RoomOptions{}, inviteCode)
+}
+
+// enterRoom はルームの作成・参加を行い、発行されたトークンを保持します。
+func (c *Client) enterRoom(operation uint8, roomName, userName, password string, opts RoomOptions, inviteCode string) error {
+	body := map[string]any{
+		"room_name": roomName,
+		"user_name": userName,
+		"password":  password,
+	}
+	if inviteCode != "" {
+		body["invite_code"] = inviteCode
+	}
+	// ログインしている場合はアカウントの名前で入室する
+	c.mutex.RLock()
+	if c.loginToken != "" {
+		body["login_token"] = c.loginToken
+		userName = c.account
+	}
+	c.mutex.RUnlock()
+	if opts.InviteOnly {
+		body["invite_only"] = true
+	}
+	if opts.Topic != "" {
+		body["topic"] = opts.Topic
+	}
+	if opts.Description != "" {
+		body["description"] = opts.Description
+	}
+	if opts.MaxMembers > 0 {
+		body["max_members"] = opts.MaxMembers
+	}
+
+	var payload map[string]string
+	err := c.request(operation, body, &payload)
+	if err != nil {
+		return err
+	}
+
+	// サーバーがルーム名を返した場合はそちらを使う
+	if respRoomName, ok := payload["roomName"]; ok {
+		roomName = respRoomName
+	}
+
+	c.mutex.Lock()
+	c.roomName = roomName
+	c.userName = userName
+	c.token = payload["token"]
+	c.motd = payload["motd"]
+	c.topic = payload["topic"]
+	c.desc = payload["description"]
+	c.mutex.Unlock()
+
+	// 空のメッセージを送り、サーバーにUDPアドレスを知らせる（入室前の履歴が届く）
+	return c.Send("")
+}
+
+// Register はアカウントを登録し、そのままログインします。
+// 以降のCreateRoom・JoinRoomはuserNameの代わりにアカウントの名前で入室します。
+func (c *Client) Register(userName, password string) error {
+	return c.authenticate(protocol.OperationRegister, userName, password)
+}
+
+// Login はアカウントにログインします。
+// 以降のCreateRoom・JoinRoomはuserNameの代わりにアカウントの名前で入室します。
+func (c *Client) Login(userName, password string) error {
+	return c.authenticate(protocol.OperationLogin, userName, password)
+}
+
+// authenticate は登録・ログインを行い、発行されたログイントークンを保持します。
+func (c *Client) authenticate(operation uint8, userName, password string) error {
+	var payload struct {
+		LoginToken string `json:"login_token"`
+		UserName   string `json:"user_name"`
+	}
+	err := c.request(operation, map[string]string{
+		"user_name": userName,
+		"password":  password,
+	}, &payload)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.account = payload.UserName
+	c.loginToken = payload.LoginToken
+	c.mutex.Unlock()
+	return nil
+}
+
+// Account はログインしたアカウントの名前を返します。ログインしていない場合は空です。
+func (c *Client) Account() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.account
+}
+
+// Send は入室中のルームにチャットメッセージを送信します。
+// "/" で始まるメッセージはサーバーでコマンドとして実行されます。"/" で始まる文章を送る場合は "//" で始めてください。
+func (c *Client) Send(message string) error {
+	c.mutex.RLock()
+	roomName, token := c.roomName, c.token
+	c.mutex.RUnlock()
+	if token == "" {
+		return ErrNotInRoom
+	}
+
+	udpMessage, err := protocol.NewUDPMessage(roomName, token, message)
+	if err != nil {
+		return err
+	}
+	data, err := protocol.EncodeUDPMessage(udpMessage)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.udpConn.Write(data); err != nil {
+		return fmt.Errorf("UDPサーバへの送信に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// SendDirect は指定した名前のユーザーだけにメッセージを送信します。
+// 宛先は同じルームから探し、いなければサーバー全体から探します。見つからない場合はEventSystemで通知されます。
+func (c *Client) SendDirect(userName, message string) error {
+	if userName == "" || strings.ContainsRune(userName, ' ') {
+		return fmt.Errorf("宛先のユーザー名が正しくありません: %q", userName)
+	}
+	return c.Send("/msg " + userName + " " + message)
+}
+
+// Leave は入室中のルームから退出し、トークンを破棄します。
+func (c *Client) Leave() error {
+	c.mutex.RLock()
+	roomName, userName, token := c.roomName, c.userName, c.token
+	c.mutex.RUnlock()
+	if token == "" {
+		return ErrNotInRoom
+	}
+
+	err := c.request(protocol.OperationLeaveRoom, map[string]string{
+		"room_name": roomName,
+		"user_name": userName,
+		"token":     token,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.roomName, c.token = "", ""
+	c.mutex.Unlock()
+	return nil
+}
+
+// SearchResult は検索で見つかったメッセージです。
+type SearchResult struct {
+	Room   string    `json:"room"`
+	Sender string    `json:"sender"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Search は入室中のルームの履歴から、空白で区切った検索語をすべて含むメッセージを新しい順に返します。
+// limitが0以下の場合はサーバーのデフォルトの件数まで返します。
+func (c *Client) Search(query string, limit int) ([]SearchResult, error) {
+	c.mutex.RLock()
+	roomName, token := c.roomName, c.token
+	c.mutex.RUnlock()
+	if token == "" {
+		return nil, ErrNotInRoom
+	}
+
+	var payload struct {
+		Results []SearchResult `json:"results"`
+	}
+	err := c.request(protocol.OperationSearch, map[string]any{
+		"room_name": roomName,
+		"token":     token,
+		"query":     query,
+		"limit":     limit,
+	}, &payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload.Results, nil
+}
+
+// Export は入室中のルームの会話記録のうち、時刻がfrom以上to未満のものを古い順に返します。
+// fromとtoはゼロ値の場合は制限しません。サーバーの上限を超えた場合、truncatedはtrueになります。
+func (c *Client) Export(from, to time.Time) (entries []transcript.Entry, truncated bool, err error) {
+	c.mutex.RLock()
+	roomName, token := c.roomName, c.token
+	c.mutex.RUnlock()
+	if token == "" {
+		return nil, false, ErrNotInRoom
+	}
+
+	body := map[string]any{"room_name": roomName, "token": token}
+	if !from.IsZero() {
+		body["from"] = from
+	}
+	if !to.IsZero() {
+		body["to"] = to
+	}
+	var payload struct {
+		Entries   []transcript.Entry `json:"entries"`
+		Truncated bool               `json:"truncated"`
+	}
+	if err := c.request(protocol.OperationExport, body, &payload); err != nil {
+		return nil, false, err
+	}
+	return payload.Entries, payload.Truncated, nil
+}
+
+// Invite はホストが発行した招待コードです。
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"` // ゼロ値は期限なし
+	SingleUse bool      `json:"single_use"`
+}
+
+// CreateInvite は入室中のルームの招待コードを発行します。ホストだけが実行できます。
+// ttlが0の場合は期限なし、singleUseがfalseの場合は何度でも使えるコードになります。どちらかは指定する必要があります。
+func (c *Client) CreateInvite(ttl time.Duration, singleUse bool) (Invite, error) {
+	var payload struct {
+		Invite Invite `json:"invite"`
+	}
+	err := c.inviteRequest(map[string]any{
+		"action":     "create",
+		"invite_ttl": int(ttl / time.Second),
+		"single_use": singleUse,
+	}, &payload)
+	return payload.Invite, err
+}
+
+// Invites は入室中のルームの有効な招待コードを発行した順に返します。ホストだけが実行できます。
+func (c *Client) Invites() ([]Invite, error) {
+	var payload struct {
+		Invites []Invite `json:"invites"`
+	}
+	err := c.inviteRequest(map[string]any{"action": "list"}, &payload)
+	return payload.Invites, err
+}
+
+// RevokeInvite は入室中のルームの招待コードを取り消します。ホストだけが実行できます。
+func (c *Client) RevokeInvite(code string) error {
+	return c.inviteRequest(map[string]any{"action": "revoke", "invite_code": code}, nil)
+}
+
+// inviteRequest は入室中のルームとトークンを加えて招待リクエストを送信します。
+func (c *Client) inviteRequest(body map[string]any, out any) error {
+	c.mutex.RLock()
+	roomName, token := c.roomName, c.token
+	c.mutex.RUnlock()
+	if token == "" {
+		return ErrNotInRoom
+	}
+	body["room_name"] = roomName
+	body["token"] = token
+	return c.request(protocol.OperationInvite, body, out)
+}
+
+// Messages は受信したイベントを返すチャネルです。Closeすると閉じられます。
+func (c *Client) Messages() <-chan Event {
+	return c.events
+}
+
+// RoomName は入室中のルーム名を返します。
+func (c *Client) RoomName() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.roomName
+}
+
+// UserName はユーザー名を返します。
+func (c *Client) UserName() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.userName
+}
+
+// Token はサーバーから発行されたトークンを返します。
+func (c *Client) Token() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.token
+}
+
+// MOTD は入室時にサーバーから届いたメッセージを返します。
+func (c *Client) MOTD() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.motd
+}
+
+// Topic は入室したときのルームのトピックを返します。
+func (c *Client) Topic() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.topic
+}
+
+// Description は入室したときのルームの説明を返します。
+func (c *Client) Description() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.desc
+}
+
+// Close はUDP接続を閉じ、受信処理を停止します。
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.udpConn.Close()
+	})
+	return err
+}
+
+// StatusError はサーバーがリクエストを拒否したことを表します。
+type StatusError struct {
+	Status  uint8  // protocol.Status* のステータスコード
+	Message string // サーバーが返した理由
+}
+
+func (e *StatusError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = protocol.StatusText(e.Status)
+	}
+	return fmt.Sprintf("%s（ステータス: %d）", message, e.Status)
+}
+
+// request はTCPでTCRPリクエストを送信し、完了応答のペイロードをoutにデコードします。outがnilの場合はデコードしません。
+func (c *Client) request(operation uint8, body any, out any) error {
+	conn, err := net.Dial("tcp", c.tcpAddr)
+	if err != nil {
+		return fmt.Errorf("サーバーへの接続に失敗しました: %w", err)
+	}
+	defer conn.Close()
+
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("JSONのエンコードに失敗しました: %w", err)
+	}
+
+	encodedRequest, err := protocol.EncodeTCRPMessage(protocol.TCRPMessage{
+		Header: protocol.TCRPHeader{
+			Operation: operation,
+			State:     protocol.StateRequest,
+		},
+		Body: requestBody,
+	})
+	if err != nil {
+		return fmt.Errorf("TCRPメッセージのエンコードに失敗しました: %w", err)
+	}
+
+	if _, err := conn.Write(encodedRequest); err != nil {
+		return fmt.Errorf("サーバーへの送信に失敗しました: %w", err)
+	}
+
+	// 準拠応答 (State = 1)
+	response, err := protocol.ReadTCRPMessage(conn)
+	if err != nil {
+		return fmt.Errorf("サーバーからの受信に失敗しました: %w", err)
+	}
+	if response.Header.State != protocol.StateResponse {
+		return fmt.Errorf("リクエスト処理に失敗しました。状態コード: %d", response.Header.State)
+	}
+	var status struct {
+		Status  uint8  `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(response.Body, &status); err != nil {
+		return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	}
+	if status.Status != protocol.StatusOK {
+		return &StatusError{Status: status.Status, Message: status.Message}
+	}
+
+	// 完了応答 (State = 2)
+	complete, err := protocol.ReadTCRPMessage(conn)
+	if err != nil {
+		return fmt.Errorf("サーバーからの完了応答の受信に失敗しました: %w", err)
+	}
+	if complete.Header.State != protocol.StateComplete {
+		return fmt.Errorf("リクエスト完了に失敗しました。状態コード: %d", complete.Header.State)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(complete.Body, out); err != nil {
+		return fmt.Errorf("JSONのデコードに失敗しました: %w", err)
+	}
+	return nil
+}
+
+// receiveLoop はUDPで受信したデータをイベントに変換してチャネルに送ります。
+func (c *Client) receiveLoop() {
+	defer close(c.events)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.udpConn.Read(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+				// Closeによる終了
+			default:
+				c.emit(Event{Type: EventError, Time: time.Now(), Err: err})
+			}
+			return
+		}
+		event := parseMessage(buf[:n])
+		if event.Type == EventRenamed {
+			c.mutex.Lock()
+			c.userName = event.Sender
+			c.mutex.Unlock()
+		}
+		c.emit(event)
+	}
+}
+
+// emit はイベントをチャネルに送ります。Close済みの場合は破棄します。
+func (c *Client) emit(event Event) {
+	select {
+	case c.events <- event:
+	case <-c.done:
+	}
+}
+
+// parseMessage はサーバーから届いたメッセージをイベントに変換します。
+func parseMessage(data []byte) Event {
+	msg, err := protocol.DecodeServerMessage(data)
+	if err != nil {
+		return Event{Type: EventError, Time: time.Now(), Err: fmt.Errorf("メッセージのデコードに失敗しました: %w", err)}
+	}
+
+	event := Event{Type: EventMessage, Sender: msg.Sender(), Text: msg.Text(), Time: msg.Time()}
+	switch msg.Header.Kind {
+	case protocol.KindSystem:
+		event.Type = EventSystem
+	case protocol.KindHistory:
+		event.Type = EventHistory
+	case protocol.KindDirect:
+		event.Type = EventDirect
+	case protocol.KindAction:
+		event.Type = EventAction
+	case protocol.KindRenamed:
+		event.Type = EventRenamed
+	}
+	return event
+}
@@ -0,0 +1,301 @@
+package client_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chattest"
+	"online_chat_messenger/internal/client"
+	"online_chat_messenger/internal/protocol"
+)
+
+// receiveTimeout はイベントを待つ時間です。
+const receiveTimeout = 2 * time.Second
+
+// next は種類がtypのイベントが届くまで、それ以外のイベントを読み飛ばします。
+func next(t *testing.T, c *client.Client, typ client.EventType) client.Event {
+	t.Helper()
+	for {
+		event, err := chattest.Receive(c, receiveTimeout)
+		if err != nil {
+			t.Fatalf("waiting for event type %d: %v", typ, err)
+		}
+		if event.Type == typ {
+			return event
+		}
+		if event.Type == client.EventError {
+			t.Fatalf("Receive = %v", event.Err)
+		}
+	}
+}
+
+// enter はhostがlobbyを作成し、guestが参加した状態を作ります。
+func enter(t *testing.T, s *chattest.Server) (host, guest *client.Client) {
+	t.Helper()
+	host, guest = s.Dial(t), s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "", client.RoomOptions{Topic: "雑談", Description: "なんでも"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := guest.JoinRoom("lobby", "hanako", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	return host, guest
+}
+
+func TestNotInRoom(t *testing.T) {
+	c := chattest.NewServer(t, chattest.Options{}).Dial(t)
+	if err := c.Send("こんにちは"); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Send = %v", err)
+	}
+	if err := c.Leave(); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Leave = %v", err)
+	}
+	if _, err := c.Search("こんにちは", 0); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Search = %v", err)
+	}
+	if _, _, err := c.Export(time.Time{}, time.Time{}); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Export = %v", err)
+	}
+	if _, err := c.CreateInvite(time.Hour, false); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("CreateInvite = %v", err)
+	}
+}
+
+func TestEnterRoom(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	host, guest := enter(t, s)
+
+	if host.RoomName() != "lobby" || host.UserName() != "taro" || host.Token() == "" {
+		t.Errorf("host: room %q, user %q, token %q", host.RoomName(), host.UserName(), host.Token())
+	}
+	if guest.Topic() != "雑談" || guest.Description() != "なんでも" {
+		t.Errorf("guest: topic %q, description %q", guest.Topic(), guest.Description())
+	}
+	if host.Token() == guest.Token() {
+		t.Error("the host and the guest have the same token")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	enter(t, s)
+
+	err := s.Dial(t).JoinRoom("nowhere", "jiro", "", "")
+	var statusErr *client.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != protocol.StatusRoomNotFound {
+		t.Fatalf("JoinRoom = %v, want StatusRoomNotFound", err)
+	}
+	if !strings.Contains(err.Error(), "ステータス: 3") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if got := (&client.StatusError{Status: protocol.StatusRoomNotFound}).Error(); !strings.HasPrefix(got, protocol.StatusText(protocol.StatusRoomNotFound)) {
+		t.Errorf("Error() without a message = %q", got)
+	}
+
+	// 拒否された場合は入室していない
+	c := s.Dial(t)
+	if err := c.CreateRoom("lobby", "jiro", "", client.RoomOptions{}); err == nil {
+		t.Fatal("CreateRoom with a used name succeeded")
+	}
+	if c.Token() != "" || c.RoomName() != "" {
+		t.Errorf("token %q, room %q after a rejected request", c.Token(), c.RoomName())
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	host, guest := enter(t, s)
+
+	if err := host.Send("こんにちは"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, guest, client.EventMessage); event.Sender != "taro" || event.Text != "こんにちは" || event.Time.IsZero() {
+		t.Errorf("message = %+v", event)
+	}
+
+	if err := host.Send("/me 手を振る"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, guest, client.EventAction); event.Sender != "taro" || event.Text != "手を振る" {
+		t.Errorf("action = %+v", event)
+	}
+
+	if err := guest.SendDirect("taro", "内緒です"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, host, client.EventDirect); event.Sender != "hanako" || event.Text != "内緒です" {
+		t.Errorf("direct = %+v", event)
+	}
+
+	// 見つからない宛先はお知らせで返る
+	if err := guest.SendDirect("jiro", "いますか"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, guest, client.EventSystem); !strings.Contains(event.Text, "jiro") {
+		t.Errorf("system = %+v", event)
+	}
+
+	// 名前の変更を受け取るとUserNameも変わる
+	if err := guest.Send("/nick hana"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, guest, client.EventRenamed); event.Sender != "hana" {
+		t.Errorf("renamed = %+v", event)
+	}
+	if guest.UserName() != "hana" {
+		t.Errorf("UserName = %q after the rename", guest.UserName())
+	}
+}
+
+func TestSendDirectRejectsInvalidName(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	_, guest := enter(t, s)
+	for _, name := range []string{"", "taro yamada"} {
+		if err := guest.SendDirect(name, "こんにちは"); err == nil {
+			t.Errorf("SendDirect(%q) = nil", name)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	host, guest := enter(t, s)
+	if err := host.Send("入室前のメッセージ"); err != nil {
+		t.Fatal(err)
+	}
+	// サーバーが受け付けるまで待つ
+	next(t, guest, client.EventMessage)
+
+	late := s.Dial(t)
+	if err := late.JoinRoom("lobby", "jiro", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, late, client.EventHistory); event.Sender != "taro" || event.Text != "入室前のメッセージ" {
+		t.Errorf("history = %+v", event)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	_, guest := enter(t, s)
+	if err := guest.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if guest.Token() != "" || guest.RoomName() != "" {
+		t.Errorf("token %q, room %q after leaving", guest.Token(), guest.RoomName())
+	}
+	if err := guest.Send("まだいますか"); !errors.Is(err, client.ErrNotInRoom) {
+		t.Errorf("Send after leaving = %v", err)
+	}
+	// 退出した後で別のルームに入れる
+	if err := guest.CreateRoom("other", "hanako", "", client.RoomOptions{}); err != nil {
+		t.Errorf("CreateRoom after leaving = %v", err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	host := s.Dial(t)
+	if err := host.CreateRoom("lobby", "taro", "", client.RoomOptions{InviteOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	invite, err := host.CreateInvite(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.Code == "" || invite.CreatedBy != "taro" || !invite.SingleUse || !invite.ExpiresAt.IsZero() {
+		t.Errorf("invite = %+v", invite)
+	}
+	if invites, err := host.Invites(); err != nil || len(invites) != 1 || invites[0].Code != invite.Code {
+		t.Errorf("Invites = %+v, %v", invites, err)
+	}
+
+	guest := s.Dial(t)
+	wantStatus := func(err error, status uint8) {
+		t.Helper()
+		var statusErr *client.StatusError
+		if !errors.As(err, &statusErr) || statusErr.Status != status {
+			t.Errorf("err = %v, want status %d", err, status)
+		}
+	}
+	wantStatus(guest.JoinRoom("lobby", "hanako", "", ""), protocol.StatusInviteRequired)
+	if err := guest.JoinRoom("lobby", "hanako", "", invite.Code); err != nil {
+		t.Fatal(err)
+	}
+	// ホストでなければ発行できない
+	wantStatus(func() error { _, err := guest.CreateInvite(time.Hour, false); return err }(), protocol.StatusPermissionDenied)
+
+	// 取り消したコードは一覧に残らない
+	other, err := host.CreateInvite(time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := host.RevokeInvite(other.Code); err != nil {
+		t.Fatal(err)
+	}
+	if invites, err := host.Invites(); err != nil || len(invites) != 0 {
+		t.Errorf("Invites after revoking = %+v, %v", invites, err)
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	accounts := auth.NewAccounts(auth.NewMemoryAccountStore())
+	accounts.SetPolicy(1000, 8, time.Hour)
+	s := chattest.NewServer(t, chattest.Options{Accounts: accounts})
+
+	c := s.Dial(t)
+	if err := c.Register("taro", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Account() != "taro" {
+		t.Errorf("Account = %q", c.Account())
+	}
+	// ログインしている場合は指定した名前ではなくアカウントの名前で入室する
+	if err := c.CreateRoom("lobby", "someone", "", client.RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.UserName() != "taro" {
+		t.Errorf("UserName = %q, want the account name", c.UserName())
+	}
+
+	other := s.Dial(t)
+	if err := other.Login("taro", "wrong password"); err == nil {
+		t.Error("Login with a wrong password succeeded")
+	}
+	if other.Account() != "" {
+		t.Errorf("Account = %q after a failed login", other.Account())
+	}
+	if err := other.Login("taro", "correct horse"); err != nil || other.Account() != "taro" {
+		t.Errorf("Login = %v, account %q", err, other.Account())
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := chattest.NewServer(t, chattest.Options{}).Dial(t)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-c.Messages():
+		if ok {
+			t.Error("received an event after Close")
+		}
+	case <-time.After(receiveTimeout):
+		t.Error("Messages was not closed")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close twice = %v", err)
+	}
+}
+
+func TestServerUnavailable(t *testing.T) {
+	s := chattest.NewServer(t, chattest.Options{})
+	c := s.Dial(t)
+	s.Close()
+	if err := c.CreateRoom("lobby", "taro", "", client.RoomOptions{}); err == nil {
+		t.Error("CreateRoom with the server stopped succeeded")
+	}
+}
@@ -0,0 +1,161 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Action はサーバーコマンドを解析した結果です。
+type Action interface {
+	// Spec はコマンドの定義を返します。
+	Spec() Spec
+}
+
+// Who はルームのメンバーを表示します。
+type Who struct{}
+
+// DirectMessage は指定したユーザーだけにメッセージを送信します。
+type DirectMessage struct {
+	To   string
+	Text string
+}
+
+// Me は自分の動作をルームに送信します。
+type Me struct {
+	Text string
+}
+
+// Nick は自分の名前を変更します。
+type Nick struct {
+	Name string
+}
+
+// Info はルームの付加情報を表示します。
+type Info struct{}
+
+// Topic はルームのトピックを表示します。Textが空でない場合はトピックを変更します。
+type Topic struct {
+	Text string
+}
+
+// Description はルームの説明を表示します。Textが空でない場合は説明を変更します。
+type Description struct {
+	Text string
+}
+
+// Limit はルームのメンバー数の上限を表示します。Setがtrueの場合は上限をMaxに変更します（0は解除）。
+type Limit struct {
+	Max int
+	Set bool
+}
+
+// Kick はユーザーをルームから退出させます。
+type Kick struct {
+	User string
+}
+
+func (Who) Spec() Spec           { return mustLookup("who") }
+func (DirectMessage) Spec() Spec { return mustLookup("msg") }
+func (Me) Spec() Spec            { return mustLookup("me") }
+func (Nick) Spec() Spec          { return mustLookup("nick") }
+func (Info) Spec() Spec          { return mustLookup("info") }
+func (Topic) Spec() Spec         { return mustLookup("topic") }
+func (Description) Spec() Spec   { return mustLookup("desc") }
+func (Limit) Spec() Spec         { return mustLookup("limit") }
+func (Kick) Spec() Spec          { return mustLookup("kick") }
+
+// RequiresHost はアクションの実行にホストの権限が必要かどうかを返します。
+// /topic・/desc・/limit は変更する場合だけ必要です。
+func RequiresHost(action Action) bool {
+	switch a := action.(type) {
+	case Topic:
+		return a.Text != ""
+	case Description:
+		return a.Text != ""
+	case Limit:
+		return a.Set
+	}
+	return action.Spec().HostOnly
+}
+
+// Parse はサーバーコマンドの入力をActionに変換します。
+// コマンドでない入力の場合はokがfalseになります。クライアントコマンドや定義されていないコマンドの場合は
+// ErrUnknownCommand、引数が正しくない場合は *UsageError を返します。
+func Parse(line string) (action Action, ok bool, err error) {
+	name, args, ok := Split(line)
+	if !ok {
+		return nil, false, nil
+	}
+	spec, found := Lookup(name)
+	if !found || spec.Scope != ScopeServer {
+		return nil, true, fmt.Errorf("%w: /%s", ErrUnknownCommand, name)
+	}
+
+	usage := &UsageError{Spec: spec}
+	switch name {
+	case "who":
+		if args != "" {
+			return nil, true, usage
+		}
+		return Who{}, true, nil
+	case "msg":
+		to, text, _ := cut(args)
+		if to == "" || text == "" {
+			return nil, true, usage
+		}
+		return DirectMessage{To: to, Text: text}, true, nil
+	case "me":
+		if args == "" {
+			return nil, true, usage
+		}
+		return Me{Text: args}, true, nil
+	case "nick":
+		if args == "" {
+			return nil, true, usage
+		}
+		if err := ValidateName(args); err != nil {
+			return nil, true, err
+		}
+		return Nick{Name: args}, true, nil
+	case "info":
+		if args != "" {
+			return nil, true, usage
+		}
+		return Info{}, true, nil
+	case "topic":
+		return Topic{Text: args}, true, nil
+	case "desc":
+		return Description{Text: args}, true, nil
+	case "limit":
+		if args == "" {
+			return Limit{}, true, nil
+		}
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 0 {
+			return nil, true, usage
+		}
+		return Limit{Max: n, Set: true}, true, nil
+	case "kick":
+		user, rest, _ := cut(args)
+		if user == "" || rest != "" {
+			return nil, true, usage
+		}
+		return Kick{User: user}, true, nil
+	}
+	return nil, true, fmt.Errorf("%w: /%s", ErrUnknownCommand, name)
+}
+
+// cut は引数を最初の単語と残りに分けます。
+func cut(args string) (first, rest string, found bool) {
+	first, rest, found = strings.Cut(args, " ")
+	return first, strings.TrimSpace(rest), found
+}
+
+func mustLookup(name string) Spec {
+	spec, ok := Lookup(name)
+	if !ok {
+		panic("command: unknown command " + name)
+	}
+	return spec
+}
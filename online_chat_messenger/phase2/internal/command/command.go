@@ -0,0 +1,111 @@
+// Package command はクライアントとサーバーで共有するスラッシュコマンドの定義と解析を提供します。
+//
+// "/" で始まる入力のうち、クライアントコマンドはクライアントが手元で処理し、
+// サーバーコマンドはチャットメッセージとしてUDPで送られ、サーバーがParseで型付きのActionに変換して実行します。
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prefix はコマンドの接頭辞です。
+const Prefix = "/"
+
+// Scope はコマンドを処理する場所です。
+type Scope int
+
+const (
+	ScopeClient Scope = iota // クライアントが手元で処理する
+	ScopeServer              // サーバーが処理する
+)
+
+// Spec はコマンドの定義です。
+type Spec struct {
+	Name     string
+	Usage    string
+	Help     string
+	Scope    Scope
+	HostOnly bool // ルームのホストだけが実行できる（/topic・/desc・/limit は変更する場合だけ）
+}
+
+// Specs はすべてのコマンドをヘルプに表示する順に並べたものです。
+var Specs = []Spec{
+	{Name: "help", Usage: "/help", Help: "コマンドの一覧を表示します", Scope: ScopeClient},
+	{Name: "clear", Usage: "/clear", Help: "画面を消去します", Scope: ScopeClient},
+	{Name: "search", Usage: "/search <検索語>", Help: "ルームの履歴を検索します", Scope: ScopeClient},
+	{Name: "export", Usage: "/export [形式] [開始時刻] [終了時刻]", Help: "会話記録をファイルに書き出します", Scope: ScopeClient},
+	{Name: "invite", Usage: "/invite [有効期間]", Help: "招待コードを発行します（ホストのみ。有効期間を省略すると1回だけ使えるコード）", Scope: ScopeClient, HostOnly: true},
+	{Name: "invites", Usage: "/invites", Help: "有効な招待コードを表示します（ホストのみ）", Scope: ScopeClient, HostOnly: true},
+	{Name: "revoke", Usage: "/revoke <招待コード>", Help: "招待コードを取り消します（ホストのみ）", Scope: ScopeClient, HostOnly: true},
+	{Name: "exit", Usage: "/exit", Help: "ルームから退出して終了します", Scope: ScopeClient},
+	{Name: "who", Usage: "/who", Help: "ルームのメンバーを表示します", Scope: ScopeServer},
+	{Name: "msg", Usage: "/msg <ユーザー名> <メッセージ>", Help: "ユーザーだけにメッセージを送信します", Scope: ScopeServer},
+	{Name: "me", Usage: "/me <動作>", Help: "自分の動作をルームに送信します", Scope: ScopeServer},
+	{Name: "nick", Usage: "/nick <新しい名前>", Help: "自分の名前を変更します", Scope: ScopeServer},
+	{Name: "info", Usage: "/info", Help: "ルームのトピック・説明・作成者などを表示します", Scope: ScopeServer},
+	{Name: "topic", Usage: "/topic [トピック]", Help: "ルームのトピックを表示します（変更はホストのみ）", Scope: ScopeServer, HostOnly: true},
+	{Name: "desc", Usage: "/desc [説明]", Help: "ルームの説明を表示します（変更はホストのみ）", Scope: ScopeServer, HostOnly: true},
+	{Name: "limit", Usage: "/limit [人数]", Help: "ルームのメンバー数の上限を表示します（変更はホストのみ。0で解除）", Scope: ScopeServer, HostOnly: true},
+	{Name: "kick", Usage: "/kick <ユーザー名>", Help: "ユーザーをルームから退出させます（ホストのみ）", Scope: ScopeServer, HostOnly: true},
+}
+
+// Lookup は名前からコマンドの定義を返します。
+func Lookup(name string) (Spec, bool) {
+	for _, spec := range Specs {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return Spec{}, false
+}
+
+// Split は入力をコマンド名と引数に分けます。"/" で始まらない入力の場合、okはfalseになります。
+// "//" で始まる入力は "/" で始まるチャットメッセージとして扱うため、okはfalseになります。
+func Split(line string) (name, args string, ok bool) {
+	rest, ok := strings.CutPrefix(line, Prefix)
+	if !ok || rest == "" || strings.HasPrefix(rest, Prefix) {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(rest, " ")
+	return name, strings.TrimSpace(args), true
+}
+
+// Unescape は "//" で始まるメッセージの先頭の "/" を1つ取り除きます。
+func Unescape(line string) string {
+	if strings.HasPrefix(line, Prefix+Prefix) {
+		return line[len(Prefix):]
+	}
+	return line
+}
+
+// MaxNameLength は名前に使える最大の文字数です。
+const MaxNameLength = 32
+
+var (
+	// ErrUnknownCommand は定義されていないコマンドであることを表します。
+	ErrUnknownCommand = errors.New("不明なコマンドです")
+	// ErrPermissionDenied はルームのホストだけが実行できるコマンドであることを表します。
+	ErrPermissionDenied = errors.New("このコマンドはルームのホストだけが実行できます")
+	// ErrInvalidName は名前に使えない文字や長さであることを表します。
+	ErrInvalidName = fmt.Errorf("名前は空白を含まない%d文字以内で指定してください", MaxNameLength)
+)
+
+// UsageError はコマンドの引数が正しくないことを表します。
+type UsageError struct {
+	Spec Spec
+}
+
+func (e *UsageError) Error() string {
+	return "使用法: " + e.Spec.Usage
+}
+
+// ValidateName はユーザー名として使えるかを確かめます。
+func ValidateName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxNameLength || strings.ContainsAny(name, " \t\r\n") {
+		return ErrInvalidName
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want Action
+	}{
+		{"/who", Who{}},
+		{"/msg taro こんにちは", DirectMessage{To: "taro", Text: "こんにちは"}},
+		{"/msg taro hello world", DirectMessage{To: "taro", Text: "hello world"}},
+		{"/me 手を振る", Me{Text: "手を振る"}},
+		{"/nick hanako", Nick{Name: "hanako"}},
+		{"/info", Info{}},
+		{"/topic", Topic{}},
+		{"/topic 今日の議題", Topic{Text: "今日の議題"}},
+		{"/desc", Description{}},
+		{"/desc 雑談用のルーム", Description{Text: "雑談用のルーム"}},
+		{"/limit", Limit{}},
+		{"/limit 10", Limit{Max: 10, Set: true}},
+		{"/limit 0", Limit{Max: 0, Set: true}},
+		{"/kick jiro", Kick{User: "jiro"}},
+		{"/who  ", Who{}},
+		{"/me   spaced  ", Me{Text: "spaced"}},
+	} {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok, err := Parse(tt.line)
+			if !ok || err != nil {
+				t.Fatalf("Parse(%q) = _, %v, %v", tt.line, ok, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNotCommand(t *testing.T) {
+	for _, line := range []string{"", "hello", "/", "//who", "// escaped", " /who"} {
+		if action, ok, err := Parse(line); ok || action != nil || err != nil {
+			t.Errorf("Parse(%q) = %v, %v, %v, want not a command", line, action, ok, err)
+		}
+	}
+}
+
+func TestParseUnknownCommand(t *testing.T) {
+	// クライアントコマンドはサーバーでは実行できない
+	for _, line := range []string{"/unknown", "/help", "/exit", "/search foo", "/WHO"} {
+		_, ok, err := Parse(line)
+		if !ok || !errors.Is(err, ErrUnknownCommand) {
+			t.Errorf("Parse(%q) = _, %v, %v, want ErrUnknownCommand", line, ok, err)
+		}
+	}
+}
+
+func TestParseUsageError(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		spec string
+	}{
+		{"/who everyone", "who"},
+		{"/msg", "msg"},
+		{"/msg taro", "msg"},
+		{"/me", "me"},
+		{"/nick", "nick"},
+		{"/info room", "info"},
+		{"/limit -1", "limit"},
+		{"/limit many", "limit"},
+		{"/kick", "kick"},
+		{"/kick jiro saburo", "kick"},
+	} {
+		t.Run(tt.line, func(t *testing.T) {
+			_, ok, err := Parse(tt.line)
+			var usage *UsageError
+			if !ok || !errors.As(err, &usage) {
+				t.Fatalf("Parse(%q) = _, %v, %v, want *UsageError", tt.line, ok, err)
+			}
+			if usage.Spec.Name != tt.spec || !strings.HasPrefix(err.Error(), "使用法: /"+tt.spec) {
+				t.Errorf("Parse(%q) error = %q", tt.line, err)
+			}
+		})
+	}
+}
+
+func TestParseInvalidNick(t *testing.T) {
+	for _, line := range []string{"/nick " + strings.Repeat("あ", MaxNameLength+1), "/nick two words"} {
+		if _, ok, err := Parse(line); !ok || !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Parse(%q) = _, %v, %v, want ErrInvalidName", line, ok, err)
+		}
+	}
+	if _, _, err := Parse("/nick " + strings.Repeat("あ", MaxNameLength)); err != nil {
+		t.Errorf("Parse(%d characters) = %v", MaxNameLength, err)
+	}
+}
+
+func TestRequiresHost(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want bool
+	}{
+		{"/who", false},
+		{"/msg taro hi", false},
+		{"/nick hanako", false},
+		{"/topic", false},
+		{"/topic new", true},
+		{"/desc", false},
+		{"/desc new", true},
+		{"/limit", false},
+		{"/limit 5", true},
+		{"/kick jiro", true},
+	} {
+		action, _, err := Parse(tt.line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.line, err)
+		}
+		if got := RequiresHost(action); got != tt.want {
+			t.Errorf("RequiresHost(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for line, want := range map[string]string{
+		"//who":  "/who",
+		"///":    "//",
+		"/who":   "/who",
+		"hello":  "hello",
+		"":       "",
+		"// hi/": "/ hi/",
+	} {
+		if got := Unescape(line); got != want {
+			t.Errorf("Unescape(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestSpecsAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, spec := range Specs {
+		if seen[spec.Name] {
+			t.Errorf("duplicate command %q", spec.Name)
+		}
+		seen[spec.Name] = true
+		if !strings.HasPrefix(spec.Usage, Prefix+spec.Name) {
+			t.Errorf("%s: usage %q does not start with the command", spec.Name, spec.Usage)
+		}
+	}
+}
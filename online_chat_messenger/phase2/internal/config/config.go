@@ -0,0 +1,561 @@
+// Package config はサーバーの設定の読み込みと検証を行います。
+// 設定はデフォルト値 < 設定ファイル < 環境変数 < コマンドライン引数 の順に上書きされます。
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/logging"
+)
+
+// Config はサーバーの設定を表します。
+// reload:"restart" タグの付いた項目は再起動しないと反映できません。
+type Config struct {
+	TCP        ListenerConfig   `json:"tcp"`
+	UDP        ListenerConfig   `json:"udp"`
+	Timeouts   TimeoutConfig    `json:"timeouts"`
+	Limits     LimitConfig      `json:"limits"`
+	Chat       ChatConfig       `json:"chat"`
+	Log        LogConfig        `json:"log"`
+	Metrics    MetricsConfig    `json:"metrics"`
+	Admin      AdminConfig      `json:"admin"`
+	Store      StoreConfig      `json:"store"`
+	MessageLog MessageLogConfig `json:"message_log"`
+	Accounts   AccountConfig    `json:"accounts"`
+	Tokens     TokenConfig      `json:"tokens"`
+}
+
+// ListenerConfig は待ち受けるアドレスの設定です。
+type ListenerConfig struct {
+	BindAddress string `json:"bind_address" reload:"restart"` // 空の場合はすべてのアドレスで待ち受ける
+	Port        int    `json:"port" reload:"restart"`
+}
+
+// Addr は net.Listen に渡すアドレスを返します。
+func (c ListenerConfig) Addr() string {
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+}
+
+// TimeoutConfig はタイムアウトに関する設定です。
+type TimeoutConfig struct {
+	InactiveTimeout Duration `json:"inactive_timeout"` // 非アクティブなユーザーを削除するまでの時間
+	CleanupInterval Duration `json:"cleanup_interval"` // 非アクティブなユーザーを削除する処理の実行間隔
+	TCPReadTimeout  Duration `json:"tcp_read_timeout"` // TCPリクエストの受信を待つ時間
+	EmptyRoomTTL    Duration `json:"empty_room_ttl"`   // 空になったルームを削除するまでの時間（0は削除しない）
+}
+
+// LimitConfig はサイズやルーム数の上限に関する設定です。0は無制限を表します。
+type LimitConfig struct {
+	MaxTCPMessageSize  int `json:"max_tcp_message_size"`  // TCRPメッセージの最大サイズ（バイト）
+	MaxUDPPacketSize   int `json:"max_udp_packet_size"`   // UDPパケットの最大サイズ（バイト）
+	MaxRooms           int `json:"max_rooms"`             // サーバー全体のルーム数の上限
+	MaxRoomsPerAddress int `json:"max_rooms_per_address"` // 同じIPアドレスから作成できるルーム数の上限
+	MaxRoomMembers     int `json:"max_room_members"`      // 1ルームあたりのメンバー数の上限
+}
+
+// ChatConfig はチャットの運用ポリシーに関する設定です。
+type ChatConfig struct {
+	MOTD        string          `json:"motd"`         // 入室時に表示するメッセージ
+	BannedWords []string        `json:"banned_words"` // 伏せ字にする禁止語
+	RateLimit   RateLimitConfig `json:"rate_limit"`
+	History     HistoryConfig   `json:"history"`
+	Search      SearchConfig    `json:"search"`
+}
+
+// HistoryConfig はルームごとに保持するメッセージの履歴の設定です。
+type HistoryConfig struct {
+	MaxMessages int      `json:"max_messages"` // ルームごとに保持する件数（0は保持しない）
+	MaxAge      Duration `json:"max_age"`      // 保持する期間（0は無制限）
+	Replay      int      `json:"replay"`       // 入室したユーザーに送信する件数
+}
+
+// SearchConfig はメッセージの全文検索の設定です。
+type SearchConfig struct {
+	MaxDocuments int `json:"max_documents"` // 検索インデックスに保持する件数（古いものから削除する）
+}
+
+// RateLimitConfig はユーザーごとのメッセージ送信頻度の上限です。
+type RateLimitConfig struct {
+	MessagesPerSecond float64 `json:"messages_per_second"` // 1秒あたりの送信数（0は無制限）
+	Burst             int     `json:"burst"`               // 連続して送信できる数
+}
+
+// LogConfig はログ出力の設定です。
+type LogConfig struct {
+	Level  string `json:"level"`                   // "debug"、"info"、"warn"、"error"
+	Format string `json:"format" reload:"restart"` // "text" または "json"
+}
+
+// MetricsConfig はPrometheus形式のメトリクスを公開するHTTPサーバーの設定です。
+type MetricsConfig struct {
+	Address string `json:"address" reload:"restart"` // 例: "127.0.0.1:9090"（空の場合は無効）
+}
+
+// AdminConfig は管理用HTTP APIとRPCの設定です。
+type AdminConfig struct {
+	Address   string `json:"address" reload:"restart"`    // 例: "127.0.0.1:9091"（空の場合は無効）
+	Token     string `json:"token" secret:"true"`         // Authorizationヘッダーで送るBearerトークン
+	RPCSocket string `json:"rpc_socket" reload:"restart"` // 管理RPCのUnixドメインソケットのパス（空の場合は無効）
+	Console   bool   `json:"console" reload:"restart"`    // 標準入力から管理コマンドを受け付ける
+}
+
+// StoreConfig はルームとセッションを保存する設定です。
+type StoreConfig struct {
+	Dir              string   `json:"dir" reload:"restart"`               // 保存先のディレクトリ（空の場合は保存しない）
+	SnapshotInterval Duration `json:"snapshot_interval" reload:"restart"` // スナップショットを作成する間隔
+}
+
+// MessageLogConfig はメッセージをルームごとにディスクへ記録するログの設定です。
+type MessageLogConfig struct {
+	Dir             string                     `json:"dir" reload:"restart"`              // 記録先のディレクトリ（空の場合は記録しない）
+	SegmentSize     int64                      `json:"segment_size" reload:"restart"`     // セグメントを切り替えるサイズ（バイト）
+	CompactInterval Duration                   `json:"compact_interval" reload:"restart"` // 保持ポリシーの適用とセグメントの統合の間隔
+	MaxAge          Duration                   `json:"max_age"`                           // メッセージを保持する期間（0は無制限）
+	MaxBytes        int64                      `json:"max_bytes"`                         // ルームごとのログの合計サイズの上限（0は無制限）
+	Rooms           map[string]RetentionConfig `json:"rooms"`                             // ルームごとに上書きする保持ポリシー
+}
+
+// AccountConfig は登録ユーザーのアカウントの設定です。
+type AccountConfig struct {
+	File              string   `json:"file" reload:"restart"` // アカウントを保存するファイル（空の場合はメモリ上に保持し、再起動で消える）
+	ReservedNames     []string `json:"reserved_names"`        // ゲストが使えず、登録もできない名前
+	LoginTTL          Duration `json:"login_ttl"`             // ログイントークンの有効期間
+	PBKDF2Iterations  int      `json:"pbkdf2_iterations"`     // パスワードのハッシュの反復回数（以降に登録するパスワードに適用）
+	MinPasswordLength int      `json:"min_password_length"`   // パスワードの最小文字数
+}
+
+// TokenConfig はルームのトークンの署名の設定です。
+type TokenConfig struct {
+	Keys []TokenKeyConfig `json:"keys" secret:"true"` // 署名に使う鍵。先頭の鍵で署名し、残りは検証にだけ使う（空の場合はランダムに生成する）
+	TTL  Duration         `json:"ttl"`                // 発行するトークンの有効期間
+}
+
+// TokenKeyConfig はトークンの署名に使う鍵です。
+type TokenKeyConfig struct {
+	ID     string `json:"id"`     // トークンに含める識別子（英数字・"_"・"-" の8文字以内）
+	Secret string `json:"secret"` // Base64でエンコードした32バイト以上の値
+}
+
+// TokenKey はBase64でエンコードされた鍵を読み取り、検証してTokenSignerに渡す形にします。
+func (k TokenKeyConfig) TokenKey() (auth.TokenKey, error) {
+	secret, err := base64.StdEncoding.DecodeString(k.Secret)
+	if err != nil {
+		return auth.TokenKey{}, fmt.Errorf("%w: %s: 鍵がBase64の形式ではありません", auth.ErrInvalidTokenKey, k.ID)
+	}
+	key := auth.TokenKey{ID: k.ID, Secret: secret}
+	if err := auth.ValidateTokenKey(key); err != nil {
+		return auth.TokenKey{}, err
+	}
+	return key, nil
+}
+
+// RetentionConfig はルームのログの保持ポリシーです。0は無制限を表します。
+type RetentionConfig struct {
+	MaxAge   Duration `json:"max_age"`
+	MaxBytes int64    `json:"max_bytes"`
+}
+
+// Default はデフォルトの設定を返します。
+func Default() Config {
+	return Config{
+		TCP: ListenerConfig{Port: 8088},
+		UDP: ListenerConfig{Port: 8089},
+		Timeouts: TimeoutConfig{
+			InactiveTimeout: Duration(5 * time.Minute),
+			CleanupInterval: Duration(1 * time.Minute),
+			TCPReadTimeout:  Duration(30 * time.Second),
+			EmptyRoomTTL:    Duration(10 * time.Minute),
+		},
+		Limits: LimitConfig{
+			MaxTCPMessageSize: 4096,
+			MaxUDPPacketSize:  4096,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Chat: ChatConfig{
+			History: HistoryConfig{MaxMessages: 100, Replay: 20},
+			Search:  SearchConfig{MaxDocuments: 100000},
+		},
+		Store: StoreConfig{
+			SnapshotInterval: Duration(1 * time.Minute),
+		},
+		MessageLog: MessageLogConfig{
+			SegmentSize:     1 << 20,
+			CompactInterval: Duration(10 * time.Minute),
+		},
+		Accounts: AccountConfig{
+			ReservedNames:     []string{"admin", "server"},
+			LoginTTL:          Duration(24 * time.Hour),
+			PBKDF2Iterations:  600000,
+			MinPasswordLength: 8,
+		},
+		Tokens: TokenConfig{
+			TTL: Duration(24 * time.Hour),
+		},
+	}
+}
+
+// LoadFile は設定ファイル(JSON)を読み込み、cfgを上書きします。
+// ファイルに含まれない項目はcfgの値がそのまま使われます。
+func LoadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("設定ファイルの読み込みに失敗しました: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("設定ファイルの解析に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// 環境変数名です。
+const (
+	EnvTCPPort           = "CHAT_TCP_PORT"
+	EnvTCPBindAddress    = "CHAT_TCP_BIND_ADDRESS"
+	EnvUDPPort           = "CHAT_UDP_PORT"
+	EnvUDPBindAddress    = "CHAT_UDP_BIND_ADDRESS"
+	EnvInactiveTimeout   = "CHAT_INACTIVE_TIMEOUT"
+	EnvCleanupInterval   = "CHAT_CLEANUP_INTERVAL"
+	EnvTCPReadTimeout    = "CHAT_TCP_READ_TIMEOUT"
+	EnvEmptyRoomTTL      = "CHAT_EMPTY_ROOM_TTL"
+	EnvMaxTCPMessageSize = "CHAT_MAX_TCP_MESSAGE_SIZE"
+	EnvMaxUDPPacketSize  = "CHAT_MAX_UDP_PACKET_SIZE"
+	EnvMaxRooms          = "CHAT_MAX_ROOMS"
+	EnvMaxRoomsPerAddr   = "CHAT_MAX_ROOMS_PER_ADDRESS"
+	EnvMaxRoomMembers    = "CHAT_MAX_ROOM_MEMBERS"
+	EnvLogLevel          = "CHAT_LOG_LEVEL"
+	EnvLogFormat         = "CHAT_LOG_FORMAT"
+	EnvMetricsAddress    = "CHAT_METRICS_ADDRESS"
+	EnvAdminAddress      = "CHAT_ADMIN_ADDRESS"
+	EnvAdminToken        = "CHAT_ADMIN_TOKEN"
+	EnvAdminRPCSocket    = "CHAT_ADMIN_RPC_SOCKET"
+	EnvAdminConsole      = "CHAT_ADMIN_CONSOLE"
+	EnvHistoryMessages   = "CHAT_HISTORY_MAX_MESSAGES"
+	EnvHistoryMaxAge     = "CHAT_HISTORY_MAX_AGE"
+	EnvHistoryReplay     = "CHAT_HISTORY_REPLAY"
+	EnvSearchDocuments   = "CHAT_SEARCH_MAX_DOCUMENTS"
+	EnvStoreDir          = "CHAT_STORE_DIR"
+	EnvMessageLogDir     = "CHAT_MESSAGE_LOG_DIR"
+	EnvMessageLogMaxAge  = "CHAT_MESSAGE_LOG_MAX_AGE"
+	EnvMessageLogMaxSize = "CHAT_MESSAGE_LOG_MAX_BYTES"
+	EnvStoreSnapshot     = "CHAT_STORE_SNAPSHOT_INTERVAL"
+	EnvAccountsFile      = "CHAT_ACCOUNTS_FILE"
+	EnvAccountsLoginTTL  = "CHAT_ACCOUNTS_LOGIN_TTL"
+	EnvTokenKeys         = "CHAT_TOKEN_KEYS" // "<識別子>:<鍵>" をカンマで区切って並べる
+	EnvTokenTTL          = "CHAT_TOKEN_TTL"
+)
+
+// ApplyEnv は環境変数で設定を上書きします。lookupには通常 os.LookupEnv を渡します。
+func ApplyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+
+	setString := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: 整数ではありません: %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	setInt64 := func(name string, dst *int64) {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: 整数ではありません: %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: 真偽値ではありません: %q", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	setDuration := func(name string, dst *Duration) {
+		if v, ok := lookup(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: 時間の形式が不正です: %q", name, v))
+				return
+			}
+			*dst = Duration(d)
+		}
+	}
+
+	setInt(EnvTCPPort, &cfg.TCP.Port)
+	setString(EnvTCPBindAddress, &cfg.TCP.BindAddress)
+	setInt(EnvUDPPort, &cfg.UDP.Port)
+	setString(EnvUDPBindAddress, &cfg.UDP.BindAddress)
+	setDuration(EnvInactiveTimeout, &cfg.Timeouts.InactiveTimeout)
+	setDuration(EnvCleanupInterval, &cfg.Timeouts.CleanupInterval)
+	setDuration(EnvTCPReadTimeout, &cfg.Timeouts.TCPReadTimeout)
+	setDuration(EnvEmptyRoomTTL, &cfg.Timeouts.EmptyRoomTTL)
+	setInt(EnvMaxTCPMessageSize, &cfg.Limits.MaxTCPMessageSize)
+	setInt(EnvMaxUDPPacketSize, &cfg.Limits.MaxUDPPacketSize)
+	setInt(EnvMaxRooms, &cfg.Limits.MaxRooms)
+	setInt(EnvMaxRoomsPerAddr, &cfg.Limits.MaxRoomsPerAddress)
+	setInt(EnvMaxRoomMembers, &cfg.Limits.MaxRoomMembers)
+	setString(EnvLogLevel, &cfg.Log.Level)
+	setString(EnvLogFormat, &cfg.Log.Format)
+	setString(EnvMetricsAddress, &cfg.Metrics.Address)
+	setString(EnvAdminAddress, &cfg.Admin.Address)
+	setString(EnvAdminToken, &cfg.Admin.Token)
+	setString(EnvAdminRPCSocket, &cfg.Admin.RPCSocket)
+	setBool(EnvAdminConsole, &cfg.Admin.Console)
+	setInt(EnvHistoryMessages, &cfg.Chat.History.MaxMessages)
+	setDuration(EnvHistoryMaxAge, &cfg.Chat.History.MaxAge)
+	setInt(EnvHistoryReplay, &cfg.Chat.History.Replay)
+	setInt(EnvSearchDocuments, &cfg.Chat.Search.MaxDocuments)
+	setString(EnvStoreDir, &cfg.Store.Dir)
+	setDuration(EnvStoreSnapshot, &cfg.Store.SnapshotInterval)
+	setString(EnvMessageLogDir, &cfg.MessageLog.Dir)
+	setDuration(EnvMessageLogMaxAge, &cfg.MessageLog.MaxAge)
+	setInt64(EnvMessageLogMaxSize, &cfg.MessageLog.MaxBytes)
+	setString(EnvAccountsFile, &cfg.Accounts.File)
+	setDuration(EnvAccountsLoginTTL, &cfg.Accounts.LoginTTL)
+	if v, ok := lookup(EnvTokenKeys); ok {
+		var keys []TokenKeyConfig
+		for _, item := range strings.Split(v, ",") {
+			id, secret, found := strings.Cut(strings.TrimSpace(item), ":")
+			if !found {
+				// 鍵が含まれるため値は出力しない
+				errs = append(errs, fmt.Errorf("%s: \"<識別子>:<鍵>\" の形式ではない項目があります", EnvTokenKeys))
+				keys = nil
+				break
+			}
+			keys = append(keys, TokenKeyConfig{ID: id, Secret: secret})
+		}
+		if keys != nil {
+			cfg.Tokens.Keys = keys
+		}
+	}
+	setDuration(EnvTokenTTL, &cfg.Tokens.TTL)
+
+	return errors.Join(errs...)
+}
+
+// UDPパケットとして送受信できる最大のペイロードサイズです。
+const maxUDPPayloadSize = 65507
+
+// Validate は設定値を検証し、すべての問題をまとめたエラーを返します。
+func (c Config) Validate() error {
+	var errs []error
+
+	errs = append(errs, c.TCP.validate("tcp"), c.UDP.validate("udp"))
+
+	if c.Timeouts.InactiveTimeout <= 0 {
+		errs = append(errs, errors.New("timeouts.inactive_timeout は正の値である必要があります"))
+	}
+	if c.Timeouts.CleanupInterval <= 0 {
+		errs = append(errs, errors.New("timeouts.cleanup_interval は正の値である必要があります"))
+	} else if c.Timeouts.CleanupInterval > c.Timeouts.InactiveTimeout {
+		errs = append(errs, errors.New("timeouts.cleanup_interval は timeouts.inactive_timeout 以下である必要があります"))
+	}
+	if c.Timeouts.TCPReadTimeout <= 0 {
+		errs = append(errs, errors.New("timeouts.tcp_read_timeout は正の値である必要があります"))
+	}
+	if c.Timeouts.EmptyRoomTTL < 0 {
+		errs = append(errs, errors.New("timeouts.empty_room_ttl は0以上である必要があります"))
+	}
+
+	// ヘッダーに加えてある程度のボディを格納できるサイズを下限とする
+	if c.Limits.MaxTCPMessageSize < 64 {
+		errs = append(errs, fmt.Errorf("limits.max_tcp_message_size は64以上である必要があります: %d", c.Limits.MaxTCPMessageSize))
+	}
+	if c.Limits.MaxUDPPacketSize < 64 || c.Limits.MaxUDPPacketSize > maxUDPPayloadSize {
+		errs = append(errs, fmt.Errorf("limits.max_udp_packet_size は64から%dの範囲である必要があります: %d", maxUDPPayloadSize, c.Limits.MaxUDPPacketSize))
+	}
+	if c.Limits.MaxRooms < 0 {
+		errs = append(errs, fmt.Errorf("limits.max_rooms は0以上である必要があります: %d", c.Limits.MaxRooms))
+	}
+	if c.Limits.MaxRoomsPerAddress < 0 {
+		errs = append(errs, fmt.Errorf("limits.max_rooms_per_address は0以上である必要があります: %d", c.Limits.MaxRoomsPerAddress))
+	}
+	if c.Limits.MaxRoomMembers < 0 {
+		errs = append(errs, fmt.Errorf("limits.max_room_members は0以上である必要があります: %d", c.Limits.MaxRoomMembers))
+	}
+
+	if c.Chat.RateLimit.MessagesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("chat.rate_limit.messages_per_second は0以上である必要があります: %g", c.Chat.RateLimit.MessagesPerSecond))
+	}
+	if c.Chat.RateLimit.MessagesPerSecond > 0 && c.Chat.RateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("chat.rate_limit.burst は1以上である必要があります: %d", c.Chat.RateLimit.Burst))
+	}
+	for i, word := range c.Chat.BannedWords {
+		if strings.TrimSpace(word) == "" {
+			errs = append(errs, fmt.Errorf("chat.banned_words[%d] が空です", i))
+		}
+	}
+
+	if c.Chat.History.MaxMessages < 0 {
+		errs = append(errs, fmt.Errorf("chat.history.max_messages は0以上である必要があります: %d", c.Chat.History.MaxMessages))
+	}
+	if c.Chat.History.MaxAge < 0 {
+		errs = append(errs, errors.New("chat.history.max_age は0以上である必要があります"))
+	}
+	if c.Chat.History.Replay < 0 {
+		errs = append(errs, fmt.Errorf("chat.history.replay は0以上である必要があります: %d", c.Chat.History.Replay))
+	}
+	if c.Chat.Search.MaxDocuments <= 0 {
+		errs = append(errs, fmt.Errorf("chat.search.max_documents は1以上である必要があります: %d", c.Chat.Search.MaxDocuments))
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format は text または json である必要があります: %q", c.Log.Format))
+	}
+
+	if err := validateHTTPAddress(c.Metrics.Address); err != nil {
+		errs = append(errs, fmt.Errorf("metrics.address: %w", err))
+	}
+	if err := validateHTTPAddress(c.Admin.Address); err != nil {
+		errs = append(errs, fmt.Errorf("admin.address: %w", err))
+	}
+	if c.Admin.Address != "" && c.Admin.Token == "" {
+		errs = append(errs, errors.New("admin.token: 管理APIを有効にする場合は必須です"))
+	}
+
+	if c.Store.SnapshotInterval <= 0 {
+		errs = append(errs, errors.New("store.snapshot_interval は正の値である必要があります"))
+	}
+
+	// 1件のメッセージが収まるよう、受信するパケットの最大サイズを下限とする
+	if c.MessageLog.SegmentSize < int64(c.Limits.MaxUDPPacketSize) {
+		errs = append(errs, fmt.Errorf("message_log.segment_size は limits.max_udp_packet_size 以上である必要があります: %d", c.MessageLog.SegmentSize))
+	}
+	if c.MessageLog.CompactInterval <= 0 {
+		errs = append(errs, errors.New("message_log.compact_interval は正の値である必要があります"))
+	}
+	errs = append(errs, RetentionConfig{c.MessageLog.MaxAge, c.MessageLog.MaxBytes}.validate("message_log"))
+	for room, retention := range c.MessageLog.Rooms {
+		errs = append(errs, retention.validate(fmt.Sprintf("message_log.rooms[%q]", room)))
+	}
+
+	for i, name := range c.Accounts.ReservedNames {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("accounts.reserved_names[%d] が空です", i))
+		}
+	}
+	if c.Accounts.LoginTTL <= 0 {
+		errs = append(errs, errors.New("accounts.login_ttl は正の値である必要があります"))
+	}
+	// 推測に耐えるよう、反復回数とパスワードの長さには下限を設ける
+	if c.Accounts.PBKDF2Iterations < 10000 {
+		errs = append(errs, fmt.Errorf("accounts.pbkdf2_iterations は10000以上である必要があります: %d", c.Accounts.PBKDF2Iterations))
+	}
+	if c.Accounts.MinPasswordLength < 1 {
+		errs = append(errs, fmt.Errorf("accounts.min_password_length は1以上である必要があります: %d", c.Accounts.MinPasswordLength))
+	}
+
+	ids := make(map[string]bool, len(c.Tokens.Keys))
+	for i, key := range c.Tokens.Keys {
+		if _, err := key.TokenKey(); err != nil {
+			errs = append(errs, fmt.Errorf("tokens.keys[%d]: %w", i, err))
+		} else if ids[key.ID] {
+			errs = append(errs, fmt.Errorf("tokens.keys[%d].id が重複しています: %q", i, key.ID))
+		}
+		ids[key.ID] = true
+	}
+	if c.Tokens.TTL <= 0 {
+		errs = append(errs, errors.New("tokens.ttl は正の値である必要があります"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateHTTPAddress は "host:port" 形式のアドレスを検証します。空の場合は無効として扱います。
+func validateHTTPAddress(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("host:port の形式である必要があります: %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("ポート番号が不正です: %q", addr)
+	}
+	return nil
+}
+
+// validate は保持ポリシーを検証します。
+func (c RetentionConfig) validate(name string) error {
+	var errs []error
+	if c.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_age は0以上である必要があります", name))
+	}
+	if c.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_bytes は0以上である必要があります: %d", name, c.MaxBytes))
+	}
+	return errors.Join(errs...)
+}
+
+// validate は待ち受けアドレスの設定を検証します。
+func (c ListenerConfig) validate(name string) error {
+	var errs []error
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("%s.port は0から65535の範囲である必要があります: %d", name, c.Port))
+	}
+	if c.BindAddress != "" && c.BindAddress != "localhost" && net.ParseIP(c.BindAddress) == nil {
+		errs = append(errs, fmt.Errorf("%s.bind_address はIPアドレスである必要があります: %q", name, c.BindAddress))
+	}
+	return errors.Join(errs...)
+}
+
+// Duration はJSONで "5m" のような文字列として表現される時間です。
+type Duration time.Duration
+
+// Std は time.Duration に変換した値を返します。
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// String は "5m0s" のような文字列表現を返します。
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON は時間を文字列としてエンコードします。
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON は "5m" のような文字列を時間としてデコードします。
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("時間は \"30s\" のような文字列で指定してください: %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("時間の形式が不正です: %q", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret はトークンの鍵として使えるBase64の値です。
+var testSecret = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string // エラーに含まれる文字列（空の場合はエラーにならない）
+	}{
+		{"ポート番号が範囲外", func(c *Config) { c.TCP.Port = 70000 }, "tcp.port"},
+		{"待ち受けアドレスがIPアドレスでない", func(c *Config) { c.UDP.BindAddress = "example.com" }, "udp.bind_address"},
+		{"localhostは待ち受けアドレスに使える", func(c *Config) { c.TCP.BindAddress = "localhost" }, ""},
+		{"削除間隔が非アクティブ判定より長い", func(c *Config) { c.Timeouts.CleanupInterval = c.Timeouts.InactiveTimeout + 1 }, "timeouts.cleanup_interval"},
+		{"非アクティブ判定が0", func(c *Config) { c.Timeouts.InactiveTimeout = 0 }, "timeouts.inactive_timeout"},
+		{"空のルームの保持時間が負", func(c *Config) { c.Timeouts.EmptyRoomTTL = -1 }, "timeouts.empty_room_ttl"},
+		{"TCRPメッセージが小さすぎる", func(c *Config) { c.Limits.MaxTCPMessageSize = 63 }, "limits.max_tcp_message_size"},
+		{"UDPパケットが大きすぎる", func(c *Config) { c.Limits.MaxUDPPacketSize = 65508 }, "limits.max_udp_packet_size"},
+		{"ルーム数の上限が負", func(c *Config) { c.Limits.MaxRooms = -1 }, "limits.max_rooms"},
+		{"送信頻度があるのにバーストが0", func(c *Config) { c.Chat.RateLimit.MessagesPerSecond = 1 }, "chat.rate_limit.burst"},
+		{"空の禁止語", func(c *Config) { c.Chat.BannedWords = []string{"spam", " "} }, "chat.banned_words[1]"},
+		{"検索の件数が0", func(c *Config) { c.Chat.Search.MaxDocuments = 0 }, "chat.search.max_documents"},
+		{"不明なログレベル", func(c *Config) { c.Log.Level = "verbose" }, "log.level"},
+		{"不明なログ形式", func(c *Config) { c.Log.Format = "xml" }, "log.format"},
+		{"メトリクスのアドレスにポートがない", func(c *Config) { c.Metrics.Address = "127.0.0.1" }, "metrics.address"},
+		{"管理APIにトークンがない", func(c *Config) { c.Admin.Address = "127.0.0.1:9091" }, "admin.token"},
+		{"セグメントがパケットより小さい", func(c *Config) { c.MessageLog.SegmentSize = 100 }, "message_log.segment_size"},
+		{"ルームごとの保持ポリシーが負", func(c *Config) { c.MessageLog.Rooms = map[string]RetentionConfig{"lobby": {MaxBytes: -1}} }, `message_log.rooms["lobby"].max_bytes`},
+		{"反復回数が少ない", func(c *Config) { c.Accounts.PBKDF2Iterations = 1000 }, "accounts.pbkdf2_iterations"},
+		{"鍵がBase64でない", func(c *Config) { c.Tokens.Keys = []TokenKeyConfig{{ID: "k1", Secret: "!"}} }, "tokens.keys[0]"},
+		{"鍵が短い", func(c *Config) {
+			c.Tokens.Keys = []TokenKeyConfig{{ID: "k1", Secret: base64.StdEncoding.EncodeToString([]byte("short"))}}
+		}, "tokens.keys[0]"},
+		{"鍵の識別子が重複", func(c *Config) {
+			c.Tokens.Keys = []TokenKeyConfig{{ID: "k1", Secret: testSecret}, {ID: "k1", Secret: testSecret}}
+		}, "tokens.keys[1].id"},
+		{"正しい鍵", func(c *Config) { c.Tokens.Keys = []TokenKeyConfig{{ID: "k1", Secret: testSecret}} }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.TCP.Port = -1
+	cfg.Log.Format = "xml"
+	cfg.Tokens.TTL = 0
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil")
+	}
+	for _, want := range []string{"tcp.port", "log.format", "tokens.ttl"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+// env はmapを環境変数として引くlookupを返します。
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cfg := Default()
+	err := ApplyEnv(&cfg, env(map[string]string{
+		EnvTCPPort:           "9000",
+		EnvUDPBindAddress:    "127.0.0.1",
+		EnvInactiveTimeout:   "90s",
+		EnvMaxRooms:          "5",
+		EnvLogLevel:          "debug",
+		EnvAdminToken:        "s3cret",
+		EnvAdminConsole:      "true",
+		EnvMessageLogMaxSize: "1048576",
+		EnvTokenKeys:         "k2:" + testSecret + ", k1:" + testSecret,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TCP.Port != 9000 || cfg.UDP.BindAddress != "127.0.0.1" || cfg.Timeouts.InactiveTimeout.Std() != 90*time.Second ||
+		cfg.Limits.MaxRooms != 5 || cfg.Log.Level != "debug" || cfg.Admin.Token != "s3cret" || !cfg.Admin.Console ||
+		cfg.MessageLog.MaxBytes != 1<<20 {
+		t.Errorf("ApplyEnv = %+v", cfg)
+	}
+	if len(cfg.Tokens.Keys) != 2 || cfg.Tokens.Keys[0].ID != "k2" || cfg.Tokens.Keys[1].ID != "k1" {
+		t.Errorf("Tokens.Keys = %+v", cfg.Tokens.Keys)
+	}
+	// 指定されていない項目はそのまま
+	if cfg.UDP.Port != Default().UDP.Port {
+		t.Errorf("UDP.Port = %d, want the default", cfg.UDP.Port)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	cfg := Default()
+	err := ApplyEnv(&cfg, env(map[string]string{
+		EnvTCPPort:         "eighty",
+		EnvInactiveTimeout: "5 minutes",
+		EnvAdminConsole:    "yes please",
+		EnvTokenKeys:       "k1:" + testSecret + ",broken",
+	}))
+	if err == nil {
+		t.Fatal("ApplyEnv = nil")
+	}
+	for _, want := range []string{EnvTCPPort, EnvInactiveTimeout, EnvAdminConsole, EnvTokenKeys} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ApplyEnv = %v, want it to mention %s", err, want)
+		}
+	}
+	// 鍵の値はエラーに含めず、一部だけの鍵も設定しない
+	if strings.Contains(err.Error(), testSecret) {
+		t.Errorf("ApplyEnv leaked the key: %v", err)
+	}
+	if cfg.Tokens.Keys != nil || cfg.TCP.Port != Default().TCP.Port {
+		t.Errorf("invalid values were applied: %+v", cfg)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	data := `{"tcp": {"port": 9000}, "timeouts": {"empty_room_ttl": "30s"}, "chat": {"banned_words": ["spam"]}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	if err := LoadFile(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TCP.Port != 9000 || cfg.Timeouts.EmptyRoomTTL.Std() != 30*time.Second || len(cfg.Chat.BannedWords) != 1 {
+		t.Errorf("LoadFile = %+v", cfg)
+	}
+	// ファイルにない項目はデフォルト値のまま
+	if cfg.UDP.Port != Default().UDP.Port || cfg.Timeouts.InactiveTimeout != Default().Timeouts.InactiveTimeout {
+		t.Errorf("LoadFile overwrote fields missing from the file: %+v", cfg)
+	}
+
+	for _, bad := range []string{`{"timeouts": {"empty_room_ttl": 30}}`, `{"timeouts": {"empty_room_ttl": "soon"}}`, `{`} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadFile(&cfg, path); err == nil {
+			t.Errorf("LoadFile(%s) = nil", bad)
+		}
+	}
+	if err := LoadFile(&cfg, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadFile(missing) = nil")
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"online_chat_messenger/internal/logging"
+)
+
+// Change は再読み込みで変更された設定項目を表します。
+type Change struct {
+	Field           string // "timeouts.inactive_timeout" のようなJSON上のパス
+	Old             string
+	New             string
+	RequiresRestart bool // 再起動しないと反映できない項目か
+}
+
+// String は "field: old -> new" 形式の文字列を返します。
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+// Reload は現在の設定と新しい設定を比較し、実行中に反映できる項目だけを新しい値にした設定と、
+// すべての変更点を返します。再起動が必要な項目は現在の値のまま残ります。
+func Reload(current, next Config) (Config, []Change) {
+	applied := current
+	var changes []Change
+	reloadStruct(reflect.ValueOf(&applied).Elem(), reflect.ValueOf(next), "", false, &changes)
+	return applied, changes
+}
+
+// reloadStruct は構造体のフィールドを再帰的に比較し、再起動が不要な項目をdstにコピーします。
+func reloadStruct(dst, src reflect.Value, prefix string, restart bool, changes *[]Change) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		requiresRestart := restart || field.Tag.Get("reload") == "restart"
+
+		dstField, srcField := dst.Field(i), src.Field(i)
+
+		// 入れ子になった設定は再帰的に比較する
+		if field.Type.Kind() == reflect.Struct {
+			reloadStruct(dstField, srcField, path, requiresRestart, changes)
+			continue
+		}
+
+		if reflect.DeepEqual(dstField.Interface(), srcField.Interface()) {
+			continue
+		}
+		change := Change{
+			Field:           path,
+			Old:             fmt.Sprint(dstField.Interface()),
+			New:             fmt.Sprint(srcField.Interface()),
+			RequiresRestart: requiresRestart,
+		}
+		// 秘密の値はログに出力されないように伏せる
+		if field.Tag.Get("secret") == "true" {
+			change.Old, change.New = logging.Redacted, logging.Redacted
+		}
+		*changes = append(*changes, change)
+		if !requiresRestart {
+			dstField.Set(srcField)
+		}
+	}
+}
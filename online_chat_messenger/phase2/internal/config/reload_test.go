@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/logging"
+)
+
+func TestReloadNoChanges(t *testing.T) {
+	applied, changes := Reload(Default(), Default())
+	if len(changes) != 0 {
+		t.Errorf("changes = %v", changes)
+	}
+	if !reflect.DeepEqual(applied, Default()) {
+		t.Errorf("applied = %+v", applied)
+	}
+}
+
+func TestReload(t *testing.T) {
+	current := Default()
+	current.Admin.Token = "old-secret"
+	next := current
+	next.Timeouts.InactiveTimeout = Duration(10 * time.Minute)
+	next.Chat.BannedWords = []string{"spam"}
+	next.Chat.RateLimit.Burst = 3
+	next.Admin.Token = "new-secret"
+	next.Tokens.Keys = []TokenKeyConfig{{ID: "k1", Secret: testSecret}}
+	next.TCP.Port = 9000
+	next.Log.Format = "json"
+	next.Store.Dir = "/var/lib/chat"
+
+	applied, changes := Reload(current, next)
+
+	want := map[string]Change{
+		"timeouts.inactive_timeout": {Field: "timeouts.inactive_timeout", Old: "5m0s", New: "10m0s"},
+		"chat.banned_words":         {Field: "chat.banned_words", Old: "[]", New: "[spam]"},
+		"chat.rate_limit.burst":     {Field: "chat.rate_limit.burst", Old: "0", New: "3"},
+		"admin.token":               {Field: "admin.token", Old: logging.Redacted, New: logging.Redacted},
+		"tokens.keys":               {Field: "tokens.keys", Old: logging.Redacted, New: logging.Redacted},
+		"tcp.port":                  {Field: "tcp.port", Old: "8088", New: "9000", RequiresRestart: true},
+		"log.format":                {Field: "log.format", Old: "text", New: "json", RequiresRestart: true},
+		"store.dir":                 {Field: "store.dir", Old: "", New: "/var/lib/chat", RequiresRestart: true},
+	}
+	if len(changes) != len(want) {
+		t.Errorf("changes = %v, want %d changes", changes, len(want))
+	}
+	for _, change := range changes {
+		if w, ok := want[change.Field]; !ok || change != w {
+			t.Errorf("change %+v, want %+v", change, w)
+		}
+	}
+
+	// 実行中に変更できる項目だけが新しい値になる
+	if applied.Timeouts.InactiveTimeout != next.Timeouts.InactiveTimeout || applied.Chat.RateLimit.Burst != 3 ||
+		applied.Admin.Token != "new-secret" || len(applied.Tokens.Keys) != 1 || len(applied.Chat.BannedWords) != 1 {
+		t.Errorf("reloadable fields were not applied: %+v", applied)
+	}
+	if applied.TCP.Port != current.TCP.Port || applied.Log.Format != "text" || applied.Store.Dir != "" {
+		t.Errorf("restart-only fields were applied: %+v", applied)
+	}
+	// 現在の設定は変更しない
+	if current.Timeouts.InactiveTimeout != Default().Timeouts.InactiveTimeout {
+		t.Errorf("Reload modified the current config: %+v", current)
+	}
+}
+
+func TestReloadMap(t *testing.T) {
+	current := Default()
+	next := Default()
+	next.MessageLog.Rooms = map[string]RetentionConfig{"lobby": {MaxAge: Duration(time.Hour)}}
+
+	applied, changes := Reload(current, next)
+	if len(changes) != 1 || changes[0].Field != "message_log.rooms" || changes[0].RequiresRestart {
+		t.Errorf("changes = %v", changes)
+	}
+	if applied.MessageLog.Rooms["lobby"].MaxAge != Duration(time.Hour) {
+		t.Errorf("Rooms = %v", applied.MessageLog.Rooms)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	change := Change{Field: "limits.max_rooms", Old: "0", New: "10"}
+	if got := change.String(); got != "limits.max_rooms: 0 -> 10" {
+		t.Errorf("String = %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+// Package logging はサーバー全体で使う構造化ロガー(log/slog)を生成します。
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Redacted は伏せられた値の代わりに出力される文字列です。
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys は値を出力してはいけない属性のキーです。
+var sensitiveKeys = map[string]bool{
+	"token":    true,
+	"password": true,
+}
+
+// New は指定された出力先・レベル・形式("text" または "json")のロガーを生成します。
+// levelに*slog.LevelVarを渡すと実行中にレベルを変更できます。
+// token、passwordという名前の属性は値が伏せられます。
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("不明なログ形式です: %q", format)
+	}
+	return slog.New(handler), nil
+}
+
+// ParseLevel は "debug"、"info"、"warn"、"error" をログレベルに変換します。
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return level, fmt.Errorf("不明なログレベルです: %q", s)
+	}
+	return level, nil
+}
+
+// redact は機密情報を含む属性の値を伏せます。
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
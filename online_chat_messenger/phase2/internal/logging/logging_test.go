@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := New(&buf, slog.LevelDebug, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.With("token", "with-secret").Info("リクエスト",
+				"password", "pass-secret",
+				"Token", "upper-secret",
+				slog.Group("request", "token", "group-secret", "room", "lobby"),
+				"user", "taro",
+			)
+
+			got := buf.String()
+			for _, secret := range []string{"with-secret", "pass-secret", "upper-secret", "group-secret"} {
+				if strings.Contains(got, secret) {
+					t.Errorf("the log contains %q: %s", secret, got)
+				}
+			}
+			if strings.Count(got, Redacted) != 4 {
+				t.Errorf("the log has %d redacted values, want 4: %s", strings.Count(got, Redacted), got)
+			}
+			// 機密情報でない属性はそのまま出力する
+			for _, want := range []string{"lobby", "taro"} {
+				if !strings.Contains(got, want) {
+					t.Errorf("the log does not contain %q: %s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("出力しない")
+	logger.Info("起動しました", "port", 8080)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if record["msg"] != "起動しました" || record["port"] != float64(8080) {
+		t.Errorf("record = %v", record)
+	}
+
+	if _, err := New(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error(`New("xml") = nil`)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := ParseLevel(s); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error(`ParseLevel("verbose") = nil`)
+	}
+}
@@ -0,0 +1,281 @@
+// Package metrics はPrometheusのテキスト形式でサーバーのメトリクスを公開します。
+// 外部ライブラリに依存しないよう、カウンター・ゲージ・ヒストグラムを最小限に実装しています。
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/protocol"
+)
+
+// Metrics はサーバーのメトリクスを保持します。
+// nilのMetricsに対するメソッド呼び出しは何もしないため、メトリクスを無効にしたサーバーでもそのまま使えます。
+type Metrics struct {
+	roomManager chat.RoomManager
+
+	tcrpRequests            *counterVec // operation, status
+	udpPacketsIn            *counterVec
+	udpPacketsOut           *counterVec
+	decodeErrors            *counterVec // protocol
+	tokenValidationFailures *counterVec
+	droppedSends            *counterVec // reason
+	broadcastLatency        *histogram
+}
+
+// New は新しいMetricsを生成します。roomManagerからはスクレイプ時にルームとユーザー数を取得します。
+func New(roomManager chat.RoomManager) *Metrics {
+	return &Metrics{
+		roomManager:             roomManager,
+		tcrpRequests:            newCounterVec("chat_tcrp_requests_total", "TCRPリクエストの処理数（オペレーション・結果別）", "operation", "status"),
+		udpPacketsIn:            newCounterVec("chat_udp_packets_received_total", "受信したUDPパケット数"),
+		udpPacketsOut:           newCounterVec("chat_udp_packets_sent_total", "送信したUDPパケット数"),
+		decodeErrors:            newCounterVec("chat_decode_errors_total", "デコードに失敗したメッセージ数（プロトコル別）", "protocol"),
+		tokenValidationFailures: newCounterVec("chat_token_validation_failures_total", "トークンの検証に失敗したUDPメッセージ数"),
+		droppedSends:            newCounterVec("chat_dropped_sends_total", "配信されなかったメッセージ数（理由別）", "reason"),
+		broadcastLatency: newHistogram("chat_broadcast_duration_seconds", "ルーム内へのブロードキャストにかかった時間",
+			[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}),
+	}
+}
+
+// TCRPRequest はTCRPリクエストの処理結果を記録します。
+func (m *Metrics) TCRPRequest(operation uint8, status string) {
+	if m == nil {
+		return
+	}
+	m.tcrpRequests.inc(operationName(operation), status)
+}
+
+// UDPPacketReceived は受信したUDPパケットを記録します。
+func (m *Metrics) UDPPacketReceived() {
+	if m == nil {
+		return
+	}
+	m.udpPacketsIn.inc()
+}
+
+// UDPPacketSent は送信したUDPパケットを記録します。
+func (m *Metrics) UDPPacketSent() {
+	if m == nil {
+		return
+	}
+	m.udpPacketsOut.inc()
+}
+
+// DecodeError はデコードに失敗したメッセージを記録します。protocolは "tcp" または "udp" です。
+func (m *Metrics) DecodeError(protocol string) {
+	if m == nil {
+		return
+	}
+	m.decodeErrors.inc(protocol)
+}
+
+// TokenValidationFailed はトークンの検証に失敗したメッセージを記録します。
+func (m *Metrics) TokenValidationFailed() {
+	if m == nil {
+		return
+	}
+	m.tokenValidationFailures.inc()
+}
+
+// SendDropped は配信されなかったメッセージを理由とともに記録します。
+func (m *Metrics) SendDropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.droppedSends.inc(reason)
+}
+
+// ObserveBroadcast はブロードキャストにかかった時間を記録します。
+func (m *Metrics) ObserveBroadcast(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.broadcastLatency.observe(d.Seconds())
+}
+
+// Handler はメトリクスをPrometheusのテキスト形式で返すHTTPハンドラーです。
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// WriteTo はすべてのメトリクスをPrometheusのテキスト形式で書き出します。
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	m.writeRoomGauges(&b)
+	m.tcrpRequests.write(&b)
+	m.udpPacketsIn.write(&b)
+	m.udpPacketsOut.write(&b)
+	m.decodeErrors.write(&b)
+	m.tokenValidationFailures.write(&b)
+	m.droppedSends.write(&b)
+	m.broadcastLatency.write(&b)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// writeRoomGauges はスクレイプ時点のルーム数とルームごとのユーザー数を書き出します。
+func (m *Metrics) writeRoomGauges(b *strings.Builder) {
+	rooms := m.roomManager.GetAllRooms()
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].GetName() < rooms[j].GetName() })
+
+	writeHeader(b, "chat_rooms_active", "存在するルーム数", "gauge")
+	fmt.Fprintf(b, "chat_rooms_active %d\n", len(rooms))
+
+	writeHeader(b, "chat_room_users", "ルームごとの参加ユーザー数", "gauge")
+	for _, room := range rooms {
+		fmt.Fprintf(b, "chat_room_users{room=%s} %d\n", quote(room.GetName()), len(room.GetUsers()))
+	}
+}
+
+// operationName はTCRPのオペレーションコードをラベル用の名前に変換します。
+func operationName(operation uint8) string {
+	switch operation {
+	case protocol.OperationCreateRoom:
+		return "create_room"
+	case protocol.OperationJoinRoom:
+		return "join_room"
+	case protocol.OperationLeaveRoom:
+		return "leave_room"
+	case protocol.OperationSearch:
+		return "search"
+	case protocol.OperationExport:
+		return "export"
+	case protocol.OperationInvite:
+		return "invite"
+	case protocol.OperationRegister:
+		return "register"
+	case protocol.OperationLogin:
+		return "login"
+	default:
+		return strconv.Itoa(int(operation))
+	}
+}
+
+// counterVec はラベルごとの値を持つカウンターです。
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64 // キーはラベル値を "\xff" で連結した文字列
+	mutex  sync.Mutex
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[strings.Join(labelValues, "\xff")]++
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeHeader(b, c.name, c.help, "counter")
+	if len(c.labels) == 0 {
+		fmt.Fprintf(b, "%s %s\n", c.name, formatFloat(c.values[""]))
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(key, "\xff")), formatFloat(c.values[key]))
+	}
+}
+
+// histogram は累積バケットを持つヒストグラムです。
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	counts  []uint64 // バケットごとの観測数（累積ではない）
+	sum     float64
+	count   uint64
+	mutex   sync.Mutex
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(b *strings.Builder) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	writeHeader(b, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{le=%s} %d\n", h.name, quote(formatFloat(upper)), cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(b, "%s_count %d\n", h.name, h.count)
+}
+
+func writeHeader(b *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + "=" + quote(value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// quote はラベル値をエスケープしてダブルクォートで囲みます。
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/protocol"
+)
+
+// scrape はハンドラーからメトリクスを取得し、ヘッダー以外の行を返します。
+func scrape(t *testing.T, m *Metrics) map[string]bool {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		samples[line] = true
+	}
+	return samples
+}
+
+func wantSamples(t *testing.T, samples map[string]bool, want ...string) {
+	t.Helper()
+	for _, line := range want {
+		if !samples[line] {
+			t.Errorf("the scrape does not contain %q", line)
+		}
+	}
+}
+
+func newTestMetrics(t *testing.T) (*Metrics, *chat.SimpleRoomManager) {
+	t.Helper()
+	rooms := chat.NewSimpleRoomManager()
+	t.Cleanup(func() { rooms.Close() })
+	return New(rooms), rooms
+}
+
+func TestEmptyScrape(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	wantSamples(t, scrape(t, m),
+		"# TYPE chat_rooms_active gauge",
+		"chat_rooms_active 0",
+		"# TYPE chat_room_users gauge",
+		"# TYPE chat_tcrp_requests_total counter",
+		"chat_udp_packets_received_total 0",
+		"chat_udp_packets_sent_total 0",
+		"chat_token_validation_failures_total 0",
+		"# TYPE chat_broadcast_duration_seconds histogram",
+		`chat_broadcast_duration_seconds_bucket{le="+Inf"} 0`,
+		"chat_broadcast_duration_seconds_count 0",
+	)
+}
+
+func TestRoomGauges(t *testing.T) {
+	m, rooms := newTestMetrics(t)
+	lobby, err := rooms.CreateRoom("lobby", "", chat.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rooms.CreateRoom(`a "quoted" room`, "", chat.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"taro", "hanako"} {
+		if err := lobby.AddUser(chat.NewUser(name, name+"-token", "192.0.2.1:50000"), i == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantSamples(t, scrape(t, m),
+		"chat_rooms_active 2",
+		`chat_room_users{room="lobby"} 2`,
+		`chat_room_users{room="a \"quoted\" room"} 0`,
+	)
+}
+
+func TestCounters(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	m.TCRPRequest(protocol.OperationCreateRoom, "ok")
+	m.TCRPRequest(protocol.OperationCreateRoom, "ok")
+	m.TCRPRequest(protocol.OperationJoinRoom, "error")
+	m.TCRPRequest(protocol.OperationLogin, "ok")
+	m.TCRPRequest(99, "error")
+	for range 3 {
+		m.UDPPacketReceived()
+	}
+	m.UDPPacketSent()
+	m.DecodeError("udp")
+	m.TokenValidationFailed()
+	m.SendDropped("rate_limited")
+	m.SendDropped("rate_limited")
+
+	wantSamples(t, scrape(t, m),
+		`chat_tcrp_requests_total{operation="create_room",status="ok"} 2`,
+		`chat_tcrp_requests_total{operation="join_room",status="error"} 1`,
+		`chat_tcrp_requests_total{operation="login",status="ok"} 1`,
+		`chat_tcrp_requests_total{operation="99",status="error"} 1`,
+		"chat_udp_packets_received_total 3",
+		"chat_udp_packets_sent_total 1",
+		`chat_decode_errors_total{protocol="udp"} 1`,
+		"chat_token_validation_failures_total 1",
+		`chat_dropped_sends_total{reason="rate_limited"} 2`,
+	)
+}
+
+func TestBroadcastHistogram(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	m.ObserveBroadcast(50 * time.Microsecond)
+	m.ObserveBroadcast(2 * time.Millisecond)
+	m.ObserveBroadcast(2 * time.Second)
+
+	// バケットは累積で、上限を超えた観測は+Infにだけ数える
+	wantSamples(t, scrape(t, m),
+		`chat_broadcast_duration_seconds_bucket{le="0.0001"} 1`,
+		`chat_broadcast_duration_seconds_bucket{le="0.001"} 1`,
+		`chat_broadcast_duration_seconds_bucket{le="0.005"} 2`,
+		`chat_broadcast_duration_seconds_bucket{le="1"} 2`,
+		`chat_broadcast_duration_seconds_bucket{le="+Inf"} 3`,
+		"chat_broadcast_duration_seconds_sum 2.00205",
+		"chat_broadcast_duration_seconds_count 3",
+	)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.TCRPRequest(protocol.OperationCreateRoom, "ok")
+	m.UDPPacketReceived()
+	m.UDPPacketSent()
+	m.DecodeError("tcp")
+	m.TokenValidationFailed()
+	m.SendDropped("full")
+	m.ObserveBroadcast(time.Millisecond)
+}
@@ -0,0 +1,258 @@
+// Package msglog はルームごとのメッセージをディスクに記録し、保持期間・容量に従って整理するログを提供します。
+//
+// ログはディレクトリの下にルームごとのサブディレクトリを作り、メッセージをJSONの1行として
+// セグメントファイル（<最初の通し番号>.log）に追記します。セグメントが一定のサイズを超えると新しいセグメントに切り替えます。
+package msglog
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize はセグメントを切り替えるデフォルトのサイズ（バイト）です。
+	DefaultSegmentSize = 1 << 20
+	// DefaultCompactInterval は保持期間・容量の適用とセグメントの統合を行うデフォルトの間隔です。
+	DefaultCompactInterval = 10 * time.Minute
+)
+
+// ErrClosed はClose済みのLogを操作しようとしたことを表します。
+var ErrClosed = errors.New("msglog: log is closed")
+
+// Record はログに記録された1件のメッセージです。
+type Record struct {
+	Seq    uint64    `json:"seq"` // ルームごとの通し番号
+	Room   string    `json:"room"`
+	Sender string    `json:"sender"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`             // サーバーがメッセージを受け付けた時刻
+	System bool      `json:"system,omitempty"` // サーバーからのお知らせや入退室などのイベント
+}
+
+// Retention はルームごとのログの保持ポリシーです。0の項目は無制限を表します。
+type Retention struct {
+	MaxAge   time.Duration // この期間より古いメッセージを削除する
+	MaxBytes int64         // ルームのログの合計サイズの上限（古いセグメントから削除する）
+}
+
+// Log はルームごとのメッセージのログです。
+// 書き込みはOSに渡した時点で完了とし、セグメントの切り替え・定期的な整理・Closeのときにディスクに同期します。
+// 同期前に停止して行が途中まで書き込まれた場合は、次に開いたときにその行を捨てます。
+type Log struct {
+	dir         string
+	segmentSize int64
+
+	rooms     map[string]*roomLog // キーはルーム名
+	retention Retention
+	overrides map[string]Retention // ルームごとの保持ポリシー
+	closed    bool
+	mutex     sync.Mutex
+
+	logger    *slog.Logger
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Open はディレクトリのログを開きます。segmentSizeが0以下の場合はDefaultSegmentSizeを使います。
+// compactIntervalごとに保持ポリシーの適用とセグメントの統合を行います。0以下の場合は自動では行いません。
+func Open(dir string, segmentSize int64, compactInterval time.Duration) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ディレクトリの作成に失敗しました: %w", err)
+	}
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	l := &Log{
+		dir:         dir,
+		segmentSize: segmentSize,
+		rooms:       make(map[string]*roomLog),
+		overrides:   make(map[string]Retention),
+		logger:      slog.Default().With("component", "msglog"),
+		done:        make(chan struct{}),
+	}
+
+	if compactInterval > 0 {
+		l.wg.Add(1)
+		go l.compactLoop(compactInterval)
+	}
+	return l, nil
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (l *Log) SetLogger(logger *slog.Logger) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.logger = logger.With("component", "msglog")
+}
+
+// SetRetention はすべてのルームの保持ポリシーと、ルームごとに上書きする保持ポリシーを設定します。
+// 次の整理から適用されます。
+func (l *Log) SetRetention(retention Retention, overrides map[string]Retention) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.retention = retention
+	l.overrides = make(map[string]Retention, len(overrides))
+	for room, r := range overrides {
+		l.overrides[room] = r
+	}
+}
+
+// Append はメッセージをルームのログに追記し、割り当てた通し番号を返します。
+// RecordのSeqは無視されます。
+func (l *Log) Append(record Record) (uint64, error) {
+	room, err := l.room(record.Room, true)
+	if err != nil {
+		return 0, err
+	}
+	return room.append(record)
+}
+
+// Rooms はログがあるルームの名前を返します。
+func (l *Log) Rooms() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("ディレクトリの読み込みに失敗しました: %w", err)
+	}
+
+	var rooms []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		rooms = append(rooms, name)
+	}
+	sort.Strings(rooms)
+	return rooms, nil
+}
+
+// Compact はすべてのルームのログに保持ポリシーを適用し、小さなセグメントを統合します。
+func (l *Log) Compact() error {
+	names, err := l.Rooms()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, name := range names {
+		room, err := l.room(name, false)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if room == nil {
+			continue
+		}
+		if err := room.compact(l.retentionFor(name), now); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close は整理を停止し、書き込み中のセグメントをディスクに同期して閉じます。
+func (l *Log) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.closed = true
+
+		var errs []error
+		for _, room := range l.rooms {
+			errs = append(errs, room.close())
+		}
+		err = errors.Join(errs...)
+	})
+	return err
+}
+
+// room はルームのログを返します。まだ開いていない場合はディレクトリから読み込んで復旧します。
+// createがfalseでログがない場合はnilを返します。
+func (l *Log) room(name string, create bool) (*roomLog, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if name == "" {
+		return nil, errors.New("msglog: empty room name")
+	}
+	if room, ok := l.rooms[name]; ok {
+		return room, nil
+	}
+
+	dir := filepath.Join(l.dir, roomDirName(name))
+	if !create {
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+
+	room, err := openRoomLog(dir, name, l.segmentSize, l.logger)
+	if err != nil {
+		return nil, err
+	}
+	l.rooms[name] = room
+	return room, nil
+}
+
+// retentionFor はルームに適用する保持ポリシーを返します。
+func (l *Log) retentionFor(name string) Retention {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if r, ok := l.overrides[name]; ok {
+		return r
+	}
+	return l.retention
+}
+
+// compactLoop は一定間隔でログを整理します。
+func (l *Log) compactLoop(interval time.Duration) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Compact(); err != nil && !errors.Is(err, ErrClosed) {
+				l.mutex.Lock()
+				logger := l.logger
+				l.mutex.Unlock()
+				logger.Error("メッセージログの整理に失敗しました", "error", err)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// roomDirName はルーム名をディレクトリ名に変換します。
+// パスの区切り文字などはエスケープし、"." や ".." にならないよう先頭のドットもエスケープします。
+func roomDirName(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped
+}
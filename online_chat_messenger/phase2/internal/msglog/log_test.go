@@ -0,0 +1,361 @@
+package msglog
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appendTexts はルームにメッセージを追記します。時刻はbaseから1分ずつずらします。
+func appendTexts(t *testing.T, l *Log, room string, base time.Time, texts ...string) {
+	t.Helper()
+	for i, text := range texts {
+		if _, err := l.Append(Record{Room: room, Sender: "taro", Text: text, Time: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scanAll はルームのすべてのレコードを返します。
+func scanAll(t *testing.T, l *Log, room string) []Record {
+	t.Helper()
+	var records []Record
+	if err := l.Scan(room, time.Time{}, time.Time{}, func(r Record) bool {
+		records = append(records, r)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// checkRecords はレコードのテキストと、通し番号がfirstSeqから途切れずに続いていることを確認します。
+func checkRecords(t *testing.T, records []Record, firstSeq uint64, texts ...string) {
+	t.Helper()
+	if len(records) != len(texts) {
+		t.Fatalf("got %d records %v, want %v", len(records), records, texts)
+	}
+	for i, r := range records {
+		if r.Text != texts[i] || r.Seq != firstSeq+uint64(i) {
+			t.Errorf("records[%d] = {Seq:%d Text:%q}, want {Seq:%d Text:%q}", i, r.Seq, r.Text, firstSeq+uint64(i), texts[i])
+		}
+	}
+}
+
+// segmentFiles はルームのディレクトリにあるファイル名を返します。
+func segmentFiles(t *testing.T, dir, room string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, roomDirName(room)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func openTestLog(t *testing.T, dir string, segmentSize int64) *Log {
+	t.Helper()
+	l, err := Open(dir, segmentSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 復旧のときの警告はテストの出力に含めない
+	l.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestAppendScanAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 200)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	texts := make([]string, 10)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("message %d", i)
+	}
+	appendTexts(t, l, "lobby", base, texts...)
+
+	if files := segmentFiles(t, dir, "lobby"); len(files) < 2 {
+		t.Errorf("segments = %v, want the log to rotate", files)
+	}
+	checkRecords(t, scanAll(t, l, "lobby"), 1, texts...)
+
+	last, err := l.Last("lobby", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, last, 8, texts[7:]...)
+
+	// 時刻の範囲で絞り込む
+	var ranged []Record
+	if err := l.Scan("lobby", base.Add(2*time.Minute), base.Add(4*time.Minute), func(r Record) bool {
+		ranged = append(ranged, r)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, ranged, 3, texts[2:4]...)
+
+	if records := scanAll(t, l, "unknown"); len(records) != 0 {
+		t.Errorf("Scan(unknown) = %v", records)
+	}
+}
+
+func TestReopenContinuesSequence(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Truncate(time.Second)
+
+	l := openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base, "a", "b")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(Record{Room: "lobby", Text: "closed"}); err != ErrClosed {
+		t.Errorf("Append after Close = %v, want ErrClosed", err)
+	}
+
+	l = openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base.Add(time.Hour), "c")
+	checkRecords(t, scanAll(t, l, "lobby"), 1, "a", "b", "c")
+}
+
+func TestRecoverTruncatesPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Truncate(time.Second)
+
+	l := openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base, "a", "b")
+	l.Close()
+
+	// 行の途中で停止した状態を再現する
+	files := segmentFiles(t, dir, "lobby")
+	path := filepath.Join(dir, roomDirName("lobby"), files[len(files)-1])
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"seq":3,"room":"lobby","text":"hal`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l = openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base.Add(time.Hour), "c")
+	checkRecords(t, scanAll(t, l, "lobby"), 1, "a", "b", "c")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hal") {
+		t.Errorf("partial line was not truncated:\n%s", data)
+	}
+}
+
+func TestRecoverTruncatesCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Truncate(time.Second)
+
+	l := openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base, "a")
+	l.Close()
+
+	files := segmentFiles(t, dir, "lobby")
+	path := filepath.Join(dir, roomDirName("lobby"), files[0])
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+
+	l = openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base.Add(time.Hour), "b")
+	checkRecords(t, scanAll(t, l, "lobby"), 1, "a", "b")
+}
+
+func TestRecoverRemovesInterruptedMerge(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	l := openTestLog(t, dir, 150)
+	appendTexts(t, l, "lobby", base, "a", "b", "c", "d")
+	l.Close()
+
+	files := segmentFiles(t, dir, "lobby")
+	if len(files) < 3 {
+		t.Fatalf("segments = %v, want at least 3", files)
+	}
+	roomDir := filepath.Join(dir, roomDirName("lobby"))
+
+	// 最初の2つのセグメントを統合したファイルに置き換え、2つ目のセグメントを削除する前に停止した状態を再現する
+	first, err := os.ReadFile(filepath.Join(roomDir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(filepath.Join(roomDir, files[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(roomDir, files[0]), append(first, second...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// 統合の途中の一時ファイル
+	if err := os.WriteFile(filepath.Join(roomDir, files[0]+".tmp"), first, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openTestLog(t, dir, 150)
+	checkRecords(t, scanAll(t, l, "lobby"), 1, "a", "b", "c", "d")
+	for _, name := range segmentFiles(t, dir, "lobby") {
+		if name == files[1] || strings.HasSuffix(name, ".tmp") {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+}
+
+func TestCompactDropsOldRecords(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 150)
+	now := time.Now()
+
+	// 古いメッセージ3件と新しいメッセージ2件
+	appendTexts(t, l, "lobby", now.Add(-3*time.Hour), "old1", "old2", "old3")
+	appendTexts(t, l, "lobby", now.Add(-time.Minute), "new1", "new2")
+	l.SetRetention(Retention{MaxAge: time.Hour}, nil)
+
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, scanAll(t, l, "lobby"), 4, "new1", "new2")
+
+	// 整理した後も通し番号は続く
+	appendTexts(t, l, "lobby", now, "new3")
+	checkRecords(t, scanAll(t, l, "lobby"), 4, "new1", "new2", "new3")
+}
+
+func TestCompactDropsAllExpiredRecords(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", time.Now().Add(-3*time.Hour), "old1", "old2")
+	l.SetRetention(Retention{MaxAge: time.Hour}, nil)
+
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if records := scanAll(t, l, "lobby"); len(records) != 0 {
+		t.Errorf("records = %v, want none", records)
+	}
+
+	// 書き込み中のセグメントを削除しても、再起動後に通し番号が戻らない
+	l.Close()
+	l = openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", time.Now(), "new")
+	checkRecords(t, scanAll(t, l, "lobby"), 3, "new")
+}
+
+func TestCompactRetentionOverride(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+	old := time.Now().Add(-3 * time.Hour)
+	appendTexts(t, l, "lobby", old, "a")
+	appendTexts(t, l, "archive", old, "b")
+	l.SetRetention(Retention{MaxAge: time.Hour}, map[string]Retention{"archive": {}})
+
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if records := scanAll(t, l, "lobby"); len(records) != 0 {
+		t.Errorf("lobby records = %v, want none", records)
+	}
+	checkRecords(t, scanAll(t, l, "archive"), 1, "b")
+}
+
+func TestCompactDropsOverSize(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 150)
+	base := time.Now().Truncate(time.Second)
+
+	texts := make([]string, 8)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("m%d", i)
+	}
+	appendTexts(t, l, "lobby", base, texts...)
+	l.SetRetention(Retention{MaxBytes: 300}, nil)
+
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	records := scanAll(t, l, "lobby")
+	if len(records) == 0 || len(records) >= len(texts) {
+		t.Fatalf("got %d records, want some old records to be dropped", len(records))
+	}
+	// 残るのは新しいレコードで、最新のレコードは必ず残る
+	first := records[0].Seq
+	checkRecords(t, records, first, texts[first-1:]...)
+}
+
+func TestCompactMergesSmallSegments(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Truncate(time.Second)
+
+	// 小さなセグメントのサイズで1件ずつのセグメントを作る
+	l := openTestLog(t, dir, 1)
+	texts := []string{"a", "b", "c", "d", "e"}
+	appendTexts(t, l, "lobby", base, texts...)
+	l.Close()
+	before := len(segmentFiles(t, dir, "lobby"))
+	if before != len(texts) {
+		t.Fatalf("segments = %d, want %d", before, len(texts))
+	}
+
+	// セグメントのサイズを大きくして開き直すと、書き込みを終えたセグメントが1つにまとまる
+	l = openTestLog(t, dir, 0)
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if after := len(segmentFiles(t, dir, "lobby")); after != 2 {
+		t.Errorf("segments = %d after compaction, want the closed segments merged into 1 and the active one", after)
+	}
+	checkRecords(t, scanAll(t, l, "lobby"), 1, texts...)
+
+	// 統合した後も書き込み中のセグメントに追記でき、開き直しても通し番号が続く
+	appendTexts(t, l, "lobby", base.Add(time.Hour), "f")
+	l.Close()
+	l = openTestLog(t, dir, 0)
+	appendTexts(t, l, "lobby", base.Add(2*time.Hour), "g")
+	checkRecords(t, scanAll(t, l, "lobby"), 1, "a", "b", "c", "d", "e", "f", "g")
+}
+
+func TestRoomsEscapesNames(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, 0)
+	base := time.Now()
+	for _, room := range []string{"..", "a/b", "ロビー"} {
+		appendTexts(t, l, room, base, "hello")
+	}
+
+	rooms, err := l.Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(rooms, ","); got != "..,a/b,ロビー" {
+		t.Errorf("Rooms = %q", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("directories = %v, want one per room inside the log directory", entries)
+	}
+}
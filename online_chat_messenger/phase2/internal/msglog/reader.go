@@ -0,0 +1,66 @@
+package msglog
+
+import "time"
+
+// Scan はルームのレコードのうち、時刻がfrom以上to未満のものを古い順にfnに渡します。
+// fromとtoはゼロ値の場合は制限しません。fnがfalseを返すと読み込みを終了します。
+// ログがないルームの場合は何もしません。
+func (l *Log) Scan(room string, from, to time.Time, fn func(Record) bool) error {
+	r, err := l.room(room, false)
+	if err != nil || r == nil {
+		return err
+	}
+
+	stopped := false
+	for _, path := range r.paths() {
+		err := readSegment(path, func(record Record, _ []byte) bool {
+			if !from.IsZero() && record.Time.Before(from) {
+				return true
+			}
+			if !to.IsZero() && !record.Time.Before(to) {
+				stopped = true
+				return false
+			}
+			if !fn(record) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Last はルームの新しいレコードからn件を古い順に返します。
+func (l *Log) Last(room string, n int) ([]Record, error) {
+	r, err := l.room(room, false)
+	if err != nil || r == nil || n <= 0 {
+		return nil, err
+	}
+
+	// 新しいセグメントから読み、n件集まったところで終了する
+	paths := r.paths()
+	var records []Record
+	for i := len(paths) - 1; i >= 0 && len(records) < n; i-- {
+		var segmentRecords []Record
+		err := readSegment(paths[i], func(record Record, _ []byte) bool {
+			segmentRecords = append(segmentRecords, record)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(segmentRecords, records...)
+	}
+
+	if len(records) > n {
+		records = records[len(records)-n:]
+	}
+	return records, nil
+}
@@ -0,0 +1,483 @@
+package msglog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentExt = ".log"
+
+// segment はセグメントファイルの情報です。
+type segment struct {
+	path      string
+	firstSeq  uint64 // ファイル名に使う最初の通し番号
+	lastSeq   uint64 // 最後のレコードの通し番号（空の場合はfirstSeq-1）
+	firstTime time.Time
+	lastTime  time.Time
+	size      int64
+	records   int
+}
+
+// roomLog は1つのルームのログです。最後のセグメントにだけ追記します。
+type roomLog struct {
+	dir         string
+	name        string
+	segmentSize int64
+	logger      *slog.Logger
+
+	segments []*segment // 通し番号の順
+	active   *os.File   // 最後のセグメント（まだ作成していない場合はnil）
+	nextSeq  uint64
+	closed   bool
+	mutex    sync.Mutex
+}
+
+// openRoomLog はルームのディレクトリのセグメントを読み込み、途中まで書き込まれた行を捨てて復旧します。
+func openRoomLog(dir, name string, segmentSize int64, logger *slog.Logger) (*roomLog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ディレクトリの作成に失敗しました: %w", err)
+	}
+
+	r := &roomLog{
+		dir:         dir,
+		name:        name,
+		segmentSize: segmentSize,
+		logger:      logger.With("room", name),
+		nextSeq:     1,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ディレクトリの読み込みに失敗しました: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		// 統合の途中で停止した場合の一時ファイル
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(path)
+			continue
+		}
+		seq, ok := parseSegmentName(entry.Name())
+		if !ok {
+			continue
+		}
+		r.segments = append(r.segments, &segment{path: path, firstSeq: seq})
+	}
+	sort.Slice(r.segments, func(i, j int) bool { return r.segments[i].firstSeq < r.segments[j].firstSeq })
+
+	var kept []*segment
+	for i, seg := range r.segments {
+		last := i == len(r.segments)-1
+		if err := r.scanSegment(seg, last); err != nil {
+			return nil, err
+		}
+		// 統合したセグメントの置き換え後、元のセグメントを削除する前に停止した場合は重複を取り除く
+		if len(kept) > 0 && seg.records > 0 && seg.lastSeq <= kept[len(kept)-1].lastSeq {
+			r.logger.Warn("統合済みのセグメントを削除します", "segment", filepath.Base(seg.path))
+			os.Remove(seg.path)
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	r.segments = kept
+
+	if n := len(r.segments); n > 0 {
+		lastSeg := r.segments[n-1]
+		r.nextSeq = max(lastSeg.lastSeq, lastSeg.firstSeq-1) + 1
+		r.active, err = os.OpenFile(lastSeg.path, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("セグメントを開けませんでした: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// scanSegment はセグメントのレコードを読み、情報を集めます。
+// lastがtrueの場合は、最後の正しい行より後ろ（書き込み途中の行）を切り捨てます。
+func (r *roomLog) scanSegment(seg *segment, last bool) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("セグメントを開けませんでした: %w", err)
+	}
+	defer f.Close()
+
+	seg.lastSeq = seg.firstSeq - 1
+	var valid int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 && last {
+				r.logger.Warn("書き込み途中の行を切り捨てます", "segment", filepath.Base(seg.path), "offset", valid, "bytes", len(line))
+			}
+			break
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			if last {
+				r.logger.Warn("壊れた行以降を切り捨てます", "segment", filepath.Base(seg.path), "offset", valid, "error", err)
+				break
+			}
+			// 追記しないセグメントの壊れた行は読み飛ばす
+			valid += int64(len(line))
+			continue
+		}
+		seg.add(record, int64(len(line)))
+		valid += int64(len(line))
+	}
+
+	if last {
+		if err := f.Truncate(valid); err != nil {
+			return fmt.Errorf("セグメントの切り詰めに失敗しました: %w", err)
+		}
+		seg.size = valid
+	} else if info, err := f.Stat(); err == nil {
+		seg.size = info.Size()
+	}
+	return nil
+}
+
+// add はレコードをセグメントの情報に反映します。
+func (s *segment) add(record Record, size int64) {
+	if s.records == 0 {
+		s.firstTime = record.Time
+	}
+	s.lastSeq = record.Seq
+	s.lastTime = record.Time
+	s.size += size
+	s.records++
+}
+
+// append はレコードに通し番号を割り当てて追記します。セグメントがいっぱいの場合は新しいセグメントに切り替えます。
+func (r *roomLog) append(record Record) (uint64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return 0, ErrClosed
+	}
+
+	record.Seq = r.nextSeq
+	record.Room = r.name
+	line, err := json.Marshal(record)
+	if err != nil {
+		return 0, fmt.Errorf("レコードのエンコードに失敗しました: %w", err)
+	}
+	line = append(line, '\n')
+
+	if r.active == nil || (r.segments[len(r.segments)-1].size+int64(len(line)) > r.segmentSize && r.segments[len(r.segments)-1].records > 0) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	// 1行を1回のWriteで書き込み、途中で停止した場合でも行単位で復旧できるようにする
+	if _, err := r.active.Write(line); err != nil {
+		return 0, fmt.Errorf("レコードの書き込みに失敗しました: %w", err)
+	}
+	r.segments[len(r.segments)-1].add(record, int64(len(line)))
+	r.nextSeq++
+	return record.Seq, nil
+}
+
+// rotate は書き込み中のセグメントを同期して閉じ、次の通し番号から始まるセグメントを作成します。
+func (r *roomLog) rotate() error {
+	if r.active != nil {
+		if err := r.active.Sync(); err != nil {
+			return fmt.Errorf("セグメントの同期に失敗しました: %w", err)
+		}
+		r.active.Close()
+		r.active = nil
+	}
+
+	path := filepath.Join(r.dir, segmentName(r.nextSeq))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("セグメントの作成に失敗しました: %w", err)
+	}
+	r.active = f
+	r.segments = append(r.segments, &segment{path: path, firstSeq: r.nextSeq, lastSeq: r.nextSeq - 1})
+	syncDir(r.dir)
+	return nil
+}
+
+// compact は保持ポリシーに従って古いレコードを削除し、隣り合う小さなセグメントを統合します。
+func (r *roomLog) compact(retention Retention, now time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	if r.active != nil {
+		// 整理のたびに書き込み中のセグメントを同期する
+		if err := r.active.Sync(); err != nil {
+			return fmt.Errorf("セグメントの同期に失敗しました: %w", err)
+		}
+	}
+
+	if retention.MaxAge > 0 {
+		if err := r.dropOlderThan(now.Add(-retention.MaxAge)); err != nil {
+			return err
+		}
+	}
+	if retention.MaxBytes > 0 {
+		r.dropOverSize(retention.MaxBytes)
+	}
+	return r.mergeSmallSegments()
+}
+
+// dropOlderThan はcutoffより古いレコードを削除します。
+// すべてのレコードが古いセグメントは削除し、一部が古いセグメントは新しいレコードだけを残して書き直します。
+func (r *roomLog) dropOlderThan(cutoff time.Time) error {
+	// 書き込み中のセグメントのレコードがすべて古い場合は、閉じて削除できるようにする
+	reopen := false
+	if n := len(r.segments); n > 0 && r.active != nil {
+		lastSeg := r.segments[n-1]
+		if lastSeg.records > 0 && lastSeg.lastTime.Before(cutoff) {
+			if err := r.closeActive(); err != nil {
+				return err
+			}
+			reopen = true
+		}
+	}
+
+	var kept []*segment
+	for i, seg := range r.segments {
+		isActive := r.active != nil && i == len(r.segments)-1
+		switch {
+		case isActive || seg.records == 0 || !seg.firstTime.Before(cutoff):
+			kept = append(kept, seg)
+		case seg.lastTime.Before(cutoff):
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("セグメントの削除に失敗しました: %w", err)
+			}
+			r.logger.Debug("保持期間を過ぎたセグメントを削除しました", "segment", filepath.Base(seg.path), "records", seg.records)
+		default:
+			rewritten, err := r.rewrite([]*segment{seg}, func(record Record) bool { return !record.Time.Before(cutoff) })
+			if err != nil {
+				return err
+			}
+			kept = append(kept, rewritten)
+		}
+	}
+	r.segments = kept
+
+	// 再起動後も通し番号を続けられるよう、次の通し番号から始まる空のセグメントを作成しておく
+	if reopen {
+		return r.rotate()
+	}
+	return nil
+}
+
+// dropOverSize は合計サイズがmaxBytes以下になるまで古いセグメントを削除します。書き込み中のセグメントは削除しません。
+func (r *roomLog) dropOverSize(maxBytes int64) {
+	var total int64
+	for _, seg := range r.segments {
+		total += seg.size
+	}
+
+	for total > maxBytes && len(r.segments) > 0 {
+		if r.active != nil && len(r.segments) == 1 {
+			break
+		}
+		seg := r.segments[0]
+		if err := os.Remove(seg.path); err != nil {
+			r.logger.Warn("セグメントの削除に失敗しました", "segment", filepath.Base(seg.path), "error", err)
+			break
+		}
+		r.logger.Debug("容量の上限を超えたためセグメントを削除しました", "segment", filepath.Base(seg.path), "records", seg.records)
+		total -= seg.size
+		r.segments = r.segments[1:]
+	}
+}
+
+// mergeSmallSegments は書き込みを終えた隣り合うセグメントを、合計がセグメントのサイズを超えない範囲で1つにまとめます。
+func (r *roomLog) mergeSmallSegments() error {
+	closed := len(r.segments)
+	if r.active != nil {
+		closed--
+	}
+
+	var merged []*segment
+	for i := 0; i < len(r.segments); {
+		if i >= closed {
+			merged = append(merged, r.segments[i])
+			i++
+			continue
+		}
+
+		j, size := i+1, r.segments[i].size
+		for j < closed && size+r.segments[j].size <= r.segmentSize {
+			size += r.segments[j].size
+			j++
+		}
+		if j-i == 1 {
+			merged = append(merged, r.segments[i])
+			i++
+			continue
+		}
+
+		seg, err := r.rewrite(r.segments[i:j], func(Record) bool { return true })
+		if err != nil {
+			return err
+		}
+		merged = append(merged, seg)
+		i = j
+	}
+	r.segments = merged
+	return nil
+}
+
+// rewrite はセグメントのレコードのうちkeepがtrueのものを、最初のセグメントと同じ名前のファイルに書き直します。
+// 一時ファイルに書き込んで同期してから置き換え、その後に残りのセグメントを削除します。
+// 置き換えの後に停止した場合に残るセグメントは、次に開いたときに重複として削除されます。
+func (r *roomLog) rewrite(segments []*segment, keep func(Record) bool) (*segment, error) {
+	first := segments[0]
+	tmp := first.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("一時ファイルの作成に失敗しました: %w", err)
+	}
+
+	result := &segment{path: first.path, firstSeq: first.firstSeq, lastSeq: first.firstSeq - 1}
+	writer := bufio.NewWriter(out)
+	for _, seg := range segments {
+		err := readSegment(seg.path, func(record Record, line []byte) bool {
+			if !keep(record) {
+				return true
+			}
+			if _, err := writer.Write(line); err != nil {
+				return false
+			}
+			result.add(record, int64(len(line)))
+			return true
+		})
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("一時ファイルの書き込みに失敗しました: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("一時ファイルの同期に失敗しました: %w", err)
+	}
+	out.Close()
+
+	if err := os.Rename(tmp, first.path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("セグメントの置き換えに失敗しました: %w", err)
+	}
+	syncDir(r.dir)
+	for _, seg := range segments[1:] {
+		os.Remove(seg.path)
+	}
+	return result, nil
+}
+
+// closeActive は書き込み中のセグメントを同期して閉じます。次の追記では新しいセグメントを作成します。
+func (r *roomLog) closeActive() error {
+	if r.active == nil {
+		return nil
+	}
+	err := r.active.Sync()
+	r.active.Close()
+	r.active = nil
+	if err != nil {
+		return fmt.Errorf("セグメントの同期に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// close はルームのログを閉じます。
+func (r *roomLog) close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	return r.closeActive()
+}
+
+// paths は読み込むセグメントのパスを古い順に返します。
+func (r *roomLog) paths() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	paths := make([]string, 0, len(r.segments))
+	for _, seg := range r.segments {
+		paths = append(paths, seg.path)
+	}
+	return paths
+}
+
+// readSegment はセグメントのレコードを順にfnに渡します。fnがfalseを返すと読み込みを終了します。
+// 壊れた行と書き込み途中の行は読み飛ばします。
+func readSegment(path string, fn func(record Record, line []byte) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// 整理で削除された
+			return nil
+		}
+		return fmt.Errorf("セグメントを開けませんでした: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("セグメントの読み込みに失敗しました: %w", err)
+		}
+		var record Record
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		if !fn(record, line) {
+			return nil
+		}
+	}
+}
+
+// segmentName は最初の通し番号からセグメントのファイル名を返します。
+func segmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%020d%s", firstSeq, segmentExt)
+}
+
+// parseSegmentName はセグメントのファイル名から最初の通し番号を返します。
+func parseSegmentName(name string) (uint64, bool) {
+	base, ok := strings.CutSuffix(name, segmentExt)
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(base, 10, 64)
+	return seq, err == nil
+}
+
+// syncDir はファイルの作成や名前の変更をディスクに反映するため、ディレクトリを同期します。
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
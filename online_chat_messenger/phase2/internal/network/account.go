@@ -0,0 +1,125 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+	"online_chat_messenger/internal/protocol"
+)
+
+// errAccountsDisabled はアカウントが設定されていないサーバーに登録・ログインしようとしたことを表します。
+var errAccountsDisabled = errors.New("このサーバーではアカウントを利用できません")
+
+// LoginResponse は登録・ログインリクエストの完了応答のペイロードです。
+type LoginResponse struct {
+	LoginToken string    `json:"login_token"`
+	UserName   string    `json:"user_name"` // 登録した名前（ログインで入力した名前と表記が違う場合がある）
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// accountLinker はアカウントに結び付けられるユーザーです。
+type accountLinker interface {
+	SetAccount(account string)
+}
+
+// newUser はユーザーを作成し、ログインしている場合はアカウントに結び付けます。
+func newUser(name, token, address, account string) chat.User {
+	user := chat.NewUser(name, token, address)
+	if linker, ok := user.(accountLinker); ok && account != "" {
+		linker.SetAccount(account)
+	}
+	return user
+}
+
+// accountOf はユーザーがログインしているアカウントの名前を返します。ゲストの場合は空です。
+func accountOf(user chat.User) string {
+	if accountUser, ok := user.(chat.AccountUser); ok {
+		return accountUser.Account()
+	}
+	return ""
+}
+
+// identify はルームの作成・参加リクエストのユーザー名を決めます。
+// ログイントークンがある場合はアカウントの名前を使い、ない場合はゲストとして登録ユーザーや予約された名前でないことを確認します。
+func (s *TCPServer) identify(request ClientRequest) (userName, account string, err error) {
+	if request.LoginToken != "" {
+		if s.accounts == nil {
+			return "", "", errAccountsDisabled
+		}
+		a, err := s.accounts.LookupLogin(request.LoginToken)
+		if err != nil {
+			return "", "", err
+		}
+		return a.Name, a.Name, nil
+	}
+
+	if err := command.ValidateName(request.UserName); err != nil {
+		return "", "", err
+	}
+	if s.accounts != nil && s.accounts.IsReserved(request.UserName) {
+		return "", "", fmt.Errorf("%s: %w", request.UserName, auth.ErrNameReserved)
+	}
+	return request.UserName, "", nil
+}
+
+// handleRegisterRequest はクライアントからのアカウントの登録リクエストを処理します。
+// 登録できた場合はそのままログインし、ログイントークンを返します。
+func (s *TCPServer) handleRegisterRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("アカウントの登録リクエストを受けました")
+
+	if s.accounts == nil {
+		return reject(conn, protocol.OperationRegister, errAccountsDisabled)
+	}
+	if err := command.ValidateName(request.UserName); err != nil {
+		return reject(conn, protocol.OperationRegister, err)
+	}
+	account, err := s.accounts.Register(request.UserName, request.Password)
+	if err != nil {
+		return reject(conn, protocol.OperationRegister, fmt.Errorf("アカウントの登録に失敗しました: %w", err))
+	}
+	token, expiresAt := s.accounts.IssueLogin(account)
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationRegister, protocol.StatusOK, ""); err != nil {
+		return err
+	}
+
+	// リクエストの完了 (2)
+	if err := sendTCRP(conn, protocol.OperationRegister, protocol.StateComplete, LoginResponse{LoginToken: token, UserName: account.Name, ExpiresAt: expiresAt}); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	logger.Info("アカウントを登録しました")
+	return nil
+}
+
+// handleLoginRequest はクライアントからのログインリクエストを処理します。
+// 名前とパスワードが正しい場合は、ルームの作成・参加に使うログイントークンを返します。
+func (s *TCPServer) handleLoginRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("ログインリクエストを受けました")
+
+	if s.accounts == nil {
+		return reject(conn, protocol.OperationLogin, errAccountsDisabled)
+	}
+	token, account, expiresAt, err := s.accounts.Login(request.UserName, request.Password)
+	if err != nil {
+		return reject(conn, protocol.OperationLogin, fmt.Errorf("ログインに失敗しました: %w", err))
+	}
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationLogin, protocol.StatusOK, ""); err != nil {
+		return err
+	}
+
+	// リクエストの完了 (2)
+	if err := sendTCRP(conn, protocol.OperationLogin, protocol.StateComplete, LoginResponse{LoginToken: token, UserName: account.Name, ExpiresAt: expiresAt}); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	logger.Info("ログインしました", "account", account.Name)
+	return nil
+}
@@ -0,0 +1,280 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+	"online_chat_messenger/internal/protocol"
+)
+
+// renamingRoom はメンバーの名前を変更できるルームです。
+type renamingRoom interface {
+	RenameUser(user chat.User, name string) error
+}
+
+// roomSaver はルームを保存し直せるRoomManagerです。
+type roomSaver interface {
+	SaveRoom(name string) error
+}
+
+// runCommand はコマンドを実行します。解析や権限の確認、実行に失敗した場合は実行したユーザーにだけ理由を返します。
+// hostはトークンの役割で判断した、ユーザーがホストとして参加しているかどうかです。
+func (s *UDPServer) runCommand(room chat.Room, user chat.User, host bool, action command.Action, err error, logger *slog.Logger) {
+	if err == nil && command.RequiresHost(action) && !host {
+		err = command.ErrPermissionDenied
+	}
+	if err == nil {
+		logger = logger.With("command", action.Spec().Name)
+		switch a := action.(type) {
+		case command.Who:
+			s.commandWho(room, user)
+		case command.DirectMessage:
+			err = s.sendDirect(room, user, a.To, a.Text, logger)
+		case command.Me:
+			s.broadcastToRoom(s.conn, room, protocol.KindAction, a.Text, user, logger)
+		case command.Nick:
+			err = s.commandNick(room, user, a.Name, logger)
+		case command.Info:
+			s.NotifyUser(user, formatRoomInfo(room))
+		case command.Topic:
+			err = s.commandTopic(room, user, a.Text, logger)
+		case command.Description:
+			err = s.commandDescription(room, user, a.Text, logger)
+		case command.Limit:
+			err = s.commandLimit(room, user, a, logger)
+		case command.Kick:
+			err = s.commandKick(room, user, a.User, logger)
+		default:
+			err = command.ErrUnknownCommand
+		}
+	}
+
+	if err != nil {
+		logger.Info("コマンドを実行できませんでした", "error", err)
+		if errors.Is(err, command.ErrUnknownCommand) {
+			err = fmt.Errorf("%w（/help でコマンドの一覧を表示します）", err)
+		}
+		s.NotifyUser(user, "エラー: "+err.Error())
+		return
+	}
+	logger.Debug("コマンドを実行しました")
+}
+
+// commandWho はルームのメンバーを名前順に返します。
+func (s *UDPServer) commandWho(room chat.Room, user chat.User) {
+	users := room.GetUsers()
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		name := u.GetName()
+		// 登録ユーザーは名前を変更していてもアカウントで見分けられるようにする
+		switch account := accountOf(u); {
+		case account == "":
+		case chat.SameName(account, name):
+			name += "（登録ユーザー）"
+		default:
+			name += "（登録ユーザー: " + account + "）"
+		}
+		if u.IsHost() {
+			name += "（ホスト）"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s.NotifyUser(user, fmt.Sprintf("メンバー（%d人）: %s", len(names), strings.Join(names, ", ")))
+}
+
+// sendDirect はメッセージを宛先のユーザーのUDPアドレスだけに送信します。
+// 宛先は送信者と同じルームから探し、いなければサーバー全体から探します。同じ名前のユーザーが複数いる場合は全員に送信します。
+// 個人宛てのメッセージはルームの履歴・メッセージログ・検索インデックスには記録しません。
+func (s *UDPServer) sendDirect(room chat.Room, sender chat.User, recipientName, text string, logger *slog.Logger) error {
+	recipients := findUsersByName(room.GetUsers(), recipientName, sender)
+	if len(recipients) == 0 {
+		for _, r := range s.roomManager.GetAllRooms() {
+			if r.GetName() != room.GetName() {
+				recipients = append(recipients, findUsersByName(r.GetUsers(), recipientName, sender)...)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("%s というユーザーは見つかりませんでした", recipientName)
+	}
+
+	data, err := encodeServerMessage(protocol.KindDirect, chat.Message{Sender: sender.GetName(), Text: text, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("メッセージのエンコードに失敗しました: %w", err)
+	}
+	s.sendToUsers(s.conn, recipients, data, logger)
+	return nil
+}
+
+// commandNick はユーザーの名前を変更し、ルームに知らせます。
+func (s *UDPServer) commandNick(room chat.Room, user chat.User, name string, logger *slog.Logger) error {
+	renaming, ok := room.(renamingRoom)
+	if !ok {
+		return errors.New("名前を変更できません")
+	}
+	oldName := user.GetName()
+	if name == oldName {
+		return nil
+	}
+	// 自分のアカウントの名前以外の登録ユーザー・予約された名前には変更できない
+	if s.accounts != nil && !chat.SameName(name, accountOf(user)) && s.accounts.IsReserved(name) {
+		return fmt.Errorf("%s は登録ユーザーか予約された名前のため使えません", name)
+	}
+	// 大文字・小文字や全角・半角だけが違う名前への変更は許可する（他のメンバーと同じかどうかはルームが確認する）
+	if err := renaming.RenameUser(user, name); err != nil {
+		if errors.Is(err, chat.ErrNameTaken) {
+			return fmt.Errorf("%s という名前はすでに使われています", name)
+		}
+		return errors.New("名前を変更できません")
+	}
+	if saver, ok := s.userManager.(sessionSaver); ok {
+		saver.SaveSession(user.GetToken())
+	}
+
+	if data, err := encodeServerMessage(protocol.KindRenamed, chat.Message{Sender: name, Text: "名前を " + name + " に変更しました", Time: time.Now()}); err == nil {
+		s.sendToUsers(s.conn, []chat.User{user}, data, logger)
+	}
+	s.NotifyRoom(room, oldName+" は名前を "+name+" に変更しました")
+	logger.Info("ユーザーの名前を変更しました", "old", oldName, "new", name)
+	return nil
+}
+
+// commandTopic はルームのトピックを表示します。textが空でない場合はトピックを変更してルームに知らせます。
+func (s *UDPServer) commandTopic(room chat.Room, user chat.User, text string, logger *slog.Logger) error {
+	if text == "" {
+		if topic := room.Metadata().Topic; topic != "" {
+			s.NotifyUser(user, "トピック: "+topic)
+		} else {
+			s.NotifyUser(user, "トピックは設定されていません")
+		}
+		return nil
+	}
+
+	if err := s.updateMetadata(room, func(meta *chat.Metadata) { meta.Topic = text }); err != nil {
+		return err
+	}
+	s.NotifyRoom(room, user.GetName()+" がトピックを「"+text+"」に変更しました")
+	logger.Info("トピックを変更しました")
+	return nil
+}
+
+// commandDescription はルームの説明を表示します。textが空でない場合は説明を変更してルームに知らせます。
+func (s *UDPServer) commandDescription(room chat.Room, user chat.User, text string, logger *slog.Logger) error {
+	if text == "" {
+		if description := room.Metadata().Description; description != "" {
+			s.NotifyUser(user, "説明: "+description)
+		} else {
+			s.NotifyUser(user, "説明は設定されていません")
+		}
+		return nil
+	}
+
+	if err := s.updateMetadata(room, func(meta *chat.Metadata) { meta.Description = text }); err != nil {
+		return err
+	}
+	s.NotifyRoom(room, user.GetName()+" がルームの説明を変更しました: "+text)
+	logger.Info("ルームの説明を変更しました")
+	return nil
+}
+
+// commandLimit はルームのメンバー数の上限を表示します。limit.Setがtrueの場合は上限を変更してルームに知らせます。
+func (s *UDPServer) commandLimit(room chat.Room, user chat.User, limit command.Limit, logger *slog.Logger) error {
+	if !limit.Set {
+		s.NotifyUser(user, "メンバー数の上限: "+formatMaxMembers(room.Metadata().MaxMembers))
+		return nil
+	}
+
+	if err := s.updateMetadata(room, func(meta *chat.Metadata) { meta.MaxMembers = limit.Max }); err != nil {
+		return err
+	}
+	s.NotifyRoom(room, user.GetName()+" がメンバー数の上限を"+formatMaxMembers(limit.Max)+"に変更しました")
+	logger.Info("メンバー数の上限を変更しました", "max_members", limit.Max)
+	return nil
+}
+
+// updateMetadata はルームの付加情報を変更して保存し直します。
+func (s *UDPServer) updateMetadata(room chat.Room, update func(meta *chat.Metadata)) error {
+	meta := room.Metadata()
+	update(&meta)
+	if err := meta.Validate(); err != nil {
+		return err
+	}
+	room.SetMetadata(meta)
+	if saver, ok := s.roomManager.(roomSaver); ok {
+		if err := saver.SaveRoom(room.GetName()); err != nil {
+			s.logger.Warn("ルームの保存に失敗しました", "room", room.GetName(), "error", err)
+		}
+	}
+	return nil
+}
+
+// formatRoomInfo はルームの付加情報を表示用の文字列にします。
+func formatRoomInfo(room chat.Room) string {
+	meta := room.Metadata()
+	lines := []string{"ルーム: " + room.GetName()}
+	if meta.Topic != "" {
+		lines = append(lines, "トピック: "+meta.Topic)
+	}
+	if meta.Description != "" {
+		lines = append(lines, "説明: "+meta.Description)
+	}
+	if meta.Creator != "" {
+		lines = append(lines, "作成者: "+meta.Creator)
+	}
+	lines = append(lines,
+		"作成日時: "+meta.CreatedAt.Local().Format(time.DateTime),
+		fmt.Sprintf("メンバー: %d人（上限: %s）", len(room.GetUsers()), formatMaxMembers(meta.MaxMembers)),
+	)
+	if meta.InviteOnly {
+		lines = append(lines, "参加: 招待制")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatMaxMembers はメンバー数の上限を表示用の文字列にします。
+func formatMaxMembers(maxMembers int) string {
+	if maxMembers == 0 {
+		return "なし"
+	}
+	return fmt.Sprintf("%d人", maxMembers)
+}
+
+// commandKick はユーザーをルームから退出させ、トークンを無効にします。
+func (s *UDPServer) commandKick(room chat.Room, host chat.User, name string, logger *slog.Logger) error {
+	if chat.SameName(name, host.GetName()) {
+		return errors.New("自分自身は退出させられません")
+	}
+	targets := findUsersByName(room.GetUsers(), name, host)
+	if len(targets) == 0 {
+		return fmt.Errorf("%s というユーザーはこのルームにいません", name)
+	}
+
+	for _, target := range targets {
+		s.NotifyUser(target, "ホストによってルームから退出させられました")
+		room.RemoveUser(target)
+		s.userManager.DeleteUser(target.GetToken())
+		revokeToken(s.tokens, target.GetToken(), logger)
+	}
+	s.NotifyRoom(room, host.GetName()+" が "+name+" を退出させました")
+	logger.Info("ユーザーを退出させました", "target", name, "count", len(targets))
+	return nil
+}
+
+// findUsersByName は名前が一致するユーザーを返します。名前はchat.SameNameで比較します。
+// exceptのユーザー（コマンドを実行したユーザー自身）は含めません。
+func findUsersByName(users []chat.User, name string, except chat.User) []chat.User {
+	var found []chat.User
+	for _, user := range users {
+		if chat.SameName(user.GetName(), name) && user.GetToken() != except.GetToken() {
+			found = append(found, user)
+		}
+	}
+	return found
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+	"online_chat_messenger/internal/protocol"
+)
+
+// inviteRoom は招待コードを扱えるルームです。
+type inviteRoom interface {
+	CreateInvite(createdBy string, ttl time.Duration, singleUse bool) (chat.Invite, error)
+	Invites() []chat.Invite
+	RevokeInvite(code string) error
+	AddUserWithInvite(user chat.User, code string) error
+}
+
+// 招待リクエストの操作です。
+const (
+	inviteActionCreate = "create"
+	inviteActionList   = "list"
+	inviteActionRevoke = "revoke"
+)
+
+// InviteResponse は招待リクエストの完了応答のペイロードです。
+// 発行した場合はInviteに、一覧の場合はInvitesに招待コードが入ります。
+type InviteResponse struct {
+	Invite  *chat.Invite  `json:"invite,omitempty"`
+	Invites []chat.Invite `json:"invites,omitempty"`
+}
+
+// joinWithInvite は招待コードを確認して、招待制のルームにユーザーを追加します。
+// 招待コードの使用状況が変わるため、参加できた場合はルームを保存し直します。
+func (s *TCPServer) joinWithInvite(room chat.Room, user chat.User, code string) error {
+	inviting, ok := room.(inviteRoom)
+	if !ok {
+		return errors.New("ルームが招待コードに対応していません")
+	}
+	if err := inviting.AddUserWithInvite(user, code); err != nil {
+		return err
+	}
+	s.saveRoom(room)
+	return nil
+}
+
+// saveRoom はルームの招待コードなどを変更したあとに、ルームを保存し直します。
+func (s *TCPServer) saveRoom(room chat.Room) {
+	if saver, ok := s.roomManager.(roomSaver); ok {
+		if err := saver.SaveRoom(room.GetName()); err != nil {
+			s.logger.Warn("ルームの保存に失敗しました", "room", room.GetName(), "error", err)
+		}
+	}
+}
+
+// handleInviteRequest はクライアントからの招待コードの発行・一覧・取り消しリクエストを処理します。
+// 実行できるのはトークンのユーザーがホストとして参加しているルームだけで、できない場合は理由に対応するステータスコードの準拠応答を返します。
+func (s *TCPServer) handleInviteRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger = logger.With("action", request.Action)
+	logger.Info("招待リクエストを受けました")
+
+	// トークンのユーザーがルームのホストか確認する
+	room, user, host, err := s.session(request)
+	if err != nil {
+		return reject(conn, protocol.OperationInvite, err)
+	}
+	if !host {
+		return reject(conn, protocol.OperationInvite, command.ErrPermissionDenied)
+	}
+	inviting, ok := room.(inviteRoom)
+	if !ok {
+		return reject(conn, protocol.OperationInvite, errors.New("ルームが招待コードに対応していません"))
+	}
+
+	var response InviteResponse
+	switch request.Action {
+	case inviteActionCreate:
+		invite, err := inviting.CreateInvite(user.GetName(), time.Duration(request.InviteTTL)*time.Second, request.SingleUse)
+		if err != nil {
+			return reject(conn, protocol.OperationInvite, err)
+		}
+		response.Invite = &invite
+		s.saveRoom(room)
+		logger.Info("招待コードを発行しました", "expires_at", invite.ExpiresAt, "single_use", invite.SingleUse)
+	case inviteActionList:
+		response.Invites = inviting.Invites()
+	case inviteActionRevoke:
+		if err := inviting.RevokeInvite(request.InviteCode); err != nil {
+			return reject(conn, protocol.OperationInvite, err)
+		}
+		s.saveRoom(room)
+		logger.Info("招待コードを取り消しました")
+	default:
+		return reject(conn, protocol.OperationInvite, fmt.Errorf("%w: 不明な操作です: %q", chat.ErrInvalidInviteOptions, request.Action))
+	}
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationInvite, protocol.StatusOK, ""); err != nil {
+		return err
+	}
+
+	// リクエストの完了 (2)
+	if err := sendTCRP(conn, protocol.OperationInvite, protocol.StateComplete, response); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// rateLimiter はキー（トークン）ごとのトークンバケットで送信頻度を制限します。
+type rateLimiter struct {
+	rate    float64 // 1秒あたりに補充する数（0は無制限）
+	burst   int     // バケットの容量
+	buckets map[string]*bucket
+	now     func() time.Time // テストで時刻を差し替える
+	mutex   sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxIdleBuckets を超えたら満タンになったバケットを削除してメモリを解放する
+const maxIdleBuckets = 1024
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// SetRate は送信頻度の上限を変更します。既存のバケットは新しい容量に合わせて切り詰められます。
+func (l *rateLimiter) SetRate(rate float64, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rate = rate
+	l.burst = burst
+	for _, b := range l.buckets {
+		b.tokens = min(b.tokens, float64(burst))
+	}
+}
+
+// Allow はkeyが今送信してよいかを返します。
+func (l *rateLimiter) Allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.pruneLocked(now)
+		}
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// 経過時間に応じてトークンを補充する
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.rate, float64(l.burst))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// pruneLocked は満タンまで回復したバケットを削除します。
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// wordFilter は禁止語を伏せ字に置き換えます。
+type wordFilter struct {
+	pattern *regexp.Regexp // nilの場合は何もしない
+	mutex   sync.RWMutex
+}
+
+// SetWords は禁止語を設定します。大文字と小文字は区別しません。
+func (f *wordFilter) SetWords(words []string) {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+
+	var pattern *regexp.Regexp
+	if len(quoted) > 0 {
+		pattern = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pattern = pattern
+}
+
+// Censor はメッセージ中の禁止語を同じ文字数の "*" に置き換えます。
+func (f *wordFilter) Censor(message string) string {
+	f.mutex.RLock()
+	pattern := f.pattern
+	f.mutex.RUnlock()
+
+	if pattern == nil {
+		return message
+	}
+	return pattern.ReplaceAllStringFunc(message, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
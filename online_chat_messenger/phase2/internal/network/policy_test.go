@@ -0,0 +1,152 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock はテストで進める時計です。
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestRateLimiter(rate float64, burst int) (*rateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter()
+	l.now = clock.now
+	l.SetRate(rate, burst)
+	return l, clock
+}
+
+// allowed はkeyでn回送信し、許可された回数を返します。
+func allowed(l *rateLimiter, key string, n int) int {
+	count := 0
+	for range n {
+		if l.Allow(key) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l, _ := newTestRateLimiter(0, 0)
+	if got := allowed(l, "taro", 1000); got != 1000 {
+		t.Errorf("allowed %d of 1000 without a limit", got)
+	}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l, clock := newTestRateLimiter(2, 3)
+
+	if got := allowed(l, "taro", 5); got != 3 {
+		t.Errorf("allowed %d at once, want the burst of 3", got)
+	}
+	// キーごとに別のバケットを使う
+	if !l.Allow("hanako") {
+		t.Error("another key was limited")
+	}
+
+	// 0.5秒で1つ補充される
+	clock.advance(400 * time.Millisecond)
+	if l.Allow("taro") {
+		t.Error("allowed before a token was refilled")
+	}
+	clock.advance(100 * time.Millisecond)
+	if !l.Allow("taro") {
+		t.Error("not allowed after a token was refilled")
+	}
+
+	// 長く待っても容量までしか貯まらない
+	clock.advance(time.Hour)
+	if got := allowed(l, "taro", 5); got != 3 {
+		t.Errorf("allowed %d after an hour, want the burst of 3", got)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l, clock := newTestRateLimiter(1, 5)
+	if !l.Allow("taro") {
+		t.Fatal("not allowed")
+	}
+
+	// 容量を小さくすると既存のバケットも切り詰められる
+	l.SetRate(1, 2)
+	if got := allowed(l, "taro", 5); got != 2 {
+		t.Errorf("allowed %d after lowering the burst, want 2", got)
+	}
+
+	// 補充の速さも新しい値になる
+	l.SetRate(10, 2)
+	clock.advance(100 * time.Millisecond)
+	if !l.Allow("taro") {
+		t.Error("the new rate was not used")
+	}
+
+	// 0にすると無制限になる
+	l.SetRate(0, 0)
+	if got := allowed(l, "taro", 100); got != 100 {
+		t.Errorf("allowed %d of 100 after removing the limit", got)
+	}
+}
+
+func TestRateLimiterPrunesFullBuckets(t *testing.T) {
+	l, clock := newTestRateLimiter(1, 1)
+	for i := range maxIdleBuckets {
+		l.Allow(fmt.Sprintf("user%d", i))
+	}
+	if len(l.buckets) != maxIdleBuckets {
+		t.Fatalf("buckets = %d", len(l.buckets))
+	}
+
+	// まだ回復していないバケットは残す
+	l.Allow("new1")
+	if len(l.buckets) != maxIdleBuckets+1 {
+		t.Errorf("buckets = %d, want none pruned", len(l.buckets))
+	}
+
+	// 満タンまで回復したバケットは削除する
+	clock.advance(time.Second)
+	l.Allow("new2")
+	if len(l.buckets) != 1 {
+		t.Errorf("buckets = %d, want only the new one", len(l.buckets))
+	}
+	if _, ok := l.buckets["new2"]; !ok {
+		t.Error("the new bucket was pruned")
+	}
+}
+
+func TestWordFilter(t *testing.T) {
+	var f wordFilter
+	if got := f.Censor("spam です"); got != "spam です" {
+		t.Errorf("Censor without words = %q", got)
+	}
+
+	f.SetWords([]string{"spam", " 馬鹿 ", "", "a.b"})
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"spam です", "**** です"},
+		{"SPAM と Spam", "**** と ****"},
+		{"この馬鹿者", "この**者"},
+		{"a.b と axb", "*** と axb"},
+		{"問題ありません", "問題ありません"},
+	}
+	for _, tt := range tests {
+		if got := f.Censor(tt.message); got != tt.want {
+			t.Errorf("Censor(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+
+	// 空にすると何も置き換えない
+	f.SetWords(nil)
+	if got := f.Censor("spam"); got != "spam" {
+		t.Errorf("Censor after clearing = %q", got)
+	}
+}
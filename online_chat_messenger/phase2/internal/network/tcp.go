@@ -2,21 +2,58 @@ package network
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"online_chat_messenger/internal/auth"
 	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+	"online_chat_messenger/internal/metrics"
+	"online_chat_messenger/internal/msglog"
 	"online_chat_messenger/internal/protocol"
+	"online_chat_messenger/internal/search"
+	"online_chat_messenger/internal/transcript"
 )
 
+// errUnknownRequest は対応していないオペレーション・状態のリクエストを表します。
+var errUnknownRequest = errors.New("不明なリクエストです")
+
 // ClientRequest はクライアントからのリクエストを表します。
 type ClientRequest struct {
-	RoomName  string `json:"room_name"`
-	Password  string `json:"password,omitempty"`
-	UserName  string `json:"user_name"`
-	Operation uint8  // protocol/tcrp.go の operationと対応させる
-	State     uint8  // protocol/tcrp.go の stateと対応させる
+	RoomName    string    `json:"room_name"`
+	Password    string    `json:"password,omitempty"` // ルームのパスワード（登録・ログインリクエストではアカウントのパスワード）
+	UserName    string    `json:"user_name"`
+	Topic       string    `json:"topic,omitempty"`       // ルーム作成リクエストで設定するトピック
+	Description string    `json:"description,omitempty"` // ルーム作成リクエストで設定する説明
+	MaxMembers  int       `json:"max_members,omitempty"` // ルーム作成リクエストで設定するメンバー数の上限
+	Token       string    `json:"token,omitempty"`       // 退出・検索・書き出しリクエストで使用する
+	Query       string    `json:"query,omitempty"`       // 検索リクエストの検索語
+	Limit       int       `json:"limit,omitempty"`       // 検索リクエストで返す件数の上限
+	From        time.Time `json:"from,omitzero"`         // 書き出すメッセージの期間の開始（ゼロ値は制限なし）
+	To          time.Time `json:"to,omitzero"`           // 書き出すメッセージの期間の終了（ゼロ値は制限なし）
+	InviteOnly  bool      `json:"invite_only,omitempty"` // ルーム作成リクエストで招待制にする
+	InviteCode  string    `json:"invite_code,omitempty"` // 招待制のルームへの参加・招待コードの取り消しで使用する
+	Action      string    `json:"action,omitempty"`      // 招待リクエストの操作（create・list・revoke）
+	InviteTTL   int       `json:"invite_ttl,omitempty"`  // 発行する招待コードの有効期間（秒。0は期限なし）
+	SingleUse   bool      `json:"single_use,omitempty"`  // 発行する招待コードを1回だけ使えるようにする
+	LoginToken  string    `json:"login_token,omitempty"` // ログインしたアカウントとしてルームを作成・参加する
+	Operation   uint8     // protocol/tcrp.go の operationと対応させる
+	State       uint8     // protocol/tcrp.go の stateと対応させる
+}
+
+// LogValue はパスワードやトークンを含めずにリクエストをログに出力します。
+func (r ClientRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("operation", int(r.Operation)),
+		slog.Int("state", int(r.State)),
+		slog.String("room", r.RoomName),
+		slog.String("user", r.UserName),
+	)
 }
 
 // TCPServer はTCPサーバーを表します。
@@ -24,28 +61,134 @@ type TCPServer struct {
 	listener    net.Listener
 	roomManager chat.RoomManager
 	userManager auth.UserManager
+	logger      *slog.Logger
+	metrics     *metrics.Metrics
+	banList     *auth.BanList     // 接続を拒否するアドレス（nilの場合は拒否しない）
+	searchIndex *search.Index     // 履歴の検索に使うインデックス（nilの場合は検索できない）
+	messageLog  *msglog.Log       // 入退室の記録先・書き出しの取得元（nilの場合は記録・書き出ししない）
+	accounts    *auth.Accounts    // 登録ユーザーのアカウント（nilの場合は登録・ログインできない）
+	tokens      *auth.TokenSigner // ルームのトークンの署名（nilの場合は署名のないトークンを発行する）
+	connID      atomic.Uint64     // ログで接続を識別するための連番
+
+	maxMessageSize int           // 受信するTCRPメッセージの最大サイズ
+	readTimeout    time.Duration // リクエストの受信を待つ時間（0は無制限）
+	motd           string        // 入室時にクライアントへ返すメッセージ
+	settingsMutex  sync.RWMutex
 }
 
-// NewTCPServer は新しいTCPServerを生成します。
-func NewTCPServer(port string, roomManager *chat.SimpleRoomManager, userManager *auth.SimpleUserManager) (*TCPServer, error) {
+// DefaultMaxMessageSize は受信するメッセージのデフォルトの最大サイズです。
+const DefaultMaxMessageSize = 4096
+
+// NewTCPServer は指定されたポートで待ち受ける新しいTCPServerを生成します。
+func NewTCPServer(port string, roomManager chat.RoomManager, userManager auth.UserManager) (*TCPServer, error) {
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return nil, fmt.Errorf("TCPサーバーの起動に失敗しました: %w", err)
 	}
+	return NewTCPServerWithListener(listener, roomManager, userManager), nil
+}
+
+// NewTCPServerWithListener は既存のリスナーを使用する新しいTCPServerを生成します。
+// エフェメラルポートやインメモリのリスナー、継承したソケットなどを利用できます。
+func NewTCPServerWithListener(listener net.Listener, roomManager chat.RoomManager, userManager auth.UserManager) *TCPServer {
 	return &TCPServer{
-		listener:    listener,
-		roomManager: roomManager,
-		userManager: userManager,
-	}, nil
+		listener:       listener,
+		roomManager:    roomManager,
+		userManager:    userManager,
+		logger:         slog.Default().With("component", "tcp"),
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (s *TCPServer) SetLogger(logger *slog.Logger) {
+	s.logger = logger.With("component", "tcp")
+}
+
+// SetMetrics はメトリクスの記録先を設定します。
+func (s *TCPServer) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetBanList は接続を拒否するアドレスの一覧を設定します。
+func (s *TCPServer) SetBanList(banList *auth.BanList) {
+	s.banList = banList
+}
+
+// SetSearchIndex は履歴の検索に使うインデックスを設定します。
+func (s *TCPServer) SetSearchIndex(index *search.Index) {
+	s.searchIndex = index
+}
+
+// SetMessageLog は入退室を記録し、会話記録の書き出しに使うメッセージログを設定します。
+func (s *TCPServer) SetMessageLog(l *msglog.Log) {
+	s.messageLog = l
+}
+
+// SetAccounts は登録ユーザーのアカウントを設定します。ゲストは登録ユーザーや予約された名前を使えなくなります。
+func (s *TCPServer) SetAccounts(accounts *auth.Accounts) {
+	s.accounts = accounts
+}
+
+// SetTokenSigner はルームのトークンを署名するTokenSignerを設定します。UDPServerと同じものを渡してください。
+func (s *TCPServer) SetTokenSigner(tokens *auth.TokenSigner) {
+	s.tokens = tokens
+}
+
+// SetMaxMessageSize は受信するTCRPメッセージの最大サイズを設定します。
+func (s *TCPServer) SetMaxMessageSize(size int) {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.maxMessageSize = size
+}
+
+// SetMOTD は入室時にクライアントへ返すメッセージを設定します。
+func (s *TCPServer) SetMOTD(motd string) {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.motd = motd
+}
+
+// completePayload は入室の完了応答のペイロードにMOTDとルームのトピック・説明を加えます。
+func (s *TCPServer) completePayload(room chat.Room, payload map[string]string) map[string]string {
+	s.settingsMutex.RLock()
+	defer s.settingsMutex.RUnlock()
+	if s.motd != "" {
+		payload["motd"] = s.motd
+	}
+	meta := room.Metadata()
+	if meta.Topic != "" {
+		payload["topic"] = meta.Topic
+	}
+	if meta.Description != "" {
+		payload["description"] = meta.Description
+	}
+	return payload
+}
+
+// SetReadTimeout はリクエストの受信を待つ時間を設定します。0の場合は無制限です。
+func (s *TCPServer) SetReadTimeout(timeout time.Duration) {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.readTimeout = timeout
+}
+
+// Addr はTCPサーバーが待ち受けているアドレスを返します。
+func (s *TCPServer) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 // Start はTCPサーバーを起動し、クライアントからの接続を待ち受けます。
 func (s *TCPServer) Start() error {
-	fmt.Println("TCPサーバーを起動しました...")
+	s.logger.Info("TCPサーバーを起動しました", "addr", s.listener.Addr().String())
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			fmt.Printf("接続の受付に失敗しました: %v\n", err)
+			// リスナーが閉じられた場合はループを終了する
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.logger.Error("接続の受付に失敗しました", "error", err)
 			continue
 		}
 		go s.handleConnection(conn)
@@ -55,20 +198,36 @@ func (s *TCPServer) Start() error {
 // handleConnection はクライアントとの接続を処理します。
 func (s *TCPServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	fmt.Printf("クライアントが接続しました: %s\n", conn.RemoteAddr().String())
+	logger := s.logger.With("conn_id", s.connID.Add(1), "remote_addr", conn.RemoteAddr().String())
+	logger.Debug("クライアントが接続しました")
+
+	// 接続を拒否されたアドレスからのリクエストは処理しない
+	if s.banList != nil && s.banList.IsBanned(conn.RemoteAddr().String()) {
+		logger.Warn("接続を拒否されたアドレスからの接続を切断しました")
+		return
+	}
+
+	s.settingsMutex.RLock()
+	maxMessageSize, readTimeout := s.maxMessageSize, s.readTimeout
+	s.settingsMutex.RUnlock()
 
-	buffer := make([]byte, 4096)
+	if readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+	}
+
+	buffer := make([]byte, maxMessageSize)
 	n, err := conn.Read(buffer)
-	fmt.Println("ユーザーリクエストバイト数（State0）", n)
 	if err != nil {
-		fmt.Printf("データの受信に失敗しました: %v\n", err)
+		logger.Warn("データの受信に失敗しました", "error", err)
 		return
 	}
+	logger.Debug("リクエストを受信しました", "bytes", n)
 
 	// TCRPメッセージをデコードする
 	tcrpMsg, err := protocol.DecodeTCRPMessage(buffer[:n])
 	if err != nil {
-		fmt.Printf("TCRPメッセージのデコードに失敗しました: %v\n", err)
+		s.metrics.DecodeError("tcp")
+		logger.Warn("TCRPメッセージのデコードに失敗しました", "error", err)
 		return
 	}
 
@@ -76,188 +235,411 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 	var request ClientRequest
 	err = json.Unmarshal(tcrpMsg.Body, &request)
 	if err != nil {
-		fmt.Printf("JSONのデコードに失敗しました.State0（リクエスト）: %v\n", err)
+		s.metrics.DecodeError("tcp")
+		logger.Warn("リクエストのJSONのデコードに失敗しました", "error", err)
 		return
 	}
 	request.Operation = tcrpMsg.Header.Operation
 	request.State = tcrpMsg.Header.State
+	logger = logger.With("request", request)
 
 	// リクエストの種類に応じて処理を分岐する
 	switch {
-	case request.Operation == 1 && request.State == 0: // チャットルーム作成リクエスト (初期化)
-		s.handleCreateRoomRequest(conn, request)
-	case request.Operation == 2 && request.State == 0: // チャットルーム参加リクエスト (初期化)
-		s.handleJoinRoomRequest(conn, request)
+	case request.Operation == protocol.OperationCreateRoom && request.State == protocol.StateRequest: // チャットルーム作成リクエスト (初期化)
+		err = s.handleCreateRoomRequest(conn, request, logger)
+	case request.Operation == protocol.OperationJoinRoom && request.State == protocol.StateRequest: // チャットルーム参加リクエスト (初期化)
+		err = s.handleJoinRoomRequest(conn, request, logger)
+	case request.Operation == protocol.OperationLeaveRoom && request.State == protocol.StateRequest: // チャットルーム退出リクエスト (初期化)
+		err = s.handleLeaveRoomRequest(conn, request, logger)
+	case request.Operation == protocol.OperationSearch && request.State == protocol.StateRequest: // 履歴の検索リクエスト (初期化)
+		err = s.handleSearchRequest(conn, request, logger)
+	case request.Operation == protocol.OperationExport && request.State == protocol.StateRequest: // 会話記録の書き出しリクエスト (初期化)
+		err = s.handleExportRequest(conn, request, logger)
+	case request.Operation == protocol.OperationInvite && request.State == protocol.StateRequest: // 招待リクエスト (初期化)
+		err = s.handleInviteRequest(conn, request, logger)
+	case request.Operation == protocol.OperationRegister && request.State == protocol.StateRequest: // アカウントの登録リクエスト (初期化)
+		err = s.handleRegisterRequest(conn, request, logger)
+	case request.Operation == protocol.OperationLogin && request.State == protocol.StateRequest: // ログインリクエスト (初期化)
+		err = s.handleLoginRequest(conn, request, logger)
 	default:
-		fmt.Println("不明なリクエストです")
+		err = errUnknownRequest
 	}
-}
 
-// handleCreateRoomRequest はクライアントからのルーム作成リクエストを処理します。
-func (s *TCPServer) handleCreateRoomRequest(conn net.Conn, request ClientRequest) {
-	fmt.Printf("ルーム作成リクエストを受けました: %+v\n", request)
-
-	// ステータスコードを含むJSONペイロードを生成
-	statusPayload, err := json.Marshal(map[string]int{"status": 0}) // 成功
 	if err != nil {
-		fmt.Printf("JSONのエンコードに失敗しました: %v\n", err)
+		s.metrics.TCRPRequest(request.Operation, "error")
+		logger.Warn("リクエストの処理に失敗しました", "error", err)
 		return
 	}
+	s.metrics.TCRPRequest(request.Operation, "ok")
+}
 
-	// リクエストの応答 (1)
-	responseTCRPMessage := protocol.TCRPMessage{
+// sendTCRP はペイロードをJSONにエンコードし、TCRPメッセージとして送信します。
+func sendTCRP(conn net.Conn, operation, state uint8, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("JSONのエンコードに失敗しました: %w", err)
+	}
+
+	encoded, err := protocol.EncodeTCRPFrames(protocol.TCRPMessage{
 		Header: protocol.TCRPHeader{
-			Operation: 1,
-			State:     1,
+			Operation: operation,
+			State:     state,
 		},
-		Body: statusPayload,
-	}
-	encodedResponseMessage, err := protocol.EncodeTCRPMessage(responseTCRPMessage)
+		Body: body,
+	})
 	if err != nil {
-		fmt.Printf("TCRPメッセージのエンコードに失敗しました: %v\n", err)
-		return
+		return fmt.Errorf("TCRPメッセージのエンコードに失敗しました: %w", err)
 	}
 
-	fmt.Println("state1のエンコード済みデータ:", encodedResponseMessage)
-	_, err = conn.Write(encodedResponseMessage)
-	if err != nil {
-		fmt.Printf("データの送信に失敗しました: %v\n", err)
-		return
+	if _, err := conn.Write(encoded); err != nil {
+		return fmt.Errorf("データの送信に失敗しました: %w", err)
 	}
+	return nil
+}
 
-	// トークンを生成
-	token := auth.GenerateToken()
+// StatusResponse は準拠応答のペイロードです。
+type StatusResponse struct {
+	Status  uint8  `json:"status"`
+	Message string `json:"message,omitempty"`
+}
 
-	// チャットルームを作成し、ホストを設定
-	room, err := s.roomManager.CreateRoom(request.RoomName, request.Password)
-	if err != nil {
-		// ルーム作成失敗時の処理
-		return
+// sendStatus は準拠応答を送信します。
+func sendStatus(conn net.Conn, operation, status uint8, message string) error {
+	if err := sendTCRP(conn, operation, protocol.StateResponse, StatusResponse{Status: status, Message: message}); err != nil {
+		return fmt.Errorf("応答の送信に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// reject はエラーに対応するステータスコードの準拠応答を送信し、エラーを返します。
+func reject(conn net.Conn, operation uint8, err error) error {
+	status := statusOf(err)
+	message := protocol.StatusText(status)
+	if status == protocol.StatusInvalidRequest {
+		// 入力の誤りは理由をそのまま返す
+		message = err.Error()
 	}
+	if sendErr := sendStatus(conn, operation, status, message); sendErr != nil {
+		return errors.Join(err, sendErr)
+	}
+	return err
+}
 
-	user := chat.NewUser(request.UserName, token, conn.RemoteAddr().String())
+// statusOf はルームの操作で発生したエラーに対応するステータスコードを返します。
+func statusOf(err error) uint8 {
+	switch {
+	case errors.Is(err, chat.ErrInvalidMetadata), errors.Is(err, chat.ErrInvalidInviteOptions), errors.Is(err, command.ErrInvalidName), errors.Is(err, auth.ErrInvalidPassword):
+		return protocol.StatusInvalidRequest
+	case errors.Is(err, chat.ErrNameTaken):
+		return protocol.StatusNameTaken
+	case errors.Is(err, chat.ErrRoomExists):
+		return protocol.StatusRoomExists
+	case errors.Is(err, chat.ErrRoomNotFound):
+		return protocol.StatusRoomNotFound
+	case errors.Is(err, chat.ErrRoomLimitReached):
+		return protocol.StatusRoomLimitReached
+	case errors.Is(err, chat.ErrAddressRoomLimitReached):
+		return protocol.StatusAddressRoomLimitReached
+	case errors.Is(err, chat.ErrRoomFull):
+		return protocol.StatusRoomFull
+	case errors.Is(err, chat.ErrInviteRequired):
+		return protocol.StatusInviteRequired
+	case errors.Is(err, chat.ErrInvalidInvite):
+		return protocol.StatusInvalidInvite
+	case errors.Is(err, command.ErrPermissionDenied):
+		return protocol.StatusPermissionDenied
+	case errors.Is(err, auth.ErrNameReserved):
+		return protocol.StatusNameReserved
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return protocol.StatusInvalidCredentials
+	case errors.Is(err, auth.ErrLoginExpired):
+		return protocol.StatusLoginExpired
+	case errors.Is(err, chat.ErrWrongPassword):
+		return protocol.StatusWrongPassword
+	}
+	return protocol.StatusServerError
+}
 
-	err = room.AddUser(user, true) //trueでhostとして設定
-	if err != nil {
-		// ユーザー追加失敗時の処理
-		return
+// remoteHost は接続元のIPアドレスを返します。
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
 	}
-	s.userManager.RegisterUser(token, user)
+	return addr
+}
+
+// handleCreateRoomRequest はクライアントからのルーム作成リクエストを処理します。
+// ルームを作成できなかった場合は、理由に対応するステータスコードの準拠応答を返します。
+func (s *TCPServer) handleCreateRoomRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("ルーム作成リクエストを受けました")
 
-	// トークンを含むJSONペイロードを生成
-	payload, err := json.Marshal(map[string]string{"token": token, "roomName": room.GetName()})
+	userName, account, err := s.identify(request)
 	if err != nil {
-		fmt.Printf("JSONのエンコードに失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationCreateRoom, err)
 	}
 
-	// リクエストの完了 (2)
-	completeTCRPMessage := protocol.TCRPMessage{
-		Header: protocol.TCRPHeader{
-			Operation: 1,
-			State:     2,
-		},
-		Body: payload,
-	}
-	encodedCompleteMessage, err := protocol.EncodeTCRPMessage(completeTCRPMessage)
+	// チャットルームを作成し、ホストを設定
+	room, err := s.roomManager.CreateRoom(request.RoomName, request.Password, chat.Metadata{
+		Topic:       request.Topic,
+		Description: request.Description,
+		Creator:     userName,
+		CreatorAddr: remoteHost(conn),
+		MaxMembers:  request.MaxMembers,
+		InviteOnly:  request.InviteOnly,
+	})
 	if err != nil {
-		fmt.Printf("TCRPメッセージのエンコードに失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationCreateRoom, fmt.Errorf("ルームの作成に失敗しました: %w", err))
 	}
 
-	_, err = conn.Write(encodedCompleteMessage)
+	// トークンを生成
+	token, err := s.issueToken(account, room.GetName(), true)
 	if err != nil {
-		fmt.Printf("データの送信に失敗しました: %v\n", err)
-		return
+		s.roomManager.DeleteRoom(room.GetName())
+		return reject(conn, protocol.OperationCreateRoom, err)
 	}
-}
 
-// handleJoinRoomRequest はクライアントからのルーム参加リクエストを処理します。
-func (s *TCPServer) handleJoinRoomRequest(conn net.Conn, request ClientRequest) {
-	fmt.Printf("ルーム参加リクエストを受けました: %+v\n", request)
+	user := newUser(userName, token, conn.RemoteAddr().String(), account)
 
-	// ステータスコードを含むJSONペイロードを生成
-	statusPayload, err := json.Marshal(map[string]int{"status": 0}) // 成功
+	err = room.AddUser(user, true) //trueでhostとして設定
 	if err != nil {
-		fmt.Printf("JSONのエンコードに失敗しました: %v\n", err)
-		return
+		s.roomManager.DeleteRoom(room.GetName())
+		return reject(conn, protocol.OperationCreateRoom, fmt.Errorf("ホストの追加に失敗しました: %w", err))
 	}
+	s.userManager.RegisterUser(token, room.GetName(), user)
 
 	// リクエストの応答 (1)
-	responseTCRPMessage := protocol.TCRPMessage{
-		Header: protocol.TCRPHeader{
-			Operation: 2,
-			State:     1,
-		},
-		Body: statusPayload,
+	if err := sendStatus(conn, protocol.OperationCreateRoom, protocol.StatusOK, ""); err != nil {
+		s.cancelJoin(room, user, token)
+		s.roomManager.DeleteRoom(room.GetName())
+		return err
 	}
-	encodedResponseMessage, err := protocol.EncodeTCRPMessage(responseTCRPMessage)
-	if err != nil {
-		fmt.Printf("TCRPメッセージのエンコードに失敗しました: %v\n", err)
-		return
+
+	// リクエストの完了 (2)
+	payload := s.completePayload(room, map[string]string{"token": token, "roomName": room.GetName()})
+	if err := sendTCRP(conn, protocol.OperationCreateRoom, protocol.StateComplete, payload); err != nil {
+		s.cancelJoin(room, user, token)
+		s.roomManager.DeleteRoom(room.GetName())
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
 	}
+	recordEvent(s.messageLog, room.GetName(), user.GetName()+" がルームを作成しました", logger)
+	logger.Info("ルームを作成しました")
+	return nil
+}
 
-	fmt.Println("state1のエンコード済みデータ:", encodedResponseMessage)
-	_, err = conn.Write(encodedResponseMessage)
+// passwordRoom はパスワードを確認できるルームです。
+type passwordRoom interface {
+	CheckPassword(password string) bool
+}
+
+// handleJoinRoomRequest はクライアントからのルーム参加リクエストを処理します。
+// 入室できなかった場合は、理由に対応するステータスコードの準拠応答を返します。
+func (s *TCPServer) handleJoinRoomRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("ルーム参加リクエストを受けました")
+
+	userName, account, err := s.identify(request)
 	if err != nil {
-		fmt.Printf("データの送信に失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationJoinRoom, err)
 	}
 
 	// チャットルームを検索
 	room, err := s.roomManager.FindRoom(request.RoomName)
 	if err != nil {
-		// ルームが見つからない場合のエラー処理
-		fmt.Printf("ルームが見つかりませんでした: %v\n", err)
-		return
+		return reject(conn, protocol.OperationJoinRoom, fmt.Errorf("ルームが見つかりませんでした: %w", err))
 	}
 
-	// パスワードが一致するか確認（必要な場合）
-	// ...
+	// パスワードが一致するか確認（パスワードのないルームは確認しない）
+	if pr, ok := room.(passwordRoom); ok && !pr.CheckPassword(request.Password) {
+		logger.Warn("ルームのパスワードが一致しません")
+		return reject(conn, protocol.OperationJoinRoom, chat.ErrWrongPassword)
+	}
 
 	// トークンを生成
-	token := auth.GenerateToken()
+	token, err := s.issueToken(account, room.GetName(), false)
+	if err != nil {
+		return reject(conn, protocol.OperationJoinRoom, err)
+	}
 
 	// ユーザーを作成
-	user := chat.NewUser(request.UserName, token, conn.RemoteAddr().String())
+	user := newUser(userName, token, conn.RemoteAddr().String(), account)
 
-	// チャットルームに参加
-	err = room.AddUser(user, false) //falseでhostではない
+	// チャットルームに参加（招待制のルームは招待コードを確認する）
+	if room.Metadata().InviteOnly {
+		err = s.joinWithInvite(room, user, request.InviteCode)
+	} else {
+		err = room.AddUser(user, false) //falseでhostではない
+	}
 	if err != nil {
-		// 参加失敗時のエラー処理
-		fmt.Printf("ルームへの参加に失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationJoinRoom, fmt.Errorf("ルームへの参加に失敗しました: %w", err))
 	}
 
 	// ユーザーを登録
-	s.userManager.RegisterUser(token, user)
+	s.userManager.RegisterUser(token, room.GetName(), user)
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationJoinRoom, protocol.StatusOK, ""); err != nil {
+		s.cancelJoin(room, user, token)
+		return err
+	}
+
+	// リクエストの完了 (2)
+	payload := s.completePayload(room, map[string]string{"token": token})
+	if err := sendTCRP(conn, protocol.OperationJoinRoom, protocol.StateComplete, payload); err != nil {
+		s.cancelJoin(room, user, token)
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	recordEvent(s.messageLog, room.GetName(), user.GetName()+" が入室しました", logger)
+	logger.Info("ルームに参加しました")
+	return nil
+}
+
+// cancelJoin はトークンを受け取れなかったユーザーをルームとユーザーの表から削除します。
+// トークンを知らないクライアントは退出できないため、残すとルームのメンバー数や名前を占有したままになります。
+func (s *TCPServer) cancelJoin(room chat.Room, user chat.User, token string) {
+	room.RemoveUser(user)
+	s.userManager.DeleteUser(token)
+}
 
-	// トークンを含むJSONペイロードを生成
-	tokenPayload, err := json.Marshal(map[string]string{"token": token})
+// handleLeaveRoomRequest はクライアントからのルーム退出リクエストを処理します。
+// 退出できなかった場合は、理由に対応するステータスコードの準拠応答を返します。
+func (s *TCPServer) handleLeaveRoomRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("ルーム退出リクエストを受けました")
+
+	// トークンからルームとユーザーを検索
+	room, user, _, err := s.session(request)
 	if err != nil {
-		fmt.Printf("JSONのエンコードに失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationLeaveRoom, fmt.Errorf("ユーザーが見つかりませんでした: %w", err))
+	}
+
+	// ルームから削除し、トークンを無効化する
+	room.RemoveUser(user)
+	s.userManager.DeleteUser(request.Token)
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationLeaveRoom, protocol.StatusOK, ""); err != nil {
+		return err
 	}
 
 	// リクエストの完了 (2)
-	completeTCRPMessage := protocol.TCRPMessage{
-		Header: protocol.TCRPHeader{
-			Operation: 2,
-			State:     2,
-		},
-		Body: tokenPayload,
+	if err := sendTCRP(conn, protocol.OperationLeaveRoom, protocol.StateComplete, map[string]string{}); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
 	}
-	encodedCompleteMessage, err := protocol.EncodeTCRPMessage(completeTCRPMessage)
+	recordEvent(s.messageLog, room.GetName(), user.GetName()+" が退出しました", logger)
+	logger.Info("ルームから退出しました")
+	return nil
+}
+
+const (
+	// defaultSearchLimit は検索リクエストで件数が指定されなかった場合に返す件数です。
+	defaultSearchLimit = 20
+	// maxSearchLimit は検索リクエストで返す件数の上限です。
+	maxSearchLimit = 100
+)
+
+// SearchResponse は検索リクエストの完了応答のペイロードです。
+type SearchResponse struct {
+	Results []search.Document `json:"results"`
+}
+
+// handleSearchRequest はクライアントからの履歴の検索リクエストを処理します。
+// 検索できるのはトークンのユーザーが参加しているルームのメッセージだけで、できない場合は理由に対応するステータスコードの準拠応答を返します。
+func (s *TCPServer) handleSearchRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("検索リクエストを受けました")
+
+	if s.searchIndex == nil {
+		return reject(conn, protocol.OperationSearch, errors.New("検索は無効です"))
+	}
+
+	// トークンのユーザーがルームに参加しているか確認する
+	_, room, err := s.authorize(request)
 	if err != nil {
-		fmt.Printf("TCRPメッセージのエンコードに失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationSearch, err)
+	}
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationSearch, protocol.StatusOK, ""); err != nil {
+		return err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	results := s.searchIndex.Search(search.Query{Text: request.Query, Rooms: []string{room.GetName()}, Limit: limit})
+	if results == nil {
+		results = []search.Document{}
+	}
+
+	// リクエストの完了 (2)
+	if err := sendTCRP(conn, protocol.OperationSearch, protocol.StateComplete, SearchResponse{Results: results}); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	logger.Info("検索結果を返しました", "results", len(results))
+	return nil
+}
+
+// maxExportEntries は書き出しリクエストで返す件数の上限です。
+const maxExportEntries = 10000
+
+// ExportResponse は書き出しリクエストの完了応答のペイロードです。
+// 件数が上限を超えた場合は古いものから上限までを返し、Truncatedをtrueにします。
+type ExportResponse struct {
+	Entries   []transcript.Entry `json:"entries"`
+	Truncated bool               `json:"truncated,omitempty"`
+}
+
+// handleExportRequest はクライアントからの会話記録の書き出しリクエストを処理します。
+// 書き出せるのはトークンのユーザーが参加しているルームの記録だけで、できない場合は理由に対応するステータスコードの準拠応答を返します。
+// 形式への変換はクライアントが行います。
+func (s *TCPServer) handleExportRequest(conn net.Conn, request ClientRequest, logger *slog.Logger) error {
+	logger.Info("書き出しリクエストを受けました")
+
+	if s.messageLog == nil {
+		return reject(conn, protocol.OperationExport, errors.New("メッセージログが無効です"))
 	}
 
-	_, err = conn.Write(encodedCompleteMessage)
+	// トークンのユーザーがルームに参加しているか確認する
+	_, room, err := s.authorize(request)
 	if err != nil {
-		fmt.Printf("データの送信に失敗しました: %v\n", err)
-		return
+		return reject(conn, protocol.OperationExport, err)
+	}
+
+	response := ExportResponse{Entries: []transcript.Entry{}}
+	err = s.messageLog.Scan(room.GetName(), request.From, request.To, func(record msglog.Record) bool {
+		if len(response.Entries) >= maxExportEntries {
+			response.Truncated = true
+			return false
+		}
+		response.Entries = append(response.Entries, transcript.Entry{Time: record.Time, Sender: record.Sender, Text: record.Text, System: record.System})
+		return true
+	})
+	if err != nil {
+		return reject(conn, protocol.OperationExport, fmt.Errorf("メッセージログの読み込みに失敗しました: %w", err))
+	}
+
+	// リクエストの応答 (1)
+	if err := sendStatus(conn, protocol.OperationExport, protocol.StatusOK, ""); err != nil {
+		return err
+	}
+
+	// リクエストの完了 (2)
+	if err := sendTCRP(conn, protocol.OperationExport, protocol.StateComplete, response); err != nil {
+		return fmt.Errorf("完了応答の送信に失敗しました: %w", err)
+	}
+	logger.Info("会話記録を返しました", "entries", len(response.Entries), "truncated", response.Truncated)
+	return nil
+}
+
+// authorize はリクエストのトークンのユーザーと、ユーザーが参加しているルームを返します。
+// トークンが無効な場合やルームに参加していない場合はcommand.ErrPermissionDeniedを返します。
+func (s *TCPServer) authorize(request ClientRequest) (chat.User, chat.Room, error) {
+	room, user, _, err := s.session(request)
+	if err != nil {
+		return nil, nil, err
 	}
+	return user, room, nil
 }
 
 // Close はTCPサーバーを停止します。
@@ -0,0 +1,171 @@
+package network
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/protocol"
+)
+
+// failingConn は指定した回数だけ書き込みに成功し、それ以降は失敗する接続です。
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if c.writes <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	c.writes--
+	return len(b), nil
+}
+
+// fakeUsers はトークンで登録したユーザーを保持するだけのユーザーの表です。
+type fakeUsers struct {
+	mutex sync.Mutex
+	users map[string]chat.User
+}
+
+func (m *fakeUsers) RegisterUser(token, roomName string, user chat.User) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.users[token] = user
+	return nil
+}
+
+func (m *fakeUsers) FindUser(token string) (chat.User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	user, ok := m.users[token]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func (m *fakeUsers) DeleteUser(token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.users, token)
+	return nil
+}
+
+func (m *fakeUsers) len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.users)
+}
+
+func newTestTCPServer(t *testing.T) (*TCPServer, *chat.SimpleRoomManager, *fakeUsers) {
+	t.Helper()
+	rooms := chat.NewSimpleRoomManager()
+	t.Cleanup(func() { rooms.Close() })
+	users := &fakeUsers{users: make(map[string]chat.User)}
+	s := NewTCPServerWithListener(nil, rooms, users)
+	s.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s, rooms, users
+}
+
+// pipeConn は相手側を読み捨てるnet.Pipeの接続を返します。
+func pipeConn(t *testing.T) net.Conn {
+	t.Helper()
+	server, client := net.Pipe()
+	go io.Copy(io.Discard, client)
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server
+}
+
+func TestCreateRoomRollsBackWhenResponseFails(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		writes int
+	}{
+		{"応答の送信に失敗", 0},
+		{"完了応答の送信に失敗", 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, rooms, users := newTestTCPServer(t)
+			conn := &failingConn{Conn: pipeConn(t), writes: tt.writes}
+
+			err := s.handleCreateRoomRequest(conn, ClientRequest{RoomName: "lobby", UserName: "taro"}, s.logger)
+			if err == nil {
+				t.Fatal("handleCreateRoomRequest succeeded, want a send error")
+			}
+			if _, err := rooms.FindRoom("lobby"); !errors.Is(err, chat.ErrRoomNotFound) {
+				t.Errorf("FindRoom after a failed create = %v, want ErrRoomNotFound", err)
+			}
+			if n := users.len(); n != 0 {
+				t.Errorf("registered users = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestJoinRoomRollsBackWhenResponseFails(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		writes int
+	}{
+		{"応答の送信に失敗", 0},
+		{"完了応答の送信に失敗", 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, rooms, users := newTestTCPServer(t)
+			if err := s.handleCreateRoomRequest(pipeConn(t), ClientRequest{RoomName: "lobby", UserName: "taro"}, s.logger); err != nil {
+				t.Fatal(err)
+			}
+			conn := &failingConn{Conn: pipeConn(t), writes: tt.writes}
+
+			err := s.handleJoinRoomRequest(conn, ClientRequest{RoomName: "lobby", UserName: "hanako"}, s.logger)
+			if err == nil {
+				t.Fatal("handleJoinRoomRequest succeeded, want a send error")
+			}
+			room, err := rooms.FindRoom("lobby")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if members := room.GetUsers(); len(members) != 1 || members[0].GetName() != "taro" {
+				t.Errorf("members after a failed join = %v, want only the host", members)
+			}
+			if n := users.len(); n != 1 {
+				t.Errorf("registered users = %d, want only the host", n)
+			}
+		})
+	}
+}
+
+func TestClientRequestLogValue(t *testing.T) {
+	var buf strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	request := ClientRequest{
+		RoomName:   "lobby",
+		UserName:   "taro",
+		Password:   "open-sesame",
+		Token:      "secret-token",
+		LoginToken: "secret-login",
+		InviteCode: "secret-invite",
+		Operation:  protocol.OperationJoinRoom,
+		State:      protocol.StateRequest,
+	}
+	logger.Info("リクエストを受け付けました", "request", request)
+
+	got := buf.String()
+	for _, secret := range []string{"open-sesame", "secret-token", "secret-login", "secret-invite"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("the log contains %q: %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, `"request":{"operation":2,"state":0,"room":"lobby","user":"taro"}`) {
+		t.Errorf("the log does not describe the request: %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"fmt"
+	"log/slog"
+
+	"online_chat_messenger/internal/auth"
+	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+)
+
+// issueToken はルームに入室するユーザーのトークンを発行します。accountは登録ユーザーのアカウントの名前で、ゲストの場合は空です。
+// TokenSignerが設定されていない場合は、ユーザーの表のキーとしてだけ使う署名のないトークンを発行します。
+func (s *TCPServer) issueToken(account, roomName string, host bool) (string, error) {
+	if s.tokens == nil {
+		return auth.GenerateToken(), nil
+	}
+	token, _, err := s.tokens.Issue(account, roomName, host)
+	if err != nil {
+		return "", fmt.Errorf("トークンの発行に失敗しました: %w", err)
+	}
+	return token, nil
+}
+
+// session はリクエストのトークンを検証し、ルームと、ルームに参加しているトークンのユーザーと、ホストとして参加しているかどうかを返します。
+func (s *TCPServer) session(request ClientRequest) (chat.Room, chat.User, bool, error) {
+	return findSession(s.roomManager, s.userManager, s.tokens, request.Token, request.RoomName)
+}
+
+// findSession はトークンを検証し、ルームと、ルームに参加しているトークンのユーザーと、ホストとして参加しているかどうかを返します。
+//
+// 署名のあるトークンはユーザーの表を引きません。ルーム名・有効期限・取り消しと役割はトークンから確認し、
+// ユーザーはルームのメンバーからトークンで探します。ユーザーの表に登録がなくても、ルームに参加していれば使えます。
+// 署名のないトークンはユーザーの表のキーのため、ユーザーの表から探してからルームのメンバーかを確認します。
+//
+// トークンが無効な場合やルームに参加していない場合はcommand.ErrPermissionDeniedを、ルームがない場合はchat.ErrRoomNotFoundを返します。
+func findSession(rooms chat.RoomManager, users auth.UserManager, tokens *auth.TokenSigner, token, roomName string) (chat.Room, chat.User, bool, error) {
+	claims, err := verifyToken(tokens, token, roomName)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%w: %w", command.ErrPermissionDenied, err)
+	}
+	room, err := rooms.FindRoom(roomName)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("ルームが見つかりませんでした: %w", err)
+	}
+
+	if claims != nil {
+		user, ok := findMember(room, token)
+		if !ok {
+			return nil, nil, false, fmt.Errorf("ユーザーはこのルームに所属していません: %w", command.ErrPermissionDenied)
+		}
+		return room, user, claims.Role == auth.RoleHost, nil
+	}
+
+	user, err := users.FindUser(token)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("無効なトークン: %w: %w", command.ErrPermissionDenied, err)
+	}
+	if !isMember(room, user) {
+		return nil, nil, false, fmt.Errorf("ユーザーはこのルームに所属していません: %w", command.ErrPermissionDenied)
+	}
+	return room, user, user.IsHost(), nil
+}
+
+// verifyToken はTokenSignerが設定されている場合に、トークンがルームのために発行された有効なものかを確認し、トークンの内容を返します。
+// TokenSignerが設定されていない場合はnilを返します。
+func verifyToken(tokens *auth.TokenSigner, token, roomName string) (*auth.TokenClaims, error) {
+	if tokens == nil {
+		return nil, nil
+	}
+	claims, err := tokens.Verify(token, roomName)
+	if err != nil {
+		return nil, fmt.Errorf("無効なトークン: %w", err)
+	}
+	return &claims, nil
+}
+
+// findMember はルームのメンバーからトークンのユーザーを探します。
+func findMember(room chat.Room, token string) (chat.User, bool) {
+	for _, u := range room.GetUsers() {
+		if u.GetToken() == token {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// isMember はユーザーがルームに参加しているかどうかを返します。
+func isMember(room chat.Room, user chat.User) bool {
+	_, ok := findMember(room, user.GetToken())
+	return ok
+}
+
+// revokeToken は退出させたユーザーのトークンを取り消します。TokenSignerが設定されていない場合は何もしません。
+func revokeToken(tokens *auth.TokenSigner, token string, logger *slog.Logger) {
+	if tokens == nil {
+		return
+	}
+	if err := tokens.Revoke(token); err != nil {
+		logger.Warn("トークンの取り消しに失敗しました", "error", err)
+	}
+}
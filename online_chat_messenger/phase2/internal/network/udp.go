@@ -1,22 +1,64 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"online_chat_messenger/internal/auth"
 	"online_chat_messenger/internal/chat"
+	"online_chat_messenger/internal/command"
+	"online_chat_messenger/internal/metrics"
+	"online_chat_messenger/internal/msglog"
 	"online_chat_messenger/internal/protocol"
+	"online_chat_messenger/internal/search"
+	"sync"
+	"time"
 )
 
 // UDPServer はUDPサーバーを表します。
 type UDPServer struct {
-	conn        *net.UDPConn
+	conn        net.PacketConn
 	roomManager chat.RoomManager
 	userManager auth.UserManager
-	port        string
+	logger      *slog.Logger
+	metrics     *metrics.Metrics
+	messageLog  *msglog.Log       // メッセージの記録先（nilの場合は記録しない）
+	searchIndex *search.Index     // メッセージを登録する検索インデックス（nilの場合は登録しない）
+	accounts    *auth.Accounts    // /nick で使えない名前の確認に使う（nilの場合は確認しない）
+	tokens      *auth.TokenSigner // トークンの署名の検証に使う（nilの場合はユーザーの表だけで確認する）
+
+	maxPacketSize int // 受信するパケットの最大サイズ
+	historyReplay int // 入室したユーザーに送信する履歴の件数
+	settingsMutex sync.RWMutex
+
+	rateLimiter *rateLimiter // ユーザーごとの送信頻度の制限
+	wordFilter  wordFilter   // 禁止語の伏せ字
 }
 
-// NewUDPServer は新しいUDPServerを生成します。
+// DefaultMaxPacketSize は受信するパケットのデフォルトの最大サイズです。
+const DefaultMaxPacketSize = 4096
+
+// DefaultHistoryReplay は入室したユーザーに送信する履歴のデフォルトの件数です。
+const DefaultHistoryReplay = 20
+
+// activityUpdater はユーザーの最終アクティビティ時間を更新できるUserManagerです。
+type activityUpdater interface {
+	UpdateActivity(token string) error
+}
+
+// historyRoom はメッセージの履歴を保持するルームです。
+type historyRoom interface {
+	AddMessage(msg chat.Message)
+	History(n int) []chat.Message
+}
+
+// sessionSaver はユーザーのセッションを保存し直せるUserManagerです。
+type sessionSaver interface {
+	SaveSession(token string) error
+}
+
+// NewUDPServer は指定されたポートで待ち受ける新しいUDPServerを生成します。
 func NewUDPServer(port string, roomManager chat.RoomManager, userManager auth.UserManager) (*UDPServer, error) {
 	addr, err := net.ResolveUDPAddr("udp", ":"+port)
 	if err != nil {
@@ -28,20 +70,85 @@ func NewUDPServer(port string, roomManager chat.RoomManager, userManager auth.Us
 		return nil, fmt.Errorf("UDPサーバーの起動に失敗しました: %w", err)
 	}
 
+	return NewUDPServerWithConn(conn, roomManager, userManager), nil
+}
+
+// NewUDPServerWithConn は既存のPacketConnを使用する新しいUDPServerを生成します。
+// エフェメラルポートやラップしたコネクション、継承したソケットなどを利用できます。
+func NewUDPServerWithConn(conn net.PacketConn, roomManager chat.RoomManager, userManager auth.UserManager) *UDPServer {
 	return &UDPServer{
-		conn:        conn,
-		roomManager: roomManager,
-		userManager: userManager,
-		port:        port,
-	}, nil
+		conn:          conn,
+		roomManager:   roomManager,
+		userManager:   userManager,
+		logger:        slog.Default().With("component", "udp"),
+		maxPacketSize: DefaultMaxPacketSize,
+		historyReplay: DefaultHistoryReplay,
+		rateLimiter:   newRateLimiter(),
+	}
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (s *UDPServer) SetLogger(logger *slog.Logger) {
+	s.logger = logger.With("component", "udp")
+}
+
+// SetMetrics はメトリクスの記録先を設定します。
+func (s *UDPServer) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetMessageLog はブロードキャストしたメッセージを記録するログを設定します。
+func (s *UDPServer) SetMessageLog(l *msglog.Log) {
+	s.messageLog = l
+}
+
+// SetSearchIndex はブロードキャストしたメッセージを登録する検索インデックスを設定します。
+func (s *UDPServer) SetSearchIndex(index *search.Index) {
+	s.searchIndex = index
+}
+
+// SetAccounts は登録ユーザーのアカウントを設定します。/nick で登録ユーザーや予約された名前に変更できなくなります。
+func (s *UDPServer) SetAccounts(accounts *auth.Accounts) {
+	s.accounts = accounts
+}
+
+// SetTokenSigner はトークンの署名を検証するTokenSignerを設定します。TCPServerと同じものを渡してください。
+func (s *UDPServer) SetTokenSigner(tokens *auth.TokenSigner) {
+	s.tokens = tokens
+}
+
+// SetRateLimit はユーザーごとの送信頻度の上限を設定します。rateが0の場合は無制限です。
+func (s *UDPServer) SetRateLimit(rate float64, burst int) {
+	s.rateLimiter.SetRate(rate, burst)
+}
+
+// SetBannedWords は伏せ字にする禁止語を設定します。
+func (s *UDPServer) SetBannedWords(words []string) {
+	s.wordFilter.SetWords(words)
+}
+
+// SetMaxPacketSize は受信するパケットの最大サイズを設定します。
+func (s *UDPServer) SetMaxPacketSize(size int) {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.maxPacketSize = size
+}
+
+// SetHistoryReplay は入室したユーザーに送信する履歴の件数を設定します。0の場合は送信しません。
+func (s *UDPServer) SetHistoryReplay(n int) {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.historyReplay = n
+}
+
+// Addr はUDPサーバーが待ち受けているアドレスを返します。
+func (s *UDPServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
 }
 
 // Start はUDPサーバーを起動し、クライアントからのメッセージを待ち受けます。
 func (s *UDPServer) Start() error {
-	fmt.Printf("UDPサーバーを起動しました (ポート: %s)...\n", s.port)
-
-	// 現時点では受信処理は実装せず、サーバーの起動のみ行う
-	// 実際の処理は後で実装する
+	s.logger.Info("UDPサーバーを起動しました", "addr", s.conn.LocalAddr().String())
 
 	s.handleConnection(s.conn)
 	return nil
@@ -55,28 +162,38 @@ func (s *UDPServer) Close() error {
 	return nil
 }
 
-func (s *UDPServer) handleConnection(conn *net.UDPConn) {
+func (s *UDPServer) handleConnection(conn net.PacketConn) {
 	defer conn.Close()
 	for {
-		buf := make([]byte, 4096)
-		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		s.settingsMutex.RLock()
+		buf := make([]byte, s.maxPacketSize)
+		s.settingsMutex.RUnlock()
+
+		n, remoteAddr, err := conn.ReadFrom(buf)
 		if err != nil {
-			fmt.Println("Error reading from UDP:", err)
+			// コネクションが閉じられた場合はループを終了する
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Error("UDPパケットの受信に失敗しました", "error", err)
 			continue
 		}
 
-		fmt.Printf("Received from %v\n", remoteAddr)
+		s.metrics.UDPPacketReceived()
+		logger := s.logger.With("remote_addr", remoteAddr.String())
+		logger.Debug("UDPパケットを受信しました", "bytes", n)
 
 		// バイトデータをUDPMessage構造体にデコード
 		udpMessage, err := protocol.DecodeUDPMessage(buf[:n])
 		if err != nil {
-			fmt.Println("クライアントメッセージをデコードできませんでした:", err)
+			s.metrics.DecodeError("udp")
+			logger.Warn("クライアントメッセージをデコードできませんでした", "error", err)
 			continue
 		}
 
 		// ヘッダーからサイズ情報を取得
-		roomNameSize := udpMessage.Header.RoomNameSize
-		tokenSize := udpMessage.Header.TokenSize
+		roomNameSize := int(udpMessage.Header.RoomNameSize)
+		tokenSize := int(udpMessage.Header.TokenSize)
 
 		// ボディからルーム名を取得
 		roomName := string(udpMessage.Body[:roomNameSize])
@@ -89,37 +206,69 @@ func (s *UDPServer) handleConnection(conn *net.UDPConn) {
 		// ボディからメッセージを取得
 		message := string(udpMessage.Body[tokenEnd:])
 
-		fmt.Printf("ルーム名: %s, トークン: %s, メッセージ: %s\n", roomName, token, message)
-
-		// ユーザーのアクティビティを更新
-		if userManager, ok := s.userManager.(*auth.SimpleUserManager); ok {
-			userManager.UpdateActivity(token)
-		}
+		logger = logger.With("room", roomName)
 
 		// トークンの検証処理
-		user, err := s.validateToken(token, roomName)
+		room, user, host, err := s.validateToken(token, roomName)
 		if err != nil {
-			fmt.Printf("トークン検証エラー: %v\n", err)
-			// エラーメッセージをクライアントに返す処理を追加することも可能
+			s.metrics.TokenValidationFailed()
+			logger.Warn("トークンの検証に失敗しました", "error", err)
+			// 有効期限の切れたトークンは署名が正しいため、黙って破棄せずに入り直す必要があることを送信元に知らせる
+			if errors.Is(err, auth.ErrTokenExpired) {
+				s.notifyAddr(remoteAddr, "トークンの有効期限が切れたため、メッセージを送信できませんでした。ルームに入り直してください", logger)
+			}
 			continue
 		}
 
-		fmt.Printf("ユーザー '%s' からのメッセージを受信しました\n", user.GetName())
+		// ユーザーのアクティビティを更新（有効期限の切れたトークンのユーザーは更新されず、非アクティブとして削除される）
+		if updater, ok := s.userManager.(activityUpdater); ok {
+			updater.UpdateActivity(token)
+		}
+
+		logger = logger.With("user", user.GetName())
+		logger.Debug("メッセージを受信しました", "length", len(message))
 
 		// ユーザーのUDPアドレスを更新
-		if udpUser, ok := user.(*chat.SimpleUser); ok {
-			udpUser.SetUDPAddr(remoteAddr)
+		firstPacket := false
+		if udpAddr := toUDPAddr(remoteAddr); udpAddr != nil {
+			previous := user.GetUDPAddr()
+			user.SetUDPAddr(udpAddr)
+			firstPacket = previous == nil
+			// 再起動後も送信先を復元できるよう、アドレスが変わった場合はセッションを保存し直す
+			if saver, ok := s.userManager.(sessionSaver); ok && (firstPacket || previous.String() != udpAddr.String()) {
+				saver.SaveSession(token)
+			}
 		}
 
-		// ルームの検索
-		room, err := s.roomManager.FindRoom(roomName)
-		if err != nil {
-			fmt.Printf("ルーム '%s' が見つかりません\n", roomName)
+		// 入室後に最初に届いたパケットでUDPアドレスがわかるため、ここで入室前の履歴を送信する
+		if firstPacket {
+			s.replayHistory(room, user, logger)
+		}
+
+		// 空のメッセージはUDPアドレスの登録のためのパケットなので配信しない
+		if message == "" {
+			continue
+		}
+
+		// 送信頻度の制限を超えたメッセージは破棄する
+		if !s.rateLimiter.Allow(token) {
+			s.metrics.SendDropped("rate_limited")
+			logger.Warn("送信頻度が上限を超えたためメッセージを破棄しました")
+			continue
+		}
+
+		// 禁止語を伏せ字にする
+		message = s.wordFilter.Censor(message)
+
+		// "/" で始まるメッセージはコマンドとして実行し、そのままは配信しない
+		if action, ok, err := command.Parse(message); ok {
+			s.runCommand(room, user, host, action, err, logger)
 			continue
 		}
+		message = command.Unescape(message)
 
 		// ルーム内の全ユーザーにメッセージをブロードキャスト
-		s.broadcastToRoom(conn, room, message, user)
+		s.broadcastToRoom(conn, room, protocol.KindChat, message, user, logger)
 
 		// // クライアントに確認応答を返す
 		// _, err = conn.WriteToUDP([]byte(message), remoteAddr)
@@ -129,65 +278,167 @@ func (s *UDPServer) handleConnection(conn *net.UDPConn) {
 	}
 }
 
-// broadcastToRoom はルーム内の全ユーザーにメッセージをブロードキャストします
-func (s *UDPServer) broadcastToRoom(conn *net.UDPConn, room chat.Room, message string, sender chat.User) {
-	senderName := sender.GetName()
-	senderToken := sender.GetToken()
+// SystemSenderName はサーバーからのお知らせの送信者名です。
+const SystemSenderName = "[サーバー]"
+
+// broadcastToRoom はルーム内の全ユーザーにメッセージをブロードキャストします。
+// kindはprotocol.KindChatかprotocol.KindActionです。動作は "/me <動作>" の形で履歴などに記録します。
+func (s *UDPServer) broadcastToRoom(conn net.PacketConn, room chat.Room, kind uint8, message string, sender chat.User, logger *slog.Logger) {
+	start := time.Now()
+	defer func() { s.metrics.ObserveBroadcast(time.Since(start)) }()
+
+	msg := chat.Message{Sender: sender.GetName(), Text: message, Time: time.Now()}
+	recorded := msg
+	if kind == protocol.KindAction {
+		recorded.Text = "/me " + message
+	}
+	if r, ok := room.(historyRoom); ok {
+		r.AddMessage(recorded)
+	}
+	if s.messageLog != nil {
+		if _, err := s.messageLog.Append(msglog.Record{Room: room.GetName(), Sender: recorded.Sender, Text: recorded.Text, Time: recorded.Time}); err != nil {
+			logger.Warn("メッセージの記録に失敗しました", "error", err)
+		}
+	}
+	if s.searchIndex != nil {
+		s.searchIndex.Add(room.GetName(), msg.Sender, msg.Text, msg.Time)
+	}
+	data, err := encodeServerMessage(kind, msg)
+	if err != nil {
+		logger.Warn("メッセージのエンコードに失敗しました", "error", err)
+		return
+	}
 
-	formattedMessage := fmt.Sprintf("%s> %s", senderName, message)
-	messageBytes := []byte(formattedMessage)
+	senderToken := sender.GetToken()
 
+	recipients := make([]chat.User, 0)
 	for _, user := range room.GetUsers() {
 		// 送信者自身には送信しない
 		if user.GetToken() == senderToken {
 			continue
 		}
+		recipients = append(recipients, user)
+	}
+	s.sendToUsers(conn, recipients, data, logger)
+}
 
-		// ユーザーのUDPアドレスを取得
-		udpUser, ok := user.(*chat.SimpleUser)
-		if !ok || udpUser.GetUDPAddr() == nil {
-			// UDPアドレスが設定されていないユーザーはスキップ
-			continue
-		}
+// replayHistory はルームの履歴をユーザーに古い順に送信します。
+func (s *UDPServer) replayHistory(room chat.Room, user chat.User, logger *slog.Logger) {
+	r, ok := room.(historyRoom)
+	if !ok {
+		return
+	}
+	s.settingsMutex.RLock()
+	n := s.historyReplay
+	s.settingsMutex.RUnlock()
 
-		// メッセージを送信
-		_, err := conn.WriteToUDP(messageBytes, udpUser.GetUDPAddr())
+	messages := r.History(n)
+	for _, msg := range messages {
+		data, err := encodeServerMessage(protocol.KindHistory, msg)
 		if err != nil {
-			fmt.Printf("ユーザー '%s' へのメッセージ送信に失敗: %v\n", user.GetName(), err)
-		} else {
-			fmt.Printf("ユーザー '%s' にメッセージを送信しました\n", user.GetName())
+			logger.Warn("履歴のエンコードに失敗しました", "error", err)
+			continue
 		}
+		s.sendToUsers(s.conn, []chat.User{user}, data, logger)
+	}
+	if len(messages) > 0 {
+		logger.Debug("履歴を送信しました", "messages", len(messages))
+	}
+}
+
+// NotifyRoom はルーム内の全ユーザーにサーバーからのお知らせを送信し、メッセージログに記録します。
+func (s *UDPServer) NotifyRoom(room chat.Room, text string) {
+	logger := s.logger.With("room", room.GetName())
+	recordEvent(s.messageLog, room.GetName(), text, logger)
+	s.notify(room.GetUsers(), text, logger)
+}
+
+// NotifyUser は指定したユーザーにサーバーからのお知らせを送信します。
+func (s *UDPServer) NotifyUser(user chat.User, text string) {
+	s.notify([]chat.User{user}, text, s.logger)
+}
+
+// notify はユーザーにサーバーからのお知らせを送信します。
+func (s *UDPServer) notify(users []chat.User, text string, logger *slog.Logger) {
+	data, err := encodeServerMessage(protocol.KindSystem, chat.Message{Sender: SystemSenderName, Text: text, Time: time.Now()})
+	if err != nil {
+		logger.Warn("お知らせのエンコードに失敗しました", "error", err)
+		return
 	}
+	s.sendToUsers(s.conn, users, data, logger)
 }
 
-// トークンの検証
-func (s *UDPServer) validateToken(token string, roomName string) (chat.User, error) {
-	// トークンからユーザーを検索
-	user, err := s.userManager.FindUser(token)
+// notifyAddr はユーザーの表にない送信元のアドレスにサーバーからのお知らせを送信します。
+func (s *UDPServer) notifyAddr(addr net.Addr, text string, logger *slog.Logger) {
+	data, err := encodeServerMessage(protocol.KindSystem, chat.Message{Sender: SystemSenderName, Text: text, Time: time.Now()})
 	if err != nil {
-		return nil, fmt.Errorf("無効なトークン: %w", err)
+		logger.Warn("お知らせのエンコードに失敗しました", "error", err)
+		return
 	}
+	if _, err := s.conn.WriteTo(data, addr); err != nil {
+		s.metrics.SendDropped("write_error")
+		logger.Warn("お知らせの送信に失敗しました", "error", err)
+	}
+}
+
+// recordEvent はルームのイベントをメッセージログに記録します。lがnilの場合は何もしません。
+func recordEvent(l *msglog.Log, roomName, text string, logger *slog.Logger) {
+	if l == nil {
+		return
+	}
+	record := msglog.Record{Room: roomName, Sender: SystemSenderName, Text: text, Time: time.Now(), System: true}
+	if _, err := l.Append(record); err != nil {
+		logger.Warn("イベントの記録に失敗しました", "error", err)
+	}
+}
 
-	// ルームを検索
-	room, err := s.roomManager.FindRoom(roomName)
+// encodeServerMessage はメッセージをクライアントへ送るバイト列にエンコードします。
+func encodeServerMessage(kind uint8, msg chat.Message) ([]byte, error) {
+	serverMessage, err := protocol.NewServerMessage(kind, msg.Sender, msg.Text, msg.Time)
 	if err != nil {
-		return nil, fmt.Errorf("ルームが存在しません: %w", err)
+		return nil, err
 	}
+	return protocol.EncodeServerMessage(serverMessage)
+}
 
-	// ユーザーがルームに所属しているか確認
-	roomUsers := room.GetUsers()
-	userFound := false
+// sendToUsers はUDPアドレスがわかっているユーザーにメッセージを送信します。
+func (s *UDPServer) sendToUsers(conn net.PacketConn, users []chat.User, messageBytes []byte, logger *slog.Logger) {
+	for _, user := range users {
+		// ユーザーのUDPアドレスを取得
+		udpAddr := user.GetUDPAddr()
+		if udpAddr == nil {
+			// UDPアドレスが設定されていないユーザーはスキップ
+			s.metrics.SendDropped("no_address")
+			continue
+		}
 
-	for _, u := range roomUsers {
-		if u.GetToken() == token {
-			userFound = true
-			break
+		// メッセージを送信
+		_, err := conn.WriteTo(messageBytes, udpAddr)
+		if err != nil {
+			s.metrics.SendDropped("write_error")
+			logger.Warn("メッセージの送信に失敗しました", "recipient", user.GetName(), "error", err)
+		} else {
+			s.metrics.UDPPacketSent()
+			logger.Debug("メッセージを送信しました", "recipient", user.GetName())
 		}
 	}
+}
 
-	if !userFound {
-		return nil, fmt.Errorf("ユーザーはこのルームに所属していません")
+// toUDPAddr はPacketConnから得たアドレスを*net.UDPAddrに変換します。
+// UDP以外のアドレスは文字列表現から解決を試み、解決できない場合はnilを返します。
+func toUDPAddr(addr net.Addr) *net.UDPAddr {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr
 	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil
+	}
+	return udpAddr
+}
 
-	return user, nil
+// validateToken はトークンを検証し、ルームと、ルームに参加しているトークンのユーザーと、ホストとして参加しているかどうかを返します。
+// 署名のあるトークンはユーザーの表を引かずに確認し、ユーザーの表はUDPアドレスとアクティビティの更新のためだけに使います（findSessionを参照）。
+func (s *UDPServer) validateToken(token string, roomName string) (chat.Room, chat.User, bool, error) {
+	return findSession(s.roomManager, s.userManager, s.tokens, token, roomName)
 }
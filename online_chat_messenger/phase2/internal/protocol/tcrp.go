@@ -5,8 +5,99 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log/slog"
 )
 
+// logger はプロトコルのデバッグ情報を出力するロガーです。
+var logger = slog.Default().With("component", "protocol")
+
+// SetLogger はプロトコルのデバッグ情報を出力するロガーを設定します。
+func SetLogger(l *slog.Logger) {
+	logger = l.With("component", "protocol")
+}
+
+// TCRPのオペレーションコードです。
+const (
+	OperationCreateRoom uint8 = 1 // ルーム作成
+	OperationJoinRoom   uint8 = 2 // ルーム参加
+	OperationLeaveRoom  uint8 = 3 // ルーム退出
+	OperationSearch     uint8 = 4 // 履歴の検索
+	OperationExport     uint8 = 5 // 会話記録の書き出し
+	OperationInvite     uint8 = 6 // 招待コードの発行・一覧・取り消し
+	OperationRegister   uint8 = 7 // アカウントの登録
+	OperationLogin      uint8 = 8 // アカウントへのログイン
+)
+
+// TCRPの状態コードです。
+const (
+	StateRequest  uint8 = 0 // リクエスト
+	StateResponse uint8 = 1 // 準拠応答
+	StateComplete uint8 = 2 // 完了
+	// StateContinued はボディが1つのメッセージに収まらない応答の途中の断片です。
+	// 続く断片のボディを順に連結し、StateContinued以外の状態の断片で終わります。
+	StateContinued uint8 = 3
+)
+
+// TCRPの準拠応答のステータスコードです。準拠応答のボディは {"status": <コード>, "message": <説明>} の形式です。
+// 0以外の場合、サーバーは完了応答を送らずに接続を閉じます。
+const (
+	StatusOK                      uint8 = 0  // 受け付けた
+	StatusInvalidRequest          uint8 = 1  // リクエストの内容が正しくない
+	StatusRoomExists              uint8 = 2  // 同じ名前のルームがすでにある
+	StatusRoomNotFound            uint8 = 3  // ルームが見つからない
+	StatusRoomLimitReached        uint8 = 4  // サーバーのルーム数が上限に達している
+	StatusAddressRoomLimitReached uint8 = 5  // 同じアドレスから作成したルーム数が上限に達している
+	StatusRoomFull                uint8 = 6  // ルームのメンバー数が上限に達している
+	StatusServerError             uint8 = 7  // サーバー内部のエラー
+	StatusNameTaken               uint8 = 8  // ルームに同じ名前のユーザーがいる
+	StatusInviteRequired          uint8 = 9  // 招待制のルームに招待コードなしで参加しようとした
+	StatusInvalidInvite           uint8 = 10 // 招待コードが正しくないか、使用済み・期限切れ
+	StatusPermissionDenied        uint8 = 11 // ルームのホストだけが実行できる
+	StatusNameReserved            uint8 = 12 // 登録ユーザーか予約された名前のため使えない
+	StatusInvalidCredentials      uint8 = 13 // ユーザー名かパスワードが正しくない
+	StatusLoginExpired            uint8 = 14 // ログイントークンが見つからないか、有効期限が切れている
+	StatusWrongPassword           uint8 = 15 // ルームのパスワードが正しくない
+)
+
+// StatusText はステータスコードの説明を返します。
+func StatusText(status uint8) string {
+	switch status {
+	case StatusOK:
+		return "成功しました"
+	case StatusInvalidRequest:
+		return "リクエストの内容が正しくありません"
+	case StatusRoomExists:
+		return "同じ名前のルームがすでにあります"
+	case StatusRoomNotFound:
+		return "ルームが見つかりません"
+	case StatusRoomLimitReached:
+		return "サーバーのルーム数が上限に達しています"
+	case StatusAddressRoomLimitReached:
+		return "このアドレスから作成できるルーム数の上限に達しています"
+	case StatusRoomFull:
+		return "ルームのメンバー数が上限に達しています"
+	case StatusServerError:
+		return "サーバーでエラーが発生しました"
+	case StatusNameTaken:
+		return "この名前はルームですでに使われています"
+	case StatusInviteRequired:
+		return "このルームに参加するには招待コードが必要です"
+	case StatusInvalidInvite:
+		return "招待コードが正しくないか、使用済みか有効期限が切れています"
+	case StatusPermissionDenied:
+		return "ルームのホストだけが実行できます"
+	case StatusNameReserved:
+		return "この名前は登録ユーザーか予約された名前のため使えません"
+	case StatusInvalidCredentials:
+		return "ユーザー名かパスワードが正しくありません"
+	case StatusLoginExpired:
+		return "ログインの有効期限が切れています。ログインし直してください"
+	case StatusWrongPassword:
+		return "ルームのパスワードが正しくありません"
+	}
+	return fmt.Sprintf("不明なステータスです: %d", status)
+}
+
 // TCRPHeader はTCRPヘッダーを表します。
 type TCRPHeader struct {
 	RoomNameSize         uint8
@@ -21,10 +112,19 @@ type TCRPMessage struct {
 	Body   []byte
 }
 
+// maxOperationPayloadSize はヘッダーで表現できるボディの最大長です。
+const maxOperationPayloadSize = 1<<8 - 1
+
 // EncodeTCRPMessage はTCRPメッセージをバイト列にエンコードします。
+// OperationPayloadSizeが未設定の場合はボディの長さを設定します。
 func EncodeTCRPMessage(msg TCRPMessage) ([]byte, error) {
 	buf := new(bytes.Buffer) // new()は型を受け取り、その型でメモリ割り当てをする。値はゼロ値を適用
 
+	// ボディ長をヘッダーに設定し、ストリーム上でメッセージの境界を判別できるようにする
+	if msg.Header.OperationPayloadSize == 0 && len(msg.Body) <= maxOperationPayloadSize {
+		msg.Header.OperationPayloadSize = uint8(len(msg.Body))
+	}
+
 	// ヘッダーを書き込み
 	if err := binary.Write(buf, binary.LittleEndian, msg.Header.RoomNameSize); err != nil {
 		return nil, err
@@ -48,6 +148,35 @@ func EncodeTCRPMessage(msg TCRPMessage) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// EncodeTCRPFrames はTCRPメッセージをバイト列にエンコードします。
+// ボディがヘッダーで表現できる長さを超える場合は、StateContinuedの断片に分割し、
+// 最後の断片にメッセージの状態を設定します。ReadTCRPMessageで1つのメッセージとして読み込めます。
+func EncodeTCRPFrames(msg TCRPMessage) ([]byte, error) {
+	if len(msg.Body) <= maxOperationPayloadSize {
+		return EncodeTCRPMessage(msg)
+	}
+
+	var frames []byte
+	body := msg.Body
+	for len(body) > 0 {
+		header := msg.Header
+		chunk := body
+		if len(chunk) > maxOperationPayloadSize {
+			chunk = chunk[:maxOperationPayloadSize]
+			header.State = StateContinued
+		}
+		header.OperationPayloadSize = uint8(len(chunk))
+
+		frame, err := EncodeTCRPMessage(TCRPMessage{Header: header, Body: chunk})
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame...)
+		body = body[len(chunk):]
+	}
+	return frames, nil
+}
+
 // DecodeTCRPMessage はバイト列をTCRPメッセージにデコードします。
 func DecodeTCRPMessage(data []byte) (TCRPMessage, error) {
 	var msg TCRPMessage
@@ -88,10 +217,48 @@ func DecodeTCRPMessage(data []byte) (TCRPMessage, error) {
 		}
 	}
 
-	// デバッグ情報を出力
-	fmt.Printf("デコード結果: ヘッダー=%+v, ボディ長=%d\n", msg.Header, len(msg.Body))
-	if len(msg.Body) > 0 {
-		fmt.Printf("ボディ内容: %s\n", string(msg.Body))
+	// デバッグ情報を出力（ボディにはトークンやパスワードが含まれるため内容は出力しない）
+	logger.Debug("TCRPメッセージをデコードしました",
+		"operation", msg.Header.Operation,
+		"state", msg.Header.State,
+		"body_len", len(msg.Body))
+
+	return msg, nil
+}
+
+// ReadTCRPMessage はストリームからTCRPメッセージを1つ読み込みます。
+// ヘッダーのOperationPayloadSize分だけボディを読み込むため、連続して送信されたメッセージを区別できます。
+// StateContinuedの断片は続く断片と連結し、最後の断片のヘッダーを持つ1つのメッセージとして返します。
+func ReadTCRPMessage(r io.Reader) (TCRPMessage, error) {
+	var body []byte
+	for {
+		msg, err := readTCRPFrame(r)
+		if err != nil {
+			return msg, err
+		}
+		if msg.Header.State != StateContinued {
+			if body != nil {
+				msg.Body = append(body, msg.Body...)
+			}
+			return msg, nil
+		}
+		body = append(body, msg.Body...)
+	}
+}
+
+// readTCRPFrame はストリームからTCRPメッセージの断片を1つ読み込みます。
+func readTCRPFrame(r io.Reader) (TCRPMessage, error) {
+	var msg TCRPMessage
+	if err := binary.Read(r, binary.LittleEndian, &msg.Header); err != nil {
+		return msg, err
+	}
+
+	bodySize := int(msg.Header.OperationPayloadSize)
+	if bodySize > 0 {
+		msg.Body = make([]byte, bodySize)
+		if _, err := io.ReadFull(r, msg.Body); err != nil {
+			return msg, fmt.Errorf("ボディの読み込みエラー: %w", err)
+		}
 	}
 
 	return msg, nil
@@ -0,0 +1,45 @@
+package protocol
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLog はプロトコルのログをバッファに出力し、テストの終了時に元に戻します。
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := logger
+	SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { logger = previous })
+	return &buf
+}
+
+func TestDecodeTCRPMessageDoesNotLogBody(t *testing.T) {
+	buf := captureLog(t)
+	body := []byte(`{"room_name":"lobby","user_name":"taro","password":"open-sesame","token":"secret-token","login_token":"secret-login"}`)
+	data, err := EncodeTCRPMessage(TCRPMessage{Header: TCRPHeader{Operation: OperationJoinRoom, State: StateRequest}, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := DecodeTCRPMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.Body, body) || msg.Header.Operation != OperationJoinRoom {
+		t.Errorf("DecodeTCRPMessage = %+v", msg)
+	}
+
+	got := buf.String()
+	for _, secret := range []string{"open-sesame", "secret-token", "secret-login", "lobby"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("the log contains %q: %s", secret, got)
+		}
+	}
+	if !strings.Contains(got, "component=protocol") || !strings.Contains(got, "body_len=") {
+		t.Errorf("the log does not describe the message: %s", got)
+	}
+}
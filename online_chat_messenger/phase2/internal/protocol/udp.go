@@ -1,25 +1,58 @@
 package protocol
 
 import (
+	"encoding/binary"
 	"fmt"
+	"time"
 )
 
+// UDPHeader はチャットメッセージのヘッダーを表します。
 type UDPHeader struct {
 	RoomNameSize uint8
 	TokenSize    uint8
 }
 
+// UDPMessage はクライアントからサーバーへ送るチャットメッセージを表します。
+// ボディはルーム名、トークン、メッセージの順に格納されます。
 type UDPMessage struct {
 	Header UDPHeader
 	Body   []byte
 }
 
+// NewUDPMessage はルーム名、トークン、メッセージからUDPMessageを組み立てます。
+func NewUDPMessage(roomName, token, message string) (UDPMessage, error) {
+	if len(roomName) > 255 {
+		return UDPMessage{}, fmt.Errorf("ルーム名が長すぎます")
+	}
+	if len(token) > 255 {
+		return UDPMessage{}, fmt.Errorf("トークンが長すぎます")
+	}
+
+	body := make([]byte, 0, len(roomName)+len(token)+len(message))
+	body = append(body, roomName...)
+	body = append(body, token...)
+	body = append(body, message...)
+
+	return UDPMessage{
+		Header: UDPHeader{
+			RoomNameSize: uint8(len(roomName)),
+			TokenSize:    uint8(len(token)),
+		},
+		Body: body,
+	}, nil
+}
+
+// EncodeUDPMessage はUDPMessageをバイト列にエンコードします。
 func EncodeUDPMessage(msg UDPMessage) ([]byte, error) {
-	var encoded []byte
+	encoded := make([]byte, 2+len(msg.Body))
+	encoded[0] = msg.Header.RoomNameSize
+	encoded[1] = msg.Header.TokenSize
+	copy(encoded[2:], msg.Body)
 
 	return encoded, nil
 }
 
+// DecodeUDPMessage はバイト列をUDPMessageにデコードします。
 func DecodeUDPMessage(data []byte) (UDPMessage, error) {
 	if len(data) < 2 {
 		return UDPMessage{}, fmt.Errorf("データが短すぎます")
@@ -44,3 +77,99 @@ func DecodeUDPMessage(data []byte) (UDPMessage, error) {
 		Body:   body,
 	}, nil
 }
+
+// サーバーからクライアントへ送るメッセージの種類です。
+const (
+	KindChat    uint8 = 0 // 他のユーザーからのチャットメッセージ
+	KindSystem  uint8 = 1 // サーバーからのお知らせ
+	KindHistory uint8 = 2 // 入室前に送信されたメッセージの履歴
+	KindDirect  uint8 = 3 // 自分だけに宛てたメッセージ
+	KindAction  uint8 = 4 // /me で送信された動作（本文は動作だけで、送信者の名前を含まない）
+	KindRenamed uint8 = 5 // 自分の名前が変更されたことの通知（送信者の欄が新しい名前）
+)
+
+// ServerMessageHeader はサーバーからクライアントへ送るメッセージのヘッダーです。
+type ServerMessageHeader struct {
+	Kind       uint8
+	SenderSize uint8
+	Timestamp  int64 // サーバーがメッセージを受け付けた時刻（Unixミリ秒）
+}
+
+// serverMessageHeaderSize はServerMessageHeaderのバイト数です。
+const serverMessageHeaderSize = 10
+
+// ServerMessage はサーバーからクライアントへ送るメッセージを表します。
+// ボディは送信者名、メッセージの順に格納されます。
+type ServerMessage struct {
+	Header ServerMessageHeader
+	Body   []byte
+}
+
+// NewServerMessage は種類、送信者名、メッセージ、時刻からServerMessageを組み立てます。
+func NewServerMessage(kind uint8, sender, text string, t time.Time) (ServerMessage, error) {
+	if len(sender) > 255 {
+		return ServerMessage{}, fmt.Errorf("送信者名が長すぎます")
+	}
+
+	body := make([]byte, 0, len(sender)+len(text))
+	body = append(body, sender...)
+	body = append(body, text...)
+
+	return ServerMessage{
+		Header: ServerMessageHeader{
+			Kind:       kind,
+			SenderSize: uint8(len(sender)),
+			Timestamp:  t.UnixMilli(),
+		},
+		Body: body,
+	}, nil
+}
+
+// Sender は送信者名を返します。
+func (m ServerMessage) Sender() string {
+	return string(m.Body[:m.Header.SenderSize])
+}
+
+// Text はメッセージを返します。
+func (m ServerMessage) Text() string {
+	return string(m.Body[m.Header.SenderSize:])
+}
+
+// Time はメッセージの時刻を返します。
+func (m ServerMessage) Time() time.Time {
+	return time.UnixMilli(m.Header.Timestamp)
+}
+
+// EncodeServerMessage はServerMessageをバイト列にエンコードします。
+func EncodeServerMessage(msg ServerMessage) ([]byte, error) {
+	encoded := make([]byte, serverMessageHeaderSize+len(msg.Body))
+	encoded[0] = msg.Header.Kind
+	encoded[1] = msg.Header.SenderSize
+	binary.LittleEndian.PutUint64(encoded[2:], uint64(msg.Header.Timestamp))
+	copy(encoded[serverMessageHeaderSize:], msg.Body)
+
+	return encoded, nil
+}
+
+// DecodeServerMessage はバイト列をServerMessageにデコードします。
+func DecodeServerMessage(data []byte) (ServerMessage, error) {
+	if len(data) < serverMessageHeaderSize {
+		return ServerMessage{}, fmt.Errorf("データが短すぎます")
+	}
+
+	header := ServerMessageHeader{
+		Kind:       data[0],
+		SenderSize: data[1],
+		Timestamp:  int64(binary.LittleEndian.Uint64(data[2:])),
+	}
+
+	body := data[serverMessageHeaderSize:]
+	if len(body) < int(header.SenderSize) {
+		return ServerMessage{}, fmt.Errorf("ボディデータが不足しています")
+	}
+
+	return ServerMessage{
+		Header: header,
+		Body:   body,
+	}, nil
+}
@@ -0,0 +1,81 @@
+// Package pwhash はアカウントやルームのパスワードを保存するためのハッシュの計算と確認を提供します。
+//
+// ハッシュはPBKDF2-SHA256で計算し、"pbkdf2-sha256$<反復回数>$<ソルト>$<ハッシュ>" の形式の文字列にします。
+// 反復回数を文字列に含めるため、反復回数を変えても以前のハッシュを確認できます。
+package pwhash
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultIterations はPBKDF2のデフォルトの反復回数です。
+const DefaultIterations = 600000
+
+// scheme はハッシュの形式名です。
+const scheme = "pbkdf2-sha256"
+
+// Hash はランダムなソルトでパスワードのハッシュを計算し、形式名と反復回数を含む文字列にします。
+func Hash(password string, iterations int) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("ソルトの生成に失敗しました: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", fmt.Errorf("パスワードのハッシュの計算に失敗しました: %w", err)
+	}
+	return strings.Join([]string{
+		scheme,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// Verify はパスワードがハッシュと一致するかどうかを返します。
+func Verify(password, encoded string) bool {
+	iterations, salt, want, ok := parse(encoded)
+	if !ok {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// IsHash は文字列がHashで計算した形式のハッシュかどうかを返します。
+// ハッシュにする前に保存された平文のパスワードを見分けるために使います。
+func IsHash(encoded string) bool {
+	_, _, _, ok := parse(encoded)
+	return ok
+}
+
+// parse はハッシュの文字列を反復回数・ソルト・ハッシュに分けます。
+func parse(encoded string) (iterations int, salt, key []byte, ok bool) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != scheme {
+		return 0, nil, nil, false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, false
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, false
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, false
+	}
+	return iterations, salt, key, true
+}
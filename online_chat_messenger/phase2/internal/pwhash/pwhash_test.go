@@ -0,0 +1,75 @@
+package pwhash
+
+import (
+	"strings"
+	"testing"
+)
+
+// testIterations はテストを速くするためのPBKDF2の反復回数です。
+const testIterations = 1000
+
+func TestHashRoundTrip(t *testing.T) {
+	hash, err := Hash("correct horse", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("Hash = %q", hash)
+	}
+	if !Verify("correct horse", hash) {
+		t.Error("Verify rejected the correct password")
+	}
+	if Verify("correct horsE", hash) {
+		t.Error("Verify accepted a wrong password")
+	}
+
+	// 同じパスワードでもソルトが違うため、ハッシュは一致しない
+	other, err := Hash("correct horse", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Error("Hash returned the same hash twice")
+	}
+}
+
+func TestVerifyRejectsMalformedHash(t *testing.T) {
+	hash, err := Hash("password", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash, "$")
+
+	for name, encoded := range map[string]string{
+		"empty":          "",
+		"plain text":     "password",
+		"other scheme":   strings.Join([]string{"bcrypt", parts[1], parts[2], parts[3]}, "$"),
+		"zero iteration": strings.Join([]string{parts[0], "0", parts[2], parts[3]}, "$"),
+		"bad iteration":  strings.Join([]string{parts[0], "x", parts[2], parts[3]}, "$"),
+		"bad salt":       strings.Join([]string{parts[0], parts[1], "!!", parts[3]}, "$"),
+		"bad hash":       strings.Join([]string{parts[0], parts[1], parts[2], "!!"}, "$"),
+		"changed count":  strings.Join([]string{parts[0], "1001", parts[2], parts[3]}, "$"),
+		"missing part":   strings.Join(parts[:3], "$"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if Verify("password", encoded) {
+				t.Errorf("Verify(%q) = true", encoded)
+			}
+		})
+	}
+}
+
+func TestIsHash(t *testing.T) {
+	hash, err := Hash("password", testIterations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHash(hash) {
+		t.Errorf("IsHash(%q) = false", hash)
+	}
+	for _, s := range []string{"", "password", "pbkdf2-sha256$1000$$", "pbkdf2-sha256$$c2FsdA$a2V5"} {
+		if IsHash(s) {
+			t.Errorf("IsHash(%q) = true", s)
+		}
+	}
+}
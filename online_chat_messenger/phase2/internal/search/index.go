@@ -0,0 +1,259 @@
+// Package search はルームのメッセージを検索するための転置インデックスを提供します。
+//
+// 日本語のように単語が空白で区切られない文章も検索できるよう、本文を文字単位のn-gram
+// （1文字と2文字）に分割して索引を作り、検索語のn-gramをすべて含むメッセージを候補として
+// 本文に検索語が含まれるかを確かめます。
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxDocuments はインデックスに保持するメッセージのデフォルトの件数です。
+const DefaultMaxDocuments = 100000
+
+// Document はインデックスに登録されたメッセージです。
+type Document struct {
+	ID     uint64    `json:"-"`
+	Room   string    `json:"room"`
+	Sender string    `json:"sender"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Query は検索条件です。
+type Query struct {
+	Text  string   // 空白で区切った検索語をすべて含むメッセージを探す
+	Rooms []string // 検索するルーム（空の場合はすべてのルーム）
+	Limit int      // 返す件数の上限（0以下の場合は無制限）
+}
+
+// entry はインデックスに登録されたメッセージと索引に使った情報です。
+type entry struct {
+	doc        Document
+	normalized string
+	grams      []string
+}
+
+// Index はメッセージの転置インデックスです。上限を超えると古いメッセージから削除します。
+type Index struct {
+	entries      map[uint64]*entry
+	postings     map[string][]uint64 // n-gramを含むメッセージのID（昇順）
+	order        []uint64            // 登録した順のID
+	nextID       uint64
+	maxDocuments int
+	mutex        sync.RWMutex
+}
+
+// NewIndex は保持するメッセージの件数の上限を指定して新しいIndexを生成します。0以下の場合は無制限です。
+func NewIndex(maxDocuments int) *Index {
+	return &Index{
+		entries:      make(map[uint64]*entry),
+		postings:     make(map[string][]uint64),
+		nextID:       1,
+		maxDocuments: maxDocuments,
+	}
+}
+
+// SetMaxDocuments は保持するメッセージの件数の上限を変更し、超えた古いメッセージを削除します。
+func (x *Index) SetMaxDocuments(maxDocuments int) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.maxDocuments = maxDocuments
+	x.evict()
+}
+
+// Len は登録されているメッセージの件数を返します。
+func (x *Index) Len() int {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return len(x.entries)
+}
+
+// Add はメッセージを登録し、IDを返します。
+func (x *Index) Add(room, sender, text string, t time.Time) uint64 {
+	normalized := normalize(text)
+	grams := uniqueGrams(normalized)
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	id := x.nextID
+	x.nextID++
+	x.entries[id] = &entry{
+		doc:        Document{ID: id, Room: room, Sender: sender, Text: text, Time: t},
+		normalized: normalized,
+		grams:      grams,
+	}
+	for _, gram := range grams {
+		x.postings[gram] = append(x.postings[gram], id)
+	}
+	x.order = append(x.order, id)
+	x.evict()
+	return id
+}
+
+// Search は検索語をすべて含むメッセージを新しい順に返します。
+func (x *Index) Search(q Query) []Document {
+	terms := strings.Fields(normalize(q.Text))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	rooms := make(map[string]bool, len(q.Rooms))
+	for _, room := range q.Rooms {
+		rooms[room] = true
+	}
+
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	candidates := x.candidates(terms)
+	var results []Document
+	// IDは登録順なので、後ろから調べると新しい順になる
+	for i := len(candidates) - 1; i >= 0; i-- {
+		e, ok := x.entries[candidates[i]]
+		if !ok {
+			continue
+		}
+		if len(rooms) > 0 && !rooms[e.doc.Room] {
+			continue
+		}
+		if !containsAll(e.normalized, terms) {
+			continue
+		}
+		results = append(results, e.doc)
+		if q.Limit > 0 && len(results) >= q.Limit {
+			break
+		}
+	}
+	return results
+}
+
+// candidates は検索語のn-gramをすべて含むメッセージのIDを昇順で返します。
+func (x *Index) candidates(terms []string) []uint64 {
+	var lists [][]uint64
+	for _, term := range terms {
+		for _, gram := range grams(term) {
+			list, ok := x.postings[gram]
+			if !ok {
+				return nil
+			}
+			lists = append(lists, list)
+		}
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	// 短いリストから積集合を取る
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	result := lists[0]
+	for _, list := range lists[1:] {
+		result = intersect(result, list)
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+// evict は上限を超えた古いメッセージを削除します。呼び出し元でx.mutexをロックしておく必要があります。
+func (x *Index) evict() {
+	if x.maxDocuments <= 0 {
+		return
+	}
+	for len(x.order) > x.maxDocuments {
+		id := x.order[0]
+		x.order = x.order[1:]
+		e := x.entries[id]
+		delete(x.entries, id)
+
+		// 最も古いメッセージなので、各リストの先頭にある
+		for _, gram := range e.grams {
+			list := x.postings[gram]
+			if len(list) > 0 && list[0] == id {
+				list = list[1:]
+			}
+			if len(list) == 0 {
+				delete(x.postings, gram)
+			} else {
+				x.postings[gram] = list
+			}
+		}
+	}
+}
+
+// intersect は昇順の2つのリストの積集合を返します。
+func intersect(a, b []uint64) []uint64 {
+	var result []uint64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// containsAll は本文がすべての検索語を含むかどうかを返します。
+func containsAll(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueGrams は本文の空白で区切られた部分ごとのn-gramを重複なく返します。
+func uniqueGrams(normalized string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, field := range strings.Fields(normalized) {
+		runes := []rune(field)
+		for i := range runes {
+			for _, gram := range []string{string(runes[i]), string(runes[i:min(i+2, len(runes))])} {
+				if !seen[gram] {
+					seen[gram] = true
+					result = append(result, gram)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// grams は検索語を調べるためのn-gramを返します。1文字の場合はその文字、2文字以上の場合は2文字ずつのn-gramです。
+func grams(term string) []string {
+	runes := []rune(term)
+	if len(runes) == 1 {
+		return []string{term}
+	}
+	result := make([]string, 0, len(runes)-1)
+	for i := 0; i+2 <= len(runes); i++ {
+		result = append(result, string(runes[i:i+2]))
+	}
+	return result
+}
+
+// normalize は大文字・小文字と全角・半角の違いを無視できるように文字列を正規化します。
+// chat.NormalizeName と同じくNFKCと大文字・小文字の畳み込みを行うため、全角英数字や半角カタカナ、
+// 合成済みの文字と結合文字の違いも無視します。
+func normalize(s string) string {
+	// 畳み込みで互換文字が現れることがあるため、もう一度NFKCで揃える
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(s)))
+}
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// texts は検索結果の本文を返します。
+func texts(docs []Document) []string {
+	var result []string
+	for _, doc := range docs {
+		result = append(result, doc.Text)
+	}
+	return result
+}
+
+func TestUniqueGrams(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"日本語", "東京都", []string{"東", "東京", "京", "京都", "都"}},
+		{"1文字", "猫", []string{"猫"}},
+		{"空白で区切る", "今日 晴れ", []string{"今", "今日", "日", "晴", "晴れ", "れ"}},
+		{"重複を除く", "ああああ", []string{"あ", "ああ"}},
+		{"空白だけ", " 　 ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uniqueGrams(normalize(tt.text)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uniqueGrams(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	x := NewIndex(0)
+	x.Add("lobby", "taro", "東京都に行きました", base)
+	x.Add("lobby", "jiro", "京都は雨でした", base.Add(time.Minute))
+	x.Add("dev", "hanako", "明日は東京で会議", base.Add(2*time.Minute))
+	x.Add("lobby", "taro", "Go言語のＧＯＰＡＴＨ", base.Add(3*time.Minute))
+	x.Add("lobby", "jiro", "ｶﾞｲﾄﾞを読んだ", base.Add(4*time.Minute))
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"2文字の検索語", Query{Text: "京都"}, []string{"京都は雨でした", "東京都に行きました"}},
+		{"1文字の検索語", Query{Text: "雨"}, []string{"京都は雨でした"}},
+		{"3文字以上の検索語は本文で確かめる", Query{Text: "東京都"}, []string{"東京都に行きました"}},
+		{"2-gramが揃っても続いていなければ一致しない", Query{Text: "東京会議"}, nil},
+		{"すべての検索語を含む", Query{Text: "東京 会議"}, []string{"明日は東京で会議"}},
+		{"一部の検索語しか含まない", Query{Text: "東京 雨"}, nil},
+		{"全角スペースで区切る", Query{Text: "京都　雨"}, []string{"京都は雨でした"}},
+		{"ルームを絞り込む", Query{Text: "東京", Rooms: []string{"lobby"}}, []string{"東京都に行きました"}},
+		{"件数の上限", Query{Text: "京", Limit: 1}, []string{"明日は東京で会議"}},
+		{"大文字・小文字と全角・半角", Query{Text: "gopath"}, []string{"Go言語のＧＯＰＡＴＨ"}},
+		{"半角カタカナの濁点", Query{Text: "ガイド"}, []string{"ｶﾞｲﾄﾞを読んだ"}},
+		{"見つからない", Query{Text: "大阪"}, nil},
+		{"空の検索語", Query{Text: "  "}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := texts(x.Search(tt.query)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%+v) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxDocumentsEvictsOldest(t *testing.T) {
+	x := NewIndex(3)
+	for i, text := range []string{"一番目の雨", "二番目の雨", "三番目の雨", "四番目の雨"} {
+		x.Add("lobby", "taro", text, base.Add(time.Duration(i)*time.Minute))
+	}
+	if got := x.Len(); got != 3 {
+		t.Fatalf("Len = %d, want 3", got)
+	}
+	if got := texts(x.Search(Query{Text: "雨"})); !reflect.DeepEqual(got, []string{"四番目の雨", "三番目の雨", "二番目の雨"}) {
+		t.Errorf("Search(雨) = %q", got)
+	}
+	if got := x.Search(Query{Text: "一番"}); len(got) != 0 {
+		t.Errorf("Search(一番) = %q, want nothing", texts(got))
+	}
+
+	// 上限を下げると、超えた古いメッセージを削除して索引からも取り除く
+	x.SetMaxDocuments(1)
+	if got := x.Len(); got != 1 {
+		t.Fatalf("Len after SetMaxDocuments(1) = %d, want 1", got)
+	}
+	if got := texts(x.Search(Query{Text: "雨"})); !reflect.DeepEqual(got, []string{"四番目の雨"}) {
+		t.Errorf("Search(雨) after SetMaxDocuments(1) = %q", got)
+	}
+	if got := x.Search(Query{Text: "二番"}); len(got) != 0 {
+		t.Errorf("Search(二番) after SetMaxDocuments(1) = %q, want nothing", texts(got))
+	}
+	if len(x.postings["二"]) != 0 {
+		t.Errorf("postings[二] = %v, want removed", x.postings["二"])
+	}
+
+	// 0にすると無制限になる
+	x.SetMaxDocuments(0)
+	x.Add("lobby", "taro", "五番目の雨", base.Add(5*time.Minute))
+	x.Add("lobby", "taro", "六番目の雨", base.Add(6*time.Minute))
+	if got := x.Len(); got != 3 {
+		t.Errorf("Len after SetMaxDocuments(0) = %d, want 3", got)
+	}
+}
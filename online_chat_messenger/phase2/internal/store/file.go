@@ -0,0 +1,302 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	journalFileName  = "journal.log"
+
+	// DefaultSnapshotInterval はスナップショットを作成するデフォルトの間隔です。
+	DefaultSnapshotInterval = time.Minute
+)
+
+// FileStore はディレクトリに状態を保存するStoreです。
+// 変更は追記専用のログ(journal.log)に1行ずつ書き込まれ、定期的にスナップショット(snapshot.json)へまとめられます。
+// 書き込み途中でプロセスが停止した場合でも、ログの壊れた末尾を捨てて直前までの状態を復元できます。
+// ファイルにはトークンやパスワードが含まれるため、所有者だけが読み書きできる権限で作成します。
+type FileStore struct {
+	dir     string
+	journal *os.File
+	state   *State
+	pending int // 前回のスナップショット以降にログへ書き込んだ件数
+	mutex   sync.Mutex
+	logger  *slog.Logger
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// OpenFileStore はディレクトリのスナップショットとログから状態を読み込み、FileStoreを開きます。
+// snapshotIntervalごとに、変更があればスナップショットを作成します。0以下の場合は閉じるときにだけ作成します。
+func OpenFileStore(dir string, snapshotInterval time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ディレクトリの作成に失敗しました: %w", err)
+	}
+
+	s := &FileStore{
+		dir:    dir,
+		state:  NewState(),
+		logger: slog.Default().With("component", "store"),
+		done:   make(chan struct{}),
+	}
+
+	if err := s.readSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayJournal(); err != nil {
+		return nil, err
+	}
+
+	if snapshotInterval > 0 {
+		s.wg.Add(1)
+		go s.snapshotLoop(snapshotInterval)
+	}
+	return s, nil
+}
+
+// SetLogger はログの出力先となるロガーを設定します。
+func (s *FileStore) SetLogger(logger *slog.Logger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.logger = logger.With("component", "store")
+}
+
+// Load は読み込んだ状態のコピーを返します。
+func (s *FileStore) Load() (*State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state.clone(), nil
+}
+
+// SaveRoom はルームを保存します。
+func (s *FileStore) SaveRoom(room Room) error {
+	return s.append(entry{Op: opSaveRoom, Room: &room})
+}
+
+// DeleteRoom はルームとそのセッションを削除します。
+func (s *FileStore) DeleteRoom(name string) error {
+	return s.append(entry{Op: opDeleteRoom, Key: name})
+}
+
+// SaveSession はセッションを保存します。
+func (s *FileStore) SaveSession(session Session) error {
+	return s.append(entry{Op: opSaveSession, Session: &session})
+}
+
+// DeleteSession はセッションを削除します。
+func (s *FileStore) DeleteSession(token string) error {
+	return s.append(entry{Op: opDeleteSession, Key: token})
+}
+
+// SaveRevocation は取り消したトークンを保存します。
+func (s *FileStore) SaveRevocation(sessionID string, expiresAt time.Time) error {
+	return s.append(entry{Op: opRevokeToken, Key: sessionID, ExpiresAt: &expiresAt})
+}
+
+// Snapshot は現在の状態をスナップショットに書き出し、ログを空にします。
+func (s *FileStore) Snapshot() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.snapshotLocked()
+}
+
+// Close はスナップショットを作成してからファイルを閉じます。
+func (s *FileStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		err = s.snapshotLocked()
+		if closeErr := s.journal.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// append は変更をログに書き込み、ディスクに同期してから状態に反映します。
+func (s *FileStore) append(e entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("変更のエンコードに失敗しました: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.journal.Write(line); err != nil {
+		return fmt.Errorf("ログの書き込みに失敗しました: %w", err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		return fmt.Errorf("ログの同期に失敗しました: %w", err)
+	}
+	s.state.apply(e)
+	s.pending++
+	return nil
+}
+
+// snapshotLoop は一定間隔で、変更があればスナップショットを作成します。
+func (s *FileStore) snapshotLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			if s.pending > 0 {
+				if err := s.snapshotLocked(); err != nil {
+					s.logger.Error("スナップショットの作成に失敗しました", "error", err)
+				}
+			}
+			s.mutex.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// snapshotLocked はスナップショットを一時ファイルに書き出してから置き換え、ログを空にします。
+// 置き換えた後にログを空にする前に停止しても、ログの変更は冪等なので再適用して同じ状態になります。
+func (s *FileStore) snapshotLocked() error {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return fmt.Errorf("スナップショットのエンコードに失敗しました: %w", err)
+	}
+
+	path := filepath.Join(s.dir, snapshotFileName)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("スナップショットの置き換えに失敗しました: %w", err)
+	}
+	syncDir(s.dir)
+
+	if err := s.journal.Truncate(0); err != nil {
+		return fmt.Errorf("ログの切り詰めに失敗しました: %w", err)
+	}
+	if _, err := s.journal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("ログの切り詰めに失敗しました: %w", err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		return fmt.Errorf("ログの同期に失敗しました: %w", err)
+	}
+
+	s.logger.Debug("スナップショットを作成しました", "rooms", len(s.state.Rooms), "sessions", len(s.state.Sessions), "entries", s.pending)
+	s.pending = 0
+	return nil
+}
+
+// readSnapshot はスナップショットがあれば読み込みます。
+func (s *FileStore) readSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("スナップショットの読み込みに失敗しました: %w", err)
+	}
+
+	state := NewState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("スナップショットのデコードに失敗しました: %w", err)
+	}
+	if state.Rooms == nil {
+		state.Rooms = make(map[string]Room)
+	}
+	if state.Sessions == nil {
+		state.Sessions = make(map[string]Session)
+	}
+	if state.Revoked == nil {
+		state.Revoked = make(map[string]time.Time)
+	}
+	s.state = state
+	return nil
+}
+
+// replayJournal はログの変更を順に適用し、追記用にログを開きます。
+// 末尾の行が書き込み途中で壊れている場合は、その行を切り捨てます。
+func (s *FileStore) replayJournal() error {
+	journal, err := os.OpenFile(filepath.Join(s.dir, journalFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("ログを開けませんでした: %w", err)
+	}
+
+	var valid int64 // 正しく読み込めた位置
+	reader := bufio.NewReader(journal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// 改行で終わっていない末尾の行は書き込み途中とみなす
+			if len(bytes.TrimSpace(line)) > 0 {
+				s.logger.Warn("ログの末尾が壊れているため切り捨てます", "offset", valid, "bytes", len(line))
+			}
+			break
+		}
+
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			s.logger.Warn("ログの壊れた行以降を切り捨てます", "offset", valid, "error", err)
+			break
+		}
+		s.state.apply(e)
+		s.pending++
+		valid += int64(len(line))
+	}
+
+	if err := journal.Truncate(valid); err != nil {
+		journal.Close()
+		return fmt.Errorf("ログの切り詰めに失敗しました: %w", err)
+	}
+	if _, err := journal.Seek(valid, io.SeekStart); err != nil {
+		journal.Close()
+		return fmt.Errorf("ログのシークに失敗しました: %w", err)
+	}
+	s.journal = journal
+	return nil
+}
+
+// writeFileSync はファイルを書き込み、ディスクに同期します。
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("ファイルを開けませんでした: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("ファイルの書き込みに失敗しました: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("ファイルの同期に失敗しました: %w", err)
+	}
+	return f.Close()
+}
+
+// syncDir はファイルの作成や名前の変更をディスクに反映するため、ディレクトリを同期します。
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
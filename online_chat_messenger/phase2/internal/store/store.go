@@ -0,0 +1,187 @@
+// Package store はルームとセッションの状態を永続化し、サーバーの再起動後に復元するための仕組みを提供します。
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Room は永続化されるルームの情報です。
+type Room struct {
+	Name        string    `json:"name"`
+	Password    string    `json:"password,omitempty"` // パスワードのハッシュ（pwhash形式。以前のバージョンでは平文）
+	CreatedAt   time.Time `json:"created_at"`
+	Topic       string    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Creator     string    `json:"creator,omitempty"`
+	CreatorAddr string    `json:"creator_addr,omitempty"`
+	MaxMembers  int       `json:"max_members,omitempty"` // ルームで決めたメンバー数の上限
+	InviteOnly  bool      `json:"invite_only,omitempty"`
+	Invites     []Invite  `json:"invites,omitempty"` // 有効な招待コード
+}
+
+// Invite は永続化される招待コードの情報です。
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	SingleUse bool      `json:"single_use,omitempty"`
+}
+
+// Session は永続化されるセッション（トークンとルームへの参加）の情報です。
+type Session struct {
+	Token    string `json:"token"`
+	UserName string `json:"user_name"`
+	RoomName string `json:"room_name"`
+	Address  string `json:"address"`            // TCPで接続してきたアドレス
+	UDPAddr  string `json:"udp_addr,omitempty"` // メッセージの送信先
+	Host     bool   `json:"host"`
+	Account  string `json:"account,omitempty"` // ログインしたアカウントの名前（ゲストの場合は空）
+}
+
+// State は復元に使うすべての状態です。
+type State struct {
+	Rooms    map[string]Room      `json:"rooms"`             // キーはルーム名
+	Sessions map[string]Session   `json:"sessions"`          // キーはトークン
+	Revoked  map[string]time.Time `json:"revoked,omitempty"` // 取り消したトークン（キーはセッションの識別子、値はトークンの有効期限）
+}
+
+// NewState は空のStateを生成します。
+func NewState() *State {
+	return &State{Rooms: make(map[string]Room), Sessions: make(map[string]Session), Revoked: make(map[string]time.Time)}
+}
+
+// apply は変更をStateに反映します。ルームを削除した場合は、そのルームのセッションも削除します。
+func (s *State) apply(e entry) {
+	switch e.Op {
+	case opSaveRoom:
+		if e.Room != nil {
+			s.Rooms[e.Room.Name] = *e.Room
+		}
+	case opDeleteRoom:
+		delete(s.Rooms, e.Key)
+		for token, session := range s.Sessions {
+			if session.RoomName == e.Key {
+				delete(s.Sessions, token)
+			}
+		}
+	case opSaveSession:
+		if e.Session != nil {
+			s.Sessions[e.Session.Token] = *e.Session
+		}
+	case opDeleteSession:
+		delete(s.Sessions, e.Key)
+	case opRevokeToken:
+		if e.ExpiresAt != nil {
+			s.Revoked[e.Key] = *e.ExpiresAt
+		}
+		// 有効期限の切れたトークンは取り消しておく必要がないため、一覧が増え続けないよう削除する
+		now := time.Now()
+		for id, expiresAt := range s.Revoked {
+			if !now.Before(expiresAt) {
+				delete(s.Revoked, id)
+			}
+		}
+	}
+}
+
+// clone はStateのコピーを返します。
+func (s *State) clone() *State {
+	c := NewState()
+	for name, room := range s.Rooms {
+		c.Rooms[name] = room
+	}
+	for token, session := range s.Sessions {
+		c.Sessions[token] = session
+	}
+	for id, expiresAt := range s.Revoked {
+		c.Revoked[id] = expiresAt
+	}
+	return c
+}
+
+// Store はルームとセッション、取り消したトークンの永続化のインターフェースです。
+// ルームを削除した場合、そのルームのセッションも削除されます。
+type Store interface {
+	Load() (*State, error)
+	SaveRoom(room Room) error
+	DeleteRoom(name string) error
+	SaveSession(session Session) error
+	DeleteSession(token string) error
+	SaveRevocation(sessionID string, expiresAt time.Time) error
+	Close() error
+}
+
+// 変更の種類
+const (
+	opSaveRoom      = "save_room"
+	opDeleteRoom    = "delete_room"
+	opSaveSession   = "save_session"
+	opDeleteSession = "delete_session"
+	opRevokeToken   = "revoke_token"
+)
+
+// entry は1件の変更を表します。FileStoreではログの1行になります。
+type entry struct {
+	Op        string     `json:"op"`
+	Key       string     `json:"key,omitempty"` // 削除するルーム名・トークン、または取り消したトークンのセッションの識別子
+	Room      *Room      `json:"room,omitempty"`
+	Session   *Session   `json:"session,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 取り消したトークンの有効期限
+}
+
+// MemoryStore はメモリ上に状態を保持するStoreです。再起動すると状態は失われます。
+type MemoryStore struct {
+	state *State
+	mutex sync.Mutex
+}
+
+// NewMemoryStore は新しいMemoryStoreを生成します。
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: NewState()}
+}
+
+// Load は現在の状態のコピーを返します。
+func (m *MemoryStore) Load() (*State, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.state.clone(), nil
+}
+
+// SaveRoom はルームを保存します。
+func (m *MemoryStore) SaveRoom(room Room) error {
+	return m.update(entry{Op: opSaveRoom, Room: &room})
+}
+
+// DeleteRoom はルームとそのセッションを削除します。
+func (m *MemoryStore) DeleteRoom(name string) error {
+	return m.update(entry{Op: opDeleteRoom, Key: name})
+}
+
+// SaveSession はセッションを保存します。
+func (m *MemoryStore) SaveSession(session Session) error {
+	return m.update(entry{Op: opSaveSession, Session: &session})
+}
+
+// DeleteSession はセッションを削除します。
+func (m *MemoryStore) DeleteSession(token string) error {
+	return m.update(entry{Op: opDeleteSession, Key: token})
+}
+
+// SaveRevocation は取り消したトークンを保存します。
+func (m *MemoryStore) SaveRevocation(sessionID string, expiresAt time.Time) error {
+	return m.update(entry{Op: opRevokeToken, Key: sessionID, ExpiresAt: &expiresAt})
+}
+
+// Close は何もしません。
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) update(e entry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.state.apply(e)
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package transcript はルームの会話記録をJSON Lines・Markdown・HTMLのファイルに書き出します。
+//
+// サーバーの管理用インターフェースとクライアントの両方から使えるよう、記録の取得元には依存せず、
+// 古い順に渡されたEntryを1件ずつ書き出します。
+package transcript
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format は書き出す形式です。
+type Format string
+
+const (
+	FormatJSONL    Format = "jsonl"    // 1行に1件のJSON
+	FormatMarkdown Format = "markdown" // 日付ごとの見出しと箇条書き
+	FormatHTML     Format = "html"     // スタイルを埋め込んだ単体で表示できるHTML
+)
+
+// ErrUnknownFormat は対応していない形式が指定されたことを表します。
+var ErrUnknownFormat = errors.New("unknown transcript format")
+
+// ParseFormat は形式の名前を解析します。"json"・"md"・"htm" などの別名と大文字・小文字の違いも受け付けます。
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "jsonl", "json", "ndjson":
+		return FormatJSONL, nil
+	case "markdown", "md":
+		return FormatMarkdown, nil
+	case "html", "htm":
+		return FormatHTML, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+// Extension はファイルの拡張子を返します。
+func (f Format) Extension() string {
+	switch f {
+	case FormatMarkdown:
+		return ".md"
+	case FormatHTML:
+		return ".html"
+	default:
+		return ".jsonl"
+	}
+}
+
+// ContentType はHTTPで返すときのContent-Typeを返します。
+func (f Format) ContentType() string {
+	switch f {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/x-ndjson; charset=utf-8"
+	}
+}
+
+// Entry は会話記録の1件です。
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Sender string    `json:"sender"`
+	Text   string    `json:"text"`
+	System bool      `json:"system,omitempty"` // サーバーからのお知らせや入退室などのイベント
+}
+
+// Header は会話記録の見出しに書く情報です。
+type Header struct {
+	Room       string
+	From, To   time.Time      // 絞り込んだ期間（ゼロ値は制限なし）
+	ExportedAt time.Time      // 書き出した時刻
+	Location   *time.Location // 時刻を表示するタイムゾーン（nilの場合はtime.Local）
+}
+
+// Writer は会話記録を指定した形式で書き出します。
+type Writer struct {
+	w       io.Writer
+	format  Format
+	header  Header
+	lastDay string // MarkdownとHTMLで最後に見出しを書いた日付
+	count   int
+	err     error
+}
+
+// NewWriter は見出しを書き出し、会話記録を書き出すWriterを返します。
+func NewWriter(w io.Writer, format Format, header Header) (*Writer, error) {
+	if _, err := ParseFormat(string(format)); err != nil {
+		return nil, err
+	}
+	if header.Location == nil {
+		header.Location = time.Local
+	}
+	tw := &Writer{w: w, format: format, header: header}
+	tw.begin()
+	return tw, tw.err
+}
+
+// Write は1件を書き出します。
+func (tw *Writer) Write(e Entry) error {
+	if tw.err != nil {
+		return tw.err
+	}
+	switch tw.format {
+	case FormatJSONL:
+		var line strings.Builder
+		encoder := json.NewEncoder(&line)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(struct {
+			Room string `json:"room"`
+			Entry
+		}{tw.header.Room, e}); err != nil {
+			return err
+		}
+		tw.printf("%s", line.String())
+	case FormatMarkdown:
+		tw.markdownEntry(e)
+	case FormatHTML:
+		tw.htmlEntry(e)
+	}
+	if tw.err == nil {
+		tw.count++
+	}
+	return tw.err
+}
+
+// Close は末尾を書き出します。書き出し先は閉じません。
+func (tw *Writer) Close() error {
+	if tw.err != nil {
+		return tw.err
+	}
+	switch tw.format {
+	case FormatMarkdown:
+		tw.printf("\n---\n%d件\n", tw.count)
+	case FormatHTML:
+		if tw.lastDay != "" {
+			tw.printf("</ol>\n</section>\n")
+		}
+		tw.printf("<footer>%d件</footer>\n</body>\n</html>\n", tw.count)
+	}
+	return tw.err
+}
+
+// Count は書き出した件数を返します。
+func (tw *Writer) Count() int {
+	return tw.count
+}
+
+func (tw *Writer) begin() {
+	h := tw.header
+	switch tw.format {
+	case FormatMarkdown:
+		tw.printf("# %s\n\n", escapeMarkdown(h.Room))
+		tw.printf("- 期間: %s\n", tw.period())
+		tw.printf("- 書き出し: %s\n", tw.formatTime(h.ExportedAt, time.DateTime))
+	case FormatHTML:
+		title := html.EscapeString(h.Room)
+		tw.printf("<!DOCTYPE html>\n<html lang=\"ja\">\n<head>\n<meta charset=\"utf-8\">\n")
+		tw.printf("<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n", title, htmlStyle)
+		tw.printf("<header>\n<h1>%s</h1>\n", title)
+		tw.printf("<p>期間: %s<br>書き出し: %s</p>\n</header>\n", html.EscapeString(tw.period()), tw.formatTime(h.ExportedAt, time.DateTime))
+	}
+}
+
+func (tw *Writer) markdownEntry(e Entry) {
+	if day := tw.formatTime(e.Time, time.DateOnly); day != tw.lastDay {
+		tw.printf("\n## %s\n\n", day)
+		tw.lastDay = day
+	}
+	clock := tw.formatTime(e.Time, time.TimeOnly)
+	if e.System {
+		tw.printf("- `%s` _%s_\n", clock, escapeMarkdown(e.Text))
+		return
+	}
+	tw.printf("- `%s` **%s**: %s\n", clock, escapeMarkdown(e.Sender), escapeMarkdown(e.Text))
+}
+
+func (tw *Writer) htmlEntry(e Entry) {
+	if day := tw.formatTime(e.Time, time.DateOnly); day != tw.lastDay {
+		if tw.lastDay != "" {
+			tw.printf("</ol>\n</section>\n")
+		}
+		tw.printf("<section>\n<h2>%s</h2>\n<ol>\n", day)
+		tw.lastDay = day
+	}
+	class := "message"
+	if e.System {
+		class = "system"
+	}
+	tw.printf("<li class=\"%s\"><time datetime=\"%s\">%s</time> <span class=\"sender\">%s</span> <span class=\"text\">%s</span></li>\n",
+		class, e.Time.Format(time.RFC3339), tw.formatTime(e.Time, time.TimeOnly), html.EscapeString(e.Sender), html.EscapeString(e.Text))
+}
+
+// period は絞り込んだ期間を表示用の文字列にします。
+func (tw *Writer) period() string {
+	from, to := "最初", "最新"
+	if !tw.header.From.IsZero() {
+		from = tw.formatTime(tw.header.From, time.DateTime)
+	}
+	if !tw.header.To.IsZero() {
+		to = tw.formatTime(tw.header.To, time.DateTime)
+	}
+	return from + " 〜 " + to
+}
+
+func (tw *Writer) formatTime(t time.Time, layout string) string {
+	return t.In(tw.header.Location).Format(layout)
+}
+
+// printf は書き出し先に出力し、最初に発生したエラーを保持します。
+func (tw *Writer) printf(format string, args ...any) {
+	if tw.err != nil {
+		return
+	}
+	_, tw.err = fmt.Fprintf(tw.w, format, args...)
+}
+
+// markdownEscaper はMarkdownで書式として解釈される文字をエスケープします。
+// 本文の "&lt;" などが文字参照として表示されないよう、& も文字参照にします。
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`, `#`, `\#`, `|`, `\|`, "\n", " ",
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+const htmlStyle = `body { font-family: sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; color: #222; }
+header p { color: #666; }
+h2 { font-size: 1em; border-bottom: 1px solid #ddd; padding-bottom: .2em; }
+ol { list-style: none; padding: 0; }
+li { margin: .3em 0; white-space: pre-wrap; word-break: break-word; }
+time { color: #888; font-family: monospace; }
+.sender { font-weight: bold; }
+.system { color: #777; font-style: italic; }
+.system .sender { display: none; }
+footer { color: #666; margin-top: 2em; }
+`
+
+// ParseTime は期間の指定に使う時刻を解析します。
+// RFC 3339 のほか、タイムゾーンを省略した "2006-01-02T15:04:05"・"2006-01-02T15:04"・"2006-01-02" をlocの時刻として受け付けます。
+// 空文字列の場合はゼロ値（制限なし）を返します。
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("時刻の形式が正しくありません: %q（例: 2006-01-02、2006-01-02T15:04）", s)
+}
@@ -0,0 +1,176 @@
+package transcript
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	day1 = time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC)
+	day2 = time.Date(2024, 6, 2, 9, 0, 30, 0, time.UTC)
+)
+
+// write は見出しと記録を書き出した結果を返します。
+func write(t *testing.T, format Format, room string, entries []Entry) string {
+	t.Helper()
+	var out strings.Builder
+	tw, err := NewWriter(&out, format, Header{Room: room, ExportedAt: day2, Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := tw.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tw.Count() != len(entries) {
+		t.Errorf("Count = %d, want %d", tw.Count(), len(entries))
+	}
+	return out.String()
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		room    string
+		entries []Entry
+		want    []string // 出力に含まれる行
+		reject  []string // 出力に含まれてはいけない文字列
+	}{
+		{
+			name:   "JSON Linesはエスケープせずにそのまま書く",
+			format: FormatJSONL,
+			room:   "lobby",
+			entries: []Entry{
+				{Time: day1, Sender: "taro", Text: "<b>A & B</b>"},
+				{Time: day1, Sender: "hanako", Text: "1行目\n2行目"},
+			},
+			want: []string{
+				`{"room":"lobby","time":"2024-06-01T10:15:00Z","sender":"taro","text":"<b>A & B</b>"}`,
+				`{"room":"lobby","time":"2024-06-01T10:15:00Z","sender":"hanako","text":"1行目\n2行目"}`,
+			},
+			reject: []string{`\u003c`, `\u0026`},
+		},
+		{
+			name:    "JSON Linesのシステムメッセージ",
+			format:  FormatJSONL,
+			room:    "lobby",
+			entries: []Entry{{Time: day1, Text: "taro が入室しました", System: true}},
+			want:    []string{`{"room":"lobby","time":"2024-06-01T10:15:00Z","sender":"","text":"taro が入室しました","system":true}`},
+		},
+		{
+			name:   "Markdownの書式を無効にする",
+			format: FormatMarkdown,
+			room:   "# dev_room",
+			entries: []Entry{
+				{Time: day1, Sender: "ta_ro", Text: "*強調* `code` [link](x) a|b #tag \\n"},
+				{Time: day1, Sender: "hanako", Text: "<script> & &lt;"},
+			},
+			want: []string{
+				`# \# dev\_room`,
+				"- `10:15:00` **ta\\_ro**: \\*強調\\* \\`code\\` \\[link\\](x) a\\|b \\#tag \\\\n",
+				"- `10:15:00` **hanako**: &lt;script&gt; &amp; &amp;lt;",
+			},
+			reject: []string{"<script>"},
+		},
+		{
+			name:   "Markdownの複数行のメッセージは1行にまとめる",
+			format: FormatMarkdown,
+			room:   "lobby",
+			entries: []Entry{
+				{Time: day1, Sender: "taro", Text: "1行目\n- 2行目"},
+				{Time: day2, Text: "taro が退出しました", System: true},
+			},
+			want: []string{
+				"## 2024-06-01",
+				"- `10:15:00` **taro**: 1行目 - 2行目",
+				"## 2024-06-02",
+				"- `09:00:30` _taro が退出しました_",
+				"2件",
+			},
+		},
+		{
+			name:   "HTMLは本文と名前をエスケープする",
+			format: FormatHTML,
+			room:   "<lobby>",
+			entries: []Entry{
+				{Time: day1, Sender: "<b>taro</b>", Text: `<script>alert("x")</script> & 'y'`},
+			},
+			want: []string{
+				"<title>&lt;lobby&gt;</title>",
+				"<h1>&lt;lobby&gt;</h1>",
+				`<li class="message"><time datetime="2024-06-01T10:15:00Z">10:15:00</time> <span class="sender">&lt;b&gt;taro&lt;/b&gt;</span> <span class="text">&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; &#39;y&#39;</span></li>`,
+			},
+			reject: []string{"<script>", "<b>"},
+		},
+		{
+			name:   "HTMLの複数行のメッセージは改行を残して日付ごとにまとめる",
+			format: FormatHTML,
+			room:   "lobby",
+			entries: []Entry{
+				{Time: day1, Sender: "taro", Text: "1行目\n2行目"},
+				{Time: day2, Text: "taro が退出しました", System: true},
+			},
+			want: []string{
+				"<section>\n<h2>2024-06-01</h2>\n<ol>",
+				`<span class="text">1行目` + "\n" + `2行目</span></li>`,
+				"</ol>\n</section>\n<section>\n<h2>2024-06-02</h2>",
+				`<li class="system">`,
+				"</ol>\n</section>\n<footer>2件</footer>\n</body>\n</html>\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := write(t, tt.format, tt.room, tt.entries)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output does not contain %q:\n%s", want, got)
+				}
+			}
+			for _, reject := range tt.reject {
+				if strings.Contains(got, reject) {
+					t.Errorf("output contains %q:\n%s", reject, got)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONLinesHasOneLinePerEntry(t *testing.T) {
+	got := write(t, FormatJSONL, "lobby", []Entry{
+		{Time: day1, Sender: "taro", Text: "1行目\n2行目\r\n3行目"},
+		{Time: day2, Sender: "hanako", Text: "よろしく"},
+	})
+	if lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n"); len(lines) != 2 {
+		t.Errorf("lines = %d, want 2:\n%s", len(lines), got)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Format
+	}{
+		{"jsonl", FormatJSONL},
+		{"JSON", FormatJSONL},
+		{".ndjson", FormatJSONL},
+		{"md", FormatMarkdown},
+		{"Markdown", FormatMarkdown},
+		{".htm", FormatHTML},
+	}
+	for _, tt := range tests {
+		if got, err := ParseFormat(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseFormat("pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ParseFormat(pdf) = %v, want ErrUnknownFormat", err)
+	}
+}